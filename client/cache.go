@@ -0,0 +1,256 @@
+package client
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	. "github.com/siddontang/mixer/mysql"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NoCacheHint in a leading comment of a select bypasses the result cache.
+const NoCacheHint = "/*mixer:nocache*/"
+
+// ResultCacheOptions configures the result cache of DB.Query, see
+// SetResultCache.
+type ResultCacheOptions struct {
+	// TTL is how long a resultset is served from the cache, 0 disables
+	// the cache.
+	TTL time.Duration
+
+	// MaxEntries bounds the cached resultsets, the least recently used
+	// are evicted first, no bound if 0.
+	MaxEntries int
+
+	// MaxBytes bounds the estimated size of the cached resultsets, no
+	// bound if 0.
+	MaxBytes int64
+}
+
+// SetResultCache caches the resultsets of Query for opts.TTL, keyed by the
+// query with whitespace normalized, the args and the db of the pool. Only
+// plain selects are cached: a locking select, a select into, a select with
+// SQL_NO_CACHE or NoCacheHint is always executed. Execute, and the
+// statements of a transaction begun by Begin, are never cached.
+//
+// A cached resultset is not invalidated by writes, it may be stale for up
+// to the TTL. Every hit returns a copy, so callers may change it. Setting
+// the cache again drops the cached resultsets.
+func (db *DB) SetResultCache(opts ResultCacheOptions) {
+	var c *resultCache
+	if opts.TTL > 0 {
+		c = newResultCache(opts)
+	}
+
+	db.Lock()
+	db.resultCache = c
+	db.Unlock()
+}
+
+// FlushResultCache drops the cached resultsets.
+func (db *DB) FlushResultCache() {
+	if c := db.getResultCache(); c != nil {
+		c.flush()
+	}
+}
+
+func (db *DB) getResultCache() *resultCache {
+	db.Lock()
+	c := db.resultCache
+	db.Unlock()
+	return c
+}
+
+// cachedQuery returns the resultset of query from the cache, or executes
+// it and caches a copy
+func (db *DB) cachedQuery(ctx context.Context, c *resultCache, query string, args []interface{}) (*Resultset, error) {
+	key := resultCacheKey(db.GetDB(), query, args)
+	if r := c.get(key); r != nil {
+		atomic.AddUint64(&db.cacheHits, 1)
+		return r, nil
+	}
+	atomic.AddUint64(&db.cacheMisses, 1)
+
+	r, err := resultsetOf(db.execute(ctx, 0, StatementQuery, query, args))
+	if err == nil {
+		c.put(key, r.Copy())
+	}
+	return r, err
+}
+
+// cacheableQuery is true for a plain select without NoCacheHint
+func cacheableQuery(query string) bool {
+	rest := query
+	for {
+		rest = strings.TrimLeft(rest, " \t\r\n")
+		if !strings.HasPrefix(rest, "/*") {
+			break
+		}
+
+		end := strings.Index(rest, "*/")
+		if end == -1 {
+			return false
+		}
+		if strings.EqualFold(strings.Join(strings.Fields(rest[:end+2]), ""), NoCacheHint) {
+			return false
+		}
+		rest = rest[end+2:]
+	}
+
+	words := strings.Fields(strings.ToLower(rest))
+	if len(words) == 0 || words[0] != "select" {
+		return false
+	}
+
+	for i, w := range words {
+		switch w {
+		case "into", "sql_no_cache":
+			return false
+		case "update", "share":
+			//for update, lock in share mode and for share
+			if words[i-1] == "for" || words[i-1] == "in" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// resultCacheKey is db, query with whitespace outside quotes collapsed,
+// and the Go syntax of args, so 1 and "1" are different keys
+func resultCacheKey(db string, query string, args []interface{}) string {
+	key := make([]byte, 0, len(db)+len(query)+2)
+	key = append(key, db...)
+	key = append(key, 0)
+
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+
+	var quote byte
+	space := false
+	for i := 0; i < len(query); i++ {
+		b := query[i]
+		switch {
+		case quote != 0:
+			if b == '\\' && i+1 < len(query) {
+				key = append(key, b)
+				i++
+				b = query[i]
+			} else if b == quote {
+				quote = 0
+			}
+		case b == '\'' || b == '"' || b == '`':
+			quote = b
+		case b == ' ' || b == '\t' || b == '\r' || b == '\n':
+			space = true
+			continue
+		}
+
+		if space {
+			key = append(key, ' ')
+			space = false
+		}
+		key = append(key, b)
+	}
+
+	if len(args) > 0 {
+		key = append(key, 0)
+		key = append(key, fmt.Sprintf("%#v", args)...)
+	}
+	return string(key)
+}
+
+type resultCacheEntry struct {
+	key     string
+	r       *Resultset
+	size    int64
+	expires time.Time
+}
+
+// resultCache is a LRU of resultsets, the front is the most recently used
+type resultCache struct {
+	sync.Mutex
+
+	opts ResultCacheOptions
+
+	lru     *list.List
+	entries map[string]*list.Element
+	size    int64
+}
+
+func newResultCache(opts ResultCacheOptions) *resultCache {
+	return &resultCache{opts: opts, lru: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// get returns a copy of the unexpired resultset of key, nil if none
+func (c *resultCache) get(key string) *Resultset {
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+
+	entry := e.Value.(*resultCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(e)
+		return nil
+	}
+
+	c.lru.MoveToFront(e)
+	return entry.r.Copy()
+}
+
+func (c *resultCache) put(key string, r *Resultset) {
+	entry := &resultCacheEntry{key: key, r: r, size: resultsetSize(key, r),
+		expires: time.Now().Add(c.opts.TTL)}
+
+	if c.opts.MaxBytes > 0 && entry.size > c.opts.MaxBytes {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.size += entry.size
+
+	for (c.opts.MaxEntries > 0 && c.lru.Len() > c.opts.MaxEntries) ||
+		(c.opts.MaxBytes > 0 && c.size > c.opts.MaxBytes) {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *resultCache) remove(e *list.Element) {
+	entry := c.lru.Remove(e).(*resultCacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
+
+func (c *resultCache) flush() {
+	c.Lock()
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	c.size = 0
+	c.Unlock()
+}
+
+// resultsetSize estimates the memory of an entry by its bytes, values are
+// counted as the row data they are parsed from
+func resultsetSize(key string, r *Resultset) int64 {
+	size := int64(len(key))
+	for _, f := range r.Fields {
+		size += int64(len(f.Data) + len(f.Schema) + len(f.Table) + len(f.OrgTable) +
+			len(f.Name) + len(f.OrgName) + len(f.DefaultValue))
+	}
+	for _, row := range r.RowDatas {
+		size += 2 * int64(len(row))
+	}
+	return size
+}
@@ -0,0 +1,223 @@
+package client
+
+import (
+	. "github.com/siddontang/mixer/mysql"
+	"testing"
+	"time"
+)
+
+// countQueries returns how many times s received query
+func countQueries(s *fakeServer, query string) int {
+	_, _, queries := s.stats()
+	n := 0
+	for _, q := range queries {
+		if q == query {
+			n++
+		}
+	}
+	return n
+}
+
+func checkCacheStats(t *testing.T, db *DB, hits uint64, misses uint64) {
+	if st := db.Stats(); st.CacheHits != hits || st.CacheMisses != misses {
+		t.Fatalf("hits %d misses %d, not %d %d", st.CacheHits, st.CacheMisses, hits, misses)
+	}
+}
+
+func TestDB_ResultCache(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	s.onQuery("select name from t where id = 1").rows([]string{"name"}, []string{"a"})
+	fields := []*Field{{Name: []byte("name"), Charset: uint16(DEFAULT_COLLATION_ID), Type: MYSQL_TYPE_VAR_STRING}}
+	s.on(COM_STMT_EXECUTE, "select name from t where id = ?").resultset(fields, true, [][]byte{[]byte("b")})
+
+	db := s.openDB("test")
+	defer db.Close()
+
+	//disabled by default
+	for i := 0; i < 2; i++ {
+		if _, err := db.Query("select name from t where id = 1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countQueries(s, "select name from t where id = 1"); n != 2 {
+		t.Fatal(n)
+	}
+	checkCacheStats(t, db, 0, 0)
+
+	db.SetResultCache(ResultCacheOptions{TTL: 100 * time.Millisecond})
+
+	for _, q := range []string{"select name from t where id = 1", "select  name\n from t where id = 1;"} {
+		r, err := db.Query(q)
+		if err != nil {
+			t.Fatal(err)
+		} else if v, _ := r.GetString(0, 0); v != "a" {
+			t.Fatal(v)
+		}
+	}
+	if n := countQueries(s, "select name from t where id = 1"); n != 3 {
+		t.Fatal(n)
+	}
+	checkCacheStats(t, db, 1, 1)
+
+	//keyed by args
+	for _, arg := range []interface{}{1, 1, "1"} {
+		if _, err := db.Query("select name from t where id = ?", arg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkCacheStats(t, db, 2, 3)
+
+	//expired
+	time.Sleep(150 * time.Millisecond)
+	if _, err := db.Query("select name from t where id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := countQueries(s, "select name from t where id = 1"); n != 4 {
+		t.Fatal(n)
+	}
+	checkCacheStats(t, db, 2, 4)
+
+	db.FlushResultCache()
+	if _, err := db.Query("select name from t where id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	checkCacheStats(t, db, 2, 5)
+}
+
+func TestDB_ResultCacheEvict(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	queries := []string{"select 1", "select 2", "select 3"}
+	for _, q := range queries {
+		s.onQuery(q).rows([]string{"v"}, []string{q})
+	}
+
+	db := s.openDB("")
+	defer db.Close()
+
+	db.SetResultCache(ResultCacheOptions{TTL: time.Minute, MaxEntries: 2})
+
+	query := func(q string) {
+		if r, err := db.Query(q); err != nil {
+			t.Fatal(err)
+		} else if v, _ := r.GetString(0, 0); v != q {
+			t.Fatal(v)
+		}
+	}
+
+	//select 1 is used again, so select 2 is the least recently used
+	query("select 1")
+	query("select 2")
+	query("select 1")
+	query("select 3")
+	query("select 1")
+	query("select 2")
+	checkCacheStats(t, db, 2, 4)
+	if n := countQueries(s, "select 2"); n != 2 {
+		t.Fatal(n)
+	}
+
+	//a resultset bigger than the bytes bound is not cached
+	db.SetResultCache(ResultCacheOptions{TTL: time.Minute, MaxBytes: 10})
+	query("select 1")
+	query("select 1")
+	checkCacheStats(t, db, 2, 6)
+
+	c := newResultCache(ResultCacheOptions{TTL: time.Minute, MaxBytes: 100})
+	for _, q := range queries {
+		r, _ := db.Query(q)
+		c.put(q, r)
+	}
+	if size := resultsetSize("select 1", c.get("select 3")); c.size > 100 || c.size < 2*size {
+		t.Fatal(c.size, size)
+	}
+}
+
+func TestDB_ResultCacheBypass(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	defer db.Close()
+
+	db.SetResultCache(ResultCacheOptions{TTL: time.Minute})
+
+	queries := []string{
+		"select * from t for update",
+		"SELECT * FROM t LOCK IN SHARE MODE",
+		"select * from t for share",
+		"select id into @id from t",
+		"select sql_no_cache * from t",
+		"/*mixer:nocache*/ select * from t",
+		"/* other */ /* mixer:nocache */select * from t",
+		"show tables",
+		"update t set name = 'a'",
+	}
+	for _, q := range queries {
+		s.onQuery(q).rows([]string{"id"}, []string{"1"})
+		for i := 0; i < 2; i++ {
+			db.Query(q)
+		}
+		if n := countQueries(s, q); n != 2 {
+			t.Fatal(q, n)
+		}
+	}
+
+	//a transaction
+	s.onQuery("select * from t").rows([]string{"id"}, []string{"1"})
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = tx.Query("select * from t"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tx.Rollback()
+	if n := countQueries(s, "select * from t"); n != 2 {
+		t.Fatal(n)
+	}
+	checkCacheStats(t, db, 0, 0)
+
+	//a comment without the hint
+	s.onQuery("/* other */ select * from t").rows([]string{"id"}, []string{"1"})
+	db.Query("/* other */ select * from t")
+	db.Query("/* other */ select * from t")
+	checkCacheStats(t, db, 1, 1)
+}
+
+func TestDB_ResultCacheCopy(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	s.onQuery("select name from t").rows([]string{"name"}, []string{"a"})
+
+	db := s.openDB("")
+	defer db.Close()
+
+	db.SetResultCache(ResultCacheOptions{TTL: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		r, err := db.Query("select name from t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, _ := r.GetString(0, 0); v != "a" {
+			t.Fatal(i, v)
+		}
+
+		r.Values[0][0].([]byte)[0] = 'x'
+		r.RowDatas[0][1] = 'x'
+		r.Fields[0].Name[0] = 'x'
+		r.Values = nil
+	}
+	checkCacheStats(t, db, 2, 1)
+
+	if r, _ := db.Query("select name from t"); string(r.Fields[0].Name) != "name" {
+		t.Fatal(string(r.Fields[0].Name))
+	}
+}
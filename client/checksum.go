@@ -0,0 +1,241 @@
+package client
+
+import (
+	"fmt"
+	. "github.com/siddontang/mixer/mysql"
+	"hash/crc32"
+)
+
+// ChecksumOptions configures ChecksumTable and CompareTables.
+type ChecksumOptions struct {
+	// KeyColumn orders and chunks the rows, the single column primary key
+	// of the table if empty.
+	KeyColumn string
+
+	// ChunkSize is the rows of a chunk, 1000 if 0.
+	ChunkSize int
+}
+
+// ChunkChecksum is the checksum of the rows of keys from Lower to Upper.
+type ChunkChecksum struct {
+	Lower []byte
+	Upper []byte
+
+	Rows     int
+	Checksum uint32
+}
+
+// ChecksumResult is the checksum of a table and of its chunks.
+type ChecksumResult struct {
+	Rows     int64
+	Checksum uint32
+
+	Chunks []ChunkChecksum
+}
+
+// ChunkDiff is a chunk of keys whose rows differ, a chunk of the master
+// with the rows of the slave in the same key range. Lower is nil for the
+// first chunk and Upper for the last one, so rows of the slave before or
+// after the keys of the master are in them too.
+type ChunkDiff struct {
+	Lower []byte
+	Upper []byte
+
+	MasterRows int
+	SlaveRows  int
+}
+
+// ChecksumTable computes the CRC32 checksum of the rows of table in order
+// of the key column, by chunks of the rows. The chunks are selected by
+// keyset pagination, the rows after the last key of the previous chunk,
+// and every chunk is streamed by QueryTo.
+func ChecksumTable(db *DB, table string, opts ChecksumOptions) (ChecksumResult, error) {
+	c, err := newTableChecksum(db, table, opts)
+	if err != nil {
+		return ChecksumResult{}, err
+	}
+	return c.checksum()
+}
+
+func (c *tableChecksum) checksum() (ChecksumResult, error) {
+	var result ChecksumResult
+
+	var last []byte
+	for first := true; ; first = false {
+		var where string
+		if !first {
+			where = fmt.Sprintf("%s > %s", c.key, c.literal(last))
+		}
+
+		chunk, err := c.chunk(where, fmt.Sprintf(" limit %d", c.opts.ChunkSize))
+		if err != nil {
+			return result, err
+		}
+		if chunk.Rows == 0 {
+			break
+		}
+
+		result.Rows += int64(chunk.Rows)
+		result.Chunks = append(result.Chunks, chunk)
+		last = chunk.Upper
+
+		if chunk.Rows < c.opts.ChunkSize {
+			break
+		}
+	}
+
+	result.Checksum = c.sum
+	return result, nil
+}
+
+// CompareTables checksums table on master by chunks, then the same key
+// ranges on slave, and returns the chunks which differ. The slave should
+// have caught up with the master, or the rows changed since differ too.
+func CompareTables(master *DB, slave *DB, table string, opts ChecksumOptions) ([]ChunkDiff, error) {
+	mc, err := newTableChecksum(master, table, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := mc.checksum()
+	if err != nil {
+		return nil, err
+	}
+
+	//the same key, not the primary key of the slave
+	c, err := newTableChecksum(slave, table, mc.opts)
+	if err != nil {
+		return nil, err
+	}
+	c.keyKind = mc.keyKind
+
+	if len(r.Chunks) == 0 {
+		//all rows of the slave differ
+		r.Chunks = []ChunkChecksum{{}}
+	}
+
+	var diffs []ChunkDiff
+	for i, m := range r.Chunks {
+		d := ChunkDiff{MasterRows: m.Rows}
+
+		var where string
+		if i > 0 {
+			d.Lower = r.Chunks[i-1].Upper
+			where = fmt.Sprintf("%s > %s", c.key, c.literal(d.Lower))
+		}
+		if i < len(r.Chunks)-1 {
+			d.Upper = m.Upper
+			if len(where) > 0 {
+				where += " and "
+			}
+			where += fmt.Sprintf("%s <= %s", c.key, c.literal(d.Upper))
+		}
+
+		s, err := c.chunk(where, "")
+		if err != nil {
+			return nil, err
+		}
+
+		if s.Rows != m.Rows || s.Checksum != m.Checksum {
+			d.SlaveRows = s.Rows
+			diffs = append(diffs, d)
+		}
+	}
+
+	return diffs, nil
+}
+
+type tableChecksum struct {
+	db    *DB
+	table string
+	opts  ChecksumOptions
+
+	key      string
+	keyIndex int
+	keyKind  valueKind
+
+	//of the chunk being read, and of the rows so far
+	chunkSum ChunkChecksum
+	sum      uint32
+}
+
+func newTableChecksum(db *DB, table string, opts ChecksumOptions) (*tableChecksum, error) {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = 1000
+	}
+
+	if len(opts.KeyColumn) == 0 {
+		r, err := db.Query(fmt.Sprintf("show keys from %s where Key_name = 'PRIMARY'", quoteTable(table)))
+		if err != nil {
+			return nil, err
+		} else if len(r.Values) != 1 {
+			return nil, fmt.Errorf("table %s has no single column primary key", table)
+		}
+
+		if opts.KeyColumn, err = r.GetStringByName(0, "Column_name"); err != nil {
+			return nil, err
+		}
+	}
+
+	c := &tableChecksum{db: db, table: quoteTable(table), opts: opts, keyIndex: -1}
+	c.key = quoteTable(opts.KeyColumn)
+	return c, nil
+}
+
+func (c *tableChecksum) literal(key []byte) string {
+	return string(appendValue(nil, c.keyKind, key))
+}
+
+// chunk checksums the rows matched by where in order of the key
+func (c *tableChecksum) chunk(where string, limit string) (ChunkChecksum, error) {
+	query := "select * from " + c.table
+	if len(where) > 0 {
+		query += " where " + where
+	}
+	query += " order by " + c.key + limit
+
+	c.chunkSum = ChunkChecksum{}
+
+	co, err := c.db.PopConn()
+	if err != nil {
+		return c.chunkSum, err
+	}
+
+	_, err = co.QueryTo(&rowWriter{fields: c.fields, row: c.row}, query)
+	c.db.PushConn(co, err)
+	return c.chunkSum, err
+}
+
+func (c *tableChecksum) fields(fs []*Field) error {
+	for i, f := range fs {
+		if string(f.Name) == c.opts.KeyColumn {
+			c.keyIndex = i
+			c.keyKind = dumpValueKind(f)
+			return nil
+		}
+	}
+	return fmt.Errorf("key column %s not in table %s", c.opts.KeyColumn, c.table)
+}
+
+// row adds the cells to the checksums, canonicalized as in a text row, a
+// length encoded string or 0xfb for NULL
+func (c *tableChecksum) row(cells [][]byte) error {
+	for _, v := range cells {
+		var b []byte
+		if v == nil {
+			b = []byte{0xfb}
+		} else {
+			b = PutLengthEncodedString(v)
+		}
+		c.chunkSum.Checksum = crc32.Update(c.chunkSum.Checksum, crc32.IEEETable, b)
+		c.sum = crc32.Update(c.sum, crc32.IEEETable, b)
+	}
+
+	key := cells[c.keyIndex]
+	if c.chunkSum.Rows == 0 {
+		c.chunkSum.Lower = key
+	}
+	c.chunkSum.Upper = key
+	c.chunkSum.Rows++
+	return nil
+}
@@ -0,0 +1,172 @@
+package client
+
+import (
+	"fmt"
+	. "github.com/siddontang/mixer/mysql"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var checksumTestFields = []*Field{
+	{Name: []byte("id"), Charset: uint16(BINARY_COLLATION_ID), Type: MYSQL_TYPE_LONGLONG},
+	{Name: []byte("name"), Charset: uint16(DEFAULT_COLLATION_ID), Type: MYSQL_TYPE_VAR_STRING},
+}
+
+var checksumTestQuery = regexp.MustCompile("^select \\* from `mixer`.`t`(?: where (.+))? order by `id`(?: limit (\\d+))?$")
+
+// checksumTestServer serves a table of id and name, it answers the
+// chunks by their key ranges
+type checksumTestServer struct {
+	*fakeServer
+
+	sync.Mutex
+	rows map[int64][]byte
+}
+
+func newChecksumTestServer(t *testing.T, n int) *checksumTestServer {
+	s := &checksumTestServer{rows: make(map[int64][]byte)}
+	for id := int64(1); id <= int64(n); id++ {
+		s.rows[id] = []byte(fmt.Sprintf("row %d", id))
+	}
+
+	s.fakeServer = newFakeServer(func(c *fakeServerConn, query string) error {
+		if query == "show keys from `mixer`.`t` where Key_name = 'PRIMARY'" {
+			return c.writeResultset([]string{"Table", "Key_name", "Column_name"}, [][]string{{"t", "PRIMARY", "id"}})
+		}
+
+		m := checksumTestQuery.FindStringSubmatch(query)
+		if m == nil {
+			return c.exec(query)
+		}
+
+		s.Lock()
+		rows := s.selectRows(t, m[1], m[2])
+		s.Unlock()
+
+		for _, p := range resultsetPackets(c.status, checksumTestFields, false, rows) {
+			if err := c.writePacket(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return s
+}
+
+func (s *checksumTestServer) selectRows(t *testing.T, where string, limit string) [][][]byte {
+	lower, upper := int64(-1<<63), int64(1<<63-1)
+	if len(where) > 0 {
+		for _, cond := range strings.Split(where, " and ") {
+			var v int64
+			if fmtScan(cond, "`id` > %d", &v) {
+				lower = v + 1
+			} else if fmtScan(cond, "`id` <= %d", &v) {
+				upper = v
+			} else {
+				t.Errorf("unexpected condition %s", cond)
+			}
+		}
+	}
+
+	var ids []int
+	for id := range s.rows {
+		if id >= lower && id <= upper {
+			ids = append(ids, int(id))
+		}
+	}
+	sort.Ints(ids)
+
+	if n, err := strconv.Atoi(limit); err == nil && n < len(ids) {
+		ids = ids[:n]
+	}
+
+	rows := make([][][]byte, len(ids))
+	for i, id := range ids {
+		rows[i] = [][]byte{[]byte(strconv.Itoa(id)), s.rows[int64(id)]}
+	}
+	return rows
+}
+
+func TestChecksumTable(t *testing.T) {
+	master := newChecksumTestServer(t, 1000)
+	defer master.Close()
+	slave := newChecksumTestServer(t, 1000)
+	defer slave.Close()
+
+	masterDB, slaveDB := master.openDB("mixer"), slave.openDB("mixer")
+	defer masterDB.Close()
+	defer slaveDB.Close()
+
+	opts := ChecksumOptions{ChunkSize: 100}
+
+	a, err := ChecksumTable(masterDB, "mixer.t", opts)
+	if err != nil {
+		t.Fatal(err)
+	} else if a.Rows != 1000 || len(a.Chunks) != 10 {
+		t.Fatal(a.Rows, len(a.Chunks))
+	}
+	for i, c := range a.Chunks {
+		if string(c.Lower) != strconv.Itoa(i*100+1) || string(c.Upper) != strconv.Itoa(i*100+100) || c.Rows != 100 {
+			t.Fatalf("chunk %d: %s %s %d", i, c.Lower, c.Upper, c.Rows)
+		}
+	}
+
+	b, err := ChecksumTable(slaveDB, "mixer.t", opts)
+	if err != nil {
+		t.Fatal(err)
+	} else if a.Checksum != b.Checksum {
+		t.Fatal(a.Checksum, b.Checksum)
+	}
+
+	//keyset pagination, never an offset
+	_, _, queries := master.stats()
+	for _, q := range queries {
+		if strings.Contains(q, "offset") || (strings.Contains(q, "limit") && strings.Contains(q, ",")) {
+			t.Fatal(q)
+		}
+	}
+
+	if diffs, err := CompareTables(masterDB, slaveDB, "mixer.t", opts); err != nil || len(diffs) != 0 {
+		t.Fatal(diffs, err)
+	}
+
+	//a changed row, a deleted row and a row after the last key of the master
+	slave.Lock()
+	slave.rows[250] = []byte("changed")
+	delete(slave.rows, 512)
+	slave.rows[1001] = []byte("row 1001")
+	slave.Unlock()
+
+	if b, err = ChecksumTable(slaveDB, "mixer.t", opts); err != nil {
+		t.Fatal(err)
+	} else if a.Checksum == b.Checksum {
+		t.Fatal(b.Checksum)
+	}
+
+	diffs, err := CompareTables(masterDB, slaveDB, "mixer.t", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []struct {
+		lower, upper string
+		slaveRows    int
+	}{
+		{"200", "300", 100},
+		{"500", "600", 99},
+		{"900", "", 101},
+	}
+	if len(diffs) != len(expect) {
+		t.Fatal(diffs)
+	}
+	for i, e := range expect {
+		if d := diffs[i]; string(d.Lower) != e.lower || string(d.Upper) != e.upper ||
+			d.MasterRows != 100 || d.SlaveRows != e.slaveRows {
+			t.Fatalf("%d: %s %s %d %d", i, d.Lower, d.Upper, d.MasterRows, d.SlaveRows)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/siddontang/mixer/mysql"
+	"net"
+	"sync"
+	"time"
+)
+
+// Cluster splits reads and writes over a master and its replicas: Query
+// runs on the healthy replicas round-robin, Execute, Begin and QueryMaster
+// on the master. A replica failing a query by its conn, a refused dial, a
+// dropped conn or a server gone away, is marked down, and the query runs
+// on the next replica, or on the master if none is left. A timeout or a
+// malformed packet is returned as is, the query itself may be at fault. A
+// down replica is up again after a successful ping of the
+// health check, see StartHealthCheck.
+type Cluster struct {
+	sync.Mutex
+
+	master   *DB
+	replicas []*clusterReplica
+
+	//the next replica to read from
+	next int
+
+	//see StartHealthCheck
+	healthQuit chan struct{}
+}
+
+type clusterReplica struct {
+	db   *DB
+	down bool
+}
+
+var (
+	_ Execer  = (*Cluster)(nil)
+	_ Queryer = (*Cluster)(nil)
+)
+
+// NewCluster returns a cluster of the pools of master and its replicas,
+// with no replica every statement runs on the master.
+func NewCluster(master *DB, replicas []*DB) (*Cluster, error) {
+	if master == nil {
+		return nil, fmt.Errorf("cluster must have a master")
+	}
+
+	c := &Cluster{master: master, replicas: make([]*clusterReplica, len(replicas))}
+	for i, db := range replicas {
+		c.replicas[i] = &clusterReplica{db: db}
+	}
+	return c, nil
+}
+
+// Master returns the pool of the master.
+func (c *Cluster) Master() *DB {
+	return c.master
+}
+
+// Execute executes command on the master, see DB.Execute.
+func (c *Cluster) Execute(command string, args ...interface{}) (*Result, error) {
+	return c.master.Execute(command, args...)
+}
+
+// Begin begins a transaction on a conn of the master, every statement of
+// the transaction runs on it, reads included.
+func (c *Cluster) Begin() (*SqlConn, error) {
+	return c.master.Begin()
+}
+
+// QueryMaster executes query on the master, to read the writes just made
+// by the caller, which a lagging replica may not have applied yet.
+func (c *Cluster) QueryMaster(query string, args ...interface{}) (*Resultset, error) {
+	return c.master.Query(query, args...)
+}
+
+// Query executes query on the next healthy replica, see DB.Query. The
+// query runs on the master if all replicas are down.
+func (c *Cluster) Query(query string, args ...interface{}) (*Resultset, error) {
+	for i := 0; i < len(c.replicas); i++ {
+		replica := c.pickReplica()
+		if replica == nil {
+			break
+		}
+
+		r, err := replica.db.Query(query, args...)
+		if !replicaFailed(err) {
+			return r, err
+		}
+		c.markDown(replica)
+	}
+	return c.master.Query(query, args...)
+}
+
+// replicaFailed is true for an error of the replica conn, not of the query
+func replicaFailed(err error) bool {
+	switch err {
+	case ErrQueryTimeout, ErrReadTimeout, ErrWriteTimeout, ErrMalformPacket:
+		return false
+	}
+
+	var oe *net.OpError
+	var ne net.Error
+	if errors.As(err, &oe) && oe.Op == "dial" {
+		return true
+	} else if errors.As(err, &ne) && ne.Timeout() {
+		return false
+	}
+	return IsConnBroken(err)
+}
+
+// pickReplica returns the next healthy replica, nil if all are down
+func (c *Cluster) pickReplica() *clusterReplica {
+	c.Lock()
+	defer c.Unlock()
+
+	for i := 0; i < len(c.replicas); i++ {
+		replica := c.replicas[(c.next+i)%len(c.replicas)]
+		if !replica.down {
+			c.next = (c.next + i + 1) % len(c.replicas)
+			return replica
+		}
+	}
+	return nil
+}
+
+func (c *Cluster) markDown(replica *clusterReplica) {
+	c.Lock()
+	replica.down = true
+	c.Unlock()
+}
+
+// ReplicaDown is true for the replica i of NewCluster if it is marked
+// down.
+func (c *Cluster) ReplicaDown(i int) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.replicas[i].down
+}
+
+// StartHealthCheck pings the down replicas every interval, and marks a
+// replica up again once its ping succeeds. A running check is replaced, 0
+// stops it, and Close stops it.
+func (c *Cluster) StartHealthCheck(interval time.Duration) {
+	c.Lock()
+	if c.healthQuit != nil {
+		close(c.healthQuit)
+		c.healthQuit = nil
+	}
+	if interval > 0 {
+		quit := make(chan struct{})
+		c.healthQuit = quit
+		go c.healthCheck(quit, interval)
+	}
+	c.Unlock()
+}
+
+// healthCheck pings the down replicas every interval until quit
+func (c *Cluster) healthCheck(quit chan struct{}, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-quit:
+			return
+		}
+
+		var down []*clusterReplica
+		c.Lock()
+		for _, replica := range c.replicas {
+			if replica.down {
+				down = append(down, replica)
+			}
+		}
+		c.Unlock()
+
+		for _, replica := range down {
+			if err := replica.db.Ping(); err == nil {
+				c.Lock()
+				replica.down = false
+				c.Unlock()
+			}
+		}
+	}
+}
+
+// Close stops the health check and closes the pools of the master and
+// the replicas.
+func (c *Cluster) Close() error {
+	c.StartHealthCheck(0)
+
+	for _, replica := range c.replicas {
+		replica.db.Close()
+	}
+	return c.master.Close()
+}
@@ -0,0 +1,162 @@
+package client
+
+import (
+	"context"
+	. "github.com/siddontang/mixer/mysql"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newClusterServer returns a server answering select @@hostname with name
+func newClusterServer(name string) *fakeServer {
+	s := newFakeServer(nil)
+	s.onQuery("select @@hostname").rows([]string{"@@hostname"}, []string{name})
+	return s
+}
+
+// downDialer dials s, and fails as a refused dial while down is 1
+func downDialer(s *fakeServer, down *int32) Dialer {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if atomic.LoadInt32(down) == 1 {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: syscall.ECONNREFUSED}
+		}
+		return s.dial(ctx, network, addr)
+	}
+}
+
+// clusterHost returns the server of a query by query
+func clusterHost(t *testing.T, query func(string, ...interface{}) (*Resultset, error)) string {
+	r, err := query("select @@hostname")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, _ := r.GetString(0, 0)
+	return host
+}
+
+func TestCluster(t *testing.T) {
+	master, r1, r2 := newClusterServer("master"), newClusterServer("r1"), newClusterServer("r2")
+	defer master.Close()
+	defer r1.Close()
+	defer r2.Close()
+
+	//r2 refuses dials when down
+	var down int32
+	db2 := r2.openDB("")
+	db2.SetDialer(downDialer(r2, &down))
+
+	c, err := NewCluster(master.openDB(""), []*DB{r1.openDB(""), db2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	//round-robin
+	for _, want := range []string{"r1", "r2", "r1", "r2"} {
+		if host := clusterHost(t, c.Query); host != want {
+			t.Fatal(host, want)
+		}
+	}
+	if host := clusterHost(t, c.QueryMaster); host != "master" {
+		t.Fatal(host)
+	}
+
+	//writes and transactions on the master
+	if _, err := c.Execute("insert into t values (1)"); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := c.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host := clusterHost(t, tx.Query); host != "master" {
+		t.Fatal(host)
+	}
+	tx.Rollback()
+	tx.Close()
+	if n := countQueries(r1, "select @@hostname"); n != 2 {
+		t.Fatal(n)
+	}
+
+	//r2 fails, marked down and skipped
+	atomic.StoreInt32(&down, 1)
+	r2.dropConns()
+	for i := 0; i < 4; i++ {
+		if host := clusterHost(t, c.Query); host != "r1" {
+			t.Fatal(host)
+		}
+	}
+	if !c.ReplicaDown(1) || c.ReplicaDown(0) {
+		t.Fatal("r2 not down")
+	}
+
+	//up after a ping
+	atomic.StoreInt32(&down, 0)
+	c.StartHealthCheck(10 * time.Millisecond)
+	deadline := time.Now().Add(5 * time.Second)
+	for c.ReplicaDown(1) {
+		if time.Now().After(deadline) {
+			t.Fatal("r2 still down")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	hosts := clusterHost(t, c.Query) + clusterHost(t, c.Query)
+	if hosts != "r1r2" && hosts != "r2r1" {
+		t.Fatal(hosts)
+	}
+}
+
+func TestCluster_ReplicaTimeout(t *testing.T) {
+	master, r1 := newClusterServer("master"), newClusterServer("r1")
+	defer master.Close()
+	defer r1.Close()
+
+	r1.onQuery("select sleep(1)").delay(time.Second).rows([]string{"sleep(1)"}, []string{"0"})
+	db1 := r1.openDB("")
+	db1.SetQueryTimeout(50 * time.Millisecond)
+
+	c, err := NewCluster(master.openDB(""), []*DB{db1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	//the slow query is not run again on the master
+	if _, err := c.Query("select sleep(1)"); err != ErrQueryTimeout {
+		t.Fatal(err)
+	} else if c.ReplicaDown(0) {
+		t.Fatal("r1 down")
+	} else if n := countQueries(master, "select sleep(1)"); n != 0 {
+		t.Fatal(n)
+	}
+	if host := clusterHost(t, c.Query); host != "r1" {
+		t.Fatal(host)
+	}
+}
+
+func TestCluster_AllReplicasDown(t *testing.T) {
+	master, r1 := newClusterServer("master"), newClusterServer("r1")
+	defer master.Close()
+	defer r1.Close()
+
+	down := int32(1)
+	db1 := r1.openDB("")
+	db1.SetDialer(downDialer(r1, &down))
+
+	c, err := NewCluster(master.openDB(""), []*DB{db1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if host := clusterHost(t, c.Query); host != "master" || !c.ReplicaDown(0) {
+		t.Fatal(host)
+	}
+
+	if _, err := NewCluster(nil, nil); err == nil {
+		t.Fatal("cluster without a master")
+	}
+}
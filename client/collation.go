@@ -0,0 +1,73 @@
+package client
+
+import (
+	"fmt"
+	. "github.com/siddontang/mixer/mysql"
+)
+
+// SetCollation sets the charset of collation and collation by set names,
+// like utf8mb4_unicode_ci for utf8mb4 with its unicode collation. A
+// charset or a collation the server does not support is an error wrapping
+// its *SqlError.
+func (c *Conn) SetCollation(collation string) error {
+	id, ok := CollationNames[collation]
+	if !ok {
+		return fmt.Errorf("invalid collation %s", collation)
+	}
+
+	charset := collationCharset(uint16(id))
+	if c.charset == charset && c.collation == id {
+		return nil
+	}
+	return c.setNames(charset, id)
+}
+
+// GetCollation returns the collation of the conn.
+func (c *Conn) GetCollation() string {
+	return Collations[c.collation]
+}
+
+// setNames sets charset and collation, the default collation of charset
+// is not named
+func (c *Conn) setNames(charset string, collation CollationId) error {
+	query := "set names " + charset
+	if collation != CharsetIds[charset] {
+		query += " collate " + Collations[collation]
+	}
+
+	if _, err := c.exec(query); err != nil {
+		if e, ok := err.(*SqlError); ok && (e.Code == ER_UNKNOWN_CHARACTER_SET || e.Code == ER_UNKNOWN_COLLATION) {
+			return fmt.Errorf("server does not support %s: %w", query[len("set names "):], err)
+		}
+		return err
+	}
+
+	c.charset = charset
+	c.collation = collation
+	return nil
+}
+
+// useCharset sets charset and collation if the conn has others
+func (c *Conn) useCharset(charset string, collation CollationId) error {
+	if c.charset == charset && c.collation == collation {
+		return nil
+	}
+	return c.setNames(charset, collation)
+}
+
+// SetCollation sets the charset of collation and collation for every
+// conn as SetCharset does, like utf8mb4_unicode_ci. A collation the server
+// does not support fails the dial of a conn. SetCharset sets the default
+// collation of its charset again.
+func (db *DB) SetCollation(collation string) error {
+	id, ok := CollationNames[collation]
+	if !ok {
+		return fmt.Errorf("invalid collation %s", collation)
+	}
+
+	db.Lock()
+	db.charset = collationCharset(uint16(id))
+	db.collation = id
+	db.Unlock()
+	return nil
+}
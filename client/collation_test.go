@@ -0,0 +1,87 @@
+package client
+
+import (
+	"errors"
+	. "github.com/siddontang/mixer/mysql"
+	"testing"
+)
+
+func TestDB_SetCollation(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	defer db.Close()
+
+	if err := db.SetCollation("klingon_ci"); err == nil {
+		t.Fatal("invalid collation accepted")
+	}
+	if err := db.SetCollation("utf8mb4_unicode_ci"); err != nil {
+		t.Fatal(err)
+	}
+
+	co := popTestConn(t, db)
+	if co.GetCharset() != "utf8mb4" || co.GetCollation() != "utf8mb4_unicode_ci" {
+		t.Fatal(co.GetCharset(), co.GetCollation())
+	}
+
+	//changed by the borrower, restored when reused
+	if err := co.SetCharset("utf8mb4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := co.SetCollation("utf8mb4_bin"); err != nil {
+		t.Fatal(err)
+	}
+	db.PushConn(co, nil)
+
+	co = popTestConn(t, db)
+	if co.GetCollation() != "utf8mb4_unicode_ci" {
+		t.Fatal(co.GetCollation())
+	}
+	db.PushConn(co, nil)
+
+	if n := countQueries(s, "set names utf8mb4 collate utf8mb4_unicode_ci"); n != 2 {
+		t.Fatal(n)
+	}
+	if _, dials, _ := s.stats(); dials != 1 {
+		t.Fatal(dials)
+	}
+
+	//the default collation of the charset again
+	db.SetCharset("utf8mb4")
+	co = popTestConn(t, db)
+	if co.GetCollation() != "utf8mb4_general_ci" {
+		t.Fatal(co.GetCollation())
+	}
+	db.PushConn(co, nil)
+	if n := countQueries(s, "set names utf8mb4"); n != 1 {
+		t.Fatal(n)
+	}
+}
+
+func TestDB_SetCollationUnsupported(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+	s.onQuery("set names utf8mb4 collate utf8mb4_0900_ai_ci").err(ER_UNKNOWN_COLLATION, "Unknown collation: 'utf8mb4_0900_ai_ci'")
+
+	db := s.openDB("")
+	defer db.Close()
+
+	//of MySQL 8.0 only
+	if err := db.SetCollation("utf8mb4_0900_ai_ci"); err != nil {
+		t.Fatal(err)
+	}
+
+	var e *SqlError
+	_, err := db.PopConn()
+	if !errors.As(err, &e) || e.Code != ER_UNKNOWN_COLLATION {
+		t.Fatal(err)
+	}
+	if err.Error() != "server does not support utf8mb4 collate utf8mb4_0900_ai_ci: "+e.Error() {
+		t.Fatal(err)
+	}
+	if st := db.Stats(); st.OpenConns != 0 {
+		t.Fatalf("%+v", st)
+	}
+}
@@ -2,17 +2,22 @@ package client
 
 import (
 	"bytes"
+	"container/list"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	. "github.com/siddontang/mixer/mysql"
 	"net"
+	"runtime"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 var (
-	pingPeriod = int64(time.Second * 30)
+	//in seconds, as lastPing
+	pingPeriod = int64(30)
 )
 
 //proxy <-> mysql server
@@ -28,6 +33,9 @@ type Conn struct {
 
 	capability uint32
 
+	//server thread id of the connection
+	connectionId uint32
+
 	status uint16
 
 	collation CollationId
@@ -36,9 +44,97 @@ type Conn struct {
 
 	lastPing int64
 
+	//of the handshake, like 8.0.33 or 5.5.5-10.6.12-MariaDB
+	serverVersion string
+	//the server answered a reset by ER_UNKNOWN_COM_ERROR
+	noReset bool
+
+	//connected, and last checked out or returned by the pool
+	created  time.Time
+	lastUsed time.Time
+
+	queryTimeout time.Duration
+	deadline     time.Time
+
+	//the handshake is upgraded to TLS by it if set, see SetTLSConfig
+	tlsConfig *tls.Config
+
+	//bound every packet read and write, see SetReadTimeout
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	//1 after interrupted by ExecuteContext, a packet deadline must not
+	//undo the interrupting one
+	interrupted int32
+
+	//kills the statement running over stmtTimeout, see SetStatementTimeout
+	stmtTimeout time.Duration
+	kill        func(connectionId uint32) error
+	watchdog    *time.Timer
+	killDone    chan struct{}
+	//1 after the watchdog fired
+	killed int32
+
+	//prepared statements, the least recently used at front
+	stmts    *list.List
+	maxStmts int
+
+	//statements marked by Stmt.CloseLater and not closed yet
+	orphanStmts int32
+
+	//1 after Close until reconnected
+	closed int32
+
+	//@@read_only or @@super_read_only of the server when last checked
+	readOnly bool
+
+	//of the server, 0 if unknown, see MaxAllowedPacket
+	maxAllowedPacket int
+	//max_allowed_packet is not read yet, set once connected
+	fetchPacketLimit bool
+
+	//pool generation when connected, see DB.checkRestart
+	generation uint64
+
+	//holds a token of it while popped from the pool, see DB.SetLimiter
+	limiter *Limiter
+
+	//track the gtids of committed transactions, see SetTrackGTIDs
+	trackGTIDs bool
+	gtid       string
+
+	//decodes the cells of result sets if set
+	converter TypeConverter
+
+	//text from and to the connection charset is UTF-8, see SetTranscode
+	transcode bool
+
+	//statements of Execute with args are kept, see SetStmtCache
+	stmtCache bool
+
+	//ask for CLIENT_OPTIONAL_RESULTSET_METADATA, see SetOptionalMetadata
+	optionalMetadata bool
+
+	//ask for CLIENT_MULTI_STATEMENTS, see SetMultiStatements
+	multiStatements bool
+
+	//see SetDialer and SetDialTimeout
+	dial        Dialer
+	dialTimeout time.Duration
+
+	//chaos tests only, see DB.SetFaultInjector
+	faults FaultInjector
+
+	//see DB.SetMetrics
+	metrics MetricsCollector
+
+	//a packet of the running statement was written, see CommandSent
+	sent bool
+
 	pkgErr error
 }
 
+// Connect connects to addr, a host:port, the path of a unix socket, or
+// either with a tcp:// or unix:// prefix forcing the network.
 func (c *Conn) Connect(addr string, user string, password string, db string) error {
 	c.addr = addr
 	c.user = user
@@ -57,24 +153,43 @@ func (c *Conn) ReConnect() error {
 		c.conn.Close()
 	}
 
-	n := "tcp"
-	if strings.Contains(c.addr, "/") {
-		n = "unix"
+	//nothing is read during the handshake
+	c.maxAllowedPacket = 0
+	c.fetchPacketLimit = false
+
+	if err := c.inject(FaultDial); err != nil {
+		return err
 	}
 
-	netConn, err := net.Dial(n, c.addr)
+	netConn, err := c.dialNet()
 	if err != nil {
 		return err
 	}
 
 	c.conn = netConn
 	c.pkg = NewPacketIO(netConn)
+	atomic.StoreInt32(&c.interrupted, 0)
+
+	if err := c.inject(FaultHandshake); err != nil {
+		c.conn.Close()
+		return err
+	}
+
+	//statements are released by server with the old connection
+	c.resetStmts()
 
 	if err := c.readInitialHandshake(); err != nil {
 		c.conn.Close()
 		return err
 	}
 
+	if c.tlsConfig != nil {
+		if err := c.upgradeTLS(); err != nil {
+			c.conn.Close()
+			return err
+		}
+	}
+
 	if err := c.writeAuthHandshake(); err != nil {
 		c.conn.Close()
 
@@ -96,28 +211,167 @@ func (c *Conn) ReConnect() error {
 		}
 	}
 
-	c.lastPing = time.Now().Unix()
+	if c.capability&CLIENT_SESSION_TRACK > 0 {
+		if _, err := c.exec("set session session_track_gtids = OWN_GTID"); err != nil {
+			c.conn.Close()
+
+			return err
+		}
+	}
+
+	c.fetchPacketLimit = true
+
+	c.created = time.Now()
+	c.lastPing = c.created.Unix()
+
+	atomic.StoreInt32(&c.closed, 0)
 
 	return nil
 }
 
 func (c *Conn) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+
 	if c.conn != nil {
 		c.conn.Close()
 		c.conn = nil
 	}
 
+	//held statements must not reference the dead connection
+	c.resetStmts()
+
 	return nil
 }
 
+// IsClosed returns true if the connection is closed and not reconnected,
+// it is safe to call from other goroutines.
+func (c *Conn) IsClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+// SetDeadline sets the read and write deadline of the underlying connection,
+// it can be called from another goroutine to abort a running query.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// SetQueryTimeout bounds the time sending every query and waiting for its
+// response, a timed out query returns ErrQueryTimeout and the connection
+// can not be used again.
+func (c *Conn) SetQueryTimeout(d time.Duration) {
+	c.queryTimeout = d
+}
+
+// SetTrackGTIDs makes the server send the gtids committed by every
+// statement in its ok packet, from the next connect. A server without
+// session tracking, before MySQL 5.7, sends no gtids.
+func (c *Conn) SetTrackGTIDs(on bool) {
+	c.trackGTIDs = on
+}
+
+// LastGTID returns the gtid set committed by the last statement, empty if
+// it committed nothing or gtids are not tracked.
+func (c *Conn) LastGTID() string {
+	return c.gtid
+}
+
+// WaitGTID waits until the server has executed the gtid set, it returns
+// false if the server has not after timeout.
+func (c *Conn) WaitGTID(gtid string, timeout time.Duration) (bool, error) {
+	r, err := c.exec(fmt.Sprintf("select wait_for_executed_gtid_set('%s', %.3f)",
+		Escape(gtid), timeout.Seconds()))
+	if err != nil {
+		return false, err
+	}
+
+	v, err := r.GetInt(0, 0)
+	if err != nil {
+		return false, err
+	}
+	return v == 0, nil
+}
+
+func (c *Conn) armTimeout() {
+	c.sent = false
+	c.armWatchdog()
+
+	if c.queryTimeout > 0 {
+		c.deadline = time.Now().Add(c.queryTimeout)
+		c.conn.SetReadDeadline(c.deadline)
+		c.conn.SetWriteDeadline(c.deadline)
+	}
+}
+
+func (c *Conn) disarmTimeout() {
+	c.disarmWatchdog()
+
+	if !c.deadline.IsZero() {
+		c.deadline = time.Time{}
+		if c.conn != nil {
+			c.conn.SetReadDeadline(c.deadline)
+			c.conn.SetWriteDeadline(c.deadline)
+		}
+	}
+}
+
+// inject runs the fault injector at p, a fault at the write and read
+// points breaks the conn like a network error
+func (c *Conn) inject(p FaultPoint) error {
+	if c.faults == nil {
+		return nil
+	}
+
+	err := c.faults.Inject(p)
+	if err != nil && p != FaultDial && p != FaultHandshake {
+		c.conn.Close()
+		err = ErrBadConn
+	}
+	return err
+}
+
 func (c *Conn) readPacket() ([]byte, error) {
+	if err := c.inject(FaultRead); err != nil {
+		c.pkgErr = err
+		return nil, err
+	}
+
+	start := c.armReadTimeout()
 	d, err := c.pkg.ReadPacket()
+	if err == nil && c.metrics != nil {
+		c.metrics.BytesRead(packetBytes(len(d)))
+	}
+	if err != nil && !c.deadline.IsZero() && !time.Now().Before(c.deadline) {
+		//protocol stream is in an unknown state now
+		err = ErrQueryTimeout
+	} else if err != nil && c.readTimeout > 0 && time.Now().Sub(start) >= c.readTimeout {
+		err = ErrReadTimeout
+	}
 	c.pkgErr = err
 	return d, err
 }
 
 func (c *Conn) writePacket(data []byte) error {
-	err := c.pkg.WritePacket(data)
+	//nothing is written, the conn can be used again
+	if err := c.checkPacketSize(data); err != nil {
+		return err
+	}
+
+	err := c.inject(FaultBeforeWrite)
+	if err == nil {
+		start := c.armWriteTimeout()
+		if err = c.pkg.WritePacket(data); err == nil {
+			c.sent = true
+			if c.metrics != nil {
+				c.metrics.BytesWritten(packetBytes(len(data) - 4))
+			}
+			err = c.inject(FaultAfterWrite)
+		} else if !c.deadline.IsZero() && !time.Now().Before(c.deadline) {
+			err = ErrQueryTimeout
+		} else if c.writeTimeout > 0 && time.Now().Sub(start) >= c.writeTimeout {
+			//the server may have got a part of the packet
+			err = ErrWriteTimeout
+		}
+	}
 	c.pkgErr = err
 	return err
 }
@@ -136,12 +390,17 @@ func (c *Conn) readInitialHandshake() error {
 		return fmt.Errorf("invalid protocol version %d, must >= 10", data[0])
 	}
 
-	//skip mysql version and connection id
 	//mysql version end with 0x00
+	pos := 1 + bytes.IndexByte(data[1:], 0x00) + 1
+	c.serverVersion = string(data[1 : pos-1])
+	c.noReset = false
+
 	//connection id length is 4
-	pos := 1 + bytes.IndexByte(data[1:], 0x00) + 1 + 4
+	c.connectionId = binary.LittleEndian.Uint32(data[pos : pos+4])
+	pos += 4
 
-	c.salt = append(c.salt, data[pos:pos+8]...)
+	//a reconnect gets a new salt
+	c.salt = append(c.salt[:0], data[pos:pos+8]...)
 
 	//skip filter
 	pos += 8 + 1
@@ -177,12 +436,30 @@ func (c *Conn) readInitialHandshake() error {
 	return nil
 }
 
-func (c *Conn) writeAuthHandshake() error {
+// clientCapability returns the capability flags of the handshake response
+// supported by the server
+func (c *Conn) clientCapability() uint32 {
 	// Adjust client capability flags based on server support
 	capability := CLIENT_PROTOCOL_41 | CLIENT_SECURE_CONNECTION |
 		CLIENT_LONG_PASSWORD | CLIENT_TRANSACTIONS | CLIENT_LONG_FLAG
+	if c.trackGTIDs {
+		capability |= CLIENT_SESSION_TRACK
+	}
+	if c.optionalMetadata {
+		capability |= CLIENT_OPTIONAL_RESULTSET_METADATA
+	}
+	if c.multiStatements {
+		capability |= CLIENT_MULTI_STATEMENTS | CLIENT_MULTI_RESULTS
+	}
+	if c.tlsConfig != nil {
+		capability |= CLIENT_SSL
+	}
 
-	capability &= c.capability
+	return capability & c.capability
+}
+
+func (c *Conn) writeAuthHandshake() error {
+	capability := c.clientCapability()
 
 	//packet length
 	//capbility 4
@@ -319,14 +596,11 @@ func (c *Conn) writeCommandStrStr(command byte, arg1 string, arg2 string) error
 }
 
 func (c *Conn) Ping() error {
+	c.sent = false
 	n := time.Now().Unix()
 
 	if n-c.lastPing > pingPeriod {
-		if err := c.writeCommand(COM_PING); err != nil {
-			return err
-		}
-
-		if _, err := c.readOK(); err != nil {
+		if err := c.ping(); err != nil {
 			return err
 		}
 	}
@@ -336,7 +610,18 @@ func (c *Conn) Ping() error {
 	return nil
 }
 
+// ping sends a COM_PING regardless of the last one
+func (c *Conn) ping() error {
+	if err := c.writeCommand(COM_PING); err != nil {
+		return err
+	}
+
+	_, err := c.readOK()
+	return err
+}
+
 func (c *Conn) UseDB(dbName string) error {
+	c.sent = false
 	if c.db == dbName {
 		return nil
 	}
@@ -353,13 +638,37 @@ func (c *Conn) UseDB(dbName string) error {
 	return nil
 }
 
+// CommandSent is true if the last statement, or Ping or UseDB, wrote a
+// packet to the server, its failure may then have been executed there. A
+// statement failed before is not executed and can be tried again.
+func (c *Conn) CommandSent() bool {
+	return c.sent
+}
+
+// ConnectionId returns the server thread id of the connection.
+func (c *Conn) ConnectionId() uint32 {
+	return c.connectionId
+}
+
+// ServerVersion returns the server version of the handshake.
+func (c *Conn) ServerVersion() string {
+	return c.serverVersion
+}
+
 func (c *Conn) GetDB() string {
 	return c.db
 }
 
-func (c *Conn) Execute(command string, args ...interface{}) (*Result, error) {
+// Execute executes command by COM_QUERY, or by a prepared statement
+// binding args if given, the statement is closed after executed unless
+// cached, see SetStmtCache.
+func (c *Conn) Execute(command string, args ...interface{}) (_ *Result, err error) {
+	defer c.recoverPanic(&err)
+
 	if len(args) == 0 {
 		return c.exec(command)
+	} else if c.stmtCache {
+		return c.executeCached(command, args)
 	} else {
 		if s, err := c.Prepare(command); err != nil {
 			return nil, err
@@ -372,18 +681,24 @@ func (c *Conn) Execute(command string, args ...interface{}) (*Result, error) {
 	}
 }
 
-func (c *Conn) Begin() error {
-	_, err := c.exec("begin")
+func (c *Conn) Begin() (err error) {
+	defer c.recoverPanic(&err)
+
+	_, err = c.exec("begin")
 	return err
 }
 
-func (c *Conn) Commit() error {
-	_, err := c.exec("commit")
+func (c *Conn) Commit() (err error) {
+	defer c.recoverPanic(&err)
+
+	_, err = c.exec("commit")
 	return err
 }
 
-func (c *Conn) Rollback() error {
-	_, err := c.exec("rollback")
+func (c *Conn) Rollback() (err error) {
+	defer c.recoverPanic(&err)
+
+	_, err = c.exec("rollback")
 	return err
 }
 
@@ -398,12 +713,7 @@ func (c *Conn) SetCharset(charset string) error {
 		return fmt.Errorf("invalid charset %s", charset)
 	}
 
-	if _, err := c.exec(fmt.Sprintf("set names %s", charset)); err != nil {
-		return err
-	} else {
-		c.collation = cid
-		return nil
-	}
+	return c.setNames(charset, cid)
 }
 
 func (c *Conn) FieldList(table string, wildcard string) ([]*Field, error) {
@@ -441,14 +751,62 @@ func (c *Conn) FieldList(table string, wildcard string) ([]*Field, error) {
 }
 
 func (c *Conn) exec(query string) (*Result, error) {
+	c.armTimeout()
+	defer c.disarmTimeout()
+
 	if err := c.writeCommandStr(COM_QUERY, query); err != nil {
 		return nil, err
 	}
 
-	return c.readResult(false)
+	r, err := c.readResult(false)
+	if err == nil && c.status&SERVER_MORE_RESULTS_EXISTS > 0 {
+		//the statements after the first of a multi statement
+		if err = c.discardMoreResults(); err != nil {
+			if r.Resultset != nil {
+				r.Release()
+			}
+			return nil, err
+		}
+	}
+	if err == nil {
+		//a use statement changes the default db as COM_INIT_DB does
+		if db, ok := parseUseDB(query); ok {
+			c.db = db
+		}
+	}
+	return r, err
 }
 
-func (c *Conn) readResultset(data []byte, binary bool) (*Result, error) {
+// parseUseDB returns the db of a "use db" statement.
+func parseUseDB(query string) (string, bool) {
+	query = strings.TrimSpace(query)
+	query = strings.TrimRight(query, "; \t\r\n")
+
+	if len(query) < 4 || !strings.EqualFold(query[0:3], "use") {
+		return "", false
+	}
+
+	if c := query[3]; c != ' ' && c != '\t' && c != '\r' && c != '\n' && c != '`' {
+		return "", false
+	}
+
+	db := strings.TrimSpace(query[3:])
+	if len(db) >= 2 && db[0] == '`' && db[len(db)-1] == '`' {
+		db = strings.Replace(db[1:len(db)-1], "``", "`", -1)
+	} else if strings.IndexAny(db, " \t\r\n") != -1 {
+		return "", false
+	}
+
+	if len(db) == 0 {
+		return "", false
+	}
+	return db, true
+}
+
+// readResultsetColumns reads the columns of a resultset, meta caches the
+// columns of a prepared statement, the resultset has them if the server
+// skips them. The rows are left to be read.
+func (c *Conn) readResultsetColumns(data []byte, meta *[]*Field) (*Result, error) {
 	result := &Result{
 		Status:       0,
 		InsertId:     0,
@@ -458,21 +816,33 @@ func (c *Conn) readResultset(data []byte, binary bool) (*Result, error) {
 	}
 
 	// column count
-	count, _, n := LengthEncodedInt(data)
-
-	if n-len(data) != 0 {
-		return nil, ErrMalformPacket
+	count, metadata, err := c.resultsetMetadata(data)
+	if err != nil {
+		return nil, err
 	}
 
-	result.Fields = make([]*Field, count)
-	result.FieldNames = make(map[string]int, count)
+	if metadata == RESULTSET_METADATA_NONE {
+		if meta == nil || len(*meta) != int(count) {
+			return nil, c.drainRows(ErrNoMetadata)
+		}
 
-	if err := c.readResultColumns(result); err != nil {
-		return nil, err
-	}
+		result.Fields = cloneFields(*meta)
+		result.FieldNames = make(map[string]int, count)
+		for i, f := range result.Fields {
+			result.FieldNames[string(f.Name)] = i
+		}
+	} else {
+		result.Fields = make([]*Field, count)
+		result.FieldNames = make(map[string]int, count)
 
-	if err := c.readResultRows(result, binary); err != nil {
-		return nil, err
+		if err := c.readResultColumns(result); err != nil {
+			return nil, err
+		}
+
+		//before the rows change them, like transcoding
+		if meta != nil {
+			*meta = cloneFields(result.Fields)
+		}
 	}
 
 	return result, nil
@@ -517,11 +887,13 @@ func (c *Conn) readResultColumns(result *Result) (err error) {
 
 func (c *Conn) readResultRows(result *Result, isBinary bool) (err error) {
 	var data []byte
+	var held int64
 
 	for {
 		data, err = c.readPacket()
 
 		if err != nil {
+			resultMemory.release(held)
 			return
 		}
 
@@ -537,15 +909,51 @@ func (c *Conn) readResultRows(result *Result, isBinary bool) (err error) {
 			break
 		}
 
+		if err = resultMemory.acquire(int64(len(data)), held > 0); err != nil {
+			resultMemory.release(held)
+			//drain the rows to keep the connection usable
+			if e := c.readUntilEOF(); e != nil {
+				return e
+			}
+			return
+		}
+		held += int64(len(data))
+
 		result.RowDatas = append(result.RowDatas, data)
 	}
 
+	if held > 0 {
+		var released int32
+		result.OnRelease(func() {
+			if atomic.CompareAndSwapInt32(&released, 0, 1) {
+				resultMemory.release(held)
+			}
+		})
+		//for the result sets never released
+		runtime.SetFinalizer(result.Resultset, (*Resultset).Release)
+	}
+
+	if c.transcode {
+		if err = c.transcodeRows(result, isBinary); err != nil {
+			result.Release()
+			return err
+		}
+	}
+
 	result.Values = make([][]interface{}, len(result.RowDatas))
 
 	for i := range result.Values {
 		result.Values[i], err = result.RowDatas[i].Parse(result.Fields, isBinary)
 
 		if err != nil {
+			result.Release()
+			return err
+		}
+	}
+
+	if c.converter != nil {
+		if err = c.convertValues(result, isBinary); err != nil {
+			result.Release()
 			return err
 		}
 	}
@@ -593,17 +1001,67 @@ func (c *Conn) handleOKPacket(data []byte) (*Result, error) {
 
 		//todo:strict_mode, check warnings as error
 		//Warnings := binary.LittleEndian.Uint16(data[pos:])
-		//pos += 2
+		pos += 2
 	} else if c.capability&CLIENT_TRANSACTIONS > 0 {
 		r.Status = binary.LittleEndian.Uint16(data[pos:])
 		c.status = r.Status
 		pos += 2
 	}
 
-	//info
+	if c.capability&CLIENT_SESSION_TRACK > 0 {
+		//info and session state are length encoded
+		if pos < len(data) {
+			info, _, n, err := LengthEnodedString(data[pos:])
+			if err != nil {
+				return nil, ErrMalformPacket
+			}
+			r.SetInfo(string(info))
+			pos += n
+		}
+
+		if r.Status&SERVER_SESSION_STATE_CHANGED > 0 && pos < len(data) {
+			state, _, _, err := LengthEnodedString(data[pos:])
+			if err != nil {
+				return nil, ErrMalformPacket
+			}
+			gtid, err := parseSessionGTID(state)
+			if err != nil {
+				return nil, err
+			}
+			r.SetGTID(gtid)
+		}
+
+		c.gtid = r.GTID()
+	} else if pos < len(data) {
+		//info, the rest of the packet
+		r.SetInfo(string(data[pos:]))
+	}
+
 	return r, nil
 }
 
+// parseSessionGTID returns the gtids in the session state changes
+func parseSessionGTID(state []byte) (string, error) {
+	for len(state) > 0 {
+		typ := state[0]
+		data, _, n, err := LengthEnodedString(state[1:])
+		if err != nil {
+			return "", ErrMalformPacket
+		}
+		state = state[1+n:]
+
+		//encoding specification, then the gtid set
+		if typ == SESSION_TRACK_GTIDS && len(data) > 1 {
+			gtid, _, _, err := LengthEnodedString(data[1:])
+			if err != nil {
+				return "", ErrMalformPacket
+			}
+			return string(gtid), nil
+		}
+	}
+	return "", nil
+}
+
 func (c *Conn) handleErrorPacket(data []byte) error {
 	e := new(SqlError)
 
@@ -621,7 +1079,7 @@ func (c *Conn) handleErrorPacket(data []byte) error {
 
 	e.Message = string(data[pos:])
 
-	return e
+	return c.timeoutError(e)
 }
 
 func (c *Conn) readOK() (*Result, error) {
@@ -640,6 +1098,26 @@ func (c *Conn) readOK() (*Result, error) {
 }
 
 func (c *Conn) readResult(binary bool) (*Result, error) {
+	return c.readStmtResult(binary, nil)
+}
+
+// readStmtResult is readResult caching the resultset columns in meta, see
+// readResultsetColumns
+func (c *Conn) readStmtResult(binary bool, meta *[]*Field) (*Result, error) {
+	result, err := c.readResultHeader(meta)
+	if err != nil || result.Resultset == nil {
+		return result, err
+	}
+
+	if err := c.readResultRows(result, binary); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// readResultHeader reads an ok packet, or the columns of a resultset
+// leaving the rows to be read
+func (c *Conn) readResultHeader(meta *[]*Field) (*Result, error) {
 	data, err := c.readPacket()
 	if err != nil {
 		return nil, err
@@ -653,7 +1131,7 @@ func (c *Conn) readResult(binary bool) (*Result, error) {
 		return nil, ErrMalformPacket
 	}
 
-	return c.readResultset(data, binary)
+	return c.readResultsetColumns(data, meta)
 }
 
 func (c *Conn) IsAutoCommit() bool {
@@ -667,3 +1145,34 @@ func (c *Conn) IsInTransaction() bool {
 func (c *Conn) GetCharset() string {
 	return c.charset
 }
+
+// IsReadOnly returns whether the server was read-only when last checked,
+// the pool checks it when connecting.
+func (c *Conn) IsReadOnly() bool {
+	return c.readOnly
+}
+
+// CheckReadOnly fetches @@read_only and @@super_read_only from the server,
+// a server without super_read_only only checks read_only.
+func (c *Conn) CheckReadOnly() (bool, error) {
+	r, err := c.exec("select @@read_only, @@super_read_only")
+	if e, ok := err.(*SqlError); ok && e.Code == ER_UNKNOWN_SYSTEM_VARIABLE {
+		r, err = c.exec("select @@read_only, 0")
+	}
+	if err != nil {
+		return false, err
+	}
+
+	readOnly, err := r.GetInt(0, 0)
+	if err != nil {
+		return false, err
+	}
+
+	superReadOnly, err := r.GetInt(0, 1)
+	if err != nil {
+		return false, err
+	}
+
+	c.readOnly = readOnly != 0 || superReadOnly != 0
+	return c.readOnly, nil
+}
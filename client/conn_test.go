@@ -3,7 +3,11 @@ package client
 import (
 	"fmt"
 	. "github.com/siddontang/mixer/mysql"
+	"net"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func newTestConn() *Conn {
@@ -16,9 +20,114 @@ func newTestConn() *Conn {
 	return c
 }
 
+func newFakeConn(t *testing.T, s *fakeServer) *Conn {
+	c := new(Conn)
+	if err := c.Connect(s.listen(t), "root", "", "mixer"); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
 func TestConn_Connect(t *testing.T) {
-	c := newTestConn()
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	c := newFakeConn(t, s)
+	defer c.Close()
+
+	if c.ConnectionId() != 101 || c.GetDB() != "mixer" {
+		t.Fatal(c.ConnectionId(), c.GetDB())
+	}
+}
+
+func TestConn_Handshake(t *testing.T) {
+	gtid := "3e11fa47-71ca-11e1-9e33-c80aa9429562:23"
+	state := append([]byte{SESSION_TRACK_GTIDS}, PutLengthEncodedString(
+		append([]byte{0}, PutLengthEncodedString([]byte(gtid))...))...)
+	status := SERVER_STATUS_AUTOCOMMIT | SERVER_SESSION_STATE_CHANGED
+	ok := []byte{OK_HEADER, 1, 0, byte(status), byte(status >> 8), 0, 0, 0}
+	ok = append(ok, PutLengthEncodedString(state)...)
+
+	for _, track := range []bool{false, true} {
+		s := newFakeServer(nil)
+		defer s.Close()
+
+		if track {
+			s.capability |= CLIENT_SESSION_TRACK
+		}
+		s.authPlugin = "mysql_native_password"
+		s.onQuery("insert into t values (1)").packets(ok)
+
+		c := new(Conn)
+		c.SetTrackGTIDs(true)
+		if err := c.Connect(s.listen(t), "root", "", "mixer"); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		if negotiated := c.capability&CLIENT_SESSION_TRACK > 0; negotiated != track {
+			t.Fatal(track, c.capability)
+		}
+
+		//only a server tracking session state is asked for gtids, and
+		//max_allowed_packet is not read until needed
+		_, _, queries := s.stats()
+		if track && (len(queries) != 1 || queries[0] != "set session session_track_gtids = OWN_GTID") {
+			t.Fatal(queries)
+		} else if !track && len(queries) != 0 {
+			t.Fatal(queries)
+		}
+
+		if !track {
+			continue
+		}
+		if r, err := c.Execute("insert into t values (1)"); err != nil {
+			t.Fatal(err)
+		} else if r.GTID() != gtid || c.LastGTID() != gtid {
+			t.Fatal(r.GTID())
+		}
+	}
+}
+
+func TestConn_BadServer(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	big := strings.Repeat("x", MaxPayloadLen+10)
+
+	s.onQuery("select 1").times(1).err(ER_UNKNOWN_ERROR, "unknown error")
+	s.onQuery("select big").rows([]string{"b"}, []string{big})
+	//a column count without columns
+	s.onQuery("select malformed").packets([]byte{1}, []byte{0x01, 'x'})
+	s.onQuery("select cut").disconnectAfter(4).rows([]string{"a"}, []string{"1"}, []string{"2"})
+
+	c := newFakeConn(t, s)
+	defer c.Close()
+
+	//a rule used up falls back to the default answer
+	if _, err := c.Execute("select 1"); err == nil {
+		t.Fatal("must error")
+	} else if _, err = c.Execute("select 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if r, err := c.Execute("select big"); err != nil {
+		t.Fatal(err)
+	} else if v, _ := r.GetString(0, 0); v != big {
+		t.Fatal(len(v))
+	}
+
+	if _, err := c.Execute("select malformed"); err == nil {
+		t.Fatal("must error")
+	}
+
+	c = newFakeConn(t, s)
 	defer c.Close()
+
+	//the conn is dropped after the first row
+	if _, err := c.Execute("select cut"); err != ErrBadConn {
+		t.Fatal(err)
+	}
 }
 
 func TestConn_Ping(t *testing.T) {
@@ -149,3 +258,261 @@ func TestConn_SetCharset(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestConn_QueryTimeout(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	s.onQuery("select sleep(1)").delay(time.Second).rows([]string{"sleep(1)"}, []string{"0"})
+
+	c := newFakeConn(t, s)
+	defer c.Close()
+
+	c.SetQueryTimeout(100 * time.Millisecond)
+
+	if _, err := c.Execute("select 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Execute("select sleep(1)"); err != ErrQueryTimeout {
+		t.Fatal(err)
+	}
+}
+
+func TestConn_RowValues(t *testing.T) {
+	c := newTestConn()
+	defer c.Close()
+
+	if _, err := c.Execute("drop table if exists mixer_test_row_values"); err != nil {
+		t.Fatal(err)
+	}
+
+	s := `CREATE TABLE mixer_test_row_values (
+          i INT,
+          u BIGINT UNSIGNED,
+          y YEAR,
+          f DOUBLE,
+          d DECIMAL(10, 2),
+          dt DATETIME,
+          da DATE,
+          tm TIME,
+          b1 BIT(1),
+          str VARCHAR(32),
+          bin VARBINARY(32),
+          n INT
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8`
+
+	if _, err := c.Execute(s); err != nil {
+		t.Fatal(err)
+	}
+
+	s = `insert into mixer_test_row_values values
+        (-1, 18446744073709551615, 2014, 3.5, 12.34, "2014-09-01 10:20:30", "2014-09-01", "100:00:01", 1, "abc", "xyz", NULL)`
+	if _, err := c.Execute(s); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []interface{}{
+		int64(-1),
+		uint64(18446744073709551615),
+		int64(2014),
+		float64(3.5),
+		"12.34",
+		time.Date(2014, 9, 1, 10, 20, 30, 0, time.UTC),
+		time.Date(2014, 9, 1, 0, 0, 0, 0, time.UTC),
+		"100:00:01",
+		true,
+		"abc",
+		[]byte("xyz"),
+		nil,
+	}
+
+	check := func(r *Result) {
+		vs := r.RowValues(0)
+		if len(vs) != len(expect) {
+			t.Fatal(len(vs))
+		}
+
+		for i := range expect {
+			if !reflect.DeepEqual(vs[i], expect[i]) {
+				t.Fatalf("column %s: %#v != %#v", r.Fields[i].Name, vs[i], expect[i])
+			}
+		}
+
+		if r.RowValues(1) != nil {
+			t.Fatal("must nil for invalid row")
+		}
+	}
+
+	s = `select * from mixer_test_row_values`
+	if r, err := c.Execute(s); err != nil {
+		t.Fatal(err)
+	} else {
+		check(r)
+	}
+
+	//binary protocol
+	s = `select * from mixer_test_row_values where i = ?`
+	if r, err := c.Execute(s, -1); err != nil {
+		t.Fatal(err)
+	} else {
+		check(r)
+	}
+}
+
+func TestConn_JSONNumber(t *testing.T) {
+	c := newTestConn()
+	defer c.Close()
+
+	d := "12345678901234567890.0123456789"
+
+	r, err := c.Execute(fmt.Sprintf("select cast('%s' as decimal(30,10)), 18446744073709551615", d))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := r.GetJSONNumber(0, 0); err != nil {
+		t.Fatal(err)
+	} else if string(n) != d {
+		t.Fatal(n)
+	}
+
+	if n, err := r.GetJSONNumber(0, 1); err != nil {
+		t.Fatal(err)
+	} else if string(n) != "18446744073709551615" {
+		t.Fatal(n)
+	}
+}
+
+func TestConn_OKInfo(t *testing.T) {
+	c := new(Conn)
+	c.capability = CLIENT_PROTOCOL_41
+
+	data := []byte{OK_HEADER, 3, 0, 2, 0, 0, 0}
+	data = append(data, "Records: 3  Duplicates: 1  Warnings: 1"...)
+
+	r, err := c.handleOKPacket(data)
+	if err != nil {
+		t.Fatal(err)
+	} else if r.AffectedRows != 3 {
+		t.Fatal(r.AffectedRows)
+	}
+
+	if records, dups, warns, ok := r.RecordsDuplicatesWarnings(); !ok || records != 3 || dups != 1 || warns != 1 {
+		t.Fatal(r.Info())
+	}
+
+	//no info
+	if r, err = c.handleOKPacket([]byte{OK_HEADER, 0, 0, 2, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	} else if len(r.Info()) != 0 {
+		t.Fatal(r.Info())
+	}
+}
+
+func TestConn_OKSessionGTID(t *testing.T) {
+	c := new(Conn)
+	c.capability = CLIENT_PROTOCOL_41 | CLIENT_SESSION_TRACK
+
+	gtid := "3e11fa47-71ca-11e1-9e33-c80aa9429562:23"
+
+	//a system variable change before the gtids
+	state := append([]byte{SESSION_TRACK_SYSTEM_VARIABLES}, PutLengthEncodedString([]byte("\x0aautocommit\x02ON"))...)
+	gtids := append([]byte{0}, PutLengthEncodedString([]byte(gtid))...)
+	state = append(state, SESSION_TRACK_GTIDS)
+	state = append(state, PutLengthEncodedString(gtids)...)
+
+	status := SERVER_STATUS_AUTOCOMMIT | SERVER_SESSION_STATE_CHANGED
+	data := []byte{OK_HEADER, 1, 0, byte(status), byte(status >> 8), 0, 0}
+	data = append(data, PutLengthEncodedString([]byte("Rows matched: 1  Changed: 1  Warnings: 0"))...)
+	data = append(data, PutLengthEncodedString(state)...)
+
+	r, err := c.handleOKPacket(data)
+	if err != nil {
+		t.Fatal(err)
+	} else if r.GTID() != gtid || c.LastGTID() != gtid {
+		t.Fatal(r.GTID())
+	} else if r.Info() != "Rows matched: 1  Changed: 1  Warnings: 0" {
+		t.Fatal(r.Info())
+	}
+
+	//nothing committed, no state and no info
+	if r, err = c.handleOKPacket([]byte{OK_HEADER, 0, 0, 2, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	} else if len(r.GTID()) != 0 || len(c.LastGTID()) != 0 || len(r.Info()) != 0 {
+		t.Fatal(r.GTID(), r.Info())
+	}
+}
+
+// newBenchConn returns a conn to an in-process server which answers every
+// command by the same response
+func newBenchConn(b *testing.B, packets [][]byte) *Conn {
+	var response []byte
+	for i, p := range packets {
+		response = append(response, byte(len(p)), byte(len(p)>>8), byte(len(p)>>16), byte(i+1))
+		response = append(response, p...)
+	}
+
+	server, cli := net.Pipe()
+
+	c := new(Conn)
+	c.conn = cli
+	c.pkg = NewPacketIO(cli)
+	c.capability = CLIENT_PROTOCOL_41
+
+	go func() {
+		defer server.Close()
+
+		pkg := NewPacketIO(server)
+		for {
+			pkg.Sequence = 0
+			if _, err := pkg.ReadPacket(); err != nil {
+				return
+			}
+			if _, err := server.Write(response); err != nil {
+				return
+			}
+		}
+	}()
+
+	return c
+}
+
+func BenchmarkConn_Query(b *testing.B) {
+	fields := []*Field{
+		&Field{Name: []byte("id"), Type: MYSQL_TYPE_LONGLONG},
+		&Field{Name: []byte("count"), Type: MYSQL_TYPE_LONG},
+		&Field{Name: []byte("name"), Type: MYSQL_TYPE_VAR_STRING},
+		&Field{Name: []byte("email"), Type: MYSQL_TYPE_VAR_STRING},
+		&Field{Name: []byte("score"), Type: MYSQL_TYPE_DOUBLE},
+	}
+
+	eof := []byte{EOF_HEADER, 0, 0, 2, 0}
+	packets := [][]byte{{byte(len(fields))}}
+	for _, f := range fields {
+		packets = append(packets, f.Dump())
+	}
+	packets = append(packets, eof)
+	for i := 0; i < 100; i++ {
+		var row []byte
+		for _, v := range []string{fmt.Sprint(1000000 + i), fmt.Sprint(i), "alice", "alice@example.com", "3.1415"} {
+			row = append(row, PutLengthEncodedString([]byte(v))...)
+		}
+		packets = append(packets, row)
+	}
+	packets = append(packets, eof)
+
+	c := newBenchConn(b, packets)
+	defer c.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		r, err := c.Execute("select id, count, name, email, score from t")
+		if err != nil {
+			b.Fatal(err)
+		}
+		r.Release()
+	}
+}
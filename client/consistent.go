@@ -0,0 +1,113 @@
+package client
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+const defaultVirtualNodes = 160
+
+// ConsistentRouter routes keys to a set of shard pools with a consistent
+// hashing ring, adding or removing a shard only remaps the keys owned
+// by that shard.
+type ConsistentRouter struct {
+	shards []*DB
+
+	//sorted virtual node hashes, owners[i] is the shard index of ring[i]
+	ring   []uint32
+	owners []int
+
+	//shard transactions can only write to one shard
+	singleShardTx bool
+}
+
+// NewConsistentRouter builds the ring with vnodes virtual nodes per shard,
+// vnodes <= 0 uses the default. A shard is placed by its addr and db name,
+// so the same shard always lands on the same ring positions.
+func NewConsistentRouter(shards []*DB, vnodes int) (*ConsistentRouter, error) {
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("consistent router must have at least one shard")
+	}
+
+	if vnodes <= 0 {
+		vnodes = defaultVirtualNodes
+	}
+
+	r := new(ConsistentRouter)
+	r.shards = shards
+
+	points := make(map[uint32]int, len(shards)*vnodes)
+	for i, db := range shards {
+		id := fmt.Sprintf("%s/%s", db.addr, db.db)
+		for j := 0; j < vnodes; j++ {
+			h := hashKey(fmt.Sprintf("%s#%d", id, j))
+			if _, ok := points[h]; ok {
+				//hash collision, keep the first owner
+				continue
+			}
+			points[h] = i
+		}
+	}
+
+	r.ring = make([]uint32, 0, len(points))
+	for h := range points {
+		r.ring = append(r.ring, h)
+	}
+	sort.Sort(uint32Slice(r.ring))
+
+	r.owners = make([]int, len(r.ring))
+	for i, h := range r.ring {
+		r.owners[i] = points[h]
+	}
+
+	return r, nil
+}
+
+// Route returns the pool of the shard owning key
+func (r *ConsistentRouter) Route(key string) *DB {
+	return r.shards[r.routeIndex(key)]
+}
+
+func (r *ConsistentRouter) routeIndex(key string) int {
+	h := hashKey(key)
+
+	i := sort.Search(len(r.ring), func(i int) bool { return r.ring[i] >= h })
+	if i == len(r.ring) {
+		i = 0
+	}
+
+	return r.owners[i]
+}
+
+func (r *ConsistentRouter) Shards() []*DB {
+	return r.shards
+}
+
+// Distribution returns how many of keys each shard owns,
+// used to verify the balance of the ring.
+func (r *ConsistentRouter) Distribution(keys []string) map[*DB]int {
+	m := make(map[*DB]int, len(r.shards))
+	for _, db := range r.shards {
+		m[db] = 0
+	}
+
+	for _, key := range keys {
+		m[r.Route(key)]++
+	}
+
+	return m
+}
+
+func hashKey(key string) uint32 {
+	//crc32 clusters badly for similar keys like "key_1", "key_2", use md5 like ketama
+	sum := md5.Sum([]byte(key))
+	return binary.LittleEndian.Uint32(sum[0:4])
+}
+
+type uint32Slice []uint32
+
+func (s uint32Slice) Len() int           { return len(s) }
+func (s uint32Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint32Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"fmt"
+	"testing"
+)
+
+func newTestConsistentShards(n int) []*DB {
+	shards := make([]*DB, n)
+	for i := range shards {
+		shards[i], _ = Open(fmt.Sprintf("127.0.0.1:%d", 3306+i), "root", "", "mixer")
+	}
+	return shards
+}
+
+func TestConsistentRouter_Distribution(t *testing.T) {
+	shards := newTestConsistentShards(4)
+
+	r, err := NewConsistentRouter(shards, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key_%d", i)
+	}
+
+	d := r.Distribution(keys)
+	for db, n := range d {
+		//expect 2500 for each, allow 30% skew
+		if n < 1750 || n > 3250 {
+			t.Fatal(db.Addr(), n)
+		}
+	}
+
+	for _, key := range keys[0:100] {
+		if r.Route(key) != r.Route(key) {
+			t.Fatal("route must be stable", key)
+		}
+	}
+}
+
+func TestConsistentRouter_AddShard(t *testing.T) {
+	shards := newTestConsistentShards(5)
+
+	r1, err := NewConsistentRouter(shards[0:4], 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r2, err := NewConsistentRouter(shards, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moved := 0
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		db1, db2 := r1.Route(key), r2.Route(key)
+		if db1 != db2 {
+			if db2 != shards[4] {
+				t.Fatal(key, "must only move to the new shard")
+			}
+			moved++
+		}
+	}
+
+	//expect 1/5 keys moved
+	if moved == 0 || moved > 3000 {
+		t.Fatal(moved)
+	}
+
+	if _, err := NewConsistentRouter(nil, 10); err == nil {
+		t.Fatal("must error")
+	}
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"context"
+	. "github.com/siddontang/mixer/mysql"
+	"sync/atomic"
+	"time"
+)
+
+// ExecuteContext is Execute interrupted when ctx is done: the statement
+// is killed by the kill func of SetStatementTimeout if set, the conn is
+// broken and can not be used again, and ctx.Err() is returned.
+func (c *Conn) ExecuteContext(ctx context.Context, command string, args ...interface{}) (*Result, error) {
+	return c.interruptible(ctx, func() (*Result, error) {
+		return c.Execute(command, args...)
+	})
+}
+
+// ExecuteContext is Execute interrupted when ctx is done, see
+// Conn.ExecuteContext.
+func (s *Stmt) ExecuteContext(ctx context.Context, args ...interface{}) (*Result, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.conn.interruptible(ctx, func() (*Result, error) {
+		return s.execute(args...)
+	})
+}
+
+// interruptible runs the statement of f, interrupted when ctx is done
+func (c *Conn) interruptible(ctx context.Context, f func() (*Result, error)) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	} else if ctx.Done() == nil {
+		//never done
+		return f()
+	}
+
+	done := make(chan struct{})
+	id, kill, conn := c.connectionId, c.kill, c.conn
+	stop := context.AfterFunc(ctx, func() {
+		defer close(done)
+
+		//stop the statement on the server, then the wait for its response
+		if kill != nil {
+			kill(id)
+		}
+		atomic.StoreInt32(&c.interrupted, 1)
+		if conn != nil {
+			conn.SetDeadline(time.Unix(1, 0))
+		}
+	})
+
+	r, err := f()
+	if stop() {
+		return r, err
+	}
+
+	<-done
+	if r != nil && r.Resultset != nil {
+		r.Release()
+	}
+	c.conn.Close()
+	c.pkgErr = ErrBadConn
+	return nil, ctx.Err()
+}
+
+// ExecuteContext is Execute ending the wait for a pooled conn and
+// interrupting the statement when ctx is done, the conn of the statement
+// is closed then, see Conn.ExecuteContext.
+func (db *DB) ExecuteContext(ctx context.Context, command string, args ...interface{}) (*Result, error) {
+	return db.execute(ctx, 0, StatementExec, command, args)
+}
+
+// execute runs command on a pooled conn taken with prio, reported to the
+// metrics as a statement of kind
+func (db *DB) execute(ctx context.Context, prio int, kind StatementKind, command string, args []interface{}) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var r *Result
+	err := db.observe(kind, func() error {
+		return db.withPriorityRetry(ctx, prio, func(c *Conn) error {
+			var err error
+			r, err = c.ExecuteContext(ctx, command, args...)
+			return err
+		})
+	})
+	return r, err
+}
+
+// QueryContext is Query interrupted when ctx is done, see ExecuteContext.
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*Resultset, error) {
+	if c := db.getResultCache(); c != nil && cacheableQuery(query) {
+		return db.cachedQuery(ctx, c, query, args)
+	}
+
+	return resultsetOf(db.execute(ctx, 0, StatementQuery, query, args))
+}
+
+// PopConnContext is PopConn ending the wait for a conn of a full pool, or
+// for a limiter token, when ctx is done.
+func (db *DB) PopConnContext(ctx context.Context) (*Conn, error) {
+	return db.popConn(ctx, 0)
+}
+
+// BeginContext is Begin interrupted when ctx is done, see ExecuteContext.
+// Only the begin is bound to ctx, the statements of the transaction are
+// bound by ExecuteContext of the returned conn.
+func (db *DB) BeginContext(ctx context.Context) (*SqlConn, error) {
+	return db.begin(ctx, []string{"begin"})
+}
+
+// begin begins a transaction by stmts on a pooled conn
+func (db *DB) begin(ctx context.Context, stmts []string) (*SqlConn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		co, err := db.popConn(ctx, 0)
+		if err == nil && ctx.Err() != nil {
+			db.PushConn(co, nil)
+			return nil, ctx.Err()
+		} else if err == nil {
+			for _, stmt := range stmts {
+				if _, err = co.ExecuteContext(ctx, stmt); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				return &SqlConn{co, db}, nil
+			}
+			db.PushConn(co, err)
+		}
+
+		//the begin stmts change no data, retried even once sent
+		if !db.shouldRetry(err, attempt, false) {
+			return nil, err
+		}
+		db.countRetry()
+		if err = db.retryWait(ctx, attempt, err); err != nil {
+			return nil, err
+		}
+	}
+}
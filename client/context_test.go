@@ -0,0 +1,172 @@
+package client
+
+import (
+	"context"
+	. "github.com/siddontang/mixer/mysql"
+	"testing"
+	"time"
+)
+
+func TestDB_QueryContext(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	s.onQuery("select sleep(10)").delay(10*time.Second).rows([]string{"sleep"}, []string{"0"})
+	s.onQuery("select 1").rows([]string{"1"}, []string{"1"})
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(2)
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := db.QueryContext(ctx, "select sleep(10)"); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	} else if d := time.Now().Sub(start); d < 20*time.Millisecond || d > time.Second {
+		t.Fatal(d)
+	}
+
+	//killed on the server, and not reused
+	if n := countKills(s); n != 1 {
+		t.Fatal(n)
+	}
+	if st := db.Stats(); st.OpenConns != 0 || st.IdleConns != 0 || st.Failed != 1 {
+		t.Fatalf("%+v", st)
+	}
+
+	//not done
+	if r, err := db.QueryContext(context.Background(), "select 1"); err != nil {
+		t.Fatal(err)
+	} else if v, _ := r.GetInt(0, 0); v != 1 {
+		t.Fatal(v)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	if _, err := db.ExecuteContext(ctx, "select 1"); err != nil {
+		t.Fatal(err)
+	}
+	if st := db.Stats(); st.OpenConns != 1 || st.IdleConns != 1 {
+		t.Fatalf("%+v", st)
+	}
+
+	//done before
+	cancel()
+	_, dials, _ := s.stats()
+	if _, err := db.ExecuteContext(ctx, "select 1"); err != context.Canceled {
+		t.Fatal(err)
+	}
+	if _, err := db.BeginContext(ctx); err != context.Canceled {
+		t.Fatal(err)
+	}
+	if _, n, _ := s.stats(); n != dials {
+		t.Fatal(n, dials)
+	}
+}
+
+func TestConn_ExecuteContext(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	s.onQuery("select sleep(10)").delay(10*time.Second).rows([]string{"sleep"}, []string{"0"})
+
+	db := s.openDB("")
+	defer db.Close()
+
+	//no kill func, the wait is interrupted only
+	co := db.dialConn()
+	if err := co.Connect(db.addr, db.user, db.password, ""); err != nil {
+		t.Fatal(err)
+	}
+	defer co.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	if _, err := co.ExecuteContext(ctx, "select sleep(10)"); err != context.Canceled {
+		t.Fatal(err)
+	}
+	if n := countKills(s); n != 0 {
+		t.Fatal(n)
+	}
+	if _, err := co.Execute("select 1"); err != ErrBadConn {
+		t.Fatal(err)
+	}
+}
+
+func TestDB_ContextPoolWait(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	held := popTestConn(t, db)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := db.ExecuteContext(ctx, "select 1"); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+	if _, err := db.BeginContext(ctx); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+	if st := db.Stats(); st.Waiting != 0 || st.WaitTimeouts != 0 || st.OpenConns != 1 {
+		t.Fatalf("%+v", st)
+	}
+	db.PushConn(held, nil)
+
+	//and of a limiter token
+	l := NewLimiter(1, 0)
+	db.SetLimiter(l)
+	held = popTestConn(t, db)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := db.QueryContext(ctx, "select 1"); err != context.Canceled {
+		t.Fatal(err)
+	}
+	if st := l.Stats(); st.Waiting != 0 || st.InUse != 1 || st.Rejections != 0 {
+		t.Fatalf("%+v", st)
+	}
+	db.PushConn(held, nil)
+}
+
+func TestConn_StmtExecuteContext(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	query := "select sleep(?)"
+	s.on(COM_STMT_EXECUTE, query).delay(10 * time.Second).ok()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Close()
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := stmt.ExecuteContext(ctx, 10); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+	if n := countKills(s); n != 1 {
+		t.Fatal(n)
+	}
+
+	//the state of the transaction is unknown
+	if err := tx.Rollback(); err != ErrBadConn {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"errors"
+	. "github.com/siddontang/mixer/mysql"
+)
+
+// ErrDefaultConversion is returned by a TypeConverter to keep the default
+// decoding of a cell.
+var ErrDefaultConversion = errors.New("use the default conversion")
+
+// ColumnType is the metadata of a result column given to a TypeConverter.
+type ColumnType struct {
+	Schema string
+	Table  string
+	Name   string
+
+	//MYSQL_TYPE_*, with UNSIGNED_FLAG, BINARY_FLAG... in Flag
+	Type    uint8
+	Flag    uint16
+	Charset uint16
+	Length  uint32
+	Decimal uint8
+
+	//raw values are in the binary protocol of prepared statements, see
+	//RowData.Cells
+	BinaryRow bool
+}
+
+// TypeConverter decodes the raw value of a non NULL cell, it returns
+// ErrDefaultConversion to keep the default decoding.
+type TypeConverter func(col ColumnType, raw []byte) (interface{}, error)
+
+func newColumnType(f *Field, binary bool) ColumnType {
+	return ColumnType{
+		Schema:    string(f.Schema),
+		Table:     string(f.Table),
+		Name:      string(f.Name),
+		Type:      f.Type,
+		Flag:      f.Flag,
+		Charset:   f.Charset,
+		Length:    f.ColumnLength,
+		Decimal:   f.Decimal,
+		BinaryRow: binary,
+	}
+}
+
+// SetTypeConverter sets the converter of the cells of read result sets,
+// nil keeps the default decoding.
+func (c *Conn) SetTypeConverter(f TypeConverter) {
+	c.converter = f
+}
+
+// SetTypeConverter sets the converter of the cells read by all conns of
+// the pool, see Conn.SetTypeConverter.
+func (db *DB) SetTypeConverter(f TypeConverter) {
+	db.Lock()
+	db.converter = f
+	db.Unlock()
+}
+
+func (db *DB) typeConverter() TypeConverter {
+	db.Lock()
+	f := db.converter
+	db.Unlock()
+	return f
+}
+
+// convertValues overrides the default decoded values of result by the
+// type converter
+func (c *Conn) convertValues(result *Result, binary bool) error {
+	cols := make([]ColumnType, len(result.Fields))
+	for i, f := range result.Fields {
+		cols[i] = newColumnType(f, binary)
+	}
+
+	for i, row := range result.RowDatas {
+		cells, err := row.Cells(result.Fields, binary)
+		if err != nil {
+			return err
+		}
+
+		for j, raw := range cells {
+			if raw == nil {
+				continue
+			}
+
+			v, err := c.converter(cols[j], raw)
+			if err == ErrDefaultConversion {
+				continue
+			} else if err != nil {
+				return err
+			}
+			result.Values[i][j] = v
+		}
+	}
+
+	return nil
+}
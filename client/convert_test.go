@@ -0,0 +1,57 @@
+package client
+
+import (
+	"fmt"
+	. "github.com/siddontang/mixer/mysql"
+	"reflect"
+	"testing"
+)
+
+type testStatus int
+
+func TestConn_TypeConverter(t *testing.T) {
+	status := &Field{Table: []byte("t"), Name: []byte("status"), Type: MYSQL_TYPE_LONG}
+	name := &Field{Table: []byte("t"), Name: []byte("name"), Type: MYSQL_TYPE_VAR_STRING}
+
+	eof := []byte{EOF_HEADER, 0, 0, 2, 0}
+	row := append(PutLengthEncodedString([]byte("2")), PutLengthEncodedString([]byte("abc"))...)
+	nullRow := append([]byte{0xfb}, PutLengthEncodedString([]byte("def"))...)
+	packets := [][]byte{{2}, status.Dump(), name.Dump(), eof, row, nullRow, eof}
+
+	c := newTestStreamConn(t, packets, packets)
+	defer c.Close()
+
+	var cols []ColumnType
+	c.SetTypeConverter(func(col ColumnType, raw []byte) (interface{}, error) {
+		cols = append(cols, col)
+		if col.Name != "status" {
+			return nil, ErrDefaultConversion
+		}
+
+		var v int
+		if _, err := fmt.Sscan(string(raw), &v); err != nil {
+			return nil, err
+		}
+		return testStatus(v), nil
+	})
+
+	r, err := c.exec("select status, name from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//NULL is not converted
+	expect := [][]interface{}{{testStatus(2), []byte("abc")}, {nil, []byte("def")}}
+	if !reflect.DeepEqual(r.Values, expect) {
+		t.Fatalf("%v", r.Values)
+	} else if len(cols) != 3 || cols[0].Table != "t" || cols[0].Type != MYSQL_TYPE_LONG || cols[0].BinaryRow {
+		t.Fatalf("%+v", cols)
+	}
+
+	c.SetTypeConverter(nil)
+	if r, err = c.exec("select status, name from t"); err != nil {
+		t.Fatal(err)
+	} else if v, _ := r.GetInt(0, 0); v != 2 {
+		t.Fatal(r.Values)
+	}
+}
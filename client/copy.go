@@ -0,0 +1,294 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	. "github.com/siddontang/mixer/mysql"
+	"sync"
+)
+
+// CopyOptions configures CopyTable.
+type CopyOptions struct {
+	// BatchSize is the rows inserted by one statement, 1000 if 0. A batch
+	// is inserted before it reaches the max_allowed_packet of dst too.
+	BatchSize int
+
+	// MaxInFlight is the batches inserted at the same time, reading the
+	// source waits while all are in flight, 1 if 0.
+	MaxInFlight int
+
+	// KeyColumn names a column of the select which orders the rows, the
+	// key of a batch is its value in the last row of the batch.
+	KeyColumn string
+
+	// Copied skips the rows whose key is copied already, it resumes a
+	// failed copy from the key of its CopyError.
+	Copied func(key []byte) bool
+
+	// Ignore inserts by insert ignore. With more than one batch in flight,
+	// batches after the boundary of a failed copy may be inserted too, so
+	// a resumed copy into a table of unique keys ignores them.
+	Ignore bool
+
+	// Progress is called in order after every batch is inserted, with the
+	// rows copied and the key of the batch.
+	Progress func(rows int64, key []byte)
+}
+
+// CopyError is returned by a failed CopyTable, the first Rows rows up to
+// the row of Key are copied.
+type CopyError struct {
+	Rows int64
+	Key  []byte
+
+	Err error
+}
+
+func (e *CopyError) Error() string {
+	return fmt.Sprintf("copy failed after %d rows, key %q: %v", e.Rows, e.Key, e.Err)
+}
+
+// CopyTable streams the rows of selectSQL on src into insertTable on dst
+// by batched multi-row inserts, the columns of the select are inserted by
+// their names. The rows are relayed by QueryTo without buffering the
+// resultset, while the inserts are behind, the source is read no further.
+// An insert is retried as dst retries its statements. It returns the rows
+// copied, an error is a *CopyError.
+func CopyTable(src *DB, dst *DB, selectSQL string, insertTable string, opts CopyOptions) (int64, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	if opts.MaxInFlight <= 0 {
+		opts.MaxInFlight = 1
+	}
+
+	maxSize, err := dst.MaxAllowedPacket()
+	if err != nil {
+		return 0, &CopyError{Err: err}
+	}
+
+	t := &tableCopy{dst: dst, table: insertTable, opts: opts, keyIndex: -1, maxSize: maxSize,
+		inFlight: make(chan struct{}, opts.MaxInFlight), done: make(map[int]copyBatch)}
+
+	co, err := src.PopConn()
+	if err == nil {
+		var s *ResultSummary
+		s, err = co.QueryTo(&rowWriter{fields: t.prepare, row: t.addRow}, selectSQL)
+		src.PushConn(co, err)
+
+		if err == nil && s.Columns == 0 {
+			err = ErrNoResultset
+		} else if err == nil {
+			err = t.flush()
+		}
+	}
+
+	t.wg.Wait()
+
+	t.Lock()
+	defer t.Unlock()
+
+	if err == nil {
+		err = t.err
+	}
+	if err != nil {
+		return t.rows, &CopyError{t.rows, t.key, err}
+	}
+	return t.rows, nil
+}
+
+// tableCopy inserts the rows of the source resultset by batches
+type tableCopy struct {
+	dst   *DB
+	table string
+	opts  CopyOptions
+
+	keyIndex int
+	insert   []byte
+
+	//bytes of an insert statement, the max_allowed_packet of dst, 0 if unknown
+	maxSize int
+
+	//the pending batch
+	values    []byte
+	batchRows int
+	batchKey  []byte
+	seq       int
+
+	inFlight chan struct{}
+	wg       sync.WaitGroup
+
+	sync.Mutex
+
+	//inserted batches after a gap by seq, and the boundary of the
+	//batches inserted in order
+	done map[int]copyBatch
+	next int
+	rows int64
+	key  []byte
+
+	//of the first failed insert
+	err error
+}
+
+type copyBatch struct {
+	rows int
+	key  []byte
+}
+
+// prepare builds the insert of the columns and finds the key column
+func (t *tableCopy) prepare(fields []*Field) error {
+	verb := "insert"
+	if t.opts.Ignore {
+		verb = "insert ignore"
+	}
+	t.insert = append(t.insert, fmt.Sprintf("%s into %s (", verb, t.table)...)
+
+	for i, f := range fields {
+		if i > 0 {
+			t.insert = append(t.insert, ',')
+		}
+		t.insert = append(t.insert, '`')
+		t.insert = append(t.insert, bytes.Replace(f.Name, []byte("`"), []byte("``"), -1)...)
+		t.insert = append(t.insert, '`')
+
+		if string(f.Name) == t.opts.KeyColumn {
+			t.keyIndex = i
+		}
+	}
+	t.insert = append(t.insert, ") values "...)
+
+	if len(t.opts.KeyColumn) > 0 && t.keyIndex < 0 {
+		return fmt.Errorf("key column %s not selected", t.opts.KeyColumn)
+	}
+	return nil
+}
+
+func (t *tableCopy) addRow(cells [][]byte) error {
+	var key []byte
+	if t.keyIndex >= 0 {
+		key = cells[t.keyIndex]
+		if t.opts.Copied != nil && t.opts.Copied(key) {
+			return nil
+		}
+	}
+
+	n := len(t.values)
+	if t.batchRows > 0 {
+		t.values = append(t.values, ',')
+	}
+	t.values = append(t.values, '(')
+	for i, v := range cells {
+		if i > 0 {
+			t.values = append(t.values, ',')
+		}
+		if v == nil {
+			t.values = append(t.values, "NULL"...)
+		} else {
+			t.values = appendQuoted(t.values, v)
+		}
+	}
+	t.values = append(t.values, ')')
+
+	//with the command byte, a row over it is inserted alone and fails
+	if t.maxSize > 0 && t.batchRows > 0 && 1+len(t.insert)+len(t.values) > t.maxSize {
+		row := append([]byte(nil), t.values[n+1:]...)
+		t.values = t.values[:n]
+		if err := t.flush(); err != nil {
+			return err
+		}
+		t.values = append(t.values, row...)
+	}
+
+	t.batchRows++
+	t.batchKey = key
+
+	if t.batchRows >= t.opts.BatchSize {
+		return t.flush()
+	}
+	return nil
+}
+
+// appendQuoted appends v as a quoted string. It escapes by byte, not by
+// rune as Escape does, so binary values are kept as they are.
+func appendQuoted(buf []byte, v []byte) []byte {
+	buf = append(buf, '\'')
+	for _, b := range v {
+		if c := EncodeMap[b]; c == DONTESCAPE {
+			buf = append(buf, b)
+		} else {
+			buf = append(buf, '\\', c)
+		}
+	}
+	return append(buf, '\'')
+}
+
+// flush inserts the pending batch once a batch is out of flight, it
+// returns the error of any failed insert to stop the copy
+func (t *tableCopy) flush() error {
+	if t.batchRows == 0 {
+		return t.failed()
+	}
+
+	query := string(append(t.insert, t.values...))
+	seq, b := t.seq, copyBatch{t.batchRows, t.batchKey}
+
+	t.seq++
+	t.values = t.values[:0]
+	t.batchRows = 0
+	t.batchKey = nil
+
+	//a batch in flight may fail while waiting
+	t.inFlight <- struct{}{}
+	if err := t.failed(); err != nil {
+		<-t.inFlight
+		return err
+	}
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+
+		_, err := t.dst.Execute(query)
+		t.inserted(seq, b, err)
+
+		<-t.inFlight
+	}()
+
+	return nil
+}
+
+func (t *tableCopy) failed() error {
+	t.Lock()
+	defer t.Unlock()
+	return t.err
+}
+
+// inserted moves the boundary over the batches inserted in order
+func (t *tableCopy) inserted(seq int, b copyBatch, err error) {
+	t.Lock()
+	defer t.Unlock()
+
+	if err != nil {
+		if t.err == nil {
+			t.err = err
+		}
+		return
+	}
+
+	t.done[seq] = b
+	for {
+		b, ok := t.done[t.next]
+		if !ok {
+			return
+		}
+		delete(t.done, t.next)
+		t.next++
+
+		t.rows += int64(b.rows)
+		t.key = b.key
+		if t.opts.Progress != nil {
+			t.opts.Progress(t.rows, t.key)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package client
+
+import (
+	"encoding/hex"
+	"fmt"
+	. "github.com/siddontang/mixer/mysql"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// copyTestRows generates the rows of a table of id and name, names
+// need escaping and every 10th is NULL
+func copyTestRows(n int) [][][]byte {
+	rows := make([][][]byte, n)
+	for i := range rows {
+		var name []byte
+		if (i+1)%10 != 0 {
+			name = []byte(fmt.Sprintf("name %d 'q' \"d\" \\ \n 中文 ħ", i+1))
+		}
+		rows[i] = [][]byte{[]byte(strconv.Itoa(i + 1)), name}
+	}
+	return rows
+}
+
+// parseTestValues parses the rows of a multi-row insert of quoted, hex
+// and number values, nil for NULL
+func parseTestValues(values string) ([][][]byte, error) {
+	unescape := make(map[byte]byte)
+	for b, c := range EncodeMap {
+		if c != DONTESCAPE {
+			unescape[c] = byte(b)
+		}
+	}
+
+	var rows [][][]byte
+	var row [][]byte
+	for i := 0; i < len(values); i++ {
+		switch c := values[i]; {
+		case c == '(' || c == ',' || c == ' ':
+		case c == ')':
+			rows = append(rows, row)
+			row = nil
+		case strings.HasPrefix(values[i:], "NULL"):
+			row = append(row, nil)
+			i += 3
+		case c == '\'':
+			v := []byte{}
+			for i++; values[i] != '\''; i++ {
+				if values[i] == '\\' {
+					i++
+					v = append(v, unescape[values[i]])
+				} else {
+					v = append(v, values[i])
+				}
+			}
+			row = append(row, v)
+		case c == 'X' && i+1 < len(values) && values[i+1] == '\'':
+			end := strings.IndexByte(values[i+2:], '\'') + i + 2
+			v, err := hex.DecodeString(values[i+2 : end])
+			if err != nil {
+				return nil, err
+			}
+			row = append(row, v)
+			i = end
+		case strings.IndexByte("-.0123456789", c) >= 0:
+			end := strings.IndexAny(values[i:], ",)") + i
+			row = append(row, []byte(values[i:end]))
+			i = end - 1
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", c, i)
+		}
+	}
+	return rows, nil
+}
+
+// copyTestServer is the destination of copies, it keeps the inserted rows
+// by id and fails the inserts listed in fails, counted from 1
+type copyTestServer struct {
+	*fakeServer
+
+	sync.Mutex
+	rows    map[string][]byte
+	inserts int
+	fails   map[int]bool
+	dups    int
+}
+
+func newCopyTestServer(t *testing.T) *copyTestServer {
+	s := &copyTestServer{rows: make(map[string][]byte), fails: make(map[int]bool)}
+	s.fakeServer = newFakeServer(func(c *fakeServerConn, query string) error {
+		prefix := "insert into dst.t (`id`,`name`) values "
+		if !strings.HasPrefix(query, prefix) {
+			return c.exec(query)
+		}
+
+		rows, err := parseTestValues(query[len(prefix):])
+		if err != nil {
+			t.Error(err)
+			return c.writeError(ER_PARSE_ERROR, err.Error())
+		}
+
+		s.Lock()
+		defer s.Unlock()
+
+		s.inserts++
+		if s.fails[s.inserts] {
+			return c.writeError(ER_LOCK_DEADLOCK, "Deadlock found when trying to get lock")
+		}
+		for _, row := range rows {
+			if _, ok := s.rows[string(row[0])]; ok {
+				s.dups++
+			}
+			s.rows[string(row[0])] = row[1]
+		}
+		return c.writeOK()
+	})
+	return s
+}
+
+func (s *copyTestServer) check(t *testing.T, rows [][][]byte) {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.rows) != len(rows) || s.dups != 0 {
+		t.Fatal(len(s.rows), s.dups)
+	}
+	for _, row := range rows {
+		if v, ok := s.rows[string(row[0])]; !ok || string(v) != string(row[1]) || (v == nil) != (row[1] == nil) {
+			t.Fatalf("row %s: %q", row[0], v)
+		}
+	}
+}
+
+func newCopyTestSource(rows [][][]byte) *fakeServer {
+	fields := []*Field{
+		{Name: []byte("id"), Charset: uint16(BINARY_COLLATION_ID), Type: MYSQL_TYPE_LONGLONG},
+		{Name: []byte("name"), Charset: uint16(DEFAULT_COLLATION_ID), Type: MYSQL_TYPE_VAR_STRING},
+	}
+
+	s := newFakeServer(nil)
+	s.onQuery("select id, name from src.t order by id").resultset(fields, false, rows...)
+	return s
+}
+
+func TestCopyTable(t *testing.T) {
+	rows := copyTestRows(2500)
+
+	src := newCopyTestSource(rows)
+	defer src.Close()
+	dst := newCopyTestServer(t)
+	defer dst.Close()
+
+	srcDB, dstDB := src.openDB("src"), dst.openDB("dst")
+	defer srcDB.Close()
+	defer dstDB.Close()
+
+	var progress []int64
+	n, err := CopyTable(srcDB, dstDB, "select id, name from src.t order by id", "dst.t", CopyOptions{
+		BatchSize:   100,
+		MaxInFlight: 4,
+		KeyColumn:   "id",
+		Progress: func(rows int64, key []byte) {
+			if k, _ := strconv.ParseInt(string(key), 10, 64); k != rows {
+				t.Errorf("rows %d key %s", rows, key)
+			}
+			progress = append(progress, rows)
+		},
+	})
+	if err != nil || n != 2500 {
+		t.Fatal(n, err)
+	}
+
+	dst.check(t, rows)
+	if dst.inserts != 25 || len(progress) != 25 || progress[24] != 2500 {
+		t.Fatal(dst.inserts, progress)
+	}
+
+	//not a select
+	src.onQuery("delete from src.t").ok()
+	if _, err = CopyTable(srcDB, dstDB, "delete from src.t", "dst.t", CopyOptions{}); err == nil {
+		t.Fatal("must error")
+	} else if e, ok := err.(*CopyError); !ok || e.Err != ErrNoResultset {
+		t.Fatal(err)
+	}
+}
+
+func TestCopyTable_Resume(t *testing.T) {
+	rows := copyTestRows(1000)
+
+	src := newCopyTestSource(rows)
+	defer src.Close()
+	dst := newCopyTestServer(t)
+	defer dst.Close()
+
+	srcDB, dstDB := src.openDB("src"), dst.openDB("dst")
+	defer srcDB.Close()
+	defer dstDB.Close()
+
+	dst.fails[7] = true
+
+	query := "select id, name from src.t order by id"
+	opts := CopyOptions{BatchSize: 100, KeyColumn: "id"}
+
+	n, err := CopyTable(srcDB, dstDB, query, "dst.t", opts)
+	e, ok := err.(*CopyError)
+	if !ok || n != 600 || e.Rows != 600 || string(e.Key) != "600" {
+		t.Fatal(n, err)
+	} else if se, ok := e.Err.(*SqlError); !ok || se.Code != ER_LOCK_DEADLOCK {
+		t.Fatal(e.Err)
+	}
+
+	//the source is not read after the failure
+	if dst.inserts != 7 {
+		t.Fatal(dst.inserts)
+	}
+
+	last, _ := strconv.Atoi(string(e.Key))
+	opts.Copied = func(key []byte) bool {
+		id, _ := strconv.Atoi(string(key))
+		return id <= last
+	}
+	if n, err = CopyTable(srcDB, dstDB, query, "dst.t", opts); err != nil || n != 400 {
+		t.Fatal(n, err)
+	}
+
+	dst.check(t, rows)
+}
@@ -2,12 +2,32 @@ package client
 
 import (
 	"container/list"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"github.com/siddontang/go-log/log"
 	. "github.com/siddontang/mixer/mysql"
+	"runtime/debug"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
+var ErrUnexpectedResultset = errors.New("command returned a resultset, use query instead")
+
+var ErrNoResultset = errors.New("query returned no resultset, use execute instead")
+
+var errDBDrifted = errors.New("default db drifted and can not be restored")
+
+var ErrWarmUpTimeout = errors.New("warm up timeout")
+
+var ErrDBClosed = errors.New("db is closed")
+
+// ErrPoolClosed is ErrDBClosed, returned by a pool after Close or Shutdown.
+var ErrPoolClosed = ErrDBClosed
+
 type DB struct {
 	sync.Mutex
 
@@ -17,9 +37,209 @@ type DB struct {
 	db           string
 	maxIdleConns int
 
+	maxStmtsPerConn int
+
 	idleConns *list.List
 
+	//set by Close, no conn is opened or pooled then
+	closed bool
+
 	connNum int32
+
+	//conns popped and not pushed back yet with their state when popped,
+	//see CancelAll and DumpConns
+	inUse map[*Conn]ConnInfo
+
+	//closed when the last conn in use is pushed back, see Shutdown
+	drained chan struct{}
+
+	//total conns handed out by PopConn and conns dropped for an error
+	acquired uint64
+	failed   uint64
+
+	//conns connected, taken from idle and closed by the pool, and idle
+	//conns failed to reuse
+	created       uint64
+	reused        uint64
+	closes        uint64
+	reuseFailures uint64
+
+	//conns closed by PushConn because idle conns were full
+	idleFullCloses uint64
+
+	//idle conns may exceed maxIdleConns for idleGrace, see SetIdleGrace
+	idleGrace time.Duration
+	overSince time.Time
+
+	//see SetConnMaxLifetime and SetConnMaxIdleTime, the expired conns are
+	//swept by the reaper until reaperQuit is closed
+	connMaxLifetime time.Duration
+	connMaxIdleTime time.Duration
+	reaperQuit      chan struct{}
+	lifetimeCloses  uint64
+	idleTimeCloses  uint64
+
+	//idle conns kept alive and refilled by the reaper, see SetMinIdleConns
+	minIdleConns int
+
+	//see OnConnect
+	onConnect func(co *Conn) error
+
+	//see SetResetSession
+	resetSession bool
+
+	//see StartHealthCheck, the idle conns are checked until healthQuit is
+	//closed, and the dead ones closed
+	healthInterval    time.Duration
+	healthQuit        chan struct{}
+	healthCheckCloses uint64
+
+	//the running health checks, waited for by Close
+	healthChecks sync.WaitGroup
+
+	//see SetLeakDetection, the conns in use are watched until leakQuit is
+	//closed
+	leakThreshold time.Duration
+	leakQuit      chan struct{}
+
+	//see SetPingInterval
+	pingInterval time.Duration
+
+	retry      RetryPredicate
+	retryDelay RetryDelay
+	//see SetRetrySent
+	noRetrySent bool
+
+	//read-only tag of the last connected or checked conn
+	readOnly int32
+
+	//of the server once read, see MaxAllowedPacket
+	maxAllowedPacket int64
+
+	//start time of the server, the generation is bumped by every restart
+	//and conns of an older one are not reused, see checkRestart
+	serverStart time.Time
+	generation  uint64
+	restarts    uint64
+
+	//set to every popped conn, see SetTypeConverter
+	converter TypeConverter
+
+	//conns track the gtids committed by their statements, see SetTrackGTIDs
+	trackGTIDs bool
+
+	//see SetTranscode
+	transcode bool
+
+	//see SetStmtCache
+	stmtCache bool
+
+	//see SetOptionalMetadata
+	optionalMetadata bool
+
+	//see SetMultiStatements
+	multiStatements bool
+
+	//see SetCharset, empty means DEFAULT_CHARSET, and SetCollation, 0
+	//means the default collation of the charset
+	charset   string
+	collation CollationId
+
+	//see SetQueryTimeout
+	queryTimeout time.Duration
+
+	//see SetStatementTimeout, the kills are sent on killConn
+	stmtTimeout time.Duration
+	killLock    sync.Mutex
+	killConn    *Conn
+
+	//see SetResultCache, hits and misses of cacheable queries
+	resultCache *resultCache
+	cacheHits   uint64
+	cacheMisses uint64
+
+	//see SetDialer, SetDialTimeout, SetReadTimeout and SetWriteTimeout
+	dial         Dialer
+	dialTimeout  time.Duration
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	//see SetTLSConfig
+	tlsConfig *tls.Config
+
+	faults FaultInjector
+
+	//see SetMetrics
+	metrics MetricsCollector
+
+	//0 means no limit, PopConn waits for a conn if reached, see SetMaxOpenConns
+	maxOpenConns int
+
+	//shared with other DBs, see SetLimiter
+	limiter *Limiter
+
+	//waiters for a conn of a full pool, see SetPriorityAging
+	waiters       waitQueue
+	waitSeq       uint64
+	priorityAging time.Duration
+	waitStats     map[int]*WaitStats
+
+	//see SetWaitTimeout, and the waits timed out
+	waitTimeout  time.Duration
+	waitTimeouts uint64
+}
+
+type DBStats struct {
+	Addr string
+
+	MaxIdleConns int
+	OpenConns    int
+	IdleConns    int
+	InUse        int
+
+	MaxStmtsPerConn int
+	//prepared statements held by idle conns
+	IdleStmts int
+
+	Acquired uint64
+	Failed   uint64
+
+	//conns connected, taken from the idle conns and closed by the pool
+	Created uint64
+	Reused  uint64
+	Closed  uint64
+
+	IdleFullCloses uint64
+	//conns closed over the max lifetime or idle time
+	LifetimeCloses uint64
+	IdleTimeCloses uint64
+	//idle conns failed the ping or the reuse checks, replaced by new ones
+	ReuseFailures uint64
+	//idle conns failed the ping of the health check
+	HealthCheckCloses uint64
+
+	MaxOpenConns int
+	//PopConn waiting for a conn of a full pool now, and by priority so far
+	Waiting int
+	Waits   map[int]WaitStats
+	//the waits of all priorities
+	WaitCount    uint64
+	WaitDuration time.Duration
+	//waits failed with ErrPoolTimeout
+	WaitTimeouts uint64
+
+	//row data buffered by all unreleased result sets of the process
+	ResultMemory int64
+
+	//queries answered by the result cache and cacheable queries executed
+	CacheHits   uint64
+	CacheMisses uint64
+
+	//server restarts detected by new conns
+	Restarts uint64
+
+	//panics recovered in the process, see SetRecoverPanics
+	Panics uint64
 }
 
 func Open(addr string, user string, password string, dbName string) (*DB, error) {
@@ -32,6 +252,10 @@ func Open(addr string, user string, password string, dbName string) (*DB, error)
 
 	db.idleConns = list.New()
 	db.connNum = 0
+	db.inUse = make(map[*Conn]ConnInfo)
+
+	db.priorityAging = defaultPriorityAging
+	db.waitStats = make(map[int]*WaitStats)
 
 	return db, nil
 }
@@ -40,13 +264,43 @@ func (db *DB) Addr() string {
 	return db.addr
 }
 
+// UseDB sets the default db of the pool, the new conns connect with it and
+// an idle conn selects it at its next checkout, as after a use statement
+// of its last user. An idle conn with a db is closed instead for "", as a
+// db can not be unselected. The conns in use keep their db until pushed
+// back.
+func (db *DB) UseDB(dbName string) {
+	db.Lock()
+	db.db = dbName
+	db.Unlock()
+}
+
+// GetDB returns the default db of the pool.
+func (db *DB) GetDB() string {
+	db.Lock()
+	dbName := db.db
+	db.Unlock()
+	return dbName
+}
+
 func (db *DB) String() string {
 	return fmt.Sprintf("%s:%s@%s/%s?maxIdleConns=%v",
-		db.user, db.password, db.addr, db.db, db.maxIdleConns)
+		db.user, db.password, db.addr, db.GetDB(), db.maxIdleConns)
 }
 
+// Close closes the idle conns, and the conns in use when pushed back,
+// then PopConn and the operations on pooled conns fail with ErrDBClosed.
+// It can be called many times and concurrently.
 func (db *DB) Close() error {
 	db.Lock()
+	if db.closed {
+		db.Unlock()
+		return nil
+	}
+	db.closed = true
+	db.stopReaper()
+	db.stopHealthCheck()
+	db.stopLeakDetection()
 
 	for {
 		if db.idleConns.Len() > 0 {
@@ -54,7 +308,8 @@ func (db *DB) Close() error {
 			co := v.Value.(*Conn)
 			db.idleConns.Remove(v)
 
-			co.Close()
+			db.closeConn(co)
+			atomic.AddInt32(&db.connNum, -1)
 
 		} else {
 			break
@@ -63,22 +318,226 @@ func (db *DB) Close() error {
 
 	db.Unlock()
 
+	//a conn being pinged is closed by the check
+	db.healthChecks.Wait()
+	db.closeKillConn()
+
 	return nil
 }
 
+// Shutdown closes the pool as Close and waits until the conns in use are
+// pushed back and closed, or ctx is done, then it returns ctx.Err(). A
+// conn is never taken from its user, call CancelAll first to interrupt
+// the running queries.
+func (db *DB) Shutdown(ctx context.Context) error {
+	db.Close()
+
+	db.Lock()
+	if len(db.inUse) == 0 {
+		db.Unlock()
+		return nil
+	}
+	if db.drained == nil {
+		db.drained = make(chan struct{})
+	}
+	drained := db.drained
+	db.Unlock()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// signalDrained wakes up Shutdown once the pool is closed and drained
+func (db *DB) signalDrained() {
+	db.Lock()
+	if db.drained != nil {
+		close(db.drained)
+		db.drained = nil
+	}
+	db.Unlock()
+}
+
+// CancelAll kills the running query of every conn checked out of the pool
+// by KILL QUERY of its server thread, the query fails promptly with
+// ER_QUERY_INTERRUPTED. The kills are sent on a new conn outside the
+// pool, so a full pool does not block them.
+//
+// It is a blunt tool for incidents: a query started just after the
+// snapshot of checked out conns is not killed, and new queries are not
+// prevented, callers must stop them first. It returns the number of
+// killed queries and the first error.
+func (db *DB) CancelAll() (int, error) {
+	db.Lock()
+	ids := make([]uint32, 0, len(db.inUse))
+	for _, info := range db.inUse {
+		ids = append(ids, info.ConnectionId)
+	}
+	db.Unlock()
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	co := db.dialConn()
+	if err := co.Connect(db.addr, db.user, db.password, ""); err != nil {
+		return 0, err
+	}
+	defer co.Close()
+
+	var err error
+	n := 0
+	for _, id := range ids {
+		_, e := co.exec(fmt.Sprintf("kill query %d", id))
+		if e == nil {
+			n++
+		} else if se, ok := e.(*SqlError); ok && se.Code == ER_NO_SUCH_THREAD {
+			//closed meanwhile
+			continue
+		} else if err == nil {
+			err = e
+		}
+	}
+
+	return n, err
+}
+
 func (db *DB) Ping() error {
-	c, err := db.PopConn()
+	return db.withRetry(func(c *Conn) error {
+		return c.Ping()
+	})
+}
+
+// Command executes a statement which only returns an OK packet,
+// like FLUSH TABLES or SET GLOBAL, it returns ErrUnexpectedResultset
+// if the server sends a resultset back.
+func (db *DB) Command(sql string) (*Result, error) {
+	r, err := db.Execute(sql)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = c.Ping()
-	db.PushConn(c, err)
-	return err
+	if r.Resultset != nil {
+		return nil, ErrUnexpectedResultset
+	}
+
+	return r, nil
+}
+
+// Execute executes command on a pooled connection, args are bound by
+// a prepared statement if given.
+func (db *DB) Execute(command string, args ...interface{}) (*Result, error) {
+	return db.ExecuteContext(context.Background(), command, args...)
+}
+
+// Query executes query on a pooled connection and returns its rows,
+// it returns ErrNoResultset if the statement returns no rows. With args,
+// the query is executed by a prepared statement, so the values are typed
+// by the binary protocol. A select may be answered by the result cache,
+// see SetResultCache.
+func (db *DB) Query(query string, args ...interface{}) (*Resultset, error) {
+	return db.QueryContext(context.Background(), query, args...)
+}
+
+// Begin begins a transaction on a pooled connection, the connection must
+// be closed after commit or rollback.
+func (db *DB) Begin() (*SqlConn, error) {
+	return db.BeginContext(context.Background())
 }
 
 func (db *DB) SetMaxIdleConnNum(num int) {
-	db.maxIdleConns = num
+	db.SetMaxIdleConns(num)
+}
+
+// SetMaxIdleConns limits the idle conns kept by PushConn, 0 keeps none.
+// It can be changed while serving: the oldest idle conns over a shrunk
+// limit are closed at once, regardless of the idle grace, and a grown
+// limit keeps more of the conns pushed back from now on.
+func (db *DB) SetMaxIdleConns(n int) {
+	if n < 0 {
+		n = 0
+	}
+
+	db.Lock()
+	db.maxIdleConns = n
+
+	var surplus []*Conn
+	for db.idleConns.Len() > n {
+		v := db.idleConns.Front()
+		surplus = append(surplus, v.Value.(*Conn))
+		db.idleConns.Remove(v)
+		atomic.AddInt32(&db.connNum, -1)
+	}
+	db.overSince = time.Time{}
+	db.Unlock()
+
+	atomic.AddUint64(&db.idleFullCloses, uint64(len(surplus)))
+	db.closeAll(surplus)
+}
+
+// SetIdleGrace lets idle conns exceed the max idle conns for d before
+// the surplus is closed. Without a grace, a conn returned to a full idle
+// pool is closed at once, and bursty load oscillating around the limit
+// closes conns only to reopen them right after. With a grace, the pool
+// keeps the surplus while it keeps being reused, and once it has been
+// over the limit for d the next returned conn closes the oldest idle
+// conns down to the limit. 0, the default, disables the grace.
+func (db *DB) SetIdleGrace(d time.Duration) {
+	db.Lock()
+	db.idleGrace = d
+	db.Unlock()
+}
+
+// SetTrackGTIDs makes new conns track the gtids committed by their
+// statements, see Conn.SetTrackGTIDs.
+func (db *DB) SetTrackGTIDs(on bool) {
+	db.trackGTIDs = on
+}
+
+// SetMaxStmtsPerConn limits the prepared statements held by every conn of the pool,
+// see Conn.SetMaxStmts.
+func (db *DB) SetMaxStmtsPerConn(num int) {
+	db.maxStmtsPerConn = num
+}
+
+// SetStmtCache makes new conns cache the statements of Execute and Query
+// with args, see Conn.SetStmtCache.
+func (db *DB) SetStmtCache(on bool) {
+	db.stmtCache = on
+}
+
+// SetCharset sets the charset of every conn, set by set names after
+// connected and restored when reused, empty means DEFAULT_CHARSET. The
+// collation is the default one of the charset, see SetCollation.
+func (db *DB) SetCharset(charset string) error {
+	if _, ok := CharsetIds[charset]; !ok && len(charset) > 0 {
+		return fmt.Errorf("invalid charset %s", charset)
+	}
+
+	db.Lock()
+	db.charset = charset
+	db.collation = 0
+	db.Unlock()
+	return nil
+}
+
+// connCharset returns the charset and the collation of the conns
+func (db *DB) connCharset() (string, CollationId) {
+	db.Lock()
+	charset := db.charset
+	collation := db.collation
+	db.Unlock()
+
+	if len(charset) == 0 {
+		charset = DEFAULT_CHARSET
+	}
+	if collation == 0 {
+		collation = CharsetIds[charset]
+	}
+	return charset, collation
 }
 
 func (db *DB) GetIdleConnNum() int {
@@ -89,22 +548,200 @@ func (db *DB) GetConnNum() int {
 	return int(db.connNum)
 }
 
-func (db *DB) newConn() (*Conn, error) {
-	co := new(Conn)
+// Stats returns a snapshot of the pool state
+func (db *DB) Stats() DBStats {
+	var s DBStats
 
-	if err := co.Connect(db.addr, db.user, db.password, db.db); err != nil {
+	db.Lock()
+	s.IdleConns = db.idleConns.Len()
+	for e := db.idleConns.Front(); e != nil; e = e.Next() {
+		s.IdleStmts += e.Value.(*Conn).StmtNum()
+	}
+	s.MaxOpenConns = db.maxOpenConns
+	s.Restarts = db.restarts
+	s.Waiting = len(db.waiters)
+	s.WaitTimeouts = db.waitTimeouts
+	s.Waits = make(map[int]WaitStats, len(db.waitStats))
+	for prio, w := range db.waitStats {
+		s.Waits[prio] = *w
+		s.WaitCount += w.Waits
+		s.WaitDuration += w.Total
+	}
+	s.MaxIdleConns = db.maxIdleConns
+	db.Unlock()
+
+	s.MaxStmtsPerConn = db.maxStmtsPerConn
+	s.Addr = db.addr
+	s.OpenConns = int(atomic.LoadInt32(&db.connNum))
+	s.InUse = s.OpenConns - s.IdleConns
+	if s.InUse < 0 {
+		s.InUse = 0
+	}
+	s.Acquired = atomic.LoadUint64(&db.acquired)
+	s.Failed = atomic.LoadUint64(&db.failed)
+	s.Created = atomic.LoadUint64(&db.created)
+	s.Reused = atomic.LoadUint64(&db.reused)
+	s.Closed = atomic.LoadUint64(&db.closes)
+	s.IdleFullCloses = atomic.LoadUint64(&db.idleFullCloses)
+	s.LifetimeCloses = atomic.LoadUint64(&db.lifetimeCloses)
+	s.IdleTimeCloses = atomic.LoadUint64(&db.idleTimeCloses)
+	s.ReuseFailures = atomic.LoadUint64(&db.reuseFailures)
+	s.HealthCheckCloses = atomic.LoadUint64(&db.healthCheckCloses)
+	s.ResultMemory = GlobalResultMemory()
+	s.Panics = Panics()
+	s.CacheHits = atomic.LoadUint64(&db.cacheHits)
+	s.CacheMisses = atomic.LoadUint64(&db.cacheMisses)
+
+	return s
+}
+
+// ConnInfo is the state of a conn of the pool, see DB.DumpConns.
+type ConnInfo struct {
+	//server thread id
+	ConnectionId uint32
+
+	Created time.Time
+	//last checked out or returned
+	LastUsed time.Time
+
+	InUse         bool
+	DB            string
+	InTransaction bool
+	Stmts         int
+
+	//where a conn in use was checked out, see SetLeakDetection
+	Stack string
+
+	//logged as leaked
+	leakLogged bool
+}
+
+func (c *Conn) info() ConnInfo {
+	return ConnInfo{
+		ConnectionId:  c.connectionId,
+		Created:       c.created,
+		LastUsed:      c.lastUsed,
+		DB:            c.db,
+		InTransaction: c.IsInTransaction(),
+		Stmts:         c.StmtNum(),
+	}
+}
+
+// DumpConns returns the idle conns of the pool from the next to pop, then
+// the checked out conns by connection id. A checked out conn is reported
+// in its state when checked out, the pool can not see how it is used.
+func (db *DB) DumpConns() []ConnInfo {
+	db.Lock()
+	conns := make([]ConnInfo, 0, db.idleConns.Len()+len(db.inUse))
+	for e := db.idleConns.Front(); e != nil; e = e.Next() {
+		conns = append(conns, e.Value.(*Conn).info())
+	}
+	idle := len(conns)
+	for _, info := range db.inUse {
+		conns = append(conns, info)
+	}
+	db.Unlock()
+
+	sort.Sort(connInfosById(conns[idle:]))
+
+	return conns
+}
+
+type connInfosById []ConnInfo
+
+func (s connInfosById) Len() int           { return len(s) }
+func (s connInfosById) Less(i, j int) bool { return s[i].ConnectionId < s[j].ConnectionId }
+func (s connInfosById) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (db *DB) newConn() (_ *Conn, err error) {
+	co := db.dialConn()
+	co.faults = db.faultInjector()
+	co.metrics = db.collector()
+	co.SetTrackGTIDs(db.trackGTIDs)
+	co.SetTranscode(db.transcode)
+	co.SetStmtCache(db.stmtCache)
+	co.SetOptionalMetadata(db.optionalMetadata)
+	co.SetMultiStatements(db.multiStatements)
+
+	//a panic fails the dial, the caller releases the slot
+	defer co.recoverPanic(&err)
+
+	if err := co.Connect(db.addr, db.user, db.password, db.GetDB()); err != nil {
 		return nil, err
 	}
 
+	//a server which can not tell, like the mixer proxy, is left unknown
+	if _, err := db.CheckReadOnly(co); err != nil && IsConnBroken(err) {
+		co.Close()
+		return nil, err
+	}
+
+	if err := co.useCharset(db.connCharset()); err != nil {
+		co.Close()
+		return nil, err
+	}
+
+	if err := db.checkRestart(co); err != nil {
+		co.Close()
+		return nil, err
+	}
+
+	if err := db.runOnConnect(co); err != nil {
+		co.Close()
+		return nil, err
+	}
+
+	atomic.AddUint64(&db.created, 1)
+	if co.metrics != nil {
+		co.metrics.ConnOpened()
+	}
 	return co, nil
 }
 
+// IsReadOnly returns whether the server was read-only, by the tag of the
+// last connected conn or the last CheckReadOnly, so a master found
+// read-only after a failover can be demoted.
+func (db *DB) IsReadOnly() bool {
+	return atomic.LoadInt32(&db.readOnly) == 1
+}
+
+// CheckReadOnly checks the server read-only state on co and tags db.
+func (db *DB) CheckReadOnly(co *Conn) (bool, error) {
+	readOnly, err := co.CheckReadOnly()
+	if err != nil {
+		return false, err
+	}
+
+	if readOnly {
+		atomic.StoreInt32(&db.readOnly, 1)
+	} else {
+		atomic.StoreInt32(&db.readOnly, 0)
+	}
+	return readOnly, nil
+}
+
 func (db *DB) tryReuse(co *Conn) error {
+	//the session state restored, the OnConnect hook runs again
+	restored := false
+
+	db.Lock()
+	reset := db.resetSession
+	db.Unlock()
+	if reset {
+		//the transaction and autocommit are reset too
+		if err := co.ResetConnection(); err == nil {
+			restored = true
+		} else if err != ErrResetUnsupported {
+			return err
+		}
+	}
+
 	if co.IsInTransaction() {
 		//we can not reuse a connection in transaction status
 		if err := co.Rollback(); err != nil {
 			return err
 		}
+		restored = true
 	}
 
 	if !co.IsAutoCommit() {
@@ -112,75 +749,417 @@ func (db *DB) tryReuse(co *Conn) error {
 		if _, err := co.exec("set autocommit = 1"); err != nil {
 			return err
 		}
+		restored = true
 	}
 
 	//connection may be set names early
-	//we must use default utf8
-	if co.GetCharset() != DEFAULT_CHARSET {
-		if err := co.SetCharset(DEFAULT_CHARSET); err != nil {
+	//we must use the charset of the pool, default utf8
+	if charset, collation := db.connCharset(); co.charset != charset || co.collation != collation {
+		if err := co.setNames(charset, collation); err != nil {
 			return err
 		}
+		restored = true
 	}
 
+	//a use statement may change the default db, the next user
+	//must not run against the wrong db
+	if dbName := db.GetDB(); co.GetDB() != dbName {
+		log.Warn("conn %s default db drifted from %q to %q, restore it", db.addr, dbName, co.GetDB())
+
+		if len(dbName) == 0 {
+			//mysql can not unselect a db, give up this connection
+			return errDBDrifted
+		}
+
+		if err := co.UseDB(dbName); err != nil {
+			return err
+		}
+		restored = true
+	}
+
+	if restored {
+		return db.runOnConnect(co)
+	}
 	return nil
 }
 
-func (db *DB) PopConn() (co *Conn, err error) {
+func (db *DB) PopConn() (*Conn, error) {
+	return db.popConn(context.Background(), 0)
+}
+
+// popConn returns a conn, the waits for a conn or a limiter token end when
+// ctx is done
+func (db *DB) popConn(ctx context.Context, prio int) (co *Conn, err error) {
+	if m := db.collector(); m != nil {
+		start := time.Now()
+		defer func() {
+			if err == nil {
+				m.ConnWait(time.Since(start))
+			}
+		}()
+	}
+
+	l := db.getLimiter()
+	if l == nil {
+		return db.takeConn(ctx, prio)
+	}
+
+	if err := l.acquire(ctx, prio); err != nil {
+		return nil, err
+	}
+
+	co, err = db.takeConn(ctx, prio)
+	if err != nil {
+		l.release()
+		return nil, err
+	}
+
+	co.limiter = l
+	return co, nil
+}
+
+// takeConn returns an idle conn or a new one
+func (db *DB) takeConn(ctx context.Context, prio int) (co *Conn, err error) {
 	db.Lock()
+	if db.closed {
+		db.Unlock()
+		return nil, ErrDBClosed
+	}
+
+	//the expired conns are skipped, their slots given up before a wait
+	now := time.Now()
+	expired := db.removeExpired(now)
+	defer db.closeAll(expired)
+
+	//pinged by the health check or used lately
+	checked := false
 	if db.idleConns.Len() > 0 {
 		v := db.idleConns.Front()
 		co = v.Value.(*Conn)
 		db.idleConns.Remove(v)
+		checked = db.healthChecked(co, now) || db.pingSkipped(co, now)
+	}
+	if db.idleConns.Len() <= db.maxIdleConns {
+		db.overSince = time.Time{}
+	}
+
+	if co != nil {
+		db.Unlock()
+	} else if db.maxOpenConns > 0 && int(atomic.LoadInt32(&db.connNum)) >= db.maxOpenConns {
+		//a released conn, or nil with the slot to open a new one
+		if co, err = db.waitConn(ctx, prio); err != nil {
+			return nil, err
+		}
+		if db.isClosed() {
+			//pass the slot on to the next waiter
+			if co != nil {
+				db.closeConn(co)
+			}
+			db.releaseSlot()
+			return nil, ErrDBClosed
+		}
+	} else {
+		//take the slot before connecting, so the pool never exceeds the max
+		atomic.AddInt32(&db.connNum, 1)
+		db.Unlock()
 	}
-	db.Unlock()
 
+	m := db.collector()
 	if co != nil {
-		if err := co.Ping(); err == nil {
-			if err := db.tryReuse(co); err == nil {
+		pinged := checked || co.guard((*Conn).Ping) == nil
+		if !pinged && m != nil {
+			m.PingFailed()
+		}
+		if pinged {
+			if err := co.guard(db.tryReuse); err == nil {
 				//connection may alive
+				co.metrics = m
+				co.SetMaxStmts(db.maxStmtsPerConn)
+				co.SetTypeConverter(db.typeConverter())
+				co.SetStatementTimeout(db.statementTimeout(), db.killQuery)
+				co.SetQueryTimeout(db.connQueryTimeout())
+				db.checkOut(co)
+				atomic.AddUint64(&db.reused, 1)
 				return co, nil
 			}
 		}
-		co.Close()
+		//the new conn takes the slot of the broken one
+		atomic.AddUint64(&db.reuseFailures, 1)
+		db.closeConn(co)
 	}
 
 	co, err = db.newConn()
 	if err == nil {
-		atomic.AddInt32(&db.connNum, 1)
+		co.SetMaxStmts(db.maxStmtsPerConn)
+		co.SetTypeConverter(db.typeConverter())
+		co.SetStatementTimeout(db.statementTimeout(), db.killQuery)
+		co.SetQueryTimeout(db.connQueryTimeout())
+		db.checkOut(co)
+	} else {
+		atomic.AddUint64(&db.failed, 1)
+		db.releaseSlot()
 	}
 	return
 }
 
+// closeConn closes a conn of the pool
+func (db *DB) closeConn(co *Conn) {
+	co.Close()
+	atomic.AddUint64(&db.closes, 1)
+	if co.metrics != nil {
+		co.metrics.ConnClosed()
+	}
+}
+
+func (db *DB) isClosed() bool {
+	db.Lock()
+	closed := db.closed
+	db.Unlock()
+	return closed
+}
+
+// checkOut counts co handed out by PopConn
+func (db *DB) checkOut(co *Conn) {
+	atomic.AddUint64(&db.acquired, 1)
+
+	//nothing of the caller is sent yet
+	co.sent = false
+	co.lastUsed = time.Now()
+	info := co.info()
+	info.InUse = true
+
+	db.Lock()
+	db.inUse[co] = info
+	detect := db.leakThreshold > 0
+	db.Unlock()
+
+	if detect {
+		db.recordCheckout(co, string(debug.Stack()))
+	}
+}
+
 func (db *DB) PushConn(co *Conn, err error) {
-	var closeConn *Conn = nil
+	var closeConns []*Conn
+
+	co.lastUsed = time.Now()
+
+	if co.limiter != nil {
+		co.limiter.release()
+		co.limiter = nil
+	}
+
+	db.Lock()
+	delete(db.inUse, co)
+	drained := db.closed && len(db.inUse) == 0
+	db.Unlock()
+	if drained {
+		//after the conn is closed below
+		defer db.signalDrained()
+	}
+
+	if err == nil && co.pkgErr != nil {
+		//a packet failed, like in the middle of a resultset or by a
+		//timeout, the protocol state is unknown
+		err = co.pkgErr
+	}
+	if err == nil {
+		err = co.closeOrphanStmts()
+	}
 
 	if err != nil {
-		closeConn = co
+		if err == ErrQueryTimeout {
+			db.killTimedOut(co)
+		}
+		db.closeConn(co)
+		atomic.AddUint64(&db.failed, 1)
+		db.releaseSlot()
+
+		if IsConnBroken(err) {
+			db.expireIdlePings()
+		}
+		return
+	}
+
+	db.Lock()
+	expired := db.lifetimeExpired(co, co.lastUsed)
+	if db.closed || co.generation != db.generation || expired {
+		//closed, connected before the server restarted, or too old
+		db.Unlock()
+		if expired {
+			atomic.AddUint64(&db.lifetimeCloses, 1)
+		}
+		db.closeConn(co)
+		db.releaseSlot()
+		return
+	}
+
+	if w := db.nextWaiter(); w != nil {
+		db.Unlock()
+		w.ch <- co
+		return
+	}
+
+	if db.maxIdleConns > 0 {
+		db.idleConns.PushBack(co)
+		closeConns = db.shrinkIdle()
 	} else {
-		if db.maxIdleConns > 0 {
-			db.Lock()
+		closeConns = append(closeConns, co)
+	}
+	db.Unlock()
 
-			if db.idleConns.Len() >= db.maxIdleConns {
-				v := db.idleConns.Front()
-				closeConn = v.Value.(*Conn)
-				db.idleConns.Remove(v)
-			}
+	atomic.AddUint64(&db.idleFullCloses, uint64(len(closeConns)))
 
-			db.idleConns.PushBack(co)
+	for _, c := range closeConns {
+		atomic.AddInt32(&db.connNum, -1)
+
+		db.closeConn(c)
+	}
+}
 
-			db.Unlock()
+// shrinkIdle removes the oldest idle conns over the max idle conns if
+// no grace or the grace has passed, must hold the lock
+func (db *DB) shrinkIdle() []*Conn {
+	over := db.idleConns.Len() - db.maxIdleConns
+	if over <= 0 {
+		db.overSince = time.Time{}
+		return nil
+	}
 
-		} else {
-			closeConn = co
+	if db.idleGrace > 0 {
+		now := time.Now()
+		if db.overSince.IsZero() {
+			db.overSince = now
 		}
 
+		if now.Sub(db.overSince) < db.idleGrace {
+			return nil
+		}
 	}
 
-	if closeConn != nil {
-		atomic.AddInt32(&db.connNum, -1)
+	conns := make([]*Conn, 0, over)
+	for i := 0; i < over; i++ {
+		v := db.idleConns.Front()
+		conns = append(conns, v.Value.(*Conn))
+		db.idleConns.Remove(v)
+	}
+	db.overSince = time.Time{}
+
+	return conns
+}
+
+// WarmUp opens connections until idle conns reach idle, at most the max
+// idle conns, and prepares queries on every warmed connection, at most
+// parallel connections are warmed at the same time. It stops warming
+// new connections after the deadline and returns the number of warmed
+// connections with the distinct errors of the failed ones joined. The
+// waits for a conn of a full pool end at the deadline, the dials are
+// bounded by SetDialTimeout.
+func (db *DB) WarmUp(idle int, queries []string, parallel int, deadline time.Time) (int, error) {
+	db.Lock()
+	if db.maxIdleConns > 0 && idle > db.maxIdleConns {
+		idle = db.maxIdleConns
+	} else if db.maxIdleConns <= 0 {
+		idle = 0
+	}
+	//warmed conns are held until all are warmed
+	if db.maxOpenConns > 0 && idle > db.maxOpenConns {
+		idle = db.maxOpenConns
+	}
+	db.Unlock()
+
+	if parallel <= 0 {
+		parallel = 1
+	}
+
+	jobs := make(chan struct{}, idle)
+	for i := 0; i < idle; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+
+	var mu sync.Mutex
+	var conns []*Conn
+	var errs []error
+
+	setErr := func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < parallel && i < idle; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for range jobs {
+				timeout := deadline.Sub(time.Now())
+				if timeout <= 0 {
+					setErr(ErrWarmUpTimeout)
+					return
+				}
+
+				ctx, cancel := context.WithDeadline(context.Background(), deadline)
+				co, err := db.popConn(ctx, 0)
+				cancel()
+				if err == context.DeadlineExceeded {
+					//waited for a conn of a full pool till the deadline
+					setErr(ErrWarmUpTimeout)
+					return
+				} else if err != nil {
+					setErr(err)
+					continue
+				}
+
+				co.SetQueryTimeout(timeout)
+				for _, query := range queries {
+					if err = co.prewarm(query); err != nil {
+						break
+					}
+				}
+				co.SetQueryTimeout(0)
+
+				if err != nil {
+					db.PushConn(co, err)
+					setErr(err)
+					continue
+				}
+
+				mu.Lock()
+				conns = append(conns, co)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	//push back after all warmed, or PopConn takes the warmed ones again
+	for _, co := range conns {
+		db.PushConn(co, nil)
+	}
+
+	return len(conns), joinErrors(errs)
+}
+
+// joinErrors joins the distinct errors of errs, a single one is returned
+// as is, nil if none
+func joinErrors(errs []error) error {
+	var distinct []error
+	for _, err := range errs {
+		dup := err == nil
+		for _, e := range distinct {
+			dup = dup || e == err
+		}
+		if !dup {
+			distinct = append(distinct, err)
+		}
+	}
 
-		closeConn.Close()
+	if len(distinct) == 1 {
+		return distinct[0]
 	}
+	return errors.Join(distinct...)
 }
 
 type SqlConn struct {
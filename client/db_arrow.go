@@ -0,0 +1,221 @@
+//go:build arrow
+// +build arrow
+
+package client
+
+import (
+	"fmt"
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/apache/arrow/go/v12/arrow/array"
+	"github.com/apache/arrow/go/v12/arrow/memory"
+	. "github.com/siddontang/mixer/mysql"
+	"strconv"
+	"time"
+)
+
+var arrowTimestamp = &arrow.TimestampType{Unit: arrow.Microsecond}
+
+// QueryArrow executes query on a pooled connection and returns its rows as
+// one arrow record, the record must be released by the caller.
+// Columns are mapped by type: integers to int64 or uint64, float and double
+// to float32 and float64, decimal and text to utf8, binary to binary,
+// datetime and timestamp to timestamp[us], date to date32. Other types like
+// time, bit or geometry return an error.
+// It is only built with the arrow build tag.
+func (db *DB) QueryArrow(query string, args ...interface{}) (arrow.Record, error) {
+	rs, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	//with args the query runs as a prepared statement and rows are binary
+	return resultsetRecord(memory.DefaultAllocator, rs, len(args) > 0)
+}
+
+func arrowSchema(fields []*Field) (*arrow.Schema, error) {
+	afs := make([]arrow.Field, len(fields))
+	for i, f := range fields {
+		t, err := arrowType(f)
+		if err != nil {
+			return nil, err
+		}
+		afs[i] = arrow.Field{
+			Name:     string(f.Name),
+			Type:     t,
+			Nullable: f.Flag&NOT_NULL_FLAG == 0,
+		}
+	}
+	return arrow.NewSchema(afs, nil), nil
+}
+
+func arrowType(f *Field) (arrow.DataType, error) {
+	unsigned := f.Flag&UNSIGNED_FLAG > 0
+
+	switch f.Type {
+	case MYSQL_TYPE_TINY, MYSQL_TYPE_SHORT, MYSQL_TYPE_INT24,
+		MYSQL_TYPE_LONG, MYSQL_TYPE_LONGLONG, MYSQL_TYPE_YEAR:
+		if unsigned {
+			return arrow.PrimitiveTypes.Uint64, nil
+		}
+		return arrow.PrimitiveTypes.Int64, nil
+	case MYSQL_TYPE_FLOAT:
+		return arrow.PrimitiveTypes.Float32, nil
+	case MYSQL_TYPE_DOUBLE:
+		return arrow.PrimitiveTypes.Float64, nil
+	case MYSQL_TYPE_DECIMAL, MYSQL_TYPE_NEWDECIMAL, MYSQL_TYPE_ENUM, MYSQL_TYPE_SET:
+		//keep decimal exact
+		return arrow.BinaryTypes.String, nil
+	case MYSQL_TYPE_VARCHAR, MYSQL_TYPE_VAR_STRING, MYSQL_TYPE_STRING,
+		MYSQL_TYPE_TINY_BLOB, MYSQL_TYPE_MEDIUM_BLOB, MYSQL_TYPE_LONG_BLOB, MYSQL_TYPE_BLOB:
+		if f.Charset == uint16(BINARY_COLLATION_ID) {
+			return arrow.BinaryTypes.Binary, nil
+		}
+		return arrow.BinaryTypes.String, nil
+	case MYSQL_TYPE_DATETIME, MYSQL_TYPE_TIMESTAMP:
+		return arrowTimestamp, nil
+	case MYSQL_TYPE_DATE, MYSQL_TYPE_NEWDATE:
+		return arrow.FixedWidthTypes.Date32, nil
+	default:
+		return nil, fmt.Errorf("column %s type %d is not supported by arrow", f.Name, f.Type)
+	}
+}
+
+// resultsetRecord builds a record from rs, text rows are decoded from the raw
+// row data straight into the column builders without boxing every cell.
+func resultsetRecord(mem memory.Allocator, rs *Resultset, binary bool) (arrow.Record, error) {
+	schema, err := arrowSchema(rs.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	b := array.NewRecordBuilder(mem, schema)
+	defer b.Release()
+
+	b.Reserve(len(rs.RowDatas))
+
+	if binary {
+		for _, row := range rs.Values {
+			for i, v := range row {
+				if err = appendArrowValue(b.Field(i), rs.Fields[i], v); err != nil {
+					return nil, err
+				}
+			}
+		}
+	} else {
+		for _, data := range rs.RowDatas {
+			pos := 0
+			for i, f := range rs.Fields {
+				v, isNull, n, err := LengthEnodedString(data[pos:])
+				if err != nil {
+					return nil, err
+				}
+				pos += n
+
+				if isNull {
+					b.Field(i).AppendNull()
+				} else if err = appendArrowText(b.Field(i), f, v); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return b.NewRecord(), nil
+}
+
+// appendArrowValue appends a binary protocol value parsed by RowData.ParseBinary
+func appendArrowValue(b array.Builder, f *Field, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		b.AppendNull()
+	case int64:
+		b.(*array.Int64Builder).Append(v)
+	case uint64:
+		b.(*array.Uint64Builder).Append(v)
+	case float64:
+		if fb, ok := b.(*array.Float32Builder); ok {
+			fb.Append(float32(v))
+		} else {
+			b.(*array.Float64Builder).Append(v)
+		}
+	case []byte:
+		return appendArrowText(b, f, v)
+	default:
+		return fmt.Errorf("column %s value type %T is not supported by arrow", f.Name, v)
+	}
+	return nil
+}
+
+func appendArrowText(b array.Builder, f *Field, v []byte) error {
+	switch b := b.(type) {
+	case *array.Int64Builder:
+		n, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			return err
+		}
+		b.Append(n)
+	case *array.Uint64Builder:
+		n, err := strconv.ParseUint(string(v), 10, 64)
+		if err != nil {
+			return err
+		}
+		b.Append(n)
+	case *array.Float32Builder:
+		n, err := strconv.ParseFloat(string(v), 32)
+		if err != nil {
+			return err
+		}
+		b.Append(float32(n))
+	case *array.Float64Builder:
+		n, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return err
+		}
+		b.Append(n)
+	case *array.StringBuilder:
+		b.Append(string(v))
+	case *array.BinaryBuilder:
+		b.Append(v)
+	case *array.TimestampBuilder:
+		t, ok, err := parseArrowTime(v)
+		if err != nil {
+			return err
+		} else if !ok {
+			b.AppendNull()
+		} else {
+			b.Append(arrow.Timestamp(t.UnixNano() / int64(time.Microsecond)))
+		}
+	case *array.Date32Builder:
+		t, ok, err := parseArrowTime(v)
+		if err != nil {
+			return err
+		} else if !ok {
+			b.AppendNull()
+		} else {
+			b.Append(arrow.Date32FromTime(t))
+		}
+	default:
+		return fmt.Errorf("column %s builder %T is not supported", f.Name, b)
+	}
+	return nil
+}
+
+// parseArrowTime parses a date or datetime in UTC, zero dates like
+// 0000-00-00 are not valid times and return false.
+func parseArrowTime(v []byte) (time.Time, bool, error) {
+	s := string(v)
+	if len(s) >= 10 && s[:10] == "0000-00-00" {
+		return time.Time{}, false, nil
+	}
+
+	layout := "2006-01-02"
+	if len(s) > 10 {
+		layout = "2006-01-02 15:04:05.999999"
+	}
+
+	t, err := time.ParseInLocation(layout, s, time.UTC)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return t, true, nil
+}
@@ -0,0 +1,106 @@
+//go:build arrow
+// +build arrow
+
+package client
+
+import (
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/apache/arrow/go/v12/arrow/array"
+	"github.com/apache/arrow/go/v12/arrow/memory"
+	. "github.com/siddontang/mixer/mysql"
+	"strconv"
+	"testing"
+)
+
+func newArrowTestResultset(rows int) *Resultset {
+	rs := &Resultset{
+		Fields: []*Field{
+			&Field{Name: []byte("id"), Type: MYSQL_TYPE_LONGLONG, Flag: NOT_NULL_FLAG},
+			&Field{Name: []byte("score"), Type: MYSQL_TYPE_DOUBLE},
+			&Field{Name: []byte("name"), Type: MYSQL_TYPE_VAR_STRING, Charset: 33},
+			&Field{Name: []byte("ctime"), Type: MYSQL_TYPE_DATETIME},
+		},
+	}
+
+	for i := 0; i < rows; i++ {
+		var data []byte
+		data = append(data, PutLengthEncodedString([]byte(strconv.Itoa(i)))...)
+		if i%2 == 0 {
+			data = append(data, 0xfb)
+		} else {
+			data = append(data, PutLengthEncodedString([]byte("1.5"))...)
+		}
+		data = append(data, PutLengthEncodedString([]byte("name"+strconv.Itoa(i)))...)
+		data = append(data, PutLengthEncodedString([]byte("2014-09-01 10:00:00.5"))...)
+		rs.RowDatas = append(rs.RowDatas, data)
+	}
+
+	return rs
+}
+
+func TestDB_ArrowRecord(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+
+	rec, err := resultsetRecord(mem, newArrowTestResultset(3), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Release()
+
+	if rec.NumRows() != 3 || rec.NumCols() != 4 {
+		t.Fatal(rec.NumRows(), rec.NumCols())
+	}
+
+	if id := rec.Column(0).(*array.Int64).Value(2); id != 2 {
+		t.Fatal(id)
+	}
+
+	score := rec.Column(1).(*array.Float64)
+	if !score.IsNull(0) || score.Value(1) != 1.5 {
+		t.Fatal(score)
+	}
+
+	if name := rec.Column(2).(*array.String).Value(1); name != "name1" {
+		t.Fatal(name)
+	}
+
+	ts := rec.Column(3).(*array.Timestamp).Value(0)
+	if ts.ToTime(arrow.Microsecond).Format("2006-01-02 15:04:05.000") != "2014-09-01 10:00:00.500" {
+		t.Fatal(ts)
+	}
+
+	rs := newArrowTestResultset(1)
+	rs.Fields[0].Type = MYSQL_TYPE_TIME
+	if _, err := resultsetRecord(mem, rs, false); err == nil {
+		t.Fatal("time must be unsupported")
+	}
+}
+
+func BenchmarkArrowRecord(b *testing.B) {
+	rs := newArrowTestResultset(10000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rec, err := resultsetRecord(memory.DefaultAllocator, rs, false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		rec.Release()
+	}
+}
+
+func BenchmarkArrowRowValues(b *testing.B) {
+	rs := newArrowTestResultset(10000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		values := make([][]interface{}, len(rs.RowDatas))
+		for j, data := range rs.RowDatas {
+			var err error
+			if values[j], err = data.ParseText(rs.Fields); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
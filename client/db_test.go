@@ -0,0 +1,456 @@
+package client
+
+import (
+	. "github.com/siddontang/mixer/mysql"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestDB() *DB {
+	db, err := Open("127.0.0.1:3306", "root", "", "mixer")
+	if err != nil {
+		panic(err)
+	}
+
+	db.SetMaxIdleConnNum(4)
+
+	return db
+}
+
+func TestDB_Command(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	if _, err := db.Command("flush tables"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Command("select 1"); err != ErrUnexpectedResultset {
+		t.Fatal(err)
+	}
+}
+
+func TestDB_ServerError(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	s.onQuery("insert into t values (1)").err(ER_DUP_ENTRY, "Duplicate entry '1' for key 'PRIMARY'")
+	s.onQuery("update t set a = 1").err(ER_LOCK_DEADLOCK, "Deadlock found when trying to get lock")
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	defer db.Close()
+
+	_, err := db.Execute("insert into t values (1)")
+	if e, ok := err.(*SqlError); !ok || e.Code != ER_DUP_ENTRY || e.State != "23000" || !IsDuplicateEntry(err) {
+		t.Fatal(err)
+	}
+	if _, err = db.Execute("update t set a = 1"); !IsDeadlock(err) || IsLockWaitTimeout(err) {
+		t.Fatal(err)
+	} else if IsConnBroken(err) {
+		t.Fatal("broken by a statement error")
+	}
+}
+
+func TestDB_ParseUseDB(t *testing.T) {
+	tbl := []struct {
+		query string
+		db    string
+		ok    bool
+	}{
+		{"use mixer", "mixer", true},
+		{"  USE mixer ; ", "mixer", true},
+		{"use `my db`", "my db", true},
+		{"use`a``b`", "a`b", true},
+		{"user_var = 1", "", false},
+		{"use a b", "", false},
+		{"use ", "", false},
+		{"select 1", "", false},
+	}
+
+	for _, v := range tbl {
+		db, ok := parseUseDB(v.query)
+		if db != v.db || ok != v.ok {
+			t.Fatal(v.query, db, ok)
+		}
+	}
+}
+
+func TestDB_RestoreDefaultDB(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	co, err := db.PopConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := co.Execute("use mysql"); err != nil {
+		t.Fatal(err)
+	} else if co.GetDB() != "mysql" {
+		t.Fatal(co.GetDB())
+	}
+
+	db.PushConn(co, nil)
+
+	if co, err = db.PopConn(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.PushConn(co, nil)
+
+	if co.GetDB() != "mixer" {
+		t.Fatal(co.GetDB())
+	}
+
+	if r, err := co.Execute("select database()"); err != nil {
+		t.Fatal(err)
+	} else if s, _ := r.GetString(0, 0); s != "mixer" {
+		t.Fatal(s)
+	}
+}
+
+func comStmtPrepare(t *testing.T, co *Conn) int64 {
+	r, err := co.Execute("show session status like 'Com_stmt_prepare'")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := r.GetInt(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestDB_WarmUp(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	query := "select ? + 1"
+
+	n, err := db.WarmUp(4, []string{query}, 2, time.Now().Add(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	} else if n != 4 {
+		t.Fatal(n)
+	}
+
+	if s := db.Stats(); s.IdleConns != 4 || s.IdleStmts != 4 {
+		t.Fatal(s)
+	}
+
+	co, err := db.PopConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.PushConn(co, nil)
+
+	before := comStmtPrepare(t, co)
+
+	s, err := co.Prepare(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if after := comStmtPrepare(t, co); after != before {
+		t.Fatal("warmed statement must not be prepared again", before, after)
+	}
+
+	if r, err := s.Execute(1); err != nil {
+		t.Fatal(err)
+	} else if v, _ := r.GetInt(0, 0); v != 2 {
+		t.Fatal(v)
+	}
+	s.Close()
+
+	if _, err := db.WarmUp(4, nil, 2, time.Now()); err != ErrWarmUpTimeout {
+		t.Fatal(err)
+	}
+}
+
+func TestDB_RetryPredicate(t *testing.T) {
+	if !DefaultRetryPredicate(ErrBadConn, maxBadConnRetries) {
+		t.Fatal("must retry bad conn")
+	} else if DefaultRetryPredicate(ErrBadConn, maxBadConnRetries+1) {
+		t.Fatal("must stop after max retries")
+	} else if DefaultRetryPredicate(ErrMalformPacket, 1) {
+		t.Fatal("must not retry other errors")
+	}
+
+	//nothing listens on port 1
+	db, _ := Open("127.0.0.1:1", "root", "", "")
+
+	var attempts []int
+	db.SetRetryPredicate(func(err error, attempt int) bool {
+		attempts = append(attempts, attempt)
+		return attempt < 3
+	})
+
+	if err := db.Ping(); err == nil {
+		t.Fatal("must error")
+	} else if len(attempts) != 3 || attempts[2] != 3 {
+		t.Fatal(attempts)
+	}
+
+	attempts = attempts[0:0]
+	if _, err := db.Begin(); err == nil {
+		t.Fatal("must error")
+	} else if len(attempts) != 3 {
+		t.Fatal(attempts)
+	}
+
+	db.SetRetryPredicate(nil)
+	if _, err := db.Execute("select 1"); err == nil {
+		t.Fatal("must error")
+	}
+}
+
+func TestDB_IsReadOnly(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	} else if db.IsReadOnly() {
+		t.Fatal("must writable")
+	}
+
+	if _, err := db.Command("set global read_only = 1"); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Command("set global read_only = 0")
+
+	co, err := db.PopConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.PushConn(co, nil)
+
+	if readOnly, err := db.CheckReadOnly(co); err != nil {
+		t.Fatal(err)
+	} else if !readOnly || !co.IsReadOnly() || !db.IsReadOnly() {
+		t.Fatal("must read only")
+	}
+}
+
+func TestDB_ReadOnlyUnknown(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	//rejected like by the mixer proxy, the conn is still connected
+	s.onQuery("select @@read_only, @@super_read_only").err(ER_UNKNOWN_ERROR, "not supported")
+	db := s.openDB("")
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	} else if db.IsReadOnly() {
+		t.Fatal("must writable")
+	}
+}
+
+func queryInt(q Queryer, query string, args ...interface{}) (int64, error) {
+	r, err := q.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return r.GetInt(0, 0)
+}
+
+func TestDB_Session(t *testing.T) {
+	db := newTestDB()
+	defer db.Close()
+
+	if n, err := queryInt(db, "select ?", 1); err != nil || n != 1 {
+		t.Fatal(n, err)
+	}
+
+	if _, err := db.Query("set @mixer_sticky = 1"); err != ErrNoResultset {
+		t.Fatal(err)
+	}
+
+	co, err := db.GetConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer co.Close()
+
+	var s Session = co
+	if _, err := s.Execute("set @mixer_sticky = 7"); err != nil {
+		t.Fatal(err)
+	}
+
+	//session variable is kept on the pinned conn
+	if n, err := queryInt(s, "select @mixer_sticky"); err != nil || n != 7 {
+		t.Fatal(n, err)
+	}
+
+	stmt, err := s.Prepare("select @mixer_sticky + ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	if r, err := stmt.Execute(1); err != nil {
+		t.Fatal(err)
+	} else if n, _ := r.GetInt(0, 0); n != 8 {
+		t.Fatal(n)
+	}
+}
+
+func TestDB_IdleGrace(t *testing.T) {
+	db, _ := Open("127.0.0.1:1", "root", "", "")
+	db.SetMaxIdleConnNum(2)
+
+	push := func(n int) {
+		for i := 0; i < n; i++ {
+			atomic.AddInt32(&db.connNum, 1)
+			db.PushConn(new(Conn), nil)
+		}
+	}
+
+	//no grace, the oldest idle conn is closed at once
+	push(3)
+	if s := db.Stats(); s.IdleConns != 2 || s.IdleFullCloses != 1 || s.OpenConns != 2 {
+		t.Fatalf("%+v", s)
+	}
+
+	db.SetIdleGrace(50 * time.Millisecond)
+	push(2)
+	if s := db.Stats(); s.IdleConns != 4 || s.IdleFullCloses != 1 {
+		t.Fatalf("%+v", s)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	push(1)
+	if s := db.Stats(); s.IdleConns != 2 || s.IdleFullCloses != 4 || s.OpenConns != 2 {
+		t.Fatalf("%+v", s)
+	}
+}
+
+func TestDB_CancelAll(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	s.onQuery("select sleep(10)").delay(10*time.Second).rows([]string{"sleep"}, []string{"0"})
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(4)
+
+	if n, err := db.CancelAll(); n != 0 || err != nil {
+		t.Fatal(n, err)
+	}
+
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := db.Query("select sleep(10)")
+			errs <- err
+		}()
+	}
+
+	for n := 0; n != 3; {
+		time.Sleep(time.Millisecond)
+		db.Lock()
+		n = len(db.inUse)
+		db.Unlock()
+	}
+
+	start := time.Now()
+	if n, err := db.CancelAll(); n != 3 || err != nil {
+		t.Fatal(n, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if e, ok := (<-errs).(*SqlError); !ok || e.Code != ER_QUERY_INTERRUPTED {
+			t.Fatal(e)
+		}
+	}
+	if d := time.Now().Sub(start); d > time.Second {
+		t.Fatal(d)
+	}
+
+	if s := db.Stats(); s.InUse != 0 || s.Failed != 3 {
+		t.Fatalf("%+v", s)
+	}
+	if _, err := db.Execute("insert into t values (1)"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitServerStmts waits until the fake server has n open statements, a
+// COM_STMT_CLOSE is not answered
+func waitServerStmts(t *testing.T, s *fakeServer, n int) {
+	for i := 0; i < 1000; i++ {
+		if open, _ := s.stmtStats(); open == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	open, _ := s.stmtStats()
+	t.Fatalf("server statements %d, not %d", open, n)
+}
+
+func TestDB_QueryArgs(t *testing.T) {
+	for _, cache := range []bool{false, true} {
+		s := newFakeServer(nil)
+
+		fields := []*Field{
+			{Name: []byte("id"), Charset: uint16(BINARY_COLLATION_ID), Type: MYSQL_TYPE_LONGLONG},
+			{Name: []byte("name"), Charset: uint16(DEFAULT_COLLATION_ID), Type: MYSQL_TYPE_VAR_STRING},
+		}
+		query := "select id, name from t where id = ?"
+		s.onQuery("select id, name from t where id = 1").resultset(fields, false, [][]byte{[]byte("1"), []byte("a")})
+		s.on(COM_STMT_EXECUTE, query).times(1).err(ER_UNKNOWN_STMT_HANDLER, "Unknown prepared statement handler")
+		s.on(COM_STMT_EXECUTE, query).resultset(fields, true, [][]byte{Uint64ToBytes(1), []byte("a")})
+
+		db := s.openDB("")
+		db.SetMaxIdleConnNum(1)
+		db.SetStmtCache(cache)
+		db.SetRetryPredicate(nil)
+
+		text, err := db.Query("select id, name from t where id = 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//the failed statement is closed, not cached
+		if _, err = db.Query(query, 1); err == nil {
+			t.Fatal("must error")
+		}
+		waitServerStmts(t, s, 0)
+
+		for i := 0; i < 3; i++ {
+			r, err := db.Query(query, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(r.Values) != 1 || len(r.Values[0]) != len(text.Values[0]) {
+				t.Fatal(r.Values)
+			}
+			for j := range text.Values[0] {
+				v, _ := r.GetString(0, j)
+				if expect, _ := text.GetString(0, j); v != expect {
+					t.Fatal(j, v, expect)
+				}
+			}
+			if v, ok := r.Values[0][0].(int64); !ok || v != 1 {
+				t.Fatalf("%T %v", r.Values[0][0], r.Values[0][0])
+			}
+		}
+
+		open, prepares := 0, 4
+		if cache {
+			open, prepares = 1, 2
+		}
+		waitServerStmts(t, s, open)
+		if _, n := s.stmtStats(); n != prepares {
+			t.Fatal(cache, n)
+		}
+
+		db.Close()
+		s.Close()
+	}
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+// Dialer opens the network conn to a server, like through an SSH tunnel
+// or a SOCKS5 proxy, or an in-memory pipe in tests. network is "unix" for
+// an addr with a slash, "tcp" otherwise, see splitNetwork. ctx is done at
+// the dial timeout, see SetDialTimeout. The handshake runs on the returned
+// conn.
+type Dialer func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// DefaultDialer dials by net.Dialer, the dialer of a conn with none set.
+var DefaultDialer Dialer = new(net.Dialer).DialContext
+
+// SetDialer sets the dialer of the next connect, nil means DefaultDialer.
+func (c *Conn) SetDialer(f Dialer) {
+	c.dial = f
+}
+
+// SetDialTimeout bounds the dial of the next connect by d, 0 means no
+// bound. The handshake after it is not bounded.
+func (c *Conn) SetDialTimeout(d time.Duration) {
+	c.dialTimeout = d
+}
+
+// SetDialer sets the dialer of every conn the pool opens from now on,
+// including the kill conns, nil means DefaultDialer.
+func (db *DB) SetDialer(f Dialer) {
+	db.Lock()
+	db.dial = f
+	db.Unlock()
+}
+
+// SetDialTimeout bounds the dial of every conn the pool opens from now on,
+// see Conn.SetDialTimeout.
+func (db *DB) SetDialTimeout(d time.Duration) {
+	db.Lock()
+	db.dialTimeout = d
+	db.Unlock()
+}
+
+// dialConn returns an unconnected conn of the dialer, the packet timeouts
+// and the TLS config of db
+func (db *DB) dialConn() *Conn {
+	db.Lock()
+	co := &Conn{dial: db.dial, dialTimeout: db.dialTimeout,
+		readTimeout: db.readTimeout, writeTimeout: db.writeTimeout,
+		tlsConfig: db.tlsConfig}
+	db.Unlock()
+	return co
+}
+
+// splitNetwork returns the network and the address to dial of addr, like
+// unix and /var/run/mysqld/mysqld.sock. A unix:// or tcp:// prefix forces
+// the network, else an addr with a slash is the path of a unix socket.
+func splitNetwork(addr string) (string, string) {
+	if path, ok := strings.CutPrefix(addr, "unix://"); ok {
+		return "unix", path
+	} else if hostport, ok := strings.CutPrefix(addr, "tcp://"); ok {
+		return "tcp", hostport
+	} else if strings.Contains(addr, "/") {
+		return "unix", addr
+	}
+	return "tcp", addr
+}
+
+// dialNet opens the network conn of c
+func (c *Conn) dialNet() (net.Conn, error) {
+	dial := c.dial
+	if dial == nil {
+		dial = DefaultDialer
+	}
+
+	ctx := context.Background()
+	if c.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.dialTimeout)
+		defer cancel()
+	}
+	network, addr := splitNetwork(c.addr)
+	return dial(ctx, network, addr)
+}
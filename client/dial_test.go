@@ -0,0 +1,290 @@
+package client
+
+import (
+	"context"
+	"errors"
+	. "github.com/siddontang/mixer/mysql"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// countingDialer counts the dials of f
+func countingDialer(n *int32, f Dialer) Dialer {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		atomic.AddInt32(n, 1)
+		return f(ctx, network, addr)
+	}
+}
+
+func TestDB_Dialer(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("test")
+	db.SetMaxIdleConnNum(1)
+	defer db.Close()
+
+	var dials int32
+	var network, addr string
+	db.SetDialer(countingDialer(&dials, func(ctx context.Context, n, a string) (net.Conn, error) {
+		network, addr = n, a
+		return s.dial(ctx, n, a)
+	}))
+
+	if _, err := db.Execute("select 1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Execute("select ?", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	tx.Close()
+
+	if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Fatal(n)
+	} else if network != "tcp" || addr != "fake:3306" {
+		t.Fatal(network, addr)
+	}
+}
+
+func TestDB_UnixSocket(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	path := s.listenOn(t, "unix", filepath.Join(t.TempDir(), "mysql.sock"))
+
+	for _, addr := range []string{path, "unix://" + path} {
+		db, err := Open(addr, "root", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Execute("select 1"); err != nil {
+			t.Fatal(addr, err)
+		}
+		db.Close()
+	}
+
+	//the error names the path
+	c := new(Conn)
+	missing := filepath.Join(t.TempDir(), "missing.sock")
+	if err := c.Connect(missing, "root", "", ""); err == nil || !strings.Contains(err.Error(), missing) {
+		t.Fatal(err)
+	}
+}
+
+func TestSplitNetwork(t *testing.T) {
+	for _, c := range []struct{ addr, network, dial string }{
+		{"127.0.0.1:3306", "tcp", "127.0.0.1:3306"},
+		{"/var/run/mysqld/mysqld.sock", "unix", "/var/run/mysqld/mysqld.sock"},
+		{"unix://mysqld.sock", "unix", "mysqld.sock"},
+		{"unix:///tmp/mysql.sock", "unix", "/tmp/mysql.sock"},
+		{"tcp://db:3306", "tcp", "db:3306"},
+	} {
+		if network, addr := splitNetwork(c.addr); network != c.network || addr != c.dial {
+			t.Fatal(c.addr, network, addr)
+		}
+	}
+}
+
+func TestDB_DialerRetries(t *testing.T) {
+	var dials int32
+	refused := errors.New("connection refused")
+
+	db, _ := Open("fake:3306", "root", "", "")
+	db.SetDialer(countingDialer(&dials, func(context.Context, string, string) (net.Conn, error) {
+		return nil, refused
+	}))
+	defer db.Close()
+
+	//a failed dial is not retried by default
+	if _, err := db.Execute("select 1"); err != refused {
+		t.Fatal(err)
+	} else if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Fatal(n)
+	}
+
+	db.SetRetryPredicate(func(err error, attempt int) bool {
+		return err == refused && attempt < 3
+	})
+	atomic.StoreInt32(&dials, 0)
+	if _, err := db.Execute("select 1"); err != refused {
+		t.Fatal(err)
+	} else if n := atomic.LoadInt32(&dials); n != 3 {
+		t.Fatal(n)
+	}
+}
+
+func TestDB_DialTimeout(t *testing.T) {
+	db, _ := Open("fake:3306", "root", "", "")
+	db.SetDialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	db.SetDialTimeout(20 * time.Millisecond)
+	defer db.Close()
+
+	start := time.Now()
+	if _, err := db.Execute("select 1"); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	} else if d := time.Now().Sub(start); d < 20*time.Millisecond {
+		t.Fatal(d)
+	}
+	if st := db.Stats(); st.OpenConns != 0 || st.Failed != 1 {
+		t.Fatalf("%+v", st)
+	}
+}
+
+func TestDB_RetryDelay(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	defer db.Close()
+
+	var dials int32
+	db.SetDialer(countingDialer(&dials, s.dial))
+	db.SetRetryPredicate(RetryBadConn(3))
+	db.SetRetryDelay(ExpBackoff(10*time.Millisecond, 15*time.Millisecond))
+
+	//broken twice, every retry dials a new conn after the delay
+	s.onQuery("select 1").times(2).disconnect()
+	start := time.Now()
+	if _, err := db.Execute("select 1"); err != nil {
+		t.Fatal(err)
+	} else if n := atomic.LoadInt32(&dials); n != 3 {
+		t.Fatal(n)
+	} else if d := time.Now().Sub(start); d < 25*time.Millisecond {
+		t.Fatal(d)
+	}
+
+	//a single attempt is not retried
+	db.SetRetryPredicate(RetryBadConn(1))
+	s.onQuery("select 2").times(1).disconnect()
+	atomic.StoreInt32(&dials, 0)
+	if _, err := db.Execute("select 2"); err != ErrBadConn {
+		t.Fatal(err)
+	} else if n := atomic.LoadInt32(&dials); n != 0 {
+		t.Fatal(n)
+	}
+
+	//a delay past the deadline fails by the error without waiting for it
+	db.SetRetryPredicate(RetryBadConn(3))
+	db.SetRetryDelay(func(int) time.Duration { return time.Hour })
+	s.onQuery("select 3").times(1).disconnect()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start = time.Now()
+	if _, err := db.ExecuteContext(ctx, "select 3"); err != ErrBadConn {
+		t.Fatal(err)
+	} else if d := time.Now().Sub(start); d > 500*time.Millisecond {
+		t.Fatal(d)
+	}
+
+	//the delay ends when ctx is canceled
+	s.onQuery("select 4").times(1).disconnect()
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := db.ExecuteContext(ctx, "select 4"); err != context.Canceled {
+		t.Fatal(err)
+	}
+}
+
+func TestRetry_ExpBackoff(t *testing.T) {
+	f := ExpBackoff(10*time.Millisecond, 50*time.Millisecond)
+	for attempt, d := range []time.Duration{10, 20, 40, 50, 50} {
+		if v := f(attempt + 1); v != d*time.Millisecond {
+			t.Fatal(attempt+1, v)
+		}
+	}
+}
+
+func TestRetry_ExpBackoffFactor(t *testing.T) {
+	f := ExpBackoffFactor(10*time.Millisecond, 50*time.Millisecond, 1.5)
+	for attempt, d := range []time.Duration{10000, 15000, 22500, 33750, 50000, 50000} {
+		if v := f(attempt + 1); v != d*time.Microsecond {
+			t.Fatal(attempt+1, v)
+		}
+	}
+}
+
+func TestRetry_Jitter(t *testing.T) {
+	f := Jitter(ExpBackoff(10*time.Millisecond, time.Second))
+	for i := 0; i < 100; i++ {
+		if d := f(2); d < 10*time.Millisecond || d >= 20*time.Millisecond {
+			t.Fatal(d)
+		}
+	}
+}
+
+func TestDB_RetryPolicy(t *testing.T) {
+	var dials int32
+	db, _ := Open("fake:3306", "root", "", "")
+	db.SetDialer(countingDialer(&dials, func(context.Context, string, string) (net.Conn, error) {
+		return nil, ErrBadConn
+	}))
+	defer db.Close()
+
+	db.SetRetryPolicy(2, Jitter(ExpBackoff(5*time.Millisecond, time.Second)))
+	start := time.Now()
+	if err := db.Ping(); err != ErrBadConn {
+		t.Fatal(err)
+	} else if n := atomic.LoadInt32(&dials); n != 3 {
+		t.Fatal(n)
+	} else if d := time.Now().Sub(start); d < 7*time.Millisecond {
+		t.Fatal(d)
+	}
+
+	//no retries
+	db.SetRetryPolicy(0, nil)
+	atomic.StoreInt32(&dials, 0)
+	if _, err := db.Begin(); err != ErrBadConn {
+		t.Fatal(err)
+	} else if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Fatal(n)
+	}
+}
+
+func TestRetry_BrokenConnErrors(t *testing.T) {
+	retried := []error{
+		ErrBadConn,
+		io.EOF,
+		&net.OpError{Op: "write", Net: "tcp", Err: syscall.EPIPE},
+		NewError(CR_SERVER_GONE_ERROR, "MySQL server has gone away"),
+		NewError(CR_SERVER_LOST, "Lost connection to MySQL server during query"),
+	}
+	for _, err := range retried {
+		if !DefaultRetryPredicate(err, 1) {
+			t.Fatalf("%v must be retried", err)
+		}
+	}
+
+	//may have run, would fail again, or not broken
+	notRetried := []error{
+		ErrQueryTimeout,
+		ErrReadTimeout,
+		ErrMalformPacket,
+		context.DeadlineExceeded,
+		&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED},
+		&OnConnectError{ErrBadConn},
+		NewError(ER_DUP_ENTRY, "Duplicate entry '1' for key 'PRIMARY'"),
+	}
+	for _, err := range notRetried {
+		if DefaultRetryPredicate(err, 1) {
+			t.Fatalf("%v must not be retried", err)
+		}
+	}
+}
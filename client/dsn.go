@@ -0,0 +1,197 @@
+package client
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dsnParam sets a pool option by the value of a dsn parameter
+type dsnParam struct {
+	name string
+	set  func(db *DB, v string) error
+}
+
+// dsnInt parses a count for f
+func dsnInt(f func(db *DB, n int)) func(db *DB, v string) error {
+	return func(db *DB, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("not a count")
+		}
+		f(db, n)
+		return nil
+	}
+}
+
+// dsnDuration parses a duration like 500ms for f
+func dsnDuration(f func(db *DB, d time.Duration)) func(db *DB, v string) error {
+	return func(db *DB, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return fmt.Errorf("not a duration")
+		}
+		f(db, d)
+		return nil
+	}
+}
+
+// dsnBool parses a bool like true or 1 for f
+func dsnBool(f func(db *DB, on bool)) func(db *DB, v string) error {
+	return func(db *DB, v string) error {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("not a bool")
+		}
+		f(db, on)
+		return nil
+	}
+}
+
+// dsnParams are the parameters of OpenDSN, applied in this order, the max
+// idle conns before the min idle conns bounded by them
+var dsnParams = []dsnParam{
+	{"maxIdleConns", dsnInt((*DB).SetMaxIdleConns)},
+	{"minIdleConns", dsnInt((*DB).SetMinIdleConns)},
+	{"maxOpenConns", dsnInt((*DB).SetMaxOpenConns)},
+	{"maxStmtsPerConn", dsnInt((*DB).SetMaxStmtsPerConn)},
+	{"timeout", dsnDuration((*DB).SetDialTimeout)},
+	{"readTimeout", dsnDuration((*DB).SetReadTimeout)},
+	{"writeTimeout", dsnDuration((*DB).SetWriteTimeout)},
+	{"waitTimeout", dsnDuration((*DB).SetWaitTimeout)},
+	{"statementTimeout", dsnDuration((*DB).SetStatementTimeout)},
+	{"connMaxLifetime", dsnDuration((*DB).SetConnMaxLifetime)},
+	{"connMaxIdleTime", dsnDuration((*DB).SetConnMaxIdleTime)},
+	{"idleGrace", dsnDuration((*DB).SetIdleGrace)},
+	{"charset", (*DB).SetCharset},
+	{"collation", (*DB).SetCollation},
+	{"stmtCache", dsnBool((*DB).SetStmtCache)},
+	{"trackGTIDs", dsnBool((*DB).SetTrackGTIDs)},
+	{"transcode", dsnBool((*DB).SetTranscode)},
+	{"optionalMetadata", dsnBool((*DB).SetOptionalMetadata)},
+	{"tls", dsnTLS},
+}
+
+// dsnTLS sets the TLS mode, true or verify-full verifies the server
+// certificate by the system roots, skip-verify encrypts only, false is
+// plaintext
+func dsnTLS(db *DB, v string) error {
+	switch v {
+	case "true", "verify-full":
+		db.SetTLSConfig(&tls.Config{})
+	case "skip-verify":
+		db.SetTLSConfig(SkipVerifyTLSConfig())
+	case "false":
+		db.SetTLSConfig(nil)
+	default:
+		return fmt.Errorf("not a tls mode")
+	}
+	return nil
+}
+
+// dsn is a parsed data source name
+type dsn struct {
+	user     string
+	password string
+	addr     string
+	db       string
+	params   url.Values
+}
+
+// parseDSN parses user:password@tcp(host:port)/db?params or
+// user:password@unix(/path)/db?params, the user and the password are
+// percent-decoded.
+func parseDSN(s string) (*dsn, error) {
+	d := new(dsn)
+
+	//the password may have an @
+	at := strings.LastIndex(s, "@tcp(")
+	if i := strings.LastIndex(s, "@unix("); i > at {
+		at = i
+	}
+	if at < 0 {
+		return nil, fmt.Errorf("invalid dsn: missing @tcp( or @unix( of the address")
+	}
+
+	user, password, _ := strings.Cut(s[:at], ":")
+	var err error
+	if d.user, err = url.PathUnescape(user); err != nil {
+		return nil, fmt.Errorf("invalid dsn: user is not percent-encoded")
+	} else if d.password, err = url.PathUnescape(password); err != nil {
+		//the password is not in the error
+		return nil, fmt.Errorf("invalid dsn: password is not percent-encoded")
+	}
+
+	network, rest, _ := strings.Cut(s[at+1:], "(")
+	var ok bool
+	if d.addr, rest, ok = strings.Cut(rest, ")"); !ok || len(d.addr) == 0 {
+		return nil, fmt.Errorf("invalid dsn: missing address")
+	}
+
+	//the conn dials unix for an addr with a slash, a relative path without
+	//one needs the network, see splitNetwork
+	if network == "tcp" && strings.Contains(d.addr, "/") {
+		return nil, fmt.Errorf("invalid dsn: tcp address %q", d.addr)
+	} else if network == "unix" && !strings.Contains(d.addr, "/") {
+		d.addr = "unix://" + d.addr
+	}
+
+	if !strings.HasPrefix(rest, "/") {
+		return nil, fmt.Errorf("invalid dsn: missing / of the db")
+	}
+
+	db, query, _ := strings.Cut(rest[1:], "?")
+	if d.db, err = url.PathUnescape(db); err != nil {
+		return nil, fmt.Errorf("invalid dsn: db %q", db)
+	}
+	if d.params, err = url.ParseQuery(query); err != nil {
+		return nil, fmt.Errorf("invalid dsn: %v", err)
+	}
+	return d, nil
+}
+
+// OpenDSN opens a pool by a data source name like
+//
+//	user:password@tcp(127.0.0.1:3306)/db?maxIdleConns=16&charset=utf8mb4&timeout=5s
+//
+// with a unix socket like unix(/tmp/mysql.sock) instead. The user and the
+// password are percent-decoded, so a % in them must be written as %25,
+// an @ or a : in the password may be written as is. Parameters, like
+// maxOpenConns and readTimeout, name the setters of DB, durations are
+// like 500ms. An unknown or a repeated parameter is an error.
+func OpenDSN(s string) (*DB, error) {
+	d, err := parseDSN(s)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool, len(dsnParams))
+	for _, p := range dsnParams {
+		known[p.name] = true
+	}
+	for name, values := range d.params {
+		if !known[name] {
+			return nil, fmt.Errorf("invalid dsn: unknown parameter %s", name)
+		} else if len(values) > 1 {
+			return nil, fmt.Errorf("invalid dsn: repeated parameter %s", name)
+		}
+	}
+
+	db, err := Open(d.addr, d.user, d.password, d.db)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range dsnParams {
+		if v, ok := d.params[p.name]; ok {
+			if err := p.set(db, v[0]); err != nil {
+				db.Close()
+				return nil, fmt.Errorf("invalid dsn: %s=%s: %v", p.name, v[0], err)
+			}
+		}
+	}
+	return db, nil
+}
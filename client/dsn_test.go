@@ -0,0 +1,126 @@
+package client
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDSN_Parse(t *testing.T) {
+	password := "p@ss:w/rd%?"
+	d, err := parseDSN("root:" + url.PathEscape(password) + "@tcp(127.0.0.1:3306)/mixer?maxIdleConns=16")
+	if err != nil {
+		t.Fatal(err)
+	} else if d.user != "root" || d.password != password || d.addr != "127.0.0.1:3306" || d.db != "mixer" {
+		t.Fatalf("%+v", d)
+	} else if d.params.Get("maxIdleConns") != "16" {
+		t.Fatal(d.params)
+	}
+
+	//not encoded
+	if d, err = parseDSN("root:p@ss:w(rd@tcp(127.0.0.1:3306)/mixer"); err != nil {
+		t.Fatal(err)
+	} else if d.user != "root" || d.password != "p@ss:w(rd" || d.addr != "127.0.0.1:3306" {
+		t.Fatalf("%+v", d)
+	}
+
+	if d, err = parseDSN("root@unix(/tmp/mysql.sock)/"); err != nil {
+		t.Fatal(err)
+	} else if d.user != "root" || d.password != "" || d.addr != "/tmp/mysql.sock" || d.db != "" {
+		t.Fatalf("%+v", d)
+	}
+	//relative, without a slash
+	if d, err = parseDSN("root@unix(mysql.sock)/"); err != nil {
+		t.Fatal(err)
+	} else if d.addr != "unix://mysql.sock" {
+		t.Fatalf("%+v", d)
+	}
+
+	for _, s := range []string{
+		"root:pass",
+		"root@127.0.0.1:3306/mixer",
+		"root@tcp(127.0.0.1:3306",
+		"root@tcp()/mixer",
+		"root@udp(127.0.0.1:3306)/mixer",
+		"root@tcp(/tmp/mysql.sock)/mixer",
+		"root@tcp(127.0.0.1:3306)",
+		"root:%zz@tcp(127.0.0.1:3306)/mixer",
+		"root@tcp(127.0.0.1:3306)/mixer?a=%zz",
+	} {
+		if _, err := parseDSN(s); err == nil {
+			t.Fatal(s)
+		} else if strings.Contains(err.Error(), "%zz") && strings.HasPrefix(s, "root:") {
+			t.Fatal("password in error", err)
+		}
+	}
+}
+
+func TestDSN_Open(t *testing.T) {
+	db, err := OpenDSN("root:secret@tcp(127.0.0.1:3306)/mixer?maxIdleConns=16&minIdleConns=4" +
+		"&maxOpenConns=32&timeout=5s&readTimeout=1s&connMaxLifetime=1h&charset=utf8mb4&stmtCache=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.Lock()
+	defer db.Unlock()
+	if db.addr != "127.0.0.1:3306" || db.user != "root" || db.password != "secret" || db.db != "mixer" {
+		t.Fatal(db.addr, db.user, db.db)
+	} else if db.maxIdleConns != 16 || db.minIdle() != 4 || db.maxOpenConns != 32 {
+		t.Fatal(db.maxIdleConns, db.minIdle(), db.maxOpenConns)
+	} else if db.dialTimeout != 5*time.Second || db.readTimeout != time.Second || db.connMaxLifetime != time.Hour {
+		t.Fatal(db.dialTimeout, db.readTimeout, db.connMaxLifetime)
+	} else if db.charset != "utf8mb4" || !db.stmtCache {
+		t.Fatal(db.charset, db.stmtCache)
+	}
+}
+
+func TestDSN_OpenErrors(t *testing.T) {
+	for _, s := range []string{
+		"root@tcp(127.0.0.1:3306)/mixer?maxIdle=16",
+		"root@tcp(127.0.0.1:3306)/mixer?maxIdleConns=16&maxIdleConns=8",
+		"root@tcp(127.0.0.1:3306)/mixer?maxIdleConns=many",
+		"root@tcp(127.0.0.1:3306)/mixer?timeout=5",
+		"root@tcp(127.0.0.1:3306)/mixer?charset=klingon",
+		"root@tcp(127.0.0.1:3306)/mixer?stmtCache=yes",
+	} {
+		if db, err := OpenDSN(s); err == nil {
+			db.Close()
+			t.Fatal(s)
+		}
+	}
+}
+
+func TestDSN_Connect(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db, err := OpenDSN("root@tcp(fake:3306)/test?maxIdleConns=1&charset=utf8mb4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetDialer(s.dial)
+	defer db.Close()
+
+	co := popTestConn(t, db)
+	if co.GetCharset() != "utf8mb4" || co.GetDB() != "test" {
+		t.Fatal(co.GetCharset(), co.GetDB())
+	}
+
+	//restored when reused
+	if err := co.SetCharset("latin1"); err != nil {
+		t.Fatal(err)
+	}
+	db.PushConn(co, nil)
+
+	co = popTestConn(t, db)
+	defer db.PushConn(co, nil)
+	if co.GetCharset() != "utf8mb4" {
+		t.Fatal(co.GetCharset())
+	}
+	if _, _, queries := s.stats(); strings.Count(strings.Join(queries, ";"), "set names utf8mb4") != 2 {
+		t.Fatal(queries)
+	}
+}
@@ -0,0 +1,274 @@
+package client
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	. "github.com/siddontang/mixer/mysql"
+	"io"
+	"strings"
+)
+
+type DumpFormat int
+
+const (
+	//insert statements
+	DumpSQL DumpFormat = iota
+	//csv of a header of column names, NULL as \N
+	DumpCSV
+)
+
+// DumpOptions configures DumpTable.
+type DumpOptions struct {
+	Format DumpFormat
+
+	// Where filters the rows dumped if set, like "id > 100".
+	Where string
+
+	// Snapshot dumps in a transaction of consistent snapshot.
+	Snapshot bool
+
+	// CreateTable writes the CREATE TABLE of the table first, SQL only.
+	CreateTable bool
+
+	// MaxStatementSize bounds the bytes of an insert statement, a row
+	// over it is inserted alone, 1MB if 0.
+	MaxStatementSize int
+}
+
+// DumpTable writes the rows of table to w as insert statements or csv. The
+// rows are relayed by QueryTo and written as they arrive, so the memory
+// used is bounded by a statement, not by the table.
+func (db *DB) DumpTable(w io.Writer, table string, opts DumpOptions) error {
+	if opts.MaxStatementSize <= 0 {
+		opts.MaxStatementSize = 1 << 20
+	}
+
+	co, err := db.PopConn()
+	if err != nil {
+		return err
+	}
+
+	err = dumpTable(co, w, table, &opts)
+	db.PushConn(co, err)
+	return err
+}
+
+func dumpTable(co *Conn, w io.Writer, table string, opts *DumpOptions) error {
+	table = quoteTable(table)
+
+	if opts.Snapshot {
+		if _, err := co.exec("start transaction with consistent snapshot"); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if opts.CreateTable && opts.Format == DumpSQL {
+		err = dumpCreateTable(co, w, table)
+	}
+
+	if err == nil {
+		query := "select * from " + table
+		if len(opts.Where) > 0 {
+			query += " where " + opts.Where
+		}
+
+		var d tableDumper
+		if opts.Format == DumpCSV {
+			d = newCSVDumper(w)
+		} else {
+			d = &sqlDumper{w: w, table: table, maxSize: opts.MaxStatementSize}
+		}
+
+		if _, err = co.QueryTo(&rowWriter{fields: d.fields, row: d.row}, query); err == nil {
+			err = d.flush()
+		}
+	}
+
+	if opts.Snapshot {
+		if _, e := co.exec("commit"); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func dumpCreateTable(co *Conn, w io.Writer, table string) error {
+	r, err := co.exec("show create table " + table)
+	if err != nil {
+		return err
+	}
+
+	create, err := r.GetString(0, 1)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s;\n\n", create)
+	return err
+}
+
+// quoteTable quotes the table name and its db if given
+func quoteTable(table string) string {
+	parts := strings.Split(table, ".")
+	for i, p := range parts {
+		parts[i] = "`" + strings.Replace(strings.Trim(p, "`"), "`", "``", -1) + "`"
+	}
+	return strings.Join(parts, ".")
+}
+
+type tableDumper interface {
+	fields(fs []*Field) error
+	row(cells [][]byte) error
+	flush() error
+}
+
+// sqlDumper writes rows as multi-row insert statements
+type sqlDumper struct {
+	w       io.Writer
+	table   string
+	maxSize int
+
+	kinds []valueKind
+	stmt  []byte
+	rows  int
+}
+
+type valueKind int
+
+const (
+	quotedValue valueKind = iota
+	numberValue
+	hexValue
+)
+
+func (d *sqlDumper) fields(fs []*Field) error {
+	d.kinds = make([]valueKind, len(fs))
+	for i, f := range fs {
+		d.kinds[i] = dumpValueKind(f)
+	}
+	return nil
+}
+
+// dumpValueKind returns how a value of f is written, binary strings are
+// written as hex literals so any bytes survive the charset of the import
+func dumpValueKind(f *Field) valueKind {
+	switch f.Type {
+	case MYSQL_TYPE_TINY, MYSQL_TYPE_SHORT, MYSQL_TYPE_INT24, MYSQL_TYPE_LONG,
+		MYSQL_TYPE_LONGLONG, MYSQL_TYPE_FLOAT, MYSQL_TYPE_DOUBLE,
+		MYSQL_TYPE_DECIMAL, MYSQL_TYPE_NEWDECIMAL, MYSQL_TYPE_YEAR:
+		return numberValue
+	case MYSQL_TYPE_BIT, MYSQL_TYPE_GEOMETRY:
+		return hexValue
+	case MYSQL_TYPE_VARCHAR, MYSQL_TYPE_VAR_STRING, MYSQL_TYPE_STRING,
+		MYSQL_TYPE_TINY_BLOB, MYSQL_TYPE_MEDIUM_BLOB, MYSQL_TYPE_LONG_BLOB, MYSQL_TYPE_BLOB:
+		if f.Charset == uint16(BINARY_COLLATION_ID) {
+			return hexValue
+		}
+	}
+	return quotedValue
+}
+
+// appendValue appends v as a literal of kind, nil as NULL
+func appendValue(buf []byte, kind valueKind, v []byte) []byte {
+	switch {
+	case v == nil:
+		return append(buf, "NULL"...)
+	case kind == numberValue:
+		return append(buf, v...)
+	case kind == hexValue && len(v) > 0:
+		buf = append(buf, "X'"...)
+		buf = append(buf, hex.EncodeToString(v)...)
+		return append(buf, '\'')
+	}
+	return appendQuoted(buf, v)
+}
+
+func (d *sqlDumper) row(cells [][]byte) error {
+	n := len(d.stmt)
+
+	if d.rows == 0 {
+		d.stmt = append(d.stmt, "INSERT INTO "...)
+		d.stmt = append(d.stmt, d.table...)
+		d.stmt = append(d.stmt, " VALUES "...)
+	} else {
+		d.stmt = append(d.stmt, ',')
+	}
+
+	d.stmt = append(d.stmt, '(')
+	for i, v := range cells {
+		if i > 0 {
+			d.stmt = append(d.stmt, ',')
+		}
+		d.stmt = appendValue(d.stmt, d.kinds[i], v)
+	}
+	d.stmt = append(d.stmt, ')')
+	d.rows++
+
+	if len(d.stmt) <= d.maxSize || d.rows == 1 {
+		return nil
+	}
+
+	//the row goes into the next statement
+	row := append([]byte(nil), d.stmt[n+1:]...)
+	d.stmt = d.stmt[:n]
+	d.rows--
+	if err := d.flush(); err != nil {
+		return err
+	}
+
+	d.stmt = append(d.stmt, "INSERT INTO "...)
+	d.stmt = append(d.stmt, d.table...)
+	d.stmt = append(d.stmt, " VALUES "...)
+	d.stmt = append(d.stmt, row...)
+	d.rows = 1
+	return nil
+}
+
+func (d *sqlDumper) flush() error {
+	if d.rows == 0 {
+		return nil
+	}
+
+	d.stmt = append(d.stmt, ";\n"...)
+	_, err := d.w.Write(d.stmt)
+
+	d.stmt = d.stmt[:0]
+	d.rows = 0
+	return err
+}
+
+// csvDumper writes rows as csv records, NULL as \N like LOAD DATA
+type csvDumper struct {
+	w      *csv.Writer
+	record []string
+}
+
+func newCSVDumper(w io.Writer) *csvDumper {
+	return &csvDumper{w: csv.NewWriter(w)}
+}
+
+func (d *csvDumper) fields(fs []*Field) error {
+	d.record = make([]string, len(fs))
+	for i, f := range fs {
+		d.record[i] = string(f.Name)
+	}
+	return d.w.Write(d.record)
+}
+
+func (d *csvDumper) row(cells [][]byte) error {
+	for i, v := range cells {
+		if v == nil {
+			d.record[i] = `\N`
+		} else {
+			d.record[i] = string(v)
+		}
+	}
+	return d.w.Write(d.record)
+}
+
+func (d *csvDumper) flush() error {
+	d.w.Flush()
+	return d.w.Error()
+}
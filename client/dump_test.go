@@ -0,0 +1,190 @@
+package client
+
+import (
+	"bytes"
+	"encoding/csv"
+	. "github.com/siddontang/mixer/mysql"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var dumpTestFields = []*Field{
+	{Name: []byte("id"), Charset: uint16(BINARY_COLLATION_ID), Type: MYSQL_TYPE_LONGLONG},
+	{Name: []byte("name"), Charset: uint16(DEFAULT_COLLATION_ID), Type: MYSQL_TYPE_VAR_STRING},
+	{Name: []byte("data"), Charset: uint16(BINARY_COLLATION_ID), Type: MYSQL_TYPE_BLOB},
+	{Name: []byte("price"), Charset: uint16(BINARY_COLLATION_ID), Type: MYSQL_TYPE_NEWDECIMAL},
+	{Name: []byte("created"), Charset: uint16(BINARY_COLLATION_ID), Type: MYSQL_TYPE_DATETIME},
+	{Name: []byte("flags"), Charset: uint16(BINARY_COLLATION_ID), Type: MYSQL_TYPE_BIT},
+}
+
+var dumpTestRows = [][][]byte{
+	{[]byte("1"), []byte("plain"), []byte("abc"), []byte("1.50"), []byte("2015-01-02 03:04:05"), []byte{1}},
+	{[]byte("2"), []byte("it's \"quoted\" \\ back\nline\r\t\x1a;\n"), []byte{0, '\'', '\\', 0xff, '\n', 0x1a}, []byte("-0.01"), []byte("0000-00-00 00:00:00"), []byte{0}},
+	{[]byte("3"), []byte("中文 ħ"), []byte{}, []byte("0"), nil, []byte{0x80, 0}},
+	{[]byte("4"), nil, nil, nil, nil, nil},
+	{[]byte("5"), []byte(`\N`), []byte("NULL"), []byte("100"), []byte("2038-01-19 03:14:07"), []byte{}},
+}
+
+const dumpTestCreate = "CREATE TABLE `t` (\n  `id` bigint NOT NULL\n) ENGINE=InnoDB"
+
+func newDumpTestSource() *fakeServer {
+	s := newFakeServer(nil)
+	s.onQuery("show create table `mixer`.`t`").rows([]string{"Table", "Create Table"}, []string{"t", dumpTestCreate})
+	s.onQuery("select * from `mixer`.`t`").resultset(dumpTestFields, false, dumpTestRows...)
+	s.onQuery("select * from `mixer`.`t` where id > 2").resultset(dumpTestFields, false, dumpTestRows[2:]...)
+	return s
+}
+
+// dumpTestTarget imports the dumped inserts and answers their rows
+type dumpTestTarget struct {
+	*fakeServer
+
+	sync.Mutex
+	rows [][][]byte
+}
+
+func newDumpTestTarget(t *testing.T) *dumpTestTarget {
+	s := new(dumpTestTarget)
+	s.fakeServer = newFakeServer(func(c *fakeServerConn, query string) error {
+		s.Lock()
+		defer s.Unlock()
+
+		prefix := "INSERT INTO `mixer`.`t` VALUES "
+		switch {
+		case strings.HasPrefix(query, prefix):
+			rows, err := parseTestValues(query[len(prefix):])
+			if err != nil {
+				t.Error(err)
+				return c.writeError(ER_PARSE_ERROR, err.Error())
+			}
+			s.rows = append(s.rows, rows...)
+			return c.writeOK()
+		case query == "select * from `mixer`.`t`":
+			for _, p := range resultsetPackets(c.status, dumpTestFields, false, s.rows) {
+				if err := c.writePacket(p); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return c.exec(query)
+	})
+	return s
+}
+
+// checkResultsetEqual compares the columns and values of two resultsets
+func checkResultsetEqual(t *testing.T, a *Resultset, b *Resultset) {
+	if len(a.Fields) != len(b.Fields) {
+		t.Fatal(len(a.Fields), len(b.Fields))
+	}
+	for i := range a.Fields {
+		if fa, fb := a.Fields[i], b.Fields[i]; string(fa.Name) != string(fb.Name) || fa.Type != fb.Type {
+			t.Fatalf("column %d: %s %s", i, fa.Name, fb.Name)
+		}
+	}
+
+	if len(a.Values) != len(b.Values) {
+		t.Fatal(len(a.Values), len(b.Values))
+	}
+	for i := range a.Values {
+		if !reflect.DeepEqual(a.Values[i], b.Values[i]) {
+			t.Fatalf("row %d: %q %q", i, a.Values[i], b.Values[i])
+		}
+	}
+}
+
+func TestDB_DumpTable(t *testing.T) {
+	src := newDumpTestSource()
+	defer src.Close()
+	dst := newDumpTestTarget(t)
+	defer dst.Close()
+
+	srcDB, dstDB := src.openDB("mixer"), dst.openDB("mixer")
+	defer srcDB.Close()
+	defer dstDB.Close()
+
+	var buf bytes.Buffer
+	opts := DumpOptions{CreateTable: true, Snapshot: true, MaxStatementSize: 150}
+	if err := srcDB.DumpTable(&buf, "mixer.t", opts); err != nil {
+		t.Fatal(err)
+	}
+
+	dump := buf.String()
+	if !strings.HasPrefix(dump, dumpTestCreate+";\n\n") {
+		t.Fatal(dump)
+	}
+	dump = dump[len(dumpTestCreate)+3:]
+
+	//bounded statements, unless of a single row
+	stmts := strings.SplitAfter(strings.TrimSuffix(dump, ";\n"), ";\n")
+	if len(stmts) < 3 {
+		t.Fatal(dump)
+	}
+	for _, stmt := range stmts {
+		stmt = strings.TrimSuffix(stmt, ";\n")
+		if len(stmt) > 150 && strings.Contains(stmt, "),(") {
+			t.Fatal(stmt)
+		}
+		if _, err := dstDB.Execute(stmt); err != nil {
+			t.Fatal(stmt, err)
+		}
+	}
+
+	a, err := srcDB.Query("select * from `mixer`.`t`")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := dstDB.Query("select * from `mixer`.`t`")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResultsetEqual(t, a, b)
+
+	//dumped in the snapshot
+	_, _, queries := src.stats()
+	expect := []string{"start transaction with consistent snapshot", "show create table `mixer`.`t`",
+		"select * from `mixer`.`t`", "commit"}
+	for i, q := range queries {
+		if q == expect[0] && !reflect.DeepEqual(queries[i:i+len(expect)], expect) {
+			t.Fatal(queries[i:])
+		} else if q == expect[0] {
+			return
+		}
+	}
+	t.Fatal(queries)
+}
+
+func TestDB_DumpTableCSV(t *testing.T) {
+	src := newDumpTestSource()
+	defer src.Close()
+
+	db := src.openDB("mixer")
+	defer db.Close()
+
+	var buf bytes.Buffer
+	if err := db.DumpTable(&buf, "mixer.t", DumpOptions{Format: DumpCSV, Where: "id > 2", CreateTable: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 4 || strings.Join(records[0], ",") != "id,name,data,price,created,flags" {
+		t.Fatal(records)
+	}
+	for i, row := range dumpTestRows[2:] {
+		for j, v := range row {
+			expect := string(v)
+			if v == nil {
+				expect = `\N`
+			}
+			if records[i+1][j] != expect {
+				t.Fatalf("row %d column %d: %q", i, j, records[i+1][j])
+			}
+		}
+	}
+}
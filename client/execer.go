@@ -0,0 +1,53 @@
+package client
+
+import (
+	. "github.com/siddontang/mixer/mysql"
+)
+
+// Execer executes a statement, args are bound by a prepared statement if given.
+type Execer interface {
+	Execute(command string, args ...interface{}) (*Result, error)
+}
+
+// Queryer executes a statement returning rows.
+type Queryer interface {
+	Query(query string, args ...interface{}) (*Resultset, error)
+}
+
+// Session is a single connection, so session state like variables,
+// temporary tables and transactions is kept between calls. A Conn or a
+// SqlConn pinned from DB.GetConn can be used as a Session.
+type Session interface {
+	Execer
+	Queryer
+
+	Prepare(query string) (*Stmt, error)
+
+	Begin() error
+	Commit() error
+	Rollback() error
+}
+
+var (
+	_ Execer  = (*DB)(nil)
+	_ Queryer = (*DB)(nil)
+
+	_ Session = (*Conn)(nil)
+	_ Session = (*SqlConn)(nil)
+)
+
+// Query executes query and returns its rows, it returns ErrNoResultset if
+// the statement returns no rows.
+func (c *Conn) Query(query string, args ...interface{}) (*Resultset, error) {
+	r, err := c.Execute(query, args...)
+	return resultsetOf(r, err)
+}
+
+func resultsetOf(r *Result, err error) (*Resultset, error) {
+	if err != nil {
+		return nil, err
+	} else if r.Resultset == nil {
+		return nil, ErrNoResultset
+	}
+	return r.Resultset, nil
+}
@@ -0,0 +1,699 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "github.com/siddontang/mixer/mysql"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const defaultFakeCapability = CLIENT_LONG_PASSWORD | CLIENT_LONG_FLAG | CLIENT_PROTOCOL_41 |
+	CLIENT_TRANSACTIONS | CLIENT_SECURE_CONNECTION
+
+// errFakeDrop is returned by a step or handle to drop the conn
+var errFakeDrop = errors.New("drop conn")
+
+// errFakeSkip is returned by a step to skip the rest steps of its rule
+var errFakeSkip = errors.New("skip steps")
+
+// fakeServer is a mysql server for the client tests, conns of a DB opened
+// by openDB are served over pipes without network, and listen serves a
+// loopback port for Conn.
+//
+// It completes the handshake of any user, answers the queries of the pool
+// itself, like the read only check and kill query, and keeps the session
+// state changed by begin, set autocommit and use. A command is answered
+// by the first scripted rule for it, see on, then queries by handle, which
+// calls exec for the default answer, or by OK. Statements are prepared
+// with no columns, their executions are scripted by the query prepared.
+type fakeServer struct {
+	sync.Mutex
+
+	//advertised in the handshake, CLIENT_PLUGIN_AUTH is added with a plugin
+	version    string
+	capability uint32
+	authPlugin string
+
+	//CLIENT_SSL is added with it, and the conns upgraded on SSL requests
+	tlsConfig *tls.Config
+
+	rules  []*fakeRule
+	handle func(c *fakeServerConn, query string) error
+
+	l      net.Listener
+	closed bool
+	nextId uint32
+	conns  map[uint32]*fakeServerConn
+
+	dials   int
+	queries []string
+
+	prepares int
+}
+
+type fakeServerConn struct {
+	s *fakeServer
+
+	id  uint32
+	c   net.Conn
+	pkg *PacketIO
+
+	//negotiated with the client
+	capability uint32
+	tls        bool
+
+	status uint16
+	db     string
+
+	//packets written before the conn is dropped, < 0 for no limit
+	dropAfter int
+
+	//signaled by kill query
+	kill chan struct{}
+
+	//prepared statements by id, guarded by s
+	stmts    map[uint32]string
+	nextStmt uint32
+
+	//the statement of the current COM_STMT_EXECUTE, and the dumped columns
+	//last sent for every statement, skipped if sent again when the client
+	//has CLIENT_OPTIONAL_RESULTSET_METADATA
+	execStmt uint32
+	stmtMeta map[uint32]string
+}
+
+// fakeRule answers a command by its steps in order, it is built by the
+// chained calls after on, like
+//
+//	s.onQuery("select sleep(10)").delay(10 * time.Second).rows([]string{"sleep"}, []string{"0"})
+type fakeRule struct {
+	cmd byte
+	arg string
+
+	//uses left, < 0 for no limit
+	left int
+
+	steps []func(c *fakeServerConn) error
+}
+
+func newFakeServer(handle func(c *fakeServerConn, query string) error) *fakeServer {
+	return &fakeServer{version: "5.6.0-fake", capability: defaultFakeCapability, handle: handle,
+		nextId: 100, conns: make(map[uint32]*fakeServerConn)}
+}
+
+// openDB opens a DB whose conns dial s
+func (s *fakeServer) openDB(dbName string) *DB {
+	db, _ := Open("fake:3306", "root", "", dbName)
+	db.SetDialer(s.dial)
+	return db
+}
+
+func (s *fakeServer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, server := net.Pipe()
+	if !s.serve(server) {
+		client.Close()
+		return nil, fmt.Errorf("dial %s %s: connection refused", network, addr)
+	}
+	return client, nil
+}
+
+// listen serves s on a loopback port and returns its address
+func (s *fakeServer) listen(t *testing.T) string {
+	return s.listenOn(t, "tcp", "127.0.0.1:0")
+}
+
+// listenOn serves s on addr of network and returns its address
+func (s *fakeServer) listenOn(t *testing.T, network, addr string) string {
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Lock()
+	s.l = l
+	s.Unlock()
+
+	go func() {
+		for {
+			co, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.serve(co)
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+// serve serves a new conn, false if s is closed
+func (s *fakeServer) serve(co net.Conn) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closed {
+		co.Close()
+		return false
+	}
+
+	s.dials++
+	s.nextId++
+	c := &fakeServerConn{s: s, id: s.nextId, c: co, pkg: NewPacketIO(co),
+		status: SERVER_STATUS_AUTOCOMMIT, dropAfter: -1, kill: make(chan struct{}, 1),
+		stmts: make(map[uint32]string), stmtMeta: make(map[uint32]string)}
+	s.conns[c.id] = c
+
+	go c.serve()
+
+	return true
+}
+
+// Close closes all conns, later dials are refused
+func (s *fakeServer) Close() {
+	s.Lock()
+	s.closed = true
+	if s.l != nil {
+		s.l.Close()
+	}
+	for _, c := range s.conns {
+		c.c.Close()
+	}
+	s.Unlock()
+}
+
+// dropConns closes the server side of all conns, like a restarted server
+func (s *fakeServer) dropConns() {
+	s.Lock()
+	for _, c := range s.conns {
+		c.c.Close()
+	}
+	s.Unlock()
+}
+
+// stats returns the open conns, the dials and the queries so far
+func (s *fakeServer) stats() (int, int, []string) {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.conns), s.dials, append([]string(nil), s.queries...)
+}
+
+// stmtStats returns the statements open on all conns and prepared so far
+func (s *fakeServer) stmtStats() (int, int) {
+	s.Lock()
+	defer s.Unlock()
+
+	open := 0
+	for _, c := range s.conns {
+		open += len(c.stmts)
+	}
+	return open, s.prepares
+}
+
+func (c *fakeServerConn) serve() {
+	defer func() {
+		c.c.Close()
+
+		c.s.Lock()
+		delete(c.s.conns, c.id)
+		c.s.Unlock()
+	}()
+
+	if c.handshake() != nil {
+		return
+	}
+
+	for {
+		c.pkg.Sequence = 0
+
+		data, err := c.pkg.ReadPacket()
+		if err != nil {
+			return
+		}
+
+		c.dropAfter = -1
+
+		if data[0] == COM_QUERY {
+			c.s.Lock()
+			c.s.queries = append(c.s.queries, string(data[1:]))
+			c.s.Unlock()
+		}
+
+		arg := string(data[1:])
+		c.execStmt = 0
+		if data[0] == COM_STMT_EXECUTE && len(data) >= 5 {
+			c.execStmt = binary.LittleEndian.Uint32(data[1:])
+			c.s.Lock()
+			if query, ok := c.stmts[c.execStmt]; ok {
+				arg = query
+			}
+			c.s.Unlock()
+		}
+
+		if r := c.s.match(data[0], arg); r != nil {
+			err = r.run(c)
+		} else {
+			err = c.command(data)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *fakeServerConn) command(data []byte) error {
+	switch data[0] {
+	case COM_QUIT:
+		return errFakeDrop
+	case COM_QUERY:
+		return c.query(string(data[1:]))
+	case COM_INIT_DB:
+		c.db = string(data[1:])
+	case COM_RESET_CONNECTION, COM_CHANGE_USER:
+		if data[0] == COM_CHANGE_USER {
+			//user, auth and db
+			user := bytes.IndexByte(data[1:], 0) + 1
+			db := data[user+2+int(data[user+1]):]
+			c.db = string(db[:bytes.IndexByte(db, 0)])
+		}
+		c.status = SERVER_STATUS_AUTOCOMMIT
+		c.s.Lock()
+		c.stmts = make(map[uint32]string)
+		c.stmtMeta = make(map[uint32]string)
+		c.s.Unlock()
+	case COM_STMT_PREPARE:
+		return c.prepare(string(data[1:]))
+	case COM_STMT_CLOSE:
+		c.s.Lock()
+		delete(c.stmts, binary.LittleEndian.Uint32(data[1:]))
+		delete(c.stmtMeta, binary.LittleEndian.Uint32(data[1:]))
+		c.s.Unlock()
+		return nil
+	}
+	return c.writeOK()
+}
+
+// prepare answers a statement of the params of query and no columns
+func (c *fakeServerConn) prepare(query string) error {
+	c.s.Lock()
+	c.s.prepares++
+	c.nextStmt++
+	id := c.nextStmt
+	c.stmts[id] = query
+	c.s.Unlock()
+
+	params := strings.Count(query, "?")
+	data := []byte{OK_HEADER, byte(id), byte(id >> 8), byte(id >> 16), byte(id >> 24),
+		0, 0, byte(params), byte(params >> 8), 0, 0, 0}
+	if c.capability&CLIENT_OPTIONAL_RESULTSET_METADATA > 0 {
+		data = append(data, RESULTSET_METADATA_FULL)
+	}
+	if err := c.writePacket(data); err != nil {
+		return err
+	}
+
+	if params == 0 {
+		return nil
+	}
+	for i := 0; i < params; i++ {
+		if err := c.writePacket((&Field{Name: []byte("?")}).Dump()); err != nil {
+			return err
+		}
+	}
+	return c.writePacket(c.eof())
+}
+
+func (c *fakeServerConn) handshake() error {
+	capability := c.s.capability
+	if len(c.s.authPlugin) > 0 {
+		capability |= CLIENT_PLUGIN_AUTH
+	}
+	if c.s.tlsConfig != nil {
+		capability |= CLIENT_SSL
+	}
+
+	data := make([]byte, 4, 128)
+	data = append(data, 10)
+	data = append(data, c.s.version...)
+	data = append(data, 0)
+	data = append(data, byte(c.id), byte(c.id>>8), byte(c.id>>16), byte(c.id>>24))
+	data = append(data, "12345678"...)
+	data = append(data, 0)
+	data = append(data, byte(capability), byte(capability>>8))
+	data = append(data, uint8(DEFAULT_COLLATION_ID))
+	data = append(data, byte(c.status), byte(c.status>>8))
+	data = append(data, byte(capability>>16), byte(capability>>24))
+	data = append(data, 0x15, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	data = append(data, "123456789012"...)
+	data = append(data, 0)
+	if len(c.s.authPlugin) > 0 {
+		data = append(data, c.s.authPlugin...)
+		data = append(data, 0)
+	}
+
+	if err := c.pkg.WritePacket(data); err != nil {
+		return err
+	}
+
+	//any user and password, the ClientHello after an SSL request must not
+	//be read ahead by the buffer of pkg
+	read := c.pkg.ReadPacket
+	if c.s.tlsConfig != nil {
+		read = c.readRawPacket
+	}
+	data, err := read()
+	if err != nil {
+		return err
+	}
+
+	//an SSL request, the response follows encrypted
+	if binary.LittleEndian.Uint32(data)&capability&CLIENT_SSL > 0 && len(data) == 32 {
+		tlsConn := tls.Server(c.c, c.s.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return err
+		}
+
+		seq := c.pkg.Sequence
+		c.pkg = NewPacketIO(tlsConn)
+		c.pkg.Sequence = seq
+		c.tls = true
+
+		if data, err = c.pkg.ReadPacket(); err != nil {
+			return err
+		}
+	}
+	c.capability = binary.LittleEndian.Uint32(data) & capability
+	c.db = handshakeDB(data)
+	return c.writeOK()
+}
+
+// readRawPacket reads a packet unbuffered
+func (c *fakeServerConn) readRawPacket() ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(c.c, header); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, int(header[0])|int(header[1])<<8|int(header[2])<<16)
+	if _, err := io.ReadFull(c.c, data); err != nil {
+		return nil, err
+	}
+	c.pkg.Sequence = header[3] + 1
+	return data, nil
+}
+
+// handshakeDB returns the db of a handshake response, after the capability,
+// max packet size, charset, filler, user and auth
+func handshakeDB(data []byte) string {
+	pos := 4 + 4 + 1 + 23
+	pos += bytes.IndexByte(data[pos:], 0) + 1
+	pos += 1 + int(data[pos])
+	if pos >= len(data) {
+		return ""
+	}
+	return string(bytes.TrimRight(data[pos:], "\x00"))
+}
+
+func (c *fakeServerConn) query(query string) error {
+	var id uint32
+
+	switch {
+	case strings.Contains(query, "@@read_only"):
+		return c.writeResultset([]string{"@@read_only", "@@super_read_only"}, [][]string{{"0", "0"}})
+	case fmtScan(query, "kill query %d", &id):
+		c.s.Lock()
+		killed, ok := c.s.conns[id]
+		c.s.Unlock()
+
+		if !ok {
+			return c.writeError(ER_NO_SUCH_THREAD, fmt.Sprintf("Unknown thread id: %d", id))
+		}
+
+		select {
+		case killed.kill <- struct{}{}:
+		default:
+		}
+		return c.writeOK()
+	case c.s.handle != nil:
+		return c.s.handle(c, query)
+	}
+
+	return c.exec(query)
+}
+
+// exec answers query by OK, after changing the session state
+func (c *fakeServerConn) exec(query string) error {
+	switch q := strings.ToLower(query); {
+	case q == "begin" || strings.HasPrefix(q, "start transaction"):
+		c.status |= SERVER_STATUS_IN_TRANS
+	case q == "commit" || q == "rollback":
+		c.status &^= SERVER_STATUS_IN_TRANS
+	case q == "set autocommit = 0":
+		c.status &^= SERVER_STATUS_AUTOCOMMIT
+	case q == "set autocommit = 1":
+		c.status |= SERVER_STATUS_AUTOCOMMIT
+	default:
+		if db, ok := parseUseDB(query); ok {
+			c.db = db
+		}
+	}
+
+	return c.writeOK()
+}
+
+func fmtScan(s string, format string, args ...interface{}) bool {
+	n, err := fmt.Sscanf(s, format, args...)
+	return err == nil && n == len(args)
+}
+
+// on scripts the answer of cmd with arg, the query of COM_QUERY, rules
+// are matched in the order scripted
+func (s *fakeServer) on(cmd byte, arg string) *fakeRule {
+	r := &fakeRule{cmd: cmd, arg: arg, left: -1}
+
+	s.Lock()
+	s.rules = append(s.rules, r)
+	s.Unlock()
+
+	return r
+}
+
+func (s *fakeServer) onQuery(query string) *fakeRule {
+	return s.on(COM_QUERY, query)
+}
+
+// match returns the first rule for cmd and arg with uses left
+func (s *fakeServer) match(cmd byte, arg string) *fakeRule {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, r := range s.rules {
+		if r.cmd == cmd && r.arg == arg && r.left != 0 {
+			if r.left > 0 {
+				r.left--
+			}
+			return r
+		}
+	}
+	return nil
+}
+
+func (r *fakeRule) run(c *fakeServerConn) error {
+	for _, step := range r.steps {
+		if err := step(c); err == errFakeSkip {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// times limits the rule to the first n matched commands
+func (r *fakeRule) times(n int) *fakeRule {
+	r.left = n
+	return r
+}
+
+// do adds a custom step
+func (r *fakeRule) do(step func(c *fakeServerConn) error) *fakeRule {
+	r.steps = append(r.steps, step)
+	return r
+}
+
+func (r *fakeRule) ok() *fakeRule {
+	return r.do(func(c *fakeServerConn) error {
+		return c.writeOK()
+	})
+}
+
+func (r *fakeRule) err(code uint16, msg string) *fakeRule {
+	return r.do(func(c *fakeServerConn) error {
+		return c.writeError(code, msg)
+	})
+}
+
+// rows answers a resultset of string columns
+func (r *fakeRule) rows(names []string, rows ...[]string) *fakeRule {
+	return r.do(func(c *fakeServerConn) error {
+		return c.writeResultset(names, rows)
+	})
+}
+
+// resultset answers the raw cells of fields, in the binary protocol of
+// COM_STMT_EXECUTE if binary
+func (r *fakeRule) resultset(fields []*Field, binary bool, rows ...[][]byte) *fakeRule {
+	return r.do(func(c *fakeServerConn) error {
+		return c.writeResultsetPackets(fields, binary, rows)
+	})
+}
+
+// packets answers raw packet payloads, like malformed or oversized ones
+func (r *fakeRule) packets(data ...[]byte) *fakeRule {
+	return r.do(func(c *fakeServerConn) error {
+		for _, p := range data {
+			if err := c.writePacket(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// delay waits d, a kill query of the conn interrupts it and answers
+// ER_QUERY_INTERRUPTED instead of the rest steps
+func (r *fakeRule) delay(d time.Duration) *fakeRule {
+	return r.do(func(c *fakeServerConn) error {
+		select {
+		case <-c.kill:
+			if err := c.writeError(ER_QUERY_INTERRUPTED, "Query execution was interrupted"); err != nil {
+				return err
+			}
+			return errFakeSkip
+		case <-time.After(d):
+			return nil
+		}
+	})
+}
+
+// disconnect drops the conn
+func (r *fakeRule) disconnect() *fakeRule {
+	return r.do(func(c *fakeServerConn) error {
+		return errFakeDrop
+	})
+}
+
+// disconnectAfter drops the conn after the next n packets of the answer,
+// in the middle of a resultset
+func (r *fakeRule) disconnectAfter(n int) *fakeRule {
+	return r.do(func(c *fakeServerConn) error {
+		c.dropAfter = n
+		return nil
+	})
+}
+
+func (c *fakeServerConn) writePacket(data []byte) error {
+	if c.dropAfter == 0 {
+		return errFakeDrop
+	} else if c.dropAfter > 0 {
+		c.dropAfter--
+	}
+	return c.pkg.WritePacket(append(make([]byte, 4, 4+len(data)), data...))
+}
+
+func (c *fakeServerConn) writeOK() error {
+	return c.writePacket([]byte{OK_HEADER, 0, 0, byte(c.status), byte(c.status >> 8), 0, 0})
+}
+
+func (c *fakeServerConn) writeError(code uint16, msg string) error {
+	state, ok := MySQLState[code]
+	if !ok {
+		state = DEFAULT_MYSQL_STATE
+	}
+	data := []byte{ERR_HEADER, byte(code), byte(code >> 8), '#'}
+	data = append(data, state...)
+	return c.writePacket(append(data, msg...))
+}
+
+func (c *fakeServerConn) eof() []byte {
+	return []byte{EOF_HEADER, 0, 0, byte(c.status), byte(c.status >> 8)}
+}
+
+// writeResultset writes the rows of string columns
+func (c *fakeServerConn) writeResultset(names []string, rows [][]string) error {
+	fields := make([]*Field, len(names))
+	for i, name := range names {
+		fields[i] = &Field{Name: []byte(name), Charset: uint16(DEFAULT_COLLATION_ID), Type: MYSQL_TYPE_VAR_STRING}
+	}
+
+	cells := make([][][]byte, len(rows))
+	for i, row := range rows {
+		for _, v := range row {
+			cells[i] = append(cells[i], []byte(v))
+		}
+	}
+
+	return c.writeResultsetPackets(fields, false, cells)
+}
+
+// writeResultsetPackets writes a resultset of raw cells, with the metadata
+// flag if negotiated, the columns of an execute are skipped if the same as
+// the last ones sent for the statement
+func (c *fakeServerConn) writeResultsetPackets(fields []*Field, binary bool, rows [][][]byte) error {
+	packets := resultsetPackets(c.status, fields, binary, rows)
+
+	if c.capability&CLIENT_OPTIONAL_RESULTSET_METADATA > 0 {
+		metadata := RESULTSET_METADATA_FULL
+		if c.execStmt != 0 {
+			var dump []byte
+			for _, f := range fields {
+				dump = append(dump, f.Dump()...)
+			}
+
+			c.s.Lock()
+			if c.stmtMeta[c.execStmt] == string(dump) {
+				metadata = RESULTSET_METADATA_NONE
+			}
+			c.stmtMeta[c.execStmt] = string(dump)
+			c.s.Unlock()
+		}
+
+		packets[0] = append(packets[0], metadata)
+		if metadata == RESULTSET_METADATA_NONE {
+			packets = append(packets[:1], packets[len(fields)+2:]...)
+		}
+	}
+
+	for _, p := range packets {
+		if err := c.writePacket(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resultsetPackets returns the packets of a resultset of raw cells, nil
+// cells are NULL
+func resultsetPackets(status uint16, fields []*Field, binary bool, rows [][][]byte) [][]byte {
+	eof := []byte{EOF_HEADER, 0, 0, byte(status), byte(status >> 8)}
+
+	packets := [][]byte{PutLengthEncodedInt(uint64(len(fields)))}
+	for _, f := range fields {
+		packets = append(packets, f.Dump())
+	}
+	packets = append(packets, eof)
+
+	for _, row := range rows {
+		packets = append(packets, NewRowData(fields, row, binary))
+	}
+	return append(packets, eof)
+}
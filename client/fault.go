@@ -0,0 +1,156 @@
+package client
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// FaultPoint is where a FaultInjector can fail or stall a conn.
+type FaultPoint int
+
+const (
+	//before dialing the server
+	FaultDial FaultPoint = iota
+	//after dialed, before the handshake
+	FaultHandshake
+	//before a packet is written, the server gets nothing
+	FaultBeforeWrite
+	//after a packet is written, the server may have executed the command
+	FaultAfterWrite
+	//before a packet is read
+	FaultRead
+)
+
+var faultPointNames = []string{"dial", "handshake", "before write", "after write", "read"}
+
+func (p FaultPoint) String() string {
+	if p < 0 || int(p) >= len(faultPointNames) {
+		return fmt.Sprintf("fault point %d", int(p))
+	}
+	return faultPointNames[p]
+}
+
+// FaultInjector fails or stalls the conns of a DB for chaos tests, see
+// DB.SetFaultInjector.
+type FaultInjector interface {
+	// Inject is called by a conn at p, it stalls the conn by sleeping and
+	// fails it by returning an error. A failed write or read breaks the
+	// conn like a network error, the operation returns ErrBadConn.
+	Inject(p FaultPoint) error
+}
+
+// FaultError is the error injected by a FaultPlan.
+type FaultError struct {
+	Point FaultPoint
+}
+
+func (e *FaultError) Error() string {
+	return fmt.Sprintf("injected %s fault", e.Point)
+}
+
+// FaultPlan is a FaultInjector failing the calls of a point by rate or by
+// schedule, and counting the injected faults of every point.
+type FaultPlan struct {
+	sync.Mutex
+
+	rand *rand.Rand
+
+	rates    map[FaultPoint]float64
+	schedule map[FaultPoint]map[int]bool
+
+	//handshake and read faults stall instead of failing if set
+	stall time.Duration
+
+	calls    map[FaultPoint]int
+	injected map[FaultPoint]int
+}
+
+// NewFaultPlan returns a plan injecting nothing, its rates are drawn from
+// seed.
+func NewFaultPlan(seed int64) *FaultPlan {
+	return &FaultPlan{
+		rand:     rand.New(rand.NewSource(seed)),
+		rates:    make(map[FaultPoint]float64),
+		schedule: make(map[FaultPoint]map[int]bool),
+		calls:    make(map[FaultPoint]int),
+		injected: make(map[FaultPoint]int),
+	}
+}
+
+// SetRate fails the fraction rate of the calls at p.
+func (f *FaultPlan) SetRate(p FaultPoint, rate float64) *FaultPlan {
+	f.Lock()
+	f.rates[p] = rate
+	f.Unlock()
+	return f
+}
+
+// FailAt fails the nth calls at p, counted from 1.
+func (f *FaultPlan) FailAt(p FaultPoint, nth ...int) *FaultPlan {
+	f.Lock()
+	if f.schedule[p] == nil {
+		f.schedule[p] = make(map[int]bool)
+	}
+	for _, n := range nth {
+		f.schedule[p][n] = true
+	}
+	f.Unlock()
+	return f
+}
+
+// SetStall makes the faults at FaultHandshake and FaultRead stall the conn
+// for d instead of failing it, like a stalled handshake or a slow read.
+func (f *FaultPlan) SetStall(d time.Duration) *FaultPlan {
+	f.Lock()
+	f.stall = d
+	f.Unlock()
+	return f
+}
+
+func (f *FaultPlan) Inject(p FaultPoint) error {
+	f.Lock()
+	f.calls[p]++
+	hit := f.schedule[p][f.calls[p]]
+	if !hit && f.rates[p] > 0 {
+		hit = f.rand.Float64() < f.rates[p]
+	}
+	if hit {
+		f.injected[p]++
+	}
+	stall := f.stall
+	f.Unlock()
+
+	if !hit {
+		return nil
+	}
+
+	if stall > 0 && (p == FaultHandshake || p == FaultRead) {
+		time.Sleep(stall)
+		return nil
+	}
+	return &FaultError{p}
+}
+
+// Injected returns the faults injected at p so far.
+func (f *FaultPlan) Injected(p FaultPoint) int {
+	f.Lock()
+	defer f.Unlock()
+	return f.injected[p]
+}
+
+// SetFaultInjector injects faults into the conns opened from now on, nil
+// disables the injection. It is meant for chaos tests only.
+func (db *DB) SetFaultInjector(f FaultInjector) {
+	db.Lock()
+	db.faults = f
+	db.Unlock()
+}
+
+func (db *DB) faultInjector() FaultInjector {
+	db.Lock()
+	f := db.faults
+	db.Unlock()
+	return f
+}
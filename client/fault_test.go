@@ -0,0 +1,159 @@
+package client
+
+import (
+	. "github.com/siddontang/mixer/mysql"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFault_Points(t *testing.T) {
+	var applied int32
+	s := newFakeServer(func(c *fakeServerConn, query string) error {
+		if query == "insert into t values (1)" {
+			atomic.AddInt32(&applied, 1)
+		}
+		return c.exec(query)
+	})
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	db.SetRetryPredicate(nil)
+
+	plan := NewFaultPlan(1)
+	db.SetFaultInjector(plan)
+
+	//fails the next call at p, after an idle conn is ready
+	next := func(p FaultPoint) {
+		co := popTestConn(t, db)
+		db.PushConn(co, nil)
+
+		plan.Lock()
+		n := plan.calls[p] + 1
+		plan.Unlock()
+		plan.FailAt(p, n)
+	}
+
+	insert := func() error {
+		_, err := db.Execute("insert into t values (1)")
+		return err
+	}
+
+	checkApplied := func(n int32) {
+		for i := 0; i < 1000 && atomic.LoadInt32(&applied) != n; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		if v := atomic.LoadInt32(&applied); v != n {
+			t.Fatalf("applied %d, not %d", v, n)
+		}
+	}
+
+	//a new conn fails by the fault error
+	for _, p := range []FaultPoint{FaultDial, FaultHandshake} {
+		plan.Lock()
+		n := plan.calls[p] + 1
+		plan.Unlock()
+		plan.FailAt(p, n)
+
+		if e, ok := insert().(*FaultError); !ok || e.Point != p {
+			t.Fatal(p, e)
+		} else if plan.Injected(p) != 1 {
+			t.Fatal(p, plan.Injected(p))
+		}
+	}
+	checkApplied(0)
+
+	//a broken conn fails by ErrBadConn, the insert may have been applied
+	for i, c := range []struct {
+		p       FaultPoint
+		applied int32
+	}{
+		{FaultBeforeWrite, 0},
+		{FaultAfterWrite, 1},
+		{FaultRead, 2},
+	} {
+		next(c.p)
+		if err := insert(); err != ErrBadConn {
+			t.Fatal(c.p, err)
+		} else if plan.Injected(c.p) != 1 {
+			t.Fatal(c.p, plan.Injected(c.p))
+		}
+		checkApplied(c.applied)
+
+		if st := db.Stats(); st.InUse != 0 || st.Failed != uint64(i+3) {
+			t.Fatalf("%s: %+v", c.p, st)
+		}
+	}
+
+	//a slow read
+	plan.SetStall(50 * time.Millisecond)
+	next(FaultRead)
+	start := time.Now()
+	if err := insert(); err != nil {
+		t.Fatal(err)
+	} else if d := time.Now().Sub(start); d < 50*time.Millisecond {
+		t.Fatal(d)
+	}
+	checkApplied(3)
+
+	//retrying a write broken after written applies it twice
+	db.SetRetryPredicate(DefaultRetryPredicate)
+	next(FaultAfterWrite)
+	if err := insert(); err != nil {
+		t.Fatal(err)
+	}
+	checkApplied(5)
+
+	//without the retries once sent, a write broken before is retried
+	db.SetRetrySent(false)
+	next(FaultBeforeWrite)
+	if err := insert(); err != nil {
+		t.Fatal(err)
+	}
+	checkApplied(6)
+
+	//but not one broken after, it may have been applied
+	next(FaultAfterWrite)
+	if err := insert(); err != ErrBadConn {
+		t.Fatal(err)
+	}
+	checkApplied(7)
+}
+
+func TestFault_CloseOrphanStmts(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+
+	plan := NewFaultPlan(1)
+	db.SetFaultInjector(plan)
+
+	co := popTestConn(t, db)
+	for _, query := range []string{"select ?", "select ? + 1", "select ? + 2"} {
+		stmt, err := co.Prepare(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stmt.CloseLater()
+	}
+
+	//the first close fails, the others are tried still
+	plan.Lock()
+	n := plan.calls[FaultBeforeWrite] + 1
+	plan.Unlock()
+	plan.FailAt(FaultBeforeWrite, n)
+
+	if err := co.closeOrphanStmts(); err != ErrBadConn {
+		t.Fatal(err)
+	} else if n := co.StmtNum(); n != 0 {
+		t.Fatal(n)
+	}
+
+	db.PushConn(co, ErrBadConn)
+	if st := db.Stats(); st.OpenConns != 0 || st.Failed != 1 {
+		t.Fatalf("%+v", st)
+	}
+}
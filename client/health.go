@@ -0,0 +1,180 @@
+package client
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// healthPingTimeout bounds the ping of an idle conn by the health check,
+// or the interval if shorter
+const healthPingTimeout = time.Second
+
+// StartHealthCheck pings every idle conn not pinged within interval, each
+// interval, and closes the dead or slow ones, so they do not pile up in a
+// quiet pool. PopConn then skips the ping of an idle conn pinged within
+// interval. A running check is replaced, 0 stops it, and Close stops it
+// and waits for a ping in flight.
+func (db *DB) StartHealthCheck(interval time.Duration) {
+	db.Lock()
+	db.stopHealthCheck()
+	db.healthInterval = interval
+	if !db.closed && interval > 0 {
+		quit := make(chan struct{})
+		db.healthQuit = quit
+		db.healthChecks.Add(1)
+		go db.healthCheck(quit, interval)
+	}
+	db.Unlock()
+}
+
+// SetHeartbeatInterval starts the health check with interval d, 0 stops
+// it, see StartHealthCheck.
+func (db *DB) SetHeartbeatInterval(d time.Duration) {
+	db.StartHealthCheck(d)
+}
+
+// stopHealthCheck stops the health check, must hold the lock
+func (db *DB) stopHealthCheck() {
+	db.healthInterval = 0
+	if db.healthQuit != nil {
+		close(db.healthQuit)
+		db.healthQuit = nil
+	}
+}
+
+// healthChecked is true for an idle conn pinged within the health check
+// interval, must hold the lock
+func (db *DB) healthChecked(co *Conn, now time.Time) bool {
+	//lastPing is in seconds, rounded down
+	return db.healthInterval > 0 && now.Sub(time.Unix(co.lastPing, 0)) < db.healthInterval
+}
+
+// SetPingInterval skips the ping of an idle conn at checkout if it was
+// returned to the pool at most d ago, and pings it otherwise, so a busy
+// pool saves the round trip of the ping. A conn broken meanwhile fails its
+// statement, retried on another conn by the retry predicate. 0, the
+// default, pings a conn at checkout if it was not pinged for 30 seconds,
+// however recently it was used.
+func (db *DB) SetPingInterval(d time.Duration) {
+	db.Lock()
+	db.pingInterval = d
+	db.Unlock()
+}
+
+// pingSkipped is true for an idle conn returned within the ping interval,
+// else it is marked to be pinged at checkout, must hold the lock
+func (db *DB) pingSkipped(co *Conn, now time.Time) bool {
+	if db.pingInterval <= 0 {
+		return false
+	}
+
+	//0 if expired by a broken conn of the pool, see expireIdlePings
+	if co.lastPing != 0 && now.Sub(co.lastUsed) <= db.pingInterval {
+		return true
+	}
+	co.lastPing = 0
+	return false
+}
+
+// healthCheck checks the idle conns every interval until quit
+func (db *DB) healthCheck(quit chan struct{}, interval time.Duration) {
+	defer db.healthChecks.Done()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-quit:
+			return
+		}
+
+		start := time.Now()
+		for db.checkIdle(quit, interval, start) {
+		}
+	}
+}
+
+// checkIdle pings the oldest idle conn due in the round started at start,
+// it is taken out of the idle conns meanwhile, so PopConn never gets it.
+// It returns false if there is none or quit.
+func (db *DB) checkIdle(quit chan struct{}, interval time.Duration, start time.Time) bool {
+	var co *Conn
+
+	db.Lock()
+	if db.healthQuit == quit {
+		for e := db.idleConns.Front(); e != nil; e = e.Next() {
+			//pinged in this round already, or within the interval
+			if c := e.Value.(*Conn); c.lastPing < start.Unix() && !db.healthChecked(c, start) {
+				co = c
+				db.idleConns.Remove(e)
+				break
+			}
+		}
+	}
+	db.Unlock()
+
+	if co == nil {
+		return false
+	}
+
+	timeout := healthPingTimeout
+	if interval < timeout {
+		timeout = interval
+	}
+	err := co.guard(func(c *Conn) error {
+		return c.pingWithin(timeout)
+	})
+	if err != nil {
+		atomic.AddUint64(&db.healthCheckCloses, 1)
+		db.closeConn(co)
+		db.releaseSlot()
+		return true
+	}
+
+	db.putIdle(co)
+	return true
+}
+
+// pingWithin pings regardless of the last ping, and waits at most d for
+// the answer like the query timeout
+func (c *Conn) pingWithin(d time.Duration) error {
+	c.deadline = time.Now().Add(d)
+	c.conn.SetReadDeadline(c.deadline)
+	defer c.disarmTimeout()
+
+	if err := c.ping(); err != nil {
+		return err
+	}
+	c.lastPing = time.Now().Unix()
+	return nil
+}
+
+// putIdle puts a checked idle conn back in front of the idle conns, it is
+// still the oldest one, or hands it to a waiter
+func (db *DB) putIdle(co *Conn) {
+	db.Lock()
+	if db.closed || co.generation != db.generation {
+		db.Unlock()
+		db.closeConn(co)
+		db.releaseSlot()
+		return
+	}
+
+	if w := db.nextWaiter(); w != nil {
+		db.Unlock()
+		w.ch <- co
+		return
+	}
+
+	db.idleConns.PushFront(co)
+	closeConns := db.shrinkIdle()
+	db.Unlock()
+
+	atomic.AddUint64(&db.idleFullCloses, uint64(len(closeConns)))
+	for _, c := range closeConns {
+		atomic.AddInt32(&db.connNum, -1)
+		db.closeConn(c)
+	}
+}
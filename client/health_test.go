@@ -0,0 +1,269 @@
+package client
+
+import (
+	. "github.com/siddontang/mixer/mysql"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countPings counts the COM_PING of all conns of s
+func countPings(s *fakeServer) *int32 {
+	n := new(int32)
+	s.on(COM_PING, "").do(func(c *fakeServerConn) error {
+		atomic.AddInt32(n, 1)
+		return c.writeOK()
+	})
+	return n
+}
+
+func TestPool_HealthCheck(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(2)
+	defer db.Close()
+
+	co1 := popTestConn(t, db)
+	co2 := popTestConn(t, db)
+	db.PushConn(co1, nil)
+	db.PushConn(co2, nil)
+
+	//dropped by the server while idle, closed without a checkout
+	s.dropConns()
+	db.StartHealthCheck(20 * time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st := db.Stats()
+		if st.HealthCheckCloses == 2 && st.OpenConns == 0 && st.IdleConns == 0 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("%+v", st)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	co := popTestConn(t, db)
+	db.PushConn(co, nil)
+
+	if st := db.Stats(); st.ReuseFailures != 0 || st.OpenConns != 1 {
+		t.Fatalf("%+v", st)
+	}
+}
+
+func TestPool_HealthCheckSlowPing(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	defer db.Close()
+
+	co := popTestConn(t, db)
+	co.lastPing = 0
+	db.PushConn(co, nil)
+
+	//not answered within the interval
+	s.on(COM_PING, "").delay(time.Second).ok()
+	db.StartHealthCheck(50 * time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for db.Stats().HealthCheckCloses != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%+v", db.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if st := db.Stats(); st.OpenConns != 0 || st.IdleConns != 0 {
+		t.Fatalf("%+v", st)
+	}
+}
+
+func TestPool_HealthCheckClose(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+
+	co := popTestConn(t, db)
+	co.lastPing = 0
+	db.PushConn(co, nil)
+
+	pinging := make(chan struct{}, 1)
+	s.on(COM_PING, "").do(func(c *fakeServerConn) error {
+		pinging <- struct{}{}
+		time.Sleep(100 * time.Millisecond)
+		return c.writeOK()
+	})
+	db.StartHealthCheck(20 * time.Millisecond)
+
+	select {
+	case <-pinging:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not pinged")
+	}
+
+	//the conn being pinged is closed before Close returns
+	db.Close()
+	if st := db.Stats(); st.Closed != 1 || st.OpenConns != 0 {
+		t.Fatalf("%+v", st)
+	}
+}
+
+func TestPool_HealthCheckSkipsPing(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+	pings := countPings(s)
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	defer db.Close()
+
+	//checked within the interval, reused without a ping
+	db.StartHealthCheck(time.Hour)
+
+	co := popTestConn(t, db)
+	id := co.ConnectionId()
+	co.lastPing = time.Now().Unix() - 2*pingPeriod
+	db.PushConn(co, nil)
+
+	co = popTestConn(t, db)
+	if co.ConnectionId() != id {
+		t.Fatal(co.ConnectionId(), id)
+	}
+	if n := atomic.LoadInt32(pings); n != 0 {
+		t.Fatal(n)
+	}
+	co.lastPing = time.Now().Unix() - 2*pingPeriod
+	db.PushConn(co, nil)
+
+	//pinged at the checkout as before without the health check
+	db.StartHealthCheck(0)
+
+	co = popTestConn(t, db)
+	if n := atomic.LoadInt32(pings); n != 1 {
+		t.Fatal(n)
+	}
+	db.PushConn(co, nil)
+
+	db.StartHealthCheck(time.Hour)
+	db.Close()
+
+	db.Lock()
+	quit := db.healthQuit
+	db.Unlock()
+	if quit != nil {
+		t.Fatal("health check not stopped")
+	}
+}
+
+func TestPool_PingInterval(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+	pings := countPings(s)
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	defer db.Close()
+
+	db.SetPingInterval(time.Hour)
+	co := popTestConn(t, db)
+	db.PushConn(co, nil)
+
+	//not pinged for long, but used lately
+	co.lastPing = time.Now().Unix() - 2*pingPeriod
+	co = popTestConn(t, db)
+	db.PushConn(co, nil)
+	if n := atomic.LoadInt32(pings); n != 0 {
+		t.Fatal(n)
+	}
+
+	//expired by a broken conn of the pool
+	co.lastPing = 0
+	co = popTestConn(t, db)
+	db.PushConn(co, nil)
+	if n := atomic.LoadInt32(pings); n != 1 {
+		t.Fatal(n)
+	}
+
+	//pinged lately, but idle over the interval
+	db.SetPingInterval(time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	co = popTestConn(t, db)
+	db.PushConn(co, nil)
+	if n := atomic.LoadInt32(pings); n != 2 {
+		t.Fatal(n)
+	}
+
+	if st := db.Stats(); st.Created != 1 || st.ReuseFailures != 0 {
+		t.Fatalf("%+v", st)
+	}
+}
+
+// BenchmarkDB_PingInterval runs point selects of a single conn whose pings
+// take 100µs, like over a WAN link, pinged at every checkout or skipped
+func BenchmarkDB_PingInterval(b *testing.B) {
+	for _, bc := range []struct {
+		name     string
+		interval time.Duration
+	}{
+		{"PingEveryCheckout", time.Nanosecond},
+		{"SkipWithin1s", time.Second},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			s := newFakeServer(nil)
+			defer s.Close()
+			s.on(COM_PING, "").delay(100 * time.Microsecond).ok()
+			s.onQuery("select 1").rows([]string{"1"}, []string{"1"})
+
+			db := s.openDB("")
+			db.SetMaxIdleConnNum(1)
+			db.SetPingInterval(bc.interval)
+			defer db.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := db.Query("select 1"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestPool_HeartbeatInterval(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+	pings := countPings(s)
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	defer db.Close()
+
+	co := popTestConn(t, db)
+	co.lastPing = 0
+	db.PushConn(co, nil)
+
+	//the idle conn is pinged off the checkout
+	db.SetHeartbeatInterval(20 * time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(pings) == 0 || db.Stats().IdleConns != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("not pinged %+v", db.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	db.SetHeartbeatInterval(0)
+
+	db.Lock()
+	quit, interval := db.healthQuit, db.healthInterval
+	db.Unlock()
+	if quit != nil || interval != 0 {
+		t.Fatal("heartbeat not stopped")
+	}
+}
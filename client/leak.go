@@ -0,0 +1,105 @@
+package client
+
+import (
+	"github.com/siddontang/go-log/log"
+	"sort"
+	"time"
+)
+
+// SetLeakDetection records where every conn is checked out, and logs once
+// a conn in use longer than threshold with the stack of its checkout, like
+// a code path popping a conn without pushing it back. LeakedConns returns
+// them. Capturing the stacks slows PopConn down, 0, the default, stops
+// the detection, and Close stops it. The conns checked out before are not
+// watched.
+func (db *DB) SetLeakDetection(threshold time.Duration) {
+	db.Lock()
+	db.stopLeakDetection()
+	db.leakThreshold = threshold
+	if !db.closed && threshold > 0 {
+		quit := make(chan struct{})
+		db.leakQuit = quit
+		go db.watchLeaks(quit, threshold)
+	}
+	db.Unlock()
+}
+
+// stopLeakDetection stops the leak detection, must hold the lock
+func (db *DB) stopLeakDetection() {
+	db.leakThreshold = 0
+	if db.leakQuit != nil {
+		close(db.leakQuit)
+		db.leakQuit = nil
+	}
+}
+
+// recordCheckout records the stack of the checkout of co
+func (db *DB) recordCheckout(co *Conn, stack string) {
+	db.Lock()
+	if info, ok := db.inUse[co]; ok {
+		info.Stack = stack
+		db.inUse[co] = info
+	}
+	db.Unlock()
+}
+
+// LeakedConns returns the conns in use longer than the leak threshold by
+// connection id, with the stack of their checkout, none if the leak
+// detection is off.
+func (db *DB) LeakedConns() []ConnInfo {
+	db.Lock()
+	defer db.Unlock()
+
+	return db.leakedConns(time.Now())
+}
+
+// leakedConns returns the leaked conns at now, must hold the lock
+func (db *DB) leakedConns(now time.Time) []ConnInfo {
+	if db.leakThreshold <= 0 {
+		return nil
+	}
+
+	var conns []ConnInfo
+	for _, info := range db.inUse {
+		//checked out before the detection
+		if len(info.Stack) > 0 && now.Sub(info.LastUsed) >= db.leakThreshold {
+			conns = append(conns, info)
+		}
+	}
+	sort.Sort(connInfosById(conns))
+	return conns
+}
+
+// watchLeaks logs the new leaked conns every half threshold until quit
+func (db *DB) watchLeaks(quit chan struct{}, threshold time.Duration) {
+	t := time.NewTicker(threshold / 2)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-quit:
+			return
+		}
+
+		now := time.Now()
+		var leaked []ConnInfo
+
+		db.Lock()
+		if db.leakQuit == quit {
+			for co, info := range db.inUse {
+				if len(info.Stack) > 0 && !info.leakLogged && now.Sub(info.LastUsed) >= threshold {
+					info.leakLogged = true
+					db.inUse[co] = info
+					leaked = append(leaked, info)
+				}
+			}
+		}
+		db.Unlock()
+
+		for _, info := range leaked {
+			log.Warn("conn %s %d in use for %v, leaked? checked out at:\n%s",
+				db.addr, info.ConnectionId, now.Sub(info.LastUsed), info.Stack)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDB_LeakDetection(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(2)
+	defer db.Close()
+
+	//off, no stack captured
+	co := popTestConn(t, db)
+	if conns := db.DumpConns(); len(conns) != 1 || len(conns[0].Stack) != 0 {
+		t.Fatalf("%+v", conns)
+	}
+	db.SetLeakDetection(20 * time.Millisecond)
+
+	leaked := popTestConn(t, db)
+	if conns := db.LeakedConns(); len(conns) != 0 {
+		t.Fatalf("%+v", conns)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	var conns []ConnInfo
+	for len(conns) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no leak detected")
+		}
+		time.Sleep(5 * time.Millisecond)
+		conns = db.LeakedConns()
+	}
+	//the conn checked out before is not watched
+	if len(conns) != 1 || conns[0].ConnectionId != leaked.ConnectionId() ||
+		!strings.Contains(conns[0].Stack, "TestDB_LeakDetection") {
+		t.Fatalf("%+v", conns)
+	}
+
+	db.PushConn(leaked, nil)
+	db.PushConn(co, nil)
+	if conns := db.LeakedConns(); len(conns) != 0 {
+		t.Fatalf("%+v", conns)
+	}
+
+	//stopped
+	leaked = popTestConn(t, db)
+	db.SetLeakDetection(0)
+	if conns := db.LeakedConns(); conns != nil {
+		t.Fatalf("%+v", conns)
+	}
+	db.PushConn(leaked, nil)
+}
@@ -0,0 +1,148 @@
+package client
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrPoolTimeout is returned by PopConn waiting too long for a conn of a
+// full pool or for a token of a limiter.
+var ErrPoolTimeout = errors.New("pool wait timeout")
+
+// Limiter bounds the backend operations in flight over all the DBs it is
+// set to, like the DBs of different schemas or users of one server, which
+// their own max open conns do not bound together. A conn popped from a
+// DB holds a token of the limiter until it is pushed back, when all are
+// held PopConn waits, the waiters of the highest priority first.
+//
+// A caller holding a conn must not wait for another conn of the same
+// limiter, or it may wait for itself.
+type Limiter struct {
+	sync.Mutex
+
+	max     int
+	timeout time.Duration
+
+	inUse   int
+	waiters waitQueue
+	waitSeq uint64
+
+	acquired   uint64
+	rejections uint64
+}
+
+// LimiterStats is a snapshot of a Limiter.
+type LimiterStats struct {
+	Max     int
+	InUse   int
+	Waiting int
+
+	Acquired uint64
+	//waits timed out
+	Rejections uint64
+}
+
+// NewLimiter returns a limiter of max tokens, PopConn waits at most timeout
+// for a token and fails with ErrPoolTimeout then, 0 waits forever.
+func NewLimiter(max int, timeout time.Duration) *Limiter {
+	return &Limiter{max: max, timeout: timeout}
+}
+
+// SetLimiter makes the conns popped from db hold a token of l, nil
+// removes the limiter. The conns popped before keep their tokens until
+// pushed back.
+func (db *DB) SetLimiter(l *Limiter) {
+	db.Lock()
+	db.limiter = l
+	db.Unlock()
+}
+
+func (db *DB) getLimiter() *Limiter {
+	db.Lock()
+	l := db.limiter
+	db.Unlock()
+	return l
+}
+
+func (l *Limiter) Stats() LimiterStats {
+	l.Lock()
+	defer l.Unlock()
+
+	return LimiterStats{Max: l.max, InUse: l.inUse, Waiting: len(l.waiters),
+		Acquired: l.acquired, Rejections: l.rejections}
+}
+
+// acquire takes a token, waiting by prio if none is left, until the
+// timeout or ctx is done
+func (l *Limiter) acquire(ctx context.Context, prio int) error {
+	l.Lock()
+	if l.inUse < l.max {
+		l.inUse++
+		l.acquired++
+		l.Unlock()
+		return nil
+	}
+
+	//served by priority and in arrival order, no aging
+	w := &connWaiter{prio: prio, rank: prio, ch: make(chan *Conn, 1)}
+	l.waitSeq++
+	w.seq = l.waitSeq
+	heap.Push(&l.waiters, w)
+	l.Unlock()
+
+	if l.timeout <= 0 && ctx.Done() == nil {
+		<-w.ch
+		return nil
+	}
+
+	var expired <-chan time.Time
+	if l.timeout > 0 {
+		t := time.NewTimer(l.timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+
+	err := ErrPoolTimeout
+	select {
+	case <-w.ch:
+		return nil
+	case <-expired:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	for i, v := range l.waiters {
+		if v == w {
+			heap.Remove(&l.waiters, i)
+			if err == ErrPoolTimeout {
+				l.rejections++
+			}
+			return err
+		}
+	}
+
+	//the token was handed over meanwhile
+	<-w.ch
+	return nil
+}
+
+// release hands the token to the next waiter, or frees it
+func (l *Limiter) release() {
+	l.Lock()
+	defer l.Unlock()
+
+	if len(l.waiters) == 0 {
+		l.inUse--
+		return
+	}
+
+	w := heap.Pop(&l.waiters).(*connWaiter)
+	l.acquired++
+	w.ch <- nil
+}
@@ -0,0 +1,135 @@
+package client
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLimiter_SharedPools(t *testing.T) {
+	var cur, max int32
+	s := newFakeServer(func(c *fakeServerConn, query string) error {
+		if query != "select 1" {
+			return c.exec(query)
+		}
+
+		n := atomic.AddInt32(&cur, 1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&cur, -1)
+		return c.writeResultset([]string{"1"}, [][]string{{"1"}})
+	})
+	defer s.Close()
+
+	l := NewLimiter(3, 0)
+
+	dbs := []*DB{s.openDB("a"), s.openDB("b")}
+	for _, db := range dbs {
+		db.SetMaxIdleConnNum(10)
+		db.SetLimiter(l)
+		defer db.Close()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(db *DB) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if _, err := db.Query("select 1"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(dbs[i%2])
+	}
+	wg.Wait()
+
+	if m := atomic.LoadInt32(&max); m > 3 || m < 2 {
+		t.Fatal(m)
+	}
+	if st := l.Stats(); st.InUse != 0 || st.Waiting != 0 || st.Acquired != 200 || st.Rejections != 0 {
+		t.Fatalf("%+v", st)
+	}
+}
+
+func TestLimiter_Priority(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	l := NewLimiter(1, 0)
+
+	a, b := s.openDB("a"), s.openDB("b")
+	defer a.Close()
+	defer b.Close()
+	a.SetLimiter(l)
+	b.SetLimiter(l)
+
+	held := popTestConn(t, a)
+
+	order := make(chan int, 3)
+	for i, prio := range []int{0, 5, 1} {
+		go func(prio int) {
+			co, err := b.PopConnWithPriority(prio)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			order <- prio
+			b.PushConn(co, nil)
+		}(prio)
+
+		//queued in this order
+		for l.Stats().Waiting != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	a.PushConn(held, nil)
+	for _, prio := range []int{5, 1, 0} {
+		if p := <-order; p != prio {
+			t.Fatal(p, prio)
+		}
+	}
+}
+
+func TestLimiter_Timeout(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	l := NewLimiter(1, 20*time.Millisecond)
+
+	a, b := s.openDB("a"), s.openDB("b")
+	defer a.Close()
+	defer b.Close()
+	a.SetLimiter(l)
+	b.SetLimiter(l)
+
+	held := popTestConn(t, a)
+
+	start := time.Now()
+	if _, err := b.Execute("select 1"); err != ErrPoolTimeout {
+		t.Fatal(err)
+	} else if d := time.Now().Sub(start); d < 20*time.Millisecond {
+		t.Fatal(d)
+	}
+	if st := l.Stats(); st.InUse != 1 || st.Waiting != 0 || st.Rejections != 1 {
+		t.Fatalf("%+v", st)
+	}
+
+	//a failed dial releases its token
+	a.PushConn(held, nil)
+	s.Close()
+	if _, err := b.Execute("select 1"); err == nil {
+		t.Fatal("must error")
+	}
+	if st := l.Stats(); st.InUse != 0 {
+		t.Fatalf("%+v", st)
+	}
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrResultMemoryLimit is returned by a query whose rows can not be
+// buffered within the global result memory limit.
+var ErrResultMemoryLimit = errors.New("result memory limit exceeded")
+
+type memoryBudget struct {
+	sync.Mutex
+	cond *sync.Cond
+
+	limit int64
+	used  int64
+
+	//wait for released memory instead of failing
+	wait bool
+}
+
+func newMemoryBudget() *memoryBudget {
+	b := new(memoryBudget)
+	b.cond = sync.NewCond(&b.Mutex)
+	return b
+}
+
+var resultMemory = newMemoryBudget()
+
+// SetGlobalResultMemoryLimit limits the row data buffered by all result sets
+// of the process which are not released yet, 0 means no limit.
+// A query over the limit fails with ErrResultMemoryLimit, see
+// SetGlobalResultMemoryWait.
+func SetGlobalResultMemoryLimit(bytes int64) {
+	resultMemory.Lock()
+	resultMemory.limit = bytes
+	resultMemory.Unlock()
+
+	resultMemory.cond.Broadcast()
+}
+
+// SetGlobalResultMemoryWait makes a new query over the result memory limit
+// wait until other result sets are released instead of failing. A query
+// which has buffered rows already still fails, so queries never wait for
+// each other, and so does a single row larger than the limit.
+func SetGlobalResultMemoryWait(wait bool) {
+	resultMemory.Lock()
+	resultMemory.wait = wait
+	resultMemory.Unlock()
+
+	resultMemory.cond.Broadcast()
+}
+
+// GlobalResultMemory returns the row data buffered by result sets not released yet.
+func GlobalResultMemory() int64 {
+	resultMemory.Lock()
+	n := resultMemory.used
+	resultMemory.Unlock()
+	return n
+}
+
+// acquire accounts n bytes, it may wait for released memory if the
+// caller holds none yet.
+func (b *memoryBudget) acquire(n int64, holding bool) error {
+	b.Lock()
+	defer b.Unlock()
+
+	for b.limit > 0 && b.used+n > b.limit {
+		if !b.wait || holding || n > b.limit {
+			return ErrResultMemoryLimit
+		}
+		b.cond.Wait()
+	}
+
+	b.used += n
+	return nil
+}
+
+func (b *memoryBudget) release(n int64) {
+	if n == 0 {
+		return
+	}
+
+	b.Lock()
+	b.used -= n
+	b.Unlock()
+
+	b.cond.Broadcast()
+}
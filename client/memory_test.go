@@ -0,0 +1,101 @@
+package client
+
+import (
+	. "github.com/siddontang/mixer/mysql"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	b := newMemoryBudget()
+	b.limit = 10
+
+	if err := b.acquire(6, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.acquire(6, false); err != ErrResultMemoryLimit {
+		t.Fatal(err)
+	}
+
+	b.wait = true
+
+	//never waits for itself or for the impossible
+	if err := b.acquire(6, true); err != ErrResultMemoryLimit {
+		t.Fatal(err)
+	}
+	if err := b.acquire(11, false); err != ErrResultMemoryLimit {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- b.acquire(6, false)
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatal("must wait", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	b.release(6)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if b.used != 6 {
+		t.Fatal(b.used)
+	}
+}
+
+func writeTestRows(pkg *PacketIO, rows []string) {
+	for _, row := range rows {
+		pkg.WritePacket(append(make([]byte, 4), PutLengthEncodedString([]byte(row))...))
+	}
+	pkg.WritePacket([]byte{0, 0, 0, 0, EOF_HEADER, 0, 0, 2, 0})
+}
+
+func TestConn_ResultMemoryLimit(t *testing.T) {
+	defer SetGlobalResultMemoryLimit(0)
+
+	server, cli := net.Pipe()
+	defer server.Close()
+	defer cli.Close()
+
+	c := new(Conn)
+	c.conn = cli
+	c.pkg = NewPacketIO(cli)
+	c.capability = CLIENT_PROTOCOL_41
+
+	spkg := NewPacketIO(server)
+	go func() {
+		writeTestRows(spkg, []string{"a", "b", "c"})
+		writeTestRows(spkg, []string{"a"})
+	}()
+
+	fields := []*Field{&Field{Name: []byte("s"), Type: MYSQL_TYPE_VAR_STRING}}
+
+	//each row is 2 bytes
+	SetGlobalResultMemoryLimit(GlobalResultMemory() + 4)
+
+	r := &Result{Resultset: &Resultset{Fields: fields}}
+	if err := c.readResultRows(r, false); err != ErrResultMemoryLimit {
+		t.Fatal(err)
+	}
+
+	//rows over the limit are drained, the next result is read
+	r = &Result{Resultset: &Resultset{Fields: fields}}
+	if err := c.readResultRows(r, false); err != nil {
+		t.Fatal(err)
+	} else if len(r.Values) != 1 {
+		t.Fatal(len(r.Values))
+	}
+
+	used := GlobalResultMemory()
+	r.Release()
+	r.Release()
+	if n := GlobalResultMemory(); n != used-2 {
+		t.Fatal(used, n)
+	}
+}
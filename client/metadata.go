@@ -0,0 +1,73 @@
+package client
+
+import (
+	"errors"
+	. "github.com/siddontang/mixer/mysql"
+)
+
+var ErrNoMetadata = errors.New("resultset metadata skipped by server and not cached")
+
+// SetOptionalMetadata asks for CLIENT_OPTIONAL_RESULTSET_METADATA when
+// connecting, it takes effect at the next connect. The server may skip
+// the column definitions of a resultset then, like those of an execute
+// whose columns did not change. The columns of every prepared statement
+// are cached from its prepare and from every execute which sends them,
+// so a resultset without them has the cached ones, the same as if sent.
+func (c *Conn) SetOptionalMetadata(on bool) {
+	c.optionalMetadata = on
+}
+
+// SetOptionalMetadata makes new conns ask for optional resultset metadata,
+// see Conn.SetOptionalMetadata.
+func (db *DB) SetOptionalMetadata(on bool) {
+	db.optionalMetadata = on
+}
+
+// resultsetMetadata parses the column count of a resultset and the
+// metadata flag after it if negotiated
+func (c *Conn) resultsetMetadata(data []byte) (uint64, byte, error) {
+	count, _, n := LengthEncodedInt(data)
+
+	metadata := RESULTSET_METADATA_FULL
+	if c.capability&CLIENT_OPTIONAL_RESULTSET_METADATA > 0 && n < len(data) {
+		metadata = data[n]
+		n++
+	}
+
+	if n != len(data) {
+		return 0, 0, ErrMalformPacket
+	}
+	return count, metadata, nil
+}
+
+// readColumnDefs reads column definitions up to the EOF after them
+func (c *Conn) readColumnDefs() ([]*Field, error) {
+	var fields []*Field
+	for {
+		data, err := c.readPacket()
+		if err != nil {
+			return nil, err
+		}
+
+		if c.isEOFPacket(data) {
+			return fields, nil
+		}
+
+		f, err := FieldData(data).Parse()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+}
+
+// cloneFields copies the fields, so the fields of a result can be changed
+// without changing the cached ones
+func cloneFields(fields []*Field) []*Field {
+	c := make([]*Field, len(fields))
+	for i, f := range fields {
+		cf := *f
+		c[i] = &cf
+	}
+	return c
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	. "github.com/siddontang/mixer/mysql"
+	"testing"
+)
+
+func TestConn_OptionalMetadata(t *testing.T) {
+	s := newFakeServer(nil)
+	s.capability |= CLIENT_OPTIONAL_RESULTSET_METADATA
+	defer s.Close()
+
+	query := "select * from t where id = ?"
+	fields := []*Field{{Name: []byte("name"), Charset: uint16(DEFAULT_COLLATION_ID), Type: MYSQL_TYPE_VAR_STRING}}
+	s.on(COM_STMT_EXECUTE, query).do(func(c *fakeServerConn) error {
+		cells := make([][]byte, len(fields))
+		for i := range cells {
+			cells[i] = []byte("a")
+		}
+		return c.writeResultsetPackets(fields, true, [][][]byte{cells})
+	})
+	s.onQuery("select name from t").rows([]string{"name"}, []string{"b"})
+
+	check := func(co *Conn, names ...string) {
+		r, err := co.Execute(query, 1)
+		if err != nil {
+			t.Fatal(err)
+		} else if r.ColumnNumber() != len(names) {
+			t.Fatal(r.ColumnNumber(), names)
+		}
+		for i, name := range names {
+			if string(r.Fields[i].Name) != name || r.FieldNames[name] != i {
+				t.Fatal(i, string(r.Fields[i].Name))
+			} else if v, _ := r.GetString(0, i); v != "a" {
+				t.Fatal(v)
+			}
+		}
+	}
+
+	db := s.openDB("")
+	db.SetStmtCache(true)
+	db.SetOptionalMetadata(true)
+	defer db.Close()
+
+	co := popTestConn(t, db)
+	defer co.Close()
+	if co.capability&CLIENT_OPTIONAL_RESULTSET_METADATA == 0 {
+		t.Fatal("not negotiated")
+	}
+
+	//sent, then skipped and cached
+	check(co, "name")
+	check(co, "name")
+
+	//changed, like by an alter table
+	fields = append(fields, &Field{Name: []byte("age"), Charset: uint16(DEFAULT_COLLATION_ID), Type: MYSQL_TYPE_VAR_STRING})
+	check(co, "name", "age")
+	check(co, "name", "age")
+
+	//text queries have the metadata
+	if r, err := co.Execute("select name from t"); err != nil {
+		t.Fatal(err)
+	} else if v, _ := r.GetString(0, 0); v != "b" {
+		t.Fatal(v)
+	}
+
+	var w testPacketWriter
+	if st, err := co.QueryTo(&w, "select name from t"); err != nil {
+		t.Fatal(err)
+	} else if st.Columns != 1 || st.Rows != 1 {
+		t.Fatal(*st)
+	}
+	r, err := co.exec("select name from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.buf.String() != string(encodeTestResult(r)) {
+		t.Fatal("relayed packets have the metadata flag")
+	}
+
+	//not negotiated
+	db.SetOptionalMetadata(false)
+	co2 := popTestConn(t, db)
+	defer co2.Close()
+	if co2.capability&CLIENT_OPTIONAL_RESULTSET_METADATA != 0 {
+		t.Fatal("negotiated")
+	}
+	check(co2, "name", "age")
+	check(co2, "name", "age")
+}
+
+func TestConn_OptionalMetadataNotCached(t *testing.T) {
+	s := newFakeServer(nil)
+	s.capability |= CLIENT_OPTIONAL_RESULTSET_METADATA
+	defer s.Close()
+
+	fields := []*Field{{Name: []byte("name"), Charset: uint16(DEFAULT_COLLATION_ID), Type: MYSQL_TYPE_VAR_STRING}}
+	s.onQuery("select name from t").do(func(c *fakeServerConn) error {
+		c.execStmt = 1
+		c.stmtMeta[1] = string(fields[0].Dump())
+		return c.writeResultsetPackets(fields, false, [][][]byte{{[]byte("a")}})
+	})
+
+	db := s.openDB("")
+	db.SetOptionalMetadata(true)
+	defer db.Close()
+
+	co := popTestConn(t, db)
+	defer co.Close()
+
+	if _, err := co.Execute("select name from t"); err != ErrNoMetadata {
+		t.Fatal(err)
+	}
+
+	//the rows are drained, the conn is usable
+	if _, err := co.Execute("select 1"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,269 @@
+package client
+
+import (
+	"expvar"
+	. "github.com/siddontang/mixer/mysql"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StatementKind tells a query, which returns rows, from an exec.
+type StatementKind int
+
+const (
+	//by Query and its variants
+	StatementQuery StatementKind = iota
+	//by Execute, Command and their variants
+	StatementExec
+)
+
+func (k StatementKind) String() string {
+	if k == StatementQuery {
+		return "query"
+	}
+	return "exec"
+}
+
+// StatementResult is the outcome of a statement reported to a
+// MetricsCollector.
+type StatementResult int
+
+const (
+	StatementOK StatementResult = iota
+	//failed by the server or the client, like a syntax error
+	StatementError
+	//failed by a broken conn after the retries, see IsConnBroken
+	StatementBadConn
+)
+
+var statementResultNames = []string{"ok", "error", "bad_conn"}
+
+func (r StatementResult) String() string {
+	if r < 0 || int(r) >= len(statementResultNames) {
+		return "result " + strconv.Itoa(int(r))
+	}
+	return statementResultNames[r]
+}
+
+func statementResult(err error) StatementResult {
+	if err == nil {
+		return StatementOK
+	} else if IsConnBroken(err) {
+		return StatementBadConn
+	}
+	return StatementError
+}
+
+// MetricsCollector receives the metrics of a DB and of its conns, see
+// DB.SetMetrics. It is called on the hot paths, so it must be cheap and
+// safe for concurrent use.
+type MetricsCollector interface {
+	// ConnOpened is called for a conn connected by the pool.
+	ConnOpened()
+	// ConnClosed is called for a conn of the pool closed.
+	ConnClosed()
+	// ConnWait observes how long PopConn took to hand out a conn,
+	// including the wait for a full pool and the dial.
+	ConnWait(d time.Duration)
+	// PingFailed is called for an idle conn failed the ping at checkout.
+	PingFailed()
+	// Statement observes a statement of DB, d includes its retries.
+	Statement(kind StatementKind, result StatementResult, d time.Duration)
+	// Retry is called for an operation of DB tried again on another conn.
+	Retry()
+	// BytesRead and BytesWritten count the packets of a conn, headers
+	// included.
+	BytesRead(n int)
+	BytesWritten(n int)
+}
+
+// SetMetrics reports the metrics of the pool and of its conns to m, nil,
+// the default, reports nothing. A checked out conn reports to the
+// collector set at its checkout.
+func (db *DB) SetMetrics(m MetricsCollector) {
+	db.Lock()
+	db.metrics = m
+	db.Unlock()
+}
+
+func (db *DB) collector() MetricsCollector {
+	db.Lock()
+	m := db.metrics
+	db.Unlock()
+	return m
+}
+
+// observe runs f as a statement of kind and reports it to the metrics
+func (db *DB) observe(kind StatementKind, f func() error) error {
+	m := db.collector()
+	if m == nil {
+		return f()
+	}
+
+	start := time.Now()
+	err := f()
+	m.Statement(kind, statementResult(err), time.Since(start))
+	return err
+}
+
+// countRetry reports a retry to the metrics
+func (db *DB) countRetry() {
+	if m := db.collector(); m != nil {
+		m.Retry()
+	}
+}
+
+// packetBytes returns the bytes of a payload on the wire, with a header
+// for every packet it is split into
+func packetBytes(payload int) int {
+	return payload + 4*(payload/MaxPayloadLen+1)
+}
+
+// DefaultBuckets are the upper bounds of the duration histograms of the
+// ExpvarMetrics created from now on.
+var DefaultBuckets = []time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond,
+	50 * time.Millisecond, 100 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 5 * time.Second,
+}
+
+// ExpvarMetrics is a MetricsCollector publishing the metrics as an
+// expvar map, served as json by the /debug/vars handler of expvar:
+//
+//	conns_opened, conns_closed, ping_failures, retries
+//	bytes_read, bytes_written
+//	query_ok, query_error, query_bad_conn, exec_ok, exec_error, exec_bad_conn
+//	conn_wait, query_duration, exec_duration
+//
+// The durations are histograms like of Prometheus, a map of the count of
+// observations at most every bucket in seconds, like le_0.005, and of
+// count and sum_seconds.
+type ExpvarMetrics struct {
+	vars *expvar.Map
+
+	connWait  *expvarHistogram
+	durations [2]*expvarHistogram
+	results   [2][3]string
+}
+
+// expvarLock serializes the lookup and publish of NewExpvarMetrics
+var expvarLock sync.Mutex
+
+// NewExpvarMetrics publishes the metrics under name. A map published under
+// name already, like by a previous DB of the name, is cleared and reused,
+// another var under name leaves the metrics unpublished, see Vars.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	expvarLock.Lock()
+	m := new(ExpvarMetrics)
+	switch v := expvar.Get(name).(type) {
+	case nil:
+		m.vars = expvar.NewMap(name)
+	case *expvar.Map:
+		m.vars = v.Init()
+	default:
+		m.vars = new(expvar.Map).Init()
+	}
+	expvarLock.Unlock()
+
+	for _, key := range []string{"conns_opened", "conns_closed", "ping_failures",
+		"retries", "bytes_read", "bytes_written"} {
+		m.vars.Add(key, 0)
+	}
+
+	m.connWait = newExpvarHistogram()
+	m.vars.Set("conn_wait", m.connWait.vars)
+	for _, kind := range []StatementKind{StatementQuery, StatementExec} {
+		m.durations[kind] = newExpvarHistogram()
+		m.vars.Set(kind.String()+"_duration", m.durations[kind].vars)
+
+		for r, name := range statementResultNames {
+			m.results[kind][r] = kind.String() + "_" + name
+			m.vars.Add(m.results[kind][r], 0)
+		}
+	}
+	return m
+}
+
+// Vars returns the published map.
+func (m *ExpvarMetrics) Vars() *expvar.Map {
+	return m.vars
+}
+
+func (m *ExpvarMetrics) ConnOpened() {
+	m.vars.Add("conns_opened", 1)
+}
+
+func (m *ExpvarMetrics) ConnClosed() {
+	m.vars.Add("conns_closed", 1)
+}
+
+func (m *ExpvarMetrics) ConnWait(d time.Duration) {
+	m.connWait.observe(d)
+}
+
+func (m *ExpvarMetrics) PingFailed() {
+	m.vars.Add("ping_failures", 1)
+}
+
+func (m *ExpvarMetrics) Statement(kind StatementKind, result StatementResult, d time.Duration) {
+	if kind != StatementQuery {
+		kind = StatementExec
+	}
+	if result < 0 || int(result) >= len(statementResultNames) {
+		result = StatementError
+	}
+
+	m.vars.Add(m.results[kind][result], 1)
+	m.durations[kind].observe(d)
+}
+
+func (m *ExpvarMetrics) Retry() {
+	m.vars.Add("retries", 1)
+}
+
+func (m *ExpvarMetrics) BytesRead(n int) {
+	m.vars.Add("bytes_read", int64(n))
+}
+
+func (m *ExpvarMetrics) BytesWritten(n int) {
+	m.vars.Add("bytes_written", int64(n))
+}
+
+// expvarHistogram counts durations by buckets, cumulatively
+type expvarHistogram struct {
+	vars    *expvar.Map
+	buckets []time.Duration
+	keys    []string
+
+	//nanoseconds, published as seconds
+	sum int64
+}
+
+func newExpvarHistogram() *expvarHistogram {
+	h := &expvarHistogram{vars: new(expvar.Map)}
+	h.buckets = append(h.buckets, DefaultBuckets...)
+	for _, b := range h.buckets {
+		key := "le_" + strconv.FormatFloat(b.Seconds(), 'f', -1, 64)
+		h.keys = append(h.keys, key)
+		h.vars.Add(key, 0)
+	}
+	h.vars.Add("le_+Inf", 0)
+	h.vars.Add("count", 0)
+	h.vars.Set("sum_seconds", expvar.Func(func() interface{} {
+		return time.Duration(atomic.LoadInt64(&h.sum)).Seconds()
+	}))
+	return h
+}
+
+func (h *expvarHistogram) observe(d time.Duration) {
+	for i, b := range h.buckets {
+		if d <= b {
+			h.vars.Add(h.keys[i], 1)
+		}
+	}
+	h.vars.Add("le_+Inf", 1)
+	h.vars.Add("count", 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	. "github.com/siddontang/mixer/mysql"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// expvarTests numbers the names published by the tests
+var expvarTests int32
+
+type testMetrics struct {
+	sync.Mutex
+
+	opened, closed, waits, pingFailures, retries int
+	read, written                                int
+	statements                                   map[string]int
+}
+
+func newTestMetrics() *testMetrics {
+	return &testMetrics{statements: make(map[string]int)}
+}
+
+func (m *testMetrics) ConnOpened()              { m.Lock(); m.opened++; m.Unlock() }
+func (m *testMetrics) ConnClosed()              { m.Lock(); m.closed++; m.Unlock() }
+func (m *testMetrics) ConnWait(d time.Duration) { m.Lock(); m.waits++; m.Unlock() }
+func (m *testMetrics) PingFailed()              { m.Lock(); m.pingFailures++; m.Unlock() }
+func (m *testMetrics) Retry()                   { m.Lock(); m.retries++; m.Unlock() }
+func (m *testMetrics) BytesRead(n int)          { m.Lock(); m.read += n; m.Unlock() }
+func (m *testMetrics) BytesWritten(n int)       { m.Lock(); m.written += n; m.Unlock() }
+
+func (m *testMetrics) Statement(kind StatementKind, result StatementResult, d time.Duration) {
+	m.Lock()
+	m.statements[kind.String()+"_"+result.String()]++
+	m.Unlock()
+}
+
+func TestDB_Metrics(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(2)
+	defer db.Close()
+
+	m := newTestMetrics()
+	db.SetMetrics(m)
+
+	s.onQuery("select 1").rows([]string{"1"}, []string{"1"})
+	s.onQuery("select bad").err(1064, "syntax error")
+	s.onQuery("delete from gone").disconnect()
+
+	if _, err := db.Query("select 1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Execute("insert into t values (1)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Query("select bad"); err == nil {
+		t.Fatal("no error")
+	}
+	//tried 3 times, every conn dropped
+	if _, err := db.Execute("delete from gone"); err == nil {
+		t.Fatal("no error")
+	}
+
+	//dropped while idle, the ping at checkout fails
+	co := popTestConn(t, db)
+	db.PushConn(co, nil)
+	co.lastPing = 0
+	s.dropConns()
+	co = popTestConn(t, db)
+	db.PushConn(co, nil)
+
+	m.Lock()
+	defer m.Unlock()
+
+	want := map[string]int{"query_ok": 1, "exec_ok": 1, "query_error": 1, "exec_bad_conn": 1}
+	for k, n := range want {
+		if m.statements[k] != n {
+			t.Fatal(m.statements)
+		}
+	}
+	if len(m.statements) != len(want) {
+		t.Fatal(m.statements)
+	}
+
+	if m.retries != 2 || m.pingFailures != 1 {
+		t.Fatal(m.retries, m.pingFailures)
+	}
+	//one conn left, every query popped one
+	if st := db.Stats(); m.opened != int(st.Created) || m.closed != int(st.Closed) || m.opened-m.closed != 1 {
+		t.Fatal(m.opened, m.closed, st)
+	}
+	if m.waits != 8 {
+		t.Fatal(m.waits)
+	}
+	if m.read == 0 || m.written == 0 {
+		t.Fatal(m.read, m.written)
+	}
+}
+
+func TestDB_ExpvarMetrics(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(2)
+	defer db.Close()
+
+	//unique for go test -count
+	name := fmt.Sprintf("mixer_client_test_%d", atomic.AddInt32(&expvarTests, 1))
+	m := NewExpvarMetrics(name)
+	db.SetMetrics(m)
+
+	s.onQuery("select 1").rows([]string{"1"}, []string{"1"})
+	if _, err := db.Query("select 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	var vars struct {
+		ConnsOpened   int64 `json:"conns_opened"`
+		QueryOK       int64 `json:"query_ok"`
+		ExecOK        int64 `json:"exec_ok"`
+		BytesRead     int64 `json:"bytes_read"`
+		QueryDuration struct {
+			Count int64   `json:"count"`
+			Inf   int64   `json:"le_+Inf"`
+			Sum   float64 `json:"sum_seconds"`
+		} `json:"query_duration"`
+	}
+	if err := json.Unmarshal([]byte(m.Vars().String()), &vars); err != nil {
+		t.Fatal(err, m.Vars().String())
+	}
+
+	if vars.ConnsOpened != 1 || vars.QueryOK != 1 || vars.ExecOK != 0 || vars.BytesRead == 0 {
+		t.Fatal(m.Vars().String())
+	}
+	if d := vars.QueryDuration; d.Count != 1 || d.Inf != 1 || d.Sum <= 0 {
+		t.Fatal(m.Vars().String())
+	}
+}
+
+func TestExpvarMetrics_Published(t *testing.T) {
+	name := fmt.Sprintf("mixer_client_test_%d", atomic.AddInt32(&expvarTests, 1))
+
+	//the published map is reused and starts over
+	m := NewExpvarMetrics(name)
+	m.ConnOpened()
+	if m2 := NewExpvarMetrics(name); m2.Vars() != m.Vars() || m2.Vars().Get("conns_opened").String() != "0" {
+		t.Fatal(m2.Vars().String())
+	}
+
+	//another var is left published
+	name += "_int"
+	v := expvar.NewInt(name)
+	if m = NewExpvarMetrics(name); m.Vars() == nil || expvar.Get(name) != v {
+		t.Fatal(expvar.Get(name))
+	}
+	m.ConnOpened()
+}
+
+func TestPacketBytes(t *testing.T) {
+	if n := packetBytes(10); n != 14 {
+		t.Fatal(n)
+	}
+	if n := packetBytes(MaxPayloadLen); n != MaxPayloadLen+8 {
+		t.Fatal(n)
+	}
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"errors"
+	. "github.com/siddontang/mixer/mysql"
+)
+
+var ErrMultiStatements = errors.New("multi statements not enabled by the conn, see SetMultiStatements")
+
+// SetMultiStatements asks for CLIENT_MULTI_STATEMENTS and
+// CLIENT_MULTI_RESULTS when connecting, it takes effect at the next
+// connect. A query may have several statements separated by semicolons
+// then, see QueryMulti. Execute returns the result of the first statement
+// and discards the others. Off by default, as it makes an injected
+// statement after a semicolon run.
+func (c *Conn) SetMultiStatements(on bool) {
+	c.multiStatements = on
+}
+
+// SetMultiStatements makes new conns ask for multi statements, see
+// Conn.SetMultiStatements.
+func (db *DB) SetMultiStatements(on bool) {
+	db.multiStatements = on
+}
+
+// QueryMulti executes the statements of query in one round trip and
+// returns a resultset for every statement, nil for a statement without
+// rows, like an insert. The server stops at the first failed statement,
+// its error is returned with the resultsets before it. A use statement in
+// query is not tracked as by Execute. It returns ErrMultiStatements if the
+// conn did not negotiate multi statements.
+func (c *Conn) QueryMulti(query string) (_ []*Resultset, err error) {
+	defer c.recoverPanic(&err)
+
+	if c.capability&CLIENT_MULTI_STATEMENTS == 0 {
+		return nil, ErrMultiStatements
+	}
+
+	c.armTimeout()
+	defer c.disarmTimeout()
+
+	if err := c.writeCommandStr(COM_QUERY, query); err != nil {
+		return nil, err
+	}
+
+	var rs []*Resultset
+	for {
+		r, err := c.readResult(false)
+		if err != nil {
+			c.status &^= SERVER_MORE_RESULTS_EXISTS
+			return rs, err
+		}
+
+		rs = append(rs, r.Resultset)
+		if c.status&SERVER_MORE_RESULTS_EXISTS == 0 {
+			return rs, nil
+		}
+	}
+}
+
+// QueryMulti runs Conn.QueryMulti on a pooled conn, the conn is returned
+// after all the results are read. New conns must ask for multi statements
+// by SetMultiStatements. It is retried as Execute, so the statements before
+// the one broken by a lost conn run again.
+func (db *DB) QueryMulti(query string) ([]*Resultset, error) {
+	var rs []*Resultset
+	err := db.observe(StatementQuery, func() error {
+		return db.withRetry(func(c *Conn) error {
+			var err error
+			rs, err = c.QueryMulti(query)
+			return err
+		})
+	})
+	return rs, err
+}
+
+// discardMoreResults reads the results after the first one of a multi
+// statement, it returns the error of a failed one
+func (c *Conn) discardMoreResults() error {
+	for c.status&SERVER_MORE_RESULTS_EXISTS > 0 {
+		r, err := c.readResult(false)
+		if err != nil {
+			//an error packet ends the results
+			c.status &^= SERVER_MORE_RESULTS_EXISTS
+			return err
+		}
+		if r.Resultset != nil {
+			r.Release()
+		}
+	}
+	return nil
+}
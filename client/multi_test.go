@@ -0,0 +1,110 @@
+package client
+
+import (
+	. "github.com/siddontang/mixer/mysql"
+	"testing"
+)
+
+// onMulti answers query by the results of its statements, a nil names
+// answers an ok packet, an error packet after code ends the results
+func onMulti(s *fakeServer, query string, code uint16, results ...[]string) {
+	s.onQuery(query).do(func(c *fakeServerConn) error {
+		defer func() { c.status &^= SERVER_MORE_RESULTS_EXISTS }()
+
+		for i, names := range results {
+			if i < len(results)-1 || code != 0 {
+				c.status |= SERVER_MORE_RESULTS_EXISTS
+			} else {
+				c.status &^= SERVER_MORE_RESULTS_EXISTS
+			}
+
+			var err error
+			if names == nil {
+				err = c.writeOK()
+			} else {
+				err = c.writeResultset(names, [][]string{names})
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if code != 0 {
+			return c.writeError(code, "failed")
+		}
+		return nil
+	})
+}
+
+func TestDB_QueryMulti(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+	s.capability |= CLIENT_MULTI_STATEMENTS | CLIENT_MULTI_RESULTS
+
+	onMulti(s, "select 1; insert into t values (1); select 2", 0, []string{"1"}, nil, []string{"2"})
+	onMulti(s, "select 1; select bad", ER_PARSE_ERROR, []string{"1"})
+	s.onQuery("select 3").rows([]string{"3"}, []string{"3"})
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	defer db.Close()
+
+	//not negotiated
+	if _, err := db.QueryMulti("select 1; select 2"); err != ErrMultiStatements {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db = s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	db.SetMultiStatements(true)
+	defer db.Close()
+
+	rs, err := db.QueryMulti("select 1; insert into t values (1); select 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 3 || rs[1] != nil {
+		t.Fatal(rs)
+	}
+	if v, _ := rs[0].GetString(0, 0); v != "1" {
+		t.Fatal(v)
+	}
+	if v, _ := rs[2].GetString(0, 0); v != "2" {
+		t.Fatal(v)
+	}
+
+	rs, err = db.QueryMulti("select 1; select bad")
+	if e, ok := err.(*SqlError); !ok || e.Code != ER_PARSE_ERROR || len(rs) != 1 {
+		t.Fatal(rs, err)
+	}
+
+	//Execute discards the results after the first
+	r, err := db.Execute("select 1; insert into t values (1); select 2")
+	if err != nil {
+		t.Fatal(err)
+	} else if v, _ := r.GetString(0, 0); v != "1" {
+		t.Fatal(v)
+	}
+	if _, err := db.Execute("select 1; select bad"); err == nil {
+		t.Fatal("error of the second statement discarded")
+	}
+
+	//and so does QueryStream
+	rows, err := db.QueryStream("select 1; insert into t values (1); select 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	//the conn is in sync
+	if r, err := db.Query("select 3"); err != nil {
+		t.Fatal(err)
+	} else if v, _ := r.GetString(0, 0); v != "3" {
+		t.Fatal(v)
+	}
+}
@@ -0,0 +1,168 @@
+package client
+
+import (
+	"fmt"
+	. "github.com/siddontang/mixer/mysql"
+	"reflect"
+	"strings"
+)
+
+// BindNamed rewrites the :name and @name placeholders of query into ? and
+// returns the args in their order, a name may be used many times. arg is
+// a map[string]interface{}, or a struct or a pointer to one whose exported
+// fields are named by their db tag, or by their name in any case, a tag
+// of "-" skips the field. An @name without an arg is kept as a user
+// variable, a :name without one is an error. The placeholders in quoted
+// strings, quoted identifiers and comments are ignored, and a ? in query
+// is an error.
+func BindNamed(query string, arg interface{}) (string, []interface{}, error) {
+	lookup, err := namedLookup(arg)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var b strings.Builder
+	var args []interface{}
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+
+		//the end of the literal or the comment starting at i
+		end := -1
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end = skipQuoted(query, i)
+		case c == '#' || isDashComment(query[i:]):
+			if end = strings.IndexByte(query[i:], '\n'); end < 0 {
+				end = len(query)
+			} else {
+				end += i
+			}
+		case strings.HasPrefix(query[i:], "/*"):
+			if end = strings.Index(query[i+2:], "*/"); end < 0 {
+				end = len(query)
+			} else {
+				end += i + 4
+			}
+		case c == '?':
+			return "", nil, fmt.Errorf("positional placeholder at %d of a named query", i)
+		}
+		if end >= 0 {
+			b.WriteString(query[i:end])
+			i = end - 1
+			continue
+		}
+
+		//not @@var or a::b
+		if (c != ':' && c != '@') || (i > 0 && (query[i-1] == ':' || query[i-1] == '@')) {
+			b.WriteByte(c)
+			continue
+		}
+		j := i + 1
+		for j < len(query) && isNameByte(query[j], j == i+1) {
+			j++
+		}
+		if j == i+1 {
+			b.WriteByte(c)
+			continue
+		}
+
+		name := query[i+1 : j]
+		v, ok := lookup(name)
+		if !ok {
+			if c == ':' {
+				return "", nil, fmt.Errorf("missing named arg %s", name)
+			}
+			//a user variable
+			b.WriteString(query[i:j])
+		} else {
+			b.WriteByte('?')
+			args = append(args, v)
+		}
+		i = j - 1
+	}
+	return b.String(), args, nil
+}
+
+// isDashComment is true for a -- comment at the start of s, the dashes
+// must be followed by a space or a control char
+func isDashComment(s string) bool {
+	return strings.HasPrefix(s, "--") && (len(s) == 2 || s[2] <= ' ')
+}
+
+// isNameByte is true for a byte of a placeholder name, a name does not
+// start with a digit
+func isNameByte(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+// skipQuoted returns the end of the quoted string or identifier at i, a
+// backslash escapes the next byte, a doubled quote is read as two strings
+func skipQuoted(query string, i int) int {
+	quote := query[i]
+	for i++; i < len(query); i++ {
+		if query[i] == '\\' && quote != '`' {
+			i++
+		} else if query[i] == quote {
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+// namedLookup returns the lookup of a name in arg
+func namedLookup(arg interface{}) (func(name string) (interface{}, bool), error) {
+	if m, ok := arg.(map[string]interface{}); ok {
+		return func(name string) (interface{}, bool) {
+			v, ok := m[name]
+			return v, ok
+		}, nil
+	}
+
+	v := reflect.ValueOf(arg)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("named args must be a map[string]interface{} or a struct, not %T", arg)
+	}
+
+	t := v.Type()
+	return func(name string) (interface{}, bool) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				//unexported
+				continue
+			}
+
+			if tag := f.Tag.Get("db"); tag == "-" {
+				continue
+			} else if len(tag) > 0 {
+				if tag == name {
+					return v.Field(i).Interface(), true
+				}
+			} else if strings.EqualFold(f.Name, name) {
+				return v.Field(i).Interface(), true
+			}
+		}
+		return nil, false
+	}, nil
+}
+
+// ExecuteNamed is Execute with the named args of arg, see BindNamed.
+func (db *DB) ExecuteNamed(command string, arg interface{}) (*Result, error) {
+	command, args, err := BindNamed(command, arg)
+	if err != nil {
+		return nil, err
+	}
+	return db.Execute(command, args...)
+}
+
+// QueryNamed is Query with the named args of arg, see BindNamed.
+func (db *DB) QueryNamed(query string, arg interface{}) (*Resultset, error) {
+	query, args, err := BindNamed(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	return db.Query(query, args...)
+}
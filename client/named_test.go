@@ -0,0 +1,94 @@
+package client
+
+import (
+	. "github.com/siddontang/mixer/mysql"
+	"reflect"
+	"testing"
+)
+
+func TestBindNamed(t *testing.T) {
+	args := map[string]interface{}{"id": 1, "name": "a"}
+	for _, c := range []struct {
+		query, bound string
+		args         []interface{}
+	}{
+		{"select * from t where id = :id", "select * from t where id = ?", []interface{}{1}},
+		{"select * from t where a = :id or b = @id and c = :name", "select * from t where a = ? or b = ? and c = ?", []interface{}{1, 1, "a"}},
+		{"select ':id', \"@id\", `:id`, 'it\\'s :id' from t where id = :id", "select ':id', \"@id\", `:id`, 'it\\'s :id' from t where id = ?", []interface{}{1}},
+		{"select 1 -- :missing\nfrom t # :missing\nwhere id = :id /* :missing */", "select 1 -- :missing\nfrom t # :missing\nwhere id = ? /* :missing */", []interface{}{1}},
+		//user and system variables
+		{"set @x = :id, @@session.sql_mode = ''", "set @x = ?, @@session.sql_mode = ''", []interface{}{1}},
+		{"select @a := 1", "select @a := 1", nil},
+	} {
+		bound, bargs, err := BindNamed(c.query, args)
+		if err != nil {
+			t.Fatal(c.query, err)
+		} else if bound != c.bound || !reflect.DeepEqual(bargs, c.args) {
+			t.Fatalf("%s: %q %v", c.query, bound, bargs)
+		}
+	}
+
+	for _, query := range []string{
+		"select * from t where id = :missing",
+		"select * from t where id = ? and name = :name",
+	} {
+		if _, _, err := BindNamed(query, args); err == nil {
+			t.Fatal(query)
+		}
+	}
+	if _, _, err := BindNamed("select :id", []interface{}{1}); err == nil {
+		t.Fatal("bound a slice")
+	}
+}
+
+func TestBindNamed_Struct(t *testing.T) {
+	type user struct {
+		Id      int
+		Name    string `db:"user_name"`
+		Skipped string `db:"-"`
+		secret  string
+	}
+
+	u := &user{Id: 1, Name: "a", Skipped: "b", secret: "c"}
+	bound, args, err := BindNamed("update t set name = :user_name where id = :id", u)
+	if err != nil {
+		t.Fatal(err)
+	} else if bound != "update t set name = ? where id = ?" || !reflect.DeepEqual(args, []interface{}{"a", 1}) {
+		t.Fatal(bound, args)
+	}
+
+	for _, name := range []string{"Name", "Skipped", "secret"} {
+		if _, _, err := BindNamed("select :"+name, u); err == nil {
+			t.Fatal(name)
+		}
+	}
+}
+
+func TestDB_QueryNamed(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	fields := []*Field{{Name: []byte("id"), Charset: uint16(BINARY_COLLATION_ID), Type: MYSQL_TYPE_LONGLONG}}
+	s.on(COM_STMT_EXECUTE, "select id from t where a = ? or b = ?").resultset(fields, true,
+		[][]byte{Uint64ToBytes(7)})
+	s.on(COM_STMT_EXECUTE, "update t set a = ? where id = ?").ok()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	defer db.Close()
+
+	//the same name twice
+	r, err := db.QueryNamed("select id from t where a = :id or b = :id", map[string]interface{}{"id": 7})
+	if err != nil {
+		t.Fatal(err)
+	} else if id, _ := r.GetInt(0, 0); id != 7 {
+		t.Fatal(id)
+	}
+
+	if _, err := db.ExecuteNamed("update t set a = :a where id = :id", map[string]interface{}{"a": 1, "id": 7}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecuteNamed("update t set a = :a", map[string]interface{}{}); err == nil {
+		t.Fatal("missing arg bound")
+	}
+}
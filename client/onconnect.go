@@ -0,0 +1,60 @@
+package client
+
+import (
+	"fmt"
+)
+
+// OnConnectError is returned by a new conn failed by the OnConnect hook,
+// Err is the error of the hook. It is not retried even if Err is
+// ErrBadConn, so the retry predicates do not dial again and again for a
+// hook which always fails, like of a bad sql_mode.
+type OnConnectError struct {
+	Err error
+}
+
+func (e *OnConnectError) Error() string {
+	return fmt.Sprintf("on connect: %v", e.Err)
+}
+
+func (e *OnConnectError) Unwrap() error {
+	return e.Err
+}
+
+// OnConnect runs f on every conn the pool opens from now on, after it
+// connected and before it is used, like for SET NAMES utf8mb4 or SET
+// sql_mode. A conn failed by f is closed, and the dial fails with an
+// *OnConnectError. f runs again on an idle conn whose session state had to
+// be restored for its reuse, like after a SET NAMES or a use statement of
+// the last borrower, as the restore may undo f. nil removes the hook.
+func (db *DB) OnConnect(f func(co *Conn) error) {
+	db.Lock()
+	db.onConnect = f
+	db.Unlock()
+}
+
+// InitStatements returns a hook for OnConnect executing stmts in order.
+func InitStatements(stmts ...string) func(co *Conn) error {
+	return func(co *Conn) error {
+		for _, stmt := range stmts {
+			if _, err := co.exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// runOnConnect runs the OnConnect hook on co
+func (db *DB) runOnConnect(co *Conn) error {
+	db.Lock()
+	f := db.onConnect
+	db.Unlock()
+
+	if f == nil {
+		return nil
+	}
+	if err := f(co); err != nil {
+		return &OnConnectError{err}
+	}
+	return nil
+}
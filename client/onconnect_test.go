@@ -0,0 +1,86 @@
+package client
+
+import (
+	"errors"
+	. "github.com/siddontang/mixer/mysql"
+	"testing"
+)
+
+func TestDB_OnConnect(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	db.OnConnect(InitStatements("SET NAMES utf8mb4", "SET sql_mode='STRICT_TRANS_TABLES'"))
+	defer db.Close()
+
+	co := popTestConn(t, db)
+	id := co.ConnectionId()
+	db.PushConn(co, nil)
+
+	//reused as is, the hook is not run again
+	co = popTestConn(t, db)
+	if co.ConnectionId() != id {
+		t.Fatal(co.ConnectionId(), id)
+	}
+	if n := countQueries(s, "SET NAMES utf8mb4"); n != 1 {
+		t.Fatal(n)
+	}
+
+	//restored for the reuse, the hook is run again after it
+	if _, err := co.Execute("set autocommit = 0"); err != nil {
+		t.Fatal(err)
+	}
+	db.PushConn(co, nil)
+
+	co = popTestConn(t, db)
+	if co.ConnectionId() != id {
+		t.Fatal(co.ConnectionId(), id)
+	}
+	db.PushConn(co, nil)
+
+	if n := countQueries(s, "SET NAMES utf8mb4"); n != 2 {
+		t.Fatal(n)
+	} else if n := countQueries(s, "SET sql_mode='STRICT_TRANS_TABLES'"); n != 2 {
+		t.Fatal(n)
+	}
+}
+
+func TestDB_OnConnectFailure(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	db.SetRetryPolicy(9, nil)
+	defer db.Close()
+
+	//always failing, not retried even by a retry policy of ErrBadConn
+	calls := 0
+	db.OnConnect(func(co *Conn) error {
+		calls++
+		return ErrBadConn
+	})
+
+	_, err := db.Execute("select 1")
+	var e *OnConnectError
+	if !errors.As(err, &e) || !errors.Is(err, ErrBadConn) {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Fatal(calls)
+	}
+	if _, dials, _ := s.stats(); dials != 1 {
+		t.Fatal(dials)
+	}
+	if st := db.Stats(); st.OpenConns != 0 || st.Failed != 1 {
+		t.Fatalf("%+v", st)
+	}
+	waitServerConns(t, s, 0)
+
+	db.OnConnect(nil)
+	if _, err := db.Execute("select 1"); err != nil {
+		t.Fatal(err)
+	}
+}
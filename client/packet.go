@@ -0,0 +1,84 @@
+package client
+
+import (
+	"fmt"
+	. "github.com/siddontang/mixer/mysql"
+	"sync/atomic"
+)
+
+// minMaxAllowedPacket is the least max_allowed_packet of a server, a
+// packet not bigger is never checked
+const minMaxAllowedPacket = 1024
+
+// fetchMaxAllowedPacket reads @@max_allowed_packet, once after connected,
+// a server which can not tell, like a proxy answering the handshake only,
+// leaves it unknown. It may run before the command packet of a statement,
+// whose sequence and sent state are kept.
+func (c *Conn) fetchMaxAllowedPacket() {
+	c.fetchPacketLimit = false
+
+	seq, sent := c.pkg.Sequence, c.sent
+	defer func() {
+		c.pkg.Sequence, c.sent = seq, sent
+	}()
+
+	if err := c.writeCommandStr(COM_QUERY, "select @@max_allowed_packet"); err != nil {
+		return
+	}
+	r, err := c.readResult(false)
+	if err != nil || r.Resultset == nil || r.RowNumber() == 0 {
+		return
+	}
+
+	if n, err := r.GetInt(0, 0); err == nil {
+		c.maxAllowedPacket = int(n)
+	}
+}
+
+// MaxAllowedPacket returns the max_allowed_packet of the server, read at
+// the first call or the first command bigger than 1024 bytes, 0 if
+// unknown. A command bigger than it fails with ER_NET_PACKET_TOO_LARGE
+// before it is sent, instead of the server dropping the connection in
+// the middle of it.
+func (c *Conn) MaxAllowedPacket() int {
+	if c.fetchPacketLimit {
+		c.fetchMaxAllowedPacket()
+	}
+	return c.maxAllowedPacket
+}
+
+// checkPacketSize checks the payload of the command packet data, with its
+// 4 bytes header, against max_allowed_packet
+func (c *Conn) checkPacketSize(data []byte) error {
+	size := len(data) - 4
+	if size <= minMaxAllowedPacket {
+		return nil
+	} else if c.fetchPacketLimit {
+		c.fetchMaxAllowedPacket()
+	}
+
+	if c.maxAllowedPacket > 0 && size > c.maxAllowedPacket {
+		return NewError(ER_NET_PACKET_TOO_LARGE,
+			fmt.Sprintf("packet of %d bytes is bigger than max_allowed_packet %d of %s",
+				size, c.maxAllowedPacket, c.addr))
+	}
+	return nil
+}
+
+// MaxAllowedPacket returns the max_allowed_packet of the server, read on a
+// pooled conn at the first call. It returns 0 if the server can not tell.
+func (db *DB) MaxAllowedPacket() (int, error) {
+	if n := atomic.LoadInt64(&db.maxAllowedPacket); n > 0 {
+		return int(n), nil
+	}
+
+	co, err := db.PopConn()
+	if err != nil {
+		return 0, err
+	}
+	n := co.MaxAllowedPacket()
+	db.PushConn(co, nil)
+
+	atomic.StoreInt64(&db.maxAllowedPacket, int64(n))
+	return n, nil
+}
@@ -0,0 +1,144 @@
+package client
+
+import (
+	. "github.com/siddontang/mixer/mysql"
+	"strings"
+	"testing"
+)
+
+func checkPacketTooLarge(t *testing.T, err error, size string) {
+	if e, ok := err.(*SqlError); !ok || e.Code != ER_NET_PACKET_TOO_LARGE {
+		t.Fatal(err)
+	} else if !strings.Contains(e.Message, size+" bytes") || !strings.Contains(e.Message, "max_allowed_packet 1024") {
+		t.Fatal(e.Message)
+	}
+}
+
+func TestConn_MaxAllowedPacket(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	s.onQuery("select @@max_allowed_packet").rows([]string{"@@max_allowed_packet"}, []string{"1024"})
+	s.onQuery("select 1").rows([]string{"1"}, []string{"1"})
+	s.on(COM_STMT_EXECUTE, "insert into t values (?)").do(func(c *fakeServerConn) error {
+		t.Error("packet too large is sent")
+		return c.writeOK()
+	})
+
+	c := newFakeConn(t, s)
+	defer c.Close()
+
+	if n := c.MaxAllowedPacket(); n != 1024 {
+		t.Fatal(n)
+	}
+
+	//the command byte counts
+	query := "select '" + strings.Repeat("a", 1014) + "'"
+	if _, err := c.Execute(query); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.Execute(query + " ")
+	checkPacketTooLarge(t, err, "1025")
+
+	//a bound blob
+	_, err = c.Execute("insert into t values (?)", make([]byte, 2000))
+	checkPacketTooLarge(t, err, "2017")
+
+	//nothing was sent, the conn is fine
+	if _, _, queries := s.stats(); queries[len(queries)-1] != query {
+		t.Fatal(queries)
+	}
+	if c.pkgErr != nil {
+		t.Fatal(c.pkgErr)
+	}
+	if _, err = c.Execute("select 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	//unknown, not checked
+	s2 := newFakeServer(nil)
+	defer s2.Close()
+
+	c2 := newFakeConn(t, s2)
+	defer c2.Close()
+
+	if _, err = c2.Execute(query + " "); err != nil {
+		t.Fatal(err)
+	} else if n := c2.MaxAllowedPacket(); n != 0 {
+		t.Fatal(n)
+	}
+
+	//read before the first command bigger than the least limit, not on
+	//connect
+	c3 := newFakeConn(t, s)
+	defer c3.Close()
+
+	if n := countQueries(s, "select @@max_allowed_packet"); n != 1 {
+		t.Fatal(n)
+	}
+	_, err = c3.Execute(query + " ")
+	checkPacketTooLarge(t, err, "1025")
+	if n := countQueries(s, "select @@max_allowed_packet"); n != 2 {
+		t.Fatal(n)
+	}
+	if _, err = c3.Execute(query); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDB_MaxAllowedPacket(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	s.onQuery("select @@max_allowed_packet").rows([]string{"@@max_allowed_packet"}, []string{"1024"})
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	defer db.Close()
+
+	if n, err := db.MaxAllowedPacket(); err != nil || n != 1024 {
+		t.Fatal(n, err)
+	}
+
+	//failed at once, not retried
+	_, err := db.Execute("insert into t values ('" + strings.Repeat("a", 2000) + "')")
+	checkPacketTooLarge(t, err, "2026")
+	if _, dials, _ := s.stats(); dials != 1 {
+		t.Fatal(dials)
+	}
+	if st := db.Stats(); st.Acquired != 2 {
+		t.Fatal(st.Acquired)
+	}
+}
+
+func TestCopyTable_MaxAllowedPacket(t *testing.T) {
+	rows := copyTestRows(100)
+
+	src := newCopyTestSource(rows)
+	defer src.Close()
+	dst := newCopyTestServer(t)
+	defer dst.Close()
+
+	dst.onQuery("select @@max_allowed_packet").rows([]string{"@@max_allowed_packet"}, []string{"1024"})
+
+	srcDB, dstDB := src.openDB("src"), dst.openDB("dst")
+	defer srcDB.Close()
+	defer dstDB.Close()
+
+	n, err := CopyTable(srcDB, dstDB, "select id, name from src.t order by id", "dst.t", CopyOptions{BatchSize: 100})
+	if err != nil || n != 100 {
+		t.Fatal(n, err)
+	}
+
+	dst.check(t, rows)
+	if dst.inserts < 5 {
+		t.Fatal(dst.inserts)
+	}
+
+	_, _, queries := dst.stats()
+	for _, q := range queries {
+		if len(q)+1 > 1024 {
+			t.Fatal(len(q))
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/siddontang/go-log/log"
+	. "github.com/siddontang/mixer/mysql"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// ErrInternal is wrapped by the *InternalError of a recovered panic.
+var ErrInternal = errors.New("internal error")
+
+// InternalError is returned by an operation which panicked, like in a type
+// converter or a protocol parser, Value is the panic value. The conn of the
+// operation is closed, as its protocol state is unknown.
+type InternalError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *InternalError) Error() string {
+	return fmt.Sprintf("internal error: panic %v", e.Value)
+}
+
+func (e *InternalError) Unwrap() error {
+	return ErrInternal
+}
+
+var (
+	recoverPanics int32 = 1
+	panics        uint64
+)
+
+// SetRecoverPanics turns the recovery of panics in DB, Conn and Stmt
+// operations on or off, it is on by default. Off, a panic crashes the
+// process as usual, like wanted by tests catching logic bugs.
+func SetRecoverPanics(on bool) {
+	if on {
+		atomic.StoreInt32(&recoverPanics, 1)
+	} else {
+		atomic.StoreInt32(&recoverPanics, 0)
+	}
+}
+
+// Panics returns the panics recovered in the process so far.
+func Panics() uint64 {
+	return atomic.LoadUint64(&panics)
+}
+
+// recoverPanic is deferred by the operations of c, a recovered panic is
+// logged with its stack, condemns c and fails the operation by err
+func (c *Conn) recoverPanic(err *error) {
+	if atomic.LoadInt32(&recoverPanics) == 0 {
+		return
+	}
+
+	v := recover()
+	if v == nil {
+		return
+	}
+
+	stack := debug.Stack()
+	atomic.AddUint64(&panics, 1)
+	log.Error("conn %s %d panic: %v\n%s", c.addr, c.connectionId, v, stack)
+
+	//never pooled again, see SqlConn.Close
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.pkgErr = ErrBadConn
+
+	*err = &InternalError{Value: v, Stack: stack}
+}
+
+// guard runs f on c, recovering a panic of it
+func (c *Conn) guard(f func(c *Conn) error) (err error) {
+	defer c.recoverPanic(&err)
+	return f(c)
+}
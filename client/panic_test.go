@@ -0,0 +1,158 @@
+package client
+
+import (
+	"errors"
+	. "github.com/siddontang/mixer/mysql"
+	"sync"
+	"testing"
+)
+
+// panicInjector panics at the nth call of a point
+type panicInjector struct {
+	sync.Mutex
+
+	point FaultPoint
+	nth   int
+	calls int
+}
+
+func (f *panicInjector) Inject(p FaultPoint) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if p == f.point {
+		if f.calls++; f.calls == f.nth {
+			panic("injected panic")
+		}
+	}
+	return nil
+}
+
+func checkInternalError(t *testing.T, err error, value interface{}) {
+	var e *InternalError
+	if !errors.Is(err, ErrInternal) || !errors.As(err, &e) {
+		t.Fatal(err)
+	} else if e.Value != value || len(e.Stack) == 0 {
+		t.Fatal(e.Value)
+	}
+}
+
+func TestDB_RecoverConverterPanic(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	s.onQuery("select v from t").rows([]string{"v"}, []string{"boom"})
+	s.onQuery("select v from u").rows([]string{"v"}, []string{"ok"})
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(2)
+	db.SetTypeConverter(func(col ColumnType, raw []byte) (interface{}, error) {
+		if string(raw) == "boom" {
+			panic("bad value")
+		}
+		return nil, ErrDefaultConversion
+	})
+	defer db.Close()
+
+	other := popTestConn(t, db)
+
+	panics := Panics()
+	if _, err := db.Query("select v from t"); err == nil {
+		t.Fatal("must error")
+	} else {
+		checkInternalError(t, err, "bad value")
+	}
+
+	//the panicked conn is not pooled, the others keep working
+	if st := db.Stats(); st.Panics != panics+1 || st.Failed != 1 || st.IdleConns != 0 || st.OpenConns != 1 {
+		t.Fatalf("%+v", st)
+	}
+	if r, err := other.Execute("select v from u"); err != nil {
+		t.Fatal(err)
+	} else if v, _ := r.GetString(0, 0); v != "ok" {
+		t.Fatal(v)
+	}
+	db.PushConn(other, nil)
+
+	if r, err := db.Query("select v from u"); err != nil {
+		t.Fatal(err)
+	} else if v, _ := r.GetString(0, 0); v != "ok" {
+		t.Fatal(v)
+	}
+
+	//in a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Execute("select v from t"); !errors.Is(err, ErrInternal) {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != ErrBadConn {
+		t.Fatal(err)
+	}
+	tx.Close()
+	if st := db.Stats(); st.Panics != panics+2 || st.Failed != 2 || st.OpenConns != 0 {
+		t.Fatalf("%+v", st)
+	}
+}
+
+func TestDB_RecoverFaultPanic(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(2)
+	defer db.Close()
+
+	//in a dial
+	db.SetFaultInjector(&panicInjector{point: FaultHandshake, nth: 1})
+	if _, err := db.Execute("select 1"); err == nil {
+		t.Fatal("must error")
+	} else {
+		checkInternalError(t, err, "injected panic")
+	}
+	if st := db.Stats(); st.OpenConns != 0 || st.Failed != 1 {
+		t.Fatalf("%+v", st)
+	}
+
+	//in a statement, the connect reads 3 packets and the connect queries 2 more each
+	db.SetFaultInjector(&panicInjector{point: FaultRead, nth: 8})
+	if _, err := db.Execute("select 1"); err == nil {
+		t.Fatal("must error")
+	} else {
+		checkInternalError(t, err, "injected panic")
+	}
+
+	db.SetFaultInjector(nil)
+	if _, err := db.Execute("select 1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConn_RecoverPanicsOff(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	s.onQuery("select v from t").rows([]string{"v"}, []string{"boom"})
+
+	db := s.openDB("")
+	defer db.Close()
+
+	co := popTestConn(t, db)
+	defer co.Close()
+	co.SetTypeConverter(func(col ColumnType, raw []byte) (interface{}, error) {
+		panic("bad value")
+	})
+
+	SetRecoverPanics(false)
+	defer SetRecoverPanics(true)
+
+	defer func() {
+		if v := recover(); v != "bad value" {
+			t.Fatal(v)
+		}
+	}()
+	co.Execute("select v from t")
+	t.Fatal("must panic")
+}
@@ -0,0 +1,815 @@
+package client
+
+import (
+	"context"
+	. "github.com/siddontang/mixer/mysql"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func popTestConn(t *testing.T, db *DB) *Conn {
+	co, err := db.PopConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return co
+}
+
+// waitServerConns waits until the fake server has n open conns
+func waitServerConns(t *testing.T, s *fakeServer, n int) {
+	for i := 0; i < 1000; i++ {
+		if open, _, _ := s.stats(); open == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	open, _, _ := s.stats()
+	t.Fatalf("server conns %d, not %d", open, n)
+}
+
+func TestPool_Reuse(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("mixer")
+	db.SetMaxIdleConnNum(2)
+
+	co := popTestConn(t, db)
+	id := co.ConnectionId()
+	db.PushConn(co, nil)
+
+	co = popTestConn(t, db)
+	if co.ConnectionId() != id {
+		t.Fatal(co.ConnectionId(), id)
+	}
+	db.PushConn(co, nil)
+
+	if _, dials, _ := s.stats(); dials != 1 {
+		t.Fatal(dials)
+	}
+	if st := db.Stats(); st.Acquired != 2 || st.OpenConns != 1 || st.IdleConns != 1 {
+		t.Fatalf("%+v", st)
+	}
+}
+
+func TestPool_TryReuse(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("mixer")
+	db.SetMaxIdleConnNum(1)
+
+	//the next user gets the conn back in a clean session
+	for _, c := range []struct {
+		query   string
+		restore string
+	}{
+		{"begin", "rollback"},
+		{"set autocommit = 0", "set autocommit = 1"},
+		{"use other", ""},
+	} {
+		co := popTestConn(t, db)
+		id := co.ConnectionId()
+		if _, err := co.Execute(c.query); err != nil {
+			t.Fatal(err)
+		}
+		db.PushConn(co, nil)
+
+		_, _, before := s.stats()
+		co = popTestConn(t, db)
+		_, _, after := s.stats()
+
+		if co.ConnectionId() != id {
+			t.Fatalf("%s: conn not reused", c.query)
+		} else if co.IsInTransaction() || !co.IsAutoCommit() || co.GetDB() != "mixer" {
+			t.Fatalf("%s: status %d db %s", c.query, co.status, co.GetDB())
+		}
+
+		if len(c.restore) > 0 && (len(after) != len(before)+1 || after[len(before)] != c.restore) {
+			t.Fatalf("%s: %v", c.query, after[len(before):])
+		}
+
+		s.Lock()
+		serverDB := s.conns[id].db
+		s.Unlock()
+		if serverDB != "mixer" {
+			t.Fatalf("%s: server db %s", c.query, serverDB)
+		}
+
+		db.PushConn(co, nil)
+	}
+
+	if _, dials, _ := s.stats(); dials != 1 {
+		t.Fatal(dials)
+	}
+}
+
+func TestPool_UseDB(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(2)
+	defer db.Close()
+
+	co := popTestConn(t, db)
+	id := co.ConnectionId()
+	db.PushConn(co, nil)
+
+	//the idle conn selects the new db, a new conn connects with it
+	db.UseDB("mixer")
+	co1, co2 := popTestConn(t, db), popTestConn(t, db)
+	if co1.ConnectionId() != id || co1.GetDB() != "mixer" || co2.GetDB() != "mixer" {
+		t.Fatal(co1.ConnectionId(), co1.GetDB(), co2.GetDB())
+	}
+	s.Lock()
+	serverDB := s.conns[id].db
+	s.Unlock()
+	if serverDB != "mixer" {
+		t.Fatal(serverDB)
+	}
+
+	//a borrower switching schemas does not leak it to the next one
+	if _, err := co1.Execute("use other"); err != nil {
+		t.Fatal(err)
+	}
+	db.PushConn(co1, nil)
+	db.PushConn(co2, nil)
+	co1, co2 = popTestConn(t, db), popTestConn(t, db)
+	if co1.GetDB() != "mixer" || co2.GetDB() != "mixer" {
+		t.Fatal(co1.GetDB(), co2.GetDB())
+	}
+	db.PushConn(co1, nil)
+	db.PushConn(co2, nil)
+
+	if _, dials, _ := s.stats(); dials != 2 {
+		t.Fatal(dials)
+	}
+}
+
+func TestPool_TryReuseFailed(t *testing.T) {
+	s := newFakeServer(func(c *fakeServerConn, query string) error {
+		if query == "rollback" {
+			return c.writeError(ER_LOCK_WAIT_TIMEOUT, "Lock wait timeout exceeded")
+		}
+		return c.exec(query)
+	})
+	defer s.Close()
+
+	//no default db to restore after a use either
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+
+	for _, query := range []string{"begin", "use other"} {
+		co := popTestConn(t, db)
+		id := co.ConnectionId()
+		if _, err := co.Execute(query); err != nil {
+			t.Fatal(err)
+		}
+		db.PushConn(co, nil)
+
+		//the conn can not be cleaned, a new one takes its place
+		co = popTestConn(t, db)
+		if co.ConnectionId() == id || co.IsInTransaction() || co.GetDB() != "" {
+			t.Fatalf("%s: reused dirty conn", query)
+		}
+		db.PushConn(co, nil)
+	}
+
+	waitServerConns(t, s, 1)
+	if _, dials, _ := s.stats(); dials != 3 {
+		t.Fatal(dials)
+	}
+	if st := db.Stats(); st.OpenConns != 1 || st.IdleConns != 1 || st.ReuseFailures != 2 || st.Created != 3 {
+		t.Fatalf("%+v", st)
+	}
+}
+
+func TestPool_BadConn(t *testing.T) {
+	var drops, fails int32
+	s := newFakeServer(func(c *fakeServerConn, query string) error {
+		switch query {
+		case "select bad":
+			if atomic.AddInt32(&fails, -1) >= 0 {
+				atomic.AddInt32(&drops, 1)
+				return errFakeDrop
+			}
+		case "select error":
+			atomic.AddInt32(&drops, 1)
+			return c.writeError(ER_UNKNOWN_ERROR, "unknown error")
+		}
+		return c.exec(query)
+	})
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(2)
+
+	//a broken conn is dropped and the query retried on a new one
+	atomic.StoreInt32(&fails, 1)
+	if _, err := db.Execute("select bad"); err != nil {
+		t.Fatal(err)
+	}
+	if st := db.Stats(); st.Failed != 1 || st.OpenConns != 1 || st.IdleConns != 1 {
+		t.Fatalf("%+v", st)
+	}
+
+	//retries stop at the max
+	atomic.StoreInt32(&fails, 100)
+	atomic.StoreInt32(&drops, 0)
+	if _, err := db.Execute("select bad"); err != ErrBadConn {
+		t.Fatal(err)
+	} else if n := atomic.LoadInt32(&drops); n != maxBadConnRetries+1 {
+		t.Fatal(n)
+	}
+	if st := db.Stats(); st.OpenConns != 0 || st.IdleConns != 0 {
+		t.Fatalf("%+v", st)
+	}
+
+	//a sql error is not retried
+	atomic.StoreInt32(&drops, 0)
+	if _, err := db.Execute("select error"); err == nil {
+		t.Fatal("must error")
+	} else if e, ok := err.(*SqlError); !ok || e.Code != ER_UNKNOWN_ERROR {
+		t.Fatal(err)
+	} else if n := atomic.LoadInt32(&drops); n != 1 {
+		t.Fatal(n)
+	}
+}
+
+func TestPool_BadConnSent(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	s.onQuery("select 1").rows([]string{"1"}, []string{"1"})
+
+	//over a socket the query to a conn closed by the server is written
+	db, _ := Open(s.listen(t), "root", "", "")
+	db.SetMaxIdleConnNum(2)
+	defer db.Close()
+
+	//an idle conn closed by the server is retried by default, the query
+	//written to it is never read
+	if _, err := db.Query("select 1"); err != nil {
+		t.Fatal(err)
+	}
+	s.dropConns()
+	if _, err := db.Query("select 1"); err != nil {
+		t.Fatal(err)
+	} else if n := countQueries(s, "select 1"); n != 2 {
+		t.Fatal(n)
+	}
+
+	//closed after the query is read, it may have run and is not sent again
+	db.SetRetrySent(false)
+	s.onQuery("insert into t values (1)").disconnect()
+	if _, err := db.Execute("insert into t values (1)"); err != ErrBadConn {
+		t.Fatal(err)
+	} else if n := countQueries(s, "insert into t values (1)"); n != 1 {
+		t.Fatal(n)
+	}
+
+	s.onQuery("select 2").disconnect()
+	if _, err := db.QueryStream("select 2"); err != ErrBadConn {
+		t.Fatal(err)
+	} else if n := countQueries(s, "select 2"); n != 1 {
+		t.Fatal(n)
+	}
+
+	//a conn broken before the query is sent is still retried
+	co := popTestConn(t, db)
+	co.Close()
+	db.PushConn(co, nil)
+	failed := db.Stats().Failed
+	if _, err := db.Execute("insert into t values (2)"); err != nil {
+		t.Fatal(err)
+	} else if n := countQueries(s, "insert into t values (2)"); n != 1 {
+		t.Fatal(n)
+	} else if st := db.Stats(); st.Failed != failed+1 {
+		t.Fatalf("%+v", st)
+	}
+}
+
+func TestPool_DeadIdleConns(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(2)
+
+	conns := []*Conn{popTestConn(t, db), popTestConn(t, db)}
+	for _, co := range conns {
+		db.PushConn(co, nil)
+	}
+
+	//like a restarted server, the first dead idle conn fails the statement,
+	//the next one is pinged before the retry and replaced
+	s.dropConns()
+	waitServerConns(t, s, 0)
+
+	if _, err := db.Execute("select 1"); err != nil {
+		t.Fatal(err)
+	}
+	if st := db.Stats(); st.Failed != 1 || st.OpenConns != 1 || st.IdleConns != 1 || st.ReuseFailures != 1 {
+		t.Fatalf("%+v", st)
+	}
+}
+
+func TestPool_MaxIdleOverflow(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(2)
+
+	conns := []*Conn{popTestConn(t, db), popTestConn(t, db), popTestConn(t, db)}
+	for _, co := range conns {
+		db.PushConn(co, nil)
+	}
+
+	if st := db.Stats(); st.OpenConns != 2 || st.IdleConns != 2 || st.IdleFullCloses != 1 {
+		t.Fatalf("%+v", st)
+	}
+	waitServerConns(t, s, 2)
+
+	//no idle conns, the idle ones are closed at once and every conn is
+	//closed when pushed back
+	db.SetMaxIdleConnNum(0)
+	co := popTestConn(t, db)
+	db.PushConn(co, nil)
+
+	if st := db.Stats(); st.OpenConns != 0 || st.IdleConns != 0 || st.IdleFullCloses != 4 {
+		t.Fatalf("%+v", st)
+	}
+	waitServerConns(t, s, 0)
+}
+
+func TestPool_DialFailed(t *testing.T) {
+	s := newFakeServer(nil)
+	s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(2)
+
+	if _, err := db.Execute("select 1"); err == nil {
+		t.Fatal("must error")
+	}
+	if st := db.Stats(); st.Failed != 1 || st.OpenConns != 0 {
+		t.Fatalf("%+v", st)
+	}
+}
+
+func TestPool_DumpConns(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	//a statement of no column and no param
+	s.on(COM_STMT_PREPARE, "do 1").packets([]byte{OK_HEADER, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+
+	db := s.openDB("mixer")
+	db.SetMaxIdleConnNum(4)
+
+	if conns := db.DumpConns(); len(conns) != 0 {
+		t.Fatal(conns)
+	}
+
+	start := time.Now()
+	a := popTestConn(t, db)
+	b := popTestConn(t, db)
+	c := popTestConn(t, db)
+
+	if _, err := a.Prepare("do 1"); err != nil {
+		t.Fatal(err)
+	}
+	db.PushConn(a, nil)
+
+	if err := b.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	db.PushConn(b, nil)
+
+	conns := db.DumpConns()
+	if len(conns) != 3 {
+		t.Fatal(conns)
+	}
+
+	expect := []struct {
+		co            *Conn
+		inUse, inTran bool
+		stmts         int
+	}{
+		{a, false, false, 1},
+		{b, false, true, 0},
+		{c, true, false, 0},
+	}
+	for i, e := range expect {
+		ci := conns[i]
+		if ci.ConnectionId != e.co.ConnectionId() || ci.InUse != e.inUse ||
+			ci.InTransaction != e.inTran || ci.Stmts != e.stmts || ci.DB != "mixer" {
+			t.Fatalf("%d: %+v", i, ci)
+		} else if ci.Created.Before(start) || ci.LastUsed.Before(ci.Created) {
+			t.Fatalf("%d: %+v", i, ci)
+		}
+	}
+
+	//returned after checked out
+	if !conns[1].LastUsed.After(conns[2].LastUsed) {
+		t.Fatalf("%+v", conns)
+	}
+
+	//popped from the front and cleaned, the checked out conns by id
+	a2 := popTestConn(t, db)
+	b2 := popTestConn(t, db)
+	if conns = db.DumpConns(); len(conns) != 3 {
+		t.Fatal(conns)
+	}
+	for i, co := range []*Conn{a2, b2, c} {
+		if ci := conns[i]; ci.ConnectionId != co.ConnectionId() || !ci.InUse || ci.InTransaction {
+			t.Fatalf("%d: %+v", i, ci)
+		}
+	}
+
+	for _, co := range []*Conn{a2, b2, c} {
+		db.PushConn(co, nil)
+	}
+	if st := db.Stats(); st.InUse != 0 || st.IdleConns != 3 {
+		t.Fatalf("%+v", st)
+	}
+}
+
+func TestPool_Close(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("mixer")
+	db.SetMaxIdleConnNum(2)
+
+	idle, held := popTestConn(t, db), popTestConn(t, db)
+	db.PushConn(idle, nil)
+
+	//idempotent and safe concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := db.Close(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if st := db.Stats(); st.IdleConns != 0 || st.OpenConns != 1 {
+		t.Fatalf("%+v", st)
+	}
+	waitServerConns(t, s, 1)
+
+	if _, err := db.PopConn(); err != ErrDBClosed {
+		t.Fatal(err)
+	}
+	if _, err := db.Execute("select 1"); err != ErrDBClosed {
+		t.Fatal(err)
+	}
+	if _, err := db.Query("select 1"); err != ErrDBClosed {
+		t.Fatal(err)
+	}
+	if _, err := db.Begin(); err != ErrDBClosed {
+		t.Fatal(err)
+	}
+	if _, dials, _ := s.stats(); dials != 2 {
+		t.Fatal(dials)
+	}
+
+	//closed when pushed back
+	db.PushConn(held, nil)
+	if st := db.Stats(); st.IdleConns != 0 || st.OpenConns != 0 {
+		t.Fatalf("%+v", st)
+	}
+	waitServerConns(t, s, 0)
+}
+
+func TestPool_CloseWaiters(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("mixer")
+	db.SetMaxIdleConnNum(1)
+	db.SetMaxOpenConns(1)
+
+	held := popTestConn(t, db)
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := db.PopConn()
+			errs <- err
+		}()
+	}
+	for db.Stats().Waiting != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	db.Close()
+	db.PushConn(held, nil)
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != ErrDBClosed {
+			t.Fatal(err)
+		}
+	}
+	if st := db.Stats(); st.OpenConns != 0 || st.Waiting != 0 {
+		t.Fatalf("%+v", st)
+	}
+	if _, dials, _ := s.stats(); dials != 1 {
+		t.Fatal(dials)
+	}
+}
+
+func TestPool_Shutdown(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("mixer")
+	db.SetMaxIdleConnNum(2)
+
+	held1, held2 := popTestConn(t, db), popTestConn(t, db)
+
+	//not drained in time
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := db.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+	if _, err := db.PopConn(); err != ErrPoolClosed {
+		t.Fatal(err)
+	} else if _, err := db.Execute("select 1"); err != ErrPoolClosed {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- db.Shutdown(context.Background())
+	}()
+
+	db.PushConn(held1, nil)
+	select {
+	case err := <-done:
+		t.Fatal("returned with a conn in use", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	db.PushConn(held2, nil)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if st := db.Stats(); st.OpenConns != 0 {
+		t.Fatalf("%+v", st)
+	}
+	waitServerConns(t, s, 0)
+
+	//drained already
+	if err := db.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPool_BeginRollback(t *testing.T) {
+	//a table of committed rows, the rows of a transaction are pending
+	var lock sync.Mutex
+	var rows, pending int
+	s := newFakeServer(func(c *fakeServerConn, query string) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch query {
+		case "insert into t values (1)":
+			pending++
+		case "commit":
+			rows += pending
+			pending = 0
+		case "rollback":
+			pending = 0
+		case "select count(*) from t":
+			return c.writeResultset([]string{"count(*)"}, [][]string{{strconv.Itoa(rows)}})
+		}
+		return c.exec(query)
+	})
+	defer s.Close()
+
+	db := s.openDB("mixer")
+	db.SetMaxIdleConnNum(1)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Execute("insert into t values (1)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	tx.Close()
+
+	if r, err := db.Query("select count(*) from t"); err != nil {
+		t.Fatal(err)
+	} else if n, _ := r.GetInt(0, 0); n != 0 {
+		t.Fatal(n)
+	}
+	if n := countQueries(s, "commit"); n != 0 {
+		t.Fatal(n)
+	}
+	if st := db.Stats(); st.IdleConns != 1 || st.InUse != 0 {
+		t.Fatalf("%+v", st)
+	}
+
+	//a failed rollback returns its error, the conn is still returned
+	s.onQuery("rollback").times(1).disconnect()
+
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != ErrBadConn {
+		t.Fatal(err)
+	}
+	tx.Close()
+
+	if st := db.Stats(); st.IdleConns != 0 || st.InUse != 0 || st.OpenConns != 0 {
+		t.Fatalf("%+v", st)
+	}
+}
+
+func TestPool_StatsCounters(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("mixer")
+	db.SetMaxIdleConnNum(1)
+	db.SetMaxOpenConns(2)
+	defer db.Close()
+
+	a, b := popTestConn(t, db), popTestConn(t, db)
+	db.PushConn(a, nil)
+	//over the idle conns
+	db.PushConn(b, nil)
+
+	a = popTestConn(t, db)
+	b = popTestConn(t, db)
+
+	//a waiter gets the slot of a bad conn
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		db.PushConn(popTestConn(t, db), nil)
+	}()
+	for db.Stats().Waiting != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	db.PushConn(a, ErrBadConn)
+	<-done
+	db.PushConn(b, nil)
+
+	st := db.Stats()
+	if st.Created != 4 || st.Reused != 1 || st.Closed != 3 || st.OpenConns != 1 {
+		t.Fatalf("%+v", st)
+	}
+	if st.WaitCount != 1 || st.WaitDuration <= 0 || st.WaitDuration != st.Waits[0].Total {
+		t.Fatalf("%+v", st)
+	}
+
+	//safe with traffic
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if _, err := db.Execute("select 1"); err != nil {
+					t.Error(err)
+				}
+				db.Stats()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if st := db.Stats(); st.Created+st.Reused != st.Acquired {
+		t.Fatalf("%+v", st)
+	}
+}
+
+func TestPool_SetMaxIdleConns(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConns(4)
+	db.SetIdleGrace(time.Hour)
+	defer db.Close()
+
+	conns := make([]*Conn, 4)
+	for i := range conns {
+		conns[i] = popTestConn(t, db)
+	}
+	for _, co := range conns {
+		db.PushConn(co, nil)
+	}
+
+	//shrunk, the oldest are closed at once despite the grace
+	db.SetMaxIdleConns(1)
+	if st := db.Stats(); st.IdleConns != 1 || st.OpenConns != 1 || st.IdleFullCloses != 3 || st.MaxIdleConns != 1 {
+		t.Fatalf("%+v", st)
+	}
+	waitServerConns(t, s, 1)
+	if co := popTestConn(t, db); co != conns[3] {
+		t.Fatal("not the newest kept")
+	} else {
+		db.PushConn(co, nil)
+	}
+
+	//none kept
+	db.SetMaxIdleConns(0)
+	co := popTestConn(t, db)
+	db.PushConn(co, nil)
+	if st := db.Stats(); st.IdleConns != 0 || st.OpenConns != 0 {
+		t.Fatalf("%+v", st)
+	}
+
+	//grown, while used concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			db.SetMaxIdleConns(i % 3)
+			if _, err := db.Execute("select 1"); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	db.SetMaxIdleConns(2)
+	for i := range conns[:2] {
+		conns[i] = popTestConn(t, db)
+	}
+	for _, co := range conns[:2] {
+		db.PushConn(co, nil)
+	}
+	if st := db.Stats(); st.IdleConns != 2 || st.OpenConns != 2 {
+		t.Fatalf("%+v", st)
+	}
+}
+
+func TestPool_PushBrokenConn(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	s.onQuery("select dup").err(ER_DUP_ENTRY, "Duplicate entry '1' for key 'PRIMARY'")
+	s.onQuery("select half").disconnectAfter(2).rows([]string{"a"}, []string{"1"}, []string{"2"})
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	defer db.Close()
+
+	//a statement error leaves the conn usable
+	co := popTestConn(t, db)
+	id := co.ConnectionId()
+	if _, err := co.Execute("select dup"); err == nil {
+		t.Fatal("must error")
+	}
+	db.PushConn(co, nil)
+
+	co = popTestConn(t, db)
+	if co.ConnectionId() != id {
+		t.Fatal(co.ConnectionId(), id)
+	}
+
+	//failed in the middle of the resultset, closed though pushed without
+	//the error
+	if _, err := co.Execute("select half"); err != ErrBadConn {
+		t.Fatal(err)
+	}
+	db.PushConn(co, nil)
+
+	if st := db.Stats(); st.OpenConns != 0 || st.IdleConns != 0 || st.Failed != 1 {
+		t.Fatalf("%+v", st)
+	}
+
+	//every packet error
+	for i, err := range []error{ErrReadTimeout, ErrWriteTimeout, ErrQueryTimeout, ErrMalformPacket} {
+		co = popTestConn(t, db)
+		co.pkgErr = err
+		db.PushConn(co, nil)
+
+		if st := db.Stats(); st.OpenConns != 0 || st.Failed != uint64(i+2) {
+			t.Fatalf("%v: %+v", err, st)
+		}
+	}
+}
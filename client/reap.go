@@ -0,0 +1,187 @@
+package client
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// SetConnMaxLifetime closes the conns connected longer than d instead of
+// reusing them, like before a load balancer or the server cuts them. 0
+// means no limit.
+func (db *DB) SetConnMaxLifetime(d time.Duration) {
+	db.Lock()
+	db.connMaxLifetime = d
+	db.startReaper()
+	db.Unlock()
+}
+
+// SetConnMaxIdleTime closes the conns idle longer than d instead of
+// reusing them, like before the idle timeout of a load balancer or the
+// wait_timeout of the server. 0 means no limit.
+func (db *DB) SetConnMaxIdleTime(d time.Duration) {
+	db.Lock()
+	db.connMaxIdleTime = d
+	db.startReaper()
+	db.Unlock()
+}
+
+// minIdleFillInterval is how often idle conns are refilled to the min idle
+// conns if no max lifetime or idle time is set
+const minIdleFillInterval = 5 * time.Second
+
+// SetMinIdleConns keeps at least n idle conns, at most the max idle conns:
+// the idle conns over the max idle time are not closed below n, and the
+// reaper opens conns until n are idle again, like after a burst took them
+// or the max lifetime closed them. WarmUp opens them in the first place.
+func (db *DB) SetMinIdleConns(n int) {
+	db.Lock()
+	db.minIdleConns = n
+	db.startReaper()
+	db.Unlock()
+}
+
+// minIdle returns the idle conns to keep, must hold the lock
+func (db *DB) minIdle() int {
+	if db.minIdleConns > db.maxIdleConns {
+		return db.maxIdleConns
+	}
+	return db.minIdleConns
+}
+
+// lifetimeExpired is true for a conn over the max lifetime, must hold the
+// lock
+func (db *DB) lifetimeExpired(co *Conn, now time.Time) bool {
+	return db.connMaxLifetime > 0 && now.Sub(co.created) >= db.connMaxLifetime
+}
+
+// idleTimeExpired is true for a conn over the max idle time, must hold the
+// lock
+func (db *DB) idleTimeExpired(co *Conn, now time.Time) bool {
+	return db.connMaxIdleTime > 0 && now.Sub(co.lastUsed) >= db.connMaxIdleTime
+}
+
+// removeExpired removes the expired idle conns and gives up their slots,
+// the conns over the idle time only are kept for the min idle conns, there
+// are no waiters while conns are idle, must hold the lock
+func (db *DB) removeExpired(now time.Time) []*Conn {
+	var expired []*Conn
+	idle, min := db.idleConns.Len(), db.minIdle()
+	for e := db.idleConns.Front(); e != nil; {
+		next := e.Next()
+		co := e.Value.(*Conn)
+		if db.lifetimeExpired(co, now) {
+			atomic.AddUint64(&db.lifetimeCloses, 1)
+		} else if idle > min && db.idleTimeExpired(co, now) {
+			atomic.AddUint64(&db.idleTimeCloses, 1)
+		} else {
+			e = next
+			continue
+		}
+
+		db.idleConns.Remove(e)
+		expired = append(expired, co)
+		atomic.AddInt32(&db.connNum, -1)
+		idle--
+		e = next
+	}
+	return expired
+}
+
+// closeAll closes the conns removed from the pool
+func (db *DB) closeAll(conns []*Conn) {
+	for _, co := range conns {
+		db.closeConn(co)
+	}
+}
+
+// fillIdle opens conns until the min idle conns are idle, stops at the
+// max open conns or the first failure
+func (db *DB) fillIdle() {
+	for {
+		db.Lock()
+		if db.closed || db.idleConns.Len() >= db.minIdle() ||
+			(db.maxOpenConns > 0 && int(atomic.LoadInt32(&db.connNum)) >= db.maxOpenConns) {
+			db.Unlock()
+			return
+		}
+		//take the slot before connecting, like takeConn
+		atomic.AddInt32(&db.connNum, 1)
+		db.Unlock()
+
+		co, err := db.newConn()
+		if err != nil {
+			atomic.AddUint64(&db.failed, 1)
+			db.releaseSlot()
+			return
+		}
+		db.PushConn(co, nil)
+	}
+}
+
+// reapInterval is how often idle conns are swept, half the shortest limit,
+// or minIdleFillInterval for the min idle conns only, 0 if none, must hold
+// the lock
+func (db *DB) reapInterval() time.Duration {
+	d := db.connMaxLifetime
+	if d <= 0 || (db.connMaxIdleTime > 0 && db.connMaxIdleTime < d) {
+		d = db.connMaxIdleTime
+	}
+	if d <= 0 && db.minIdle() > 0 {
+		return minIdleFillInterval
+	}
+	return d / 2
+}
+
+// startReaper starts sweeping idle conns if a limit is set, a running
+// sweeper is replaced to sweep at the new interval, must hold the lock
+func (db *DB) startReaper() {
+	db.stopReaper()
+	if db.closed || db.reapInterval() <= 0 {
+		return
+	}
+
+	quit := make(chan struct{})
+	db.reaperQuit = quit
+	go db.reap(quit)
+}
+
+// stopReaper stops the sweeper, must hold the lock
+func (db *DB) stopReaper() {
+	if db.reaperQuit != nil {
+		close(db.reaperQuit)
+		db.reaperQuit = nil
+	}
+}
+
+// reap closes the expired idle conns periodically, so they do not pile up
+// in a quiet pool, and refills the min idle conns, until quit or no limit
+// is set
+func (db *DB) reap(quit chan struct{}) {
+	for {
+		db.Lock()
+		d := db.reapInterval()
+		if d <= 0 {
+			if db.reaperQuit == quit {
+				db.reaperQuit = nil
+			}
+			db.Unlock()
+			return
+		}
+		db.Unlock()
+
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-quit:
+			t.Stop()
+			return
+		}
+
+		db.Lock()
+		expired := db.removeExpired(time.Now())
+		db.Unlock()
+
+		db.closeAll(expired)
+		db.fillIdle()
+	}
+}
@@ -0,0 +1,189 @@
+package client
+
+import (
+	. "github.com/siddontang/mixer/mysql"
+	"testing"
+	"time"
+)
+
+func TestPool_ConnMaxIdleTime(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(2)
+	db.SetConnMaxIdleTime(time.Hour)
+	defer db.Close()
+
+	//busy, the push renews it
+	co := popTestConn(t, db)
+	id := co.ConnectionId()
+	co.lastUsed = time.Now().Add(-2 * time.Hour)
+	db.PushConn(co, nil)
+
+	co = popTestConn(t, db)
+	if co.ConnectionId() != id {
+		t.Fatal(co.ConnectionId(), id)
+	}
+	db.PushConn(co, nil)
+
+	//idle too long, skipped and closed
+	db.Lock()
+	co.lastUsed = time.Now().Add(-2 * time.Hour)
+	db.Unlock()
+
+	co = popTestConn(t, db)
+	if co.ConnectionId() == id {
+		t.Fatal("expired conn reused")
+	}
+	db.PushConn(co, nil)
+
+	if st := db.Stats(); st.IdleTimeCloses != 1 || st.LifetimeCloses != 0 || st.OpenConns != 1 || st.IdleConns != 1 {
+		t.Fatalf("%+v", st)
+	}
+	waitServerConns(t, s, 1)
+}
+
+func TestPool_ConnMaxLifetime(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(2)
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(time.Hour)
+	defer db.Close()
+
+	//too old when pushed back
+	co := popTestConn(t, db)
+	co.created = time.Now().Add(-2 * time.Hour)
+	db.PushConn(co, nil)
+
+	if st := db.Stats(); st.LifetimeCloses != 1 || st.OpenConns != 0 || st.IdleConns != 0 {
+		t.Fatalf("%+v", st)
+	}
+
+	//too old when idle, its slot is given up before waiting on a full pool
+	co = popTestConn(t, db)
+	db.PushConn(co, nil)
+	db.Lock()
+	co.created = time.Now().Add(-2 * time.Hour)
+	db.Unlock()
+
+	co = popTestConn(t, db)
+	db.PushConn(co, nil)
+
+	if st := db.Stats(); st.LifetimeCloses != 2 || st.IdleTimeCloses != 0 || st.OpenConns != 1 || st.IdleConns != 1 {
+		t.Fatalf("%+v", st)
+	}
+	if _, dials, _ := s.stats(); dials != 3 {
+		t.Fatal(dials)
+	}
+}
+
+func TestPool_Reaper(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(2)
+
+	db.PushConn(popTestConn(t, db), nil)
+	waitServerConns(t, s, 1)
+
+	//swept in a quiet pool
+	db.SetConnMaxIdleTime(20 * time.Millisecond)
+	waitServerConns(t, s, 0)
+	if st := db.Stats(); st.IdleTimeCloses != 1 || st.OpenConns != 0 || st.IdleConns != 0 {
+		t.Fatalf("%+v", st)
+	}
+
+	//stopped without limits
+	db.SetConnMaxIdleTime(0)
+	for i := 0; ; i++ {
+		db.Lock()
+		running := db.reaperQuit != nil
+		db.Unlock()
+		if !running {
+			break
+		} else if i == 1000 {
+			t.Fatal("reaper not stopped")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	//and by Close
+	db.SetConnMaxLifetime(time.Hour)
+	db.Close()
+	db.Lock()
+	defer db.Unlock()
+	if db.reaperQuit != nil {
+		t.Fatal("reaper not stopped")
+	}
+}
+
+func TestPool_MinIdleConns(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(4)
+	db.SetMinIdleConns(2)
+	defer db.Close()
+
+	if n, err := db.WarmUp(3, nil, 3, time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	} else if n != 3 {
+		t.Fatal(n)
+	}
+	waitServerConns(t, s, 3)
+
+	//idle too long, but the min idle conns are kept
+	db.SetConnMaxIdleTime(20 * time.Millisecond)
+	waitServerConns(t, s, 2)
+	time.Sleep(50 * time.Millisecond)
+	if st := db.Stats(); st.IdleTimeCloses != 1 || st.OpenConns != 2 || st.IdleConns != 2 {
+		t.Fatalf("%+v", st)
+	}
+
+	//refilled after broken
+	for _, co := range []*Conn{popTestConn(t, db), popTestConn(t, db)} {
+		db.PushConn(co, ErrBadConn)
+	}
+	for i := 0; ; i++ {
+		if st := db.Stats(); st.IdleConns == 2 {
+			break
+		} else if i == 1000 {
+			t.Fatalf("%+v", st)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	waitServerConns(t, s, 2)
+}
+
+func TestPool_WarmUpTimeout(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(2)
+	db.SetMaxOpenConns(2)
+	defer db.Close()
+
+	//the warmed conns are held, so the second waits on the full pool
+	held := popTestConn(t, db)
+
+	start := time.Now()
+	if n, err := db.WarmUp(2, nil, 2, time.Now().Add(20*time.Millisecond)); err != ErrWarmUpTimeout {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	} else if d := time.Now().Sub(start); d > time.Second {
+		t.Fatal(d)
+	}
+	db.PushConn(held, nil)
+
+	if st := db.Stats(); st.OpenConns != 2 || st.IdleConns != 2 || st.Waiting != 0 {
+		t.Fatalf("%+v", st)
+	}
+}
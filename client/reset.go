@@ -0,0 +1,122 @@
+package client
+
+import (
+	"errors"
+	. "github.com/siddontang/mixer/mysql"
+	"strconv"
+	"strings"
+)
+
+var ErrResetUnsupported = errors.New("server supports neither COM_RESET_CONNECTION nor COM_CHANGE_USER")
+
+// ResetConnection resets the session as a new connection has it: the
+// transaction is rolled back, autocommit, the session variables and the
+// user variables are reset, and the temporary tables, the locks and the
+// prepared statements are released. It uses COM_RESET_CONNECTION of MySQL
+// 5.7.3 or MariaDB 10.2.4 on, and COM_CHANGE_USER re-authenticating as the
+// same user before. The default db, the charset and the collation are
+// kept, and the gtid tracking set again. A server supporting neither
+// returns ErrResetUnsupported, from then on without a round trip.
+func (c *Conn) ResetConnection() (err error) {
+	defer c.recoverPanic(&err)
+
+	if c.noReset {
+		return ErrResetUnsupported
+	}
+
+	if hasResetConnection(c.serverVersion) {
+		err = c.writeCommand(COM_RESET_CONNECTION)
+	} else {
+		err = c.writeChangeUser()
+	}
+	if err == nil {
+		_, err = c.readOK()
+	}
+	if e, ok := err.(*SqlError); ok && e.Code == ER_UNKNOWN_COM_ERROR {
+		c.noReset = true
+		return ErrResetUnsupported
+	} else if err != nil {
+		return err
+	}
+
+	//released by the server, prepared again when executed
+	c.resetStmts()
+
+	if hasResetConnection(c.serverVersion) {
+		//set names of the global charset, not the one of the conn
+		if err := c.setNames(c.charset, c.collation); err != nil {
+			return err
+		}
+	}
+
+	if c.capability&CLIENT_SESSION_TRACK > 0 {
+		if _, err := c.exec("set session session_track_gtids = OWN_GTID"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeChangeUser writes a COM_CHANGE_USER of the user, the db and the
+// charset of the conn, the auth is by the salt of the handshake
+func (c *Conn) writeChangeUser() error {
+	auth := CalcPassword(c.salt, []byte(c.password))
+
+	arg := make([]byte, 0, len(c.user)+1+1+len(auth)+len(c.db)+1+2)
+	arg = append(arg, c.user...)
+	arg = append(arg, 0)
+	arg = append(arg, byte(len(auth)))
+	arg = append(arg, auth...)
+	arg = append(arg, c.db...)
+	arg = append(arg, 0)
+	arg = append(arg, byte(c.collation), 0)
+
+	return c.writeCommandBuf(COM_CHANGE_USER, arg)
+}
+
+// hasResetConnection is true for a server version with
+// COM_RESET_CONNECTION, MySQL 5.7.3 or MariaDB 10.2.4 on
+func hasResetConnection(version string) bool {
+	if i := strings.Index(version, "-MariaDB"); i >= 0 {
+		//5.5.5-10.6.12-MariaDB of the old replication
+		v := strings.TrimPrefix(version[:i], "5.5.5-")
+		return versionAtLeast(v, 10, 2, 4)
+	}
+	return versionAtLeast(version, 5, 7, 3)
+}
+
+// versionAtLeast compares the leading major.minor.patch of version, a
+// version not parsed is not at least any
+func versionAtLeast(version string, want ...int) bool {
+	if i := strings.IndexFunc(version, func(r rune) bool {
+		return r != '.' && (r < '0' || r > '9')
+	}); i >= 0 {
+		version = version[:i]
+	}
+
+	parts := strings.Split(version, ".")
+	for i, w := range want {
+		if i >= len(parts) {
+			return false
+		}
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return false
+		} else if n != w {
+			return n > w
+		}
+	}
+	return true
+}
+
+// SetResetSession resets the session of every idle conn before its reuse
+// by ResetConnection, so no temporary table, user variable or session
+// variable of the last borrower leaks into the next one. It costs a round
+// trip a checkout, and the prepared statements of the conn, like of
+// SetStmtCache, are prepared again. A conn of a server supporting no reset
+// is restored by a rollback and autocommit as without it.
+func (db *DB) SetResetSession(on bool) {
+	db.Lock()
+	db.resetSession = on
+	db.Unlock()
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	. "github.com/siddontang/mixer/mysql"
+	"sync/atomic"
+	"testing"
+)
+
+func TestConn_ResetConnection(t *testing.T) {
+	for _, version := range []string{"8.0.33", "5.6.0-fake"} {
+		s := newFakeServer(nil)
+		s.version = version
+
+		c := newFakeConn(t, s)
+		if _, err := c.Prepare("select ?"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Execute("set autocommit = 0"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Execute("begin"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.ResetConnection(); err != nil {
+			t.Fatal(version, err)
+		}
+		if !c.IsAutoCommit() || c.IsInTransaction() || c.StmtNum() != 0 || c.GetDB() != "mixer" {
+			t.Fatal(version, c.IsAutoCommit(), c.IsInTransaction(), c.StmtNum(), c.GetDB())
+		}
+		if open, _ := s.stmtStats(); open != 0 {
+			t.Fatal(version, open)
+		}
+
+		//the charset is set again after a reset to the global one only
+		names := 0
+		if hasResetConnection(version) {
+			names = 1
+		}
+		if n := countQueries(s, "set names utf8"); n != names {
+			t.Fatal(version, n)
+		}
+
+		c.Close()
+		s.Close()
+	}
+}
+
+func TestConn_ResetConnectionUnsupported(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+	s.version = "8.0.33"
+
+	var resets int32
+	s.on(COM_RESET_CONNECTION, "").do(func(c *fakeServerConn) error {
+		atomic.AddInt32(&resets, 1)
+		return c.writeError(ER_UNKNOWN_COM_ERROR, "Unknown command")
+	})
+
+	c := newFakeConn(t, s)
+	defer c.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := c.ResetConnection(); err != ErrResetUnsupported {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&resets); n != 1 {
+		t.Fatal(n)
+	}
+	if _, err := c.Execute("select 1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDB_ResetSession(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+	s.version = "8.0.33"
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	db.SetStmtCache(true)
+	db.SetResetSession(true)
+	defer db.Close()
+
+	co := popTestConn(t, db)
+	id := co.ConnectionId()
+	if _, err := co.Execute("select ?", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := co.Execute("set autocommit = 0"); err != nil {
+		t.Fatal(err)
+	}
+	db.PushConn(co, nil)
+
+	//reset by one command instead of restoring autocommit
+	co = popTestConn(t, db)
+	if co.ConnectionId() != id {
+		t.Fatal(co.ConnectionId(), id)
+	}
+	if !co.IsAutoCommit() || co.StmtNum() != 0 {
+		t.Fatal(co.IsAutoCommit(), co.StmtNum())
+	}
+	if n := countQueries(s, "set autocommit = 1"); n != 0 {
+		t.Fatal(n)
+	}
+	if _, err := co.Execute("select ?", 1); err != nil {
+		t.Fatal(err)
+	}
+	db.PushConn(co, nil)
+}
+
+func TestResetConnectionVersion(t *testing.T) {
+	tests := map[string]bool{
+		"8.0.33":                true,
+		"5.7.3-log":             true,
+		"5.7.2":                 false,
+		"5.6.51-log":            false,
+		"5.5.5-10.2.4-MariaDB":  true,
+		"5.5.5-10.1.48-MariaDB": false,
+		"10.6.12-MariaDB-log":   true,
+		"fake":                  false,
+	}
+	for version, want := range tests {
+		if got := hasResetConnection(version); got != want {
+			t.Fatal(version, got)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"github.com/siddontang/go-log/log"
+	. "github.com/siddontang/mixer/mysql"
+	"time"
+)
+
+// serverStartSlack is how far the start times of the server seen by two
+// conns may differ without a restart, uptime is in seconds and the conns
+// do not read it at the same time
+const serverStartSlack = 2 * time.Second
+
+// serverStart returns the start time of the server by its uptime, zero if
+// the server can not tell
+func (c *Conn) serverStart() (time.Time, error) {
+	r, err := c.exec("show global status like 'Uptime'")
+	if _, ok := err.(*SqlError); ok {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+
+	if r.Resultset == nil || r.RowNumber() == 0 || r.ColumnNumber() < 2 {
+		return time.Time{}, nil
+	}
+
+	uptime, err := r.GetUint(0, 1)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(-time.Duration(uptime) * time.Second), nil
+}
+
+// checkRestart compares the start time of the server read on the new
+// conn co with the one of the pool. A later start means the server has
+// restarted since, so the conns of the pool are dead and the statements
+// prepared on them are gone: the pool generation is bumped, the idle
+// conns are closed and conns in use are closed when pushed back, instead
+// of failing one by one. An earlier start is of a conn connected before
+// the restart, it fails as a bad conn.
+func (db *DB) checkRestart(co *Conn) error {
+	start, err := co.serverStart()
+	if err != nil {
+		return err
+	}
+
+	var idle []*Conn
+
+	db.Lock()
+	restarted := !start.IsZero() && !db.serverStart.IsZero() &&
+		start.Sub(db.serverStart) > serverStartSlack
+	if restarted {
+		db.generation++
+		db.restarts++
+		for db.idleConns.Len() > 0 {
+			idle = append(idle, db.idleConns.Remove(db.idleConns.Front()).(*Conn))
+		}
+	}
+	if restarted || db.serverStart.IsZero() {
+		db.serverStart = start
+	}
+	co.generation = db.generation
+	db.Unlock()
+
+	if !restarted {
+		return nil
+	}
+
+	log.Warn("server %s restarted at %s, close %d idle conns of the pool",
+		db.addr, start.Format("2006-01-02 15:04:05"), len(idle))
+
+	for _, c := range idle {
+		db.closeConn(c)
+		db.releaseSlot()
+	}
+	db.closeKillConn()
+	db.FlushResultCache()
+	return nil
+}
+
+// expireIdlePings makes the idle conns be pinged before reuse. A broken
+// conn may be the first sign of a restart, then the dead idle conns are
+// replaced by new ones, and the first new one detects the restart and
+// closes the rest, instead of every one failing a statement.
+func (db *DB) expireIdlePings() {
+	db.Lock()
+	for e := db.idleConns.Front(); e != nil; e = e.Next() {
+		e.Value.(*Conn).lastPing = 0
+	}
+	db.Unlock()
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	. "github.com/siddontang/mixer/mysql"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// onUptime answers the uptime of s by *uptime, a restart resets it
+func onUptime(s *fakeServer, uptime *int64) {
+	s.onQuery("show global status like 'Uptime'").do(func(c *fakeServerConn) error {
+		return c.writeResultset([]string{"Variable_name", "Value"},
+			[][]string{{"Uptime", strconv.FormatInt(atomic.LoadInt64(uptime), 10)}})
+	})
+}
+
+func TestDB_Restart(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	uptime := int64(1000)
+	onUptime(s, &uptime)
+
+	fields := []*Field{{Name: []byte("name"), Charset: uint16(DEFAULT_COLLATION_ID), Type: MYSQL_TYPE_VAR_STRING}}
+	query := "select name from t where id = ?"
+	s.on(COM_STMT_EXECUTE, query).resultset(fields, true, [][]byte{[]byte("a")})
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(5)
+	db.SetStmtCache(true)
+	defer db.Close()
+
+	//idle conns with cached statements
+	conns := make([]*Conn, 5)
+	for i := range conns {
+		conns[i] = popTestConn(t, db)
+		if _, err := conns[i].Execute(query, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, co := range conns {
+		db.PushConn(co, nil)
+	}
+
+	//in use across the restart
+	held := popTestConn(t, db)
+
+	atomic.StoreInt64(&uptime, 0)
+	s.dropConns()
+
+	for i := 0; i < 5; i++ {
+		if r, err := db.Query(query, 1); err != nil {
+			t.Fatal(err)
+		} else if v, _ := r.GetString(0, 0); v != "a" {
+			t.Fatal(v)
+		}
+	}
+
+	//the first idle conn fails and is retried, the next one is pinged
+	//and replaced, the other two are closed without
+	st := db.Stats()
+	if st.Restarts != 1 || st.IdleConns != 1 || st.OpenConns != 2 || st.Failed != 1 {
+		t.Fatalf("%+v", st)
+	}
+	if _, dials, _ := s.stats(); dials != 6 {
+		t.Fatal(dials)
+	}
+
+	//not reused
+	db.PushConn(held, nil)
+	if st = db.Stats(); st.IdleConns != 1 || st.OpenConns != 1 {
+		t.Fatalf("%+v", st)
+	}
+
+	//new conns of the same server
+	conns = conns[:2]
+	for i := range conns {
+		conns[i] = popTestConn(t, db)
+	}
+	for _, co := range conns {
+		db.PushConn(co, nil)
+	}
+	if st = db.Stats(); st.Restarts != 1 || st.IdleConns != 2 {
+		t.Fatalf("%+v", st)
+	}
+}
+
+func TestDB_RestartUnknown(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(2)
+	defer db.Close()
+
+	co := popTestConn(t, db)
+	db.PushConn(co, nil)
+
+	s.dropConns()
+
+	if _, err := db.Execute("select 1"); err != nil {
+		t.Fatal(err)
+	}
+	if st := db.Stats(); st.Restarts != 0 || st.IdleConns != 1 {
+		t.Fatalf("%+v", st)
+	}
+}
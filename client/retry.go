@@ -0,0 +1,211 @@
+package client
+
+import (
+	"context"
+	"errors"
+	. "github.com/siddontang/mixer/mysql"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// maxBadConnRetries is how many times the default predicate retries
+// an operation failed by a broken connection.
+const maxBadConnRetries = 2
+
+// isRetryable is true for an error of a broken connection, see
+// IsConnBroken, but a timeout, as the statement may have run and would
+// likely time out again, and a malformed packet, a failed dial or a failed
+// OnConnect hook, as they would likely fail again.
+func isRetryable(err error) bool {
+	switch err {
+	case ErrQueryTimeout, ErrReadTimeout, ErrWriteTimeout, ErrMalformPacket:
+		return false
+	}
+	if !IsConnBroken(err) {
+		return false
+	}
+
+	var ne net.Error
+	var oe *net.OpError
+	var he *OnConnectError
+	if errors.As(err, &ne) && ne.Timeout() {
+		return false
+	} else if errors.As(err, &oe) && oe.Op == "dial" {
+		return false
+	}
+	return !errors.As(err, &he)
+}
+
+// RetryPredicate decides whether an operation failed with err is tried
+// again on another connection, attempt is 1 for the first failure.
+//
+// A retried write is executed again, so a predicate must only retry
+// writes which are idempotent, or errors which guarantee the write was
+// never applied, see SetRetrySent.
+type RetryPredicate func(err error, attempt int) bool
+
+// DefaultRetryPredicate retries the errors of a broken connection, like
+// ErrBadConn, io.EOF or a server gone away, but the timeouts, up to
+// maxBadConnRetries times.
+func DefaultRetryPredicate(err error, attempt int) bool {
+	return isRetryable(err) && attempt <= maxBadConnRetries
+}
+
+// RetryBadConn retries the errors of a broken connection, as
+// DefaultRetryPredicate, until the operation was tried attempts times, 1
+// disables retries.
+func RetryBadConn(attempts int) RetryPredicate {
+	return func(err error, attempt int) bool {
+		return isRetryable(err) && attempt < attempts
+	}
+}
+
+// RetryDelay returns how long to wait before trying an operation again,
+// attempt is 1 for the first failure, like for backing off a server which
+// is down instead of reconnecting back to back.
+type RetryDelay func(attempt int) time.Duration
+
+// ExpBackoff doubles the delay from base with every attempt, at most max.
+func ExpBackoff(base time.Duration, max time.Duration) RetryDelay {
+	return ExpBackoffFactor(base, max, 2)
+}
+
+// ExpBackoffFactor multiplies the delay from base by factor with every
+// attempt, at most max, like 1.5 for a slower growth than ExpBackoff.
+func ExpBackoffFactor(base time.Duration, max time.Duration, factor float64) RetryDelay {
+	return func(attempt int) time.Duration {
+		d := float64(base)
+		for i := 1; i < attempt && d < float64(max); i++ {
+			d *= factor
+		}
+		if d > float64(max) {
+			return max
+		}
+		return time.Duration(d)
+	}
+}
+
+// Jitter spreads the delays of f randomly between half and all of them,
+// so clients broken by the same restart do not retry in lockstep.
+func Jitter(f RetryDelay) RetryDelay {
+	return func(attempt int) time.Duration {
+		d := f(attempt)
+		if d <= 1 {
+			return d
+		}
+		return d/2 + time.Duration(rand.Int63n(int64(d-d/2)))
+	}
+}
+
+// SetRetryPolicy retries ErrBadConn at most maxRetries times, waiting
+// by backoff before every retry, like Jitter(ExpBackoff(...)) to spare a
+// restarting server. It replaces the retry predicate and delay.
+func (db *DB) SetRetryPolicy(maxRetries int, backoff RetryDelay) {
+	db.SetRetryPredicate(RetryBadConn(maxRetries + 1))
+	db.SetRetryDelay(backoff)
+}
+
+// SetRetryPredicate replaces the retry policy of Ping, Command, Execute
+// and Begin, nil disables retries.
+func (db *DB) SetRetryPredicate(f RetryPredicate) {
+	db.Lock()
+	if f == nil {
+		f = func(error, int) bool { return false }
+	}
+	db.retry = f
+	db.Unlock()
+}
+
+// SetRetrySent false stops the retries of a statement once its command
+// was sent, it may have run and would run twice, only the failures before,
+// like a dead conn taken from the pool, are passed to the retry predicate.
+// It is on by default, for the writes which are not idempotent turn it off.
+func (db *DB) SetRetrySent(on bool) {
+	db.Lock()
+	db.noRetrySent = !on
+	db.Unlock()
+}
+
+// SetRetryDelay waits by f before every retry allowed by the retry
+// predicate, nil retries at once, the default. A delay past the deadline
+// of the context is not waited, the last error is returned.
+func (db *DB) SetRetryDelay(f RetryDelay) {
+	db.Lock()
+	db.retryDelay = f
+	db.Unlock()
+}
+
+// retryWait waits the retry delay of attempt after the failure err, or
+// until ctx is done. It returns err at once, no more retries, if the
+// deadline of ctx is before the end of the delay.
+func (db *DB) retryWait(ctx context.Context, attempt int, err error) error {
+	db.Lock()
+	f := db.retryDelay
+	db.Unlock()
+
+	var d time.Duration
+	if f != nil {
+		d = f(attempt)
+	}
+	if d <= 0 {
+		return nil
+	} else if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		return err
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (db *DB) shouldRetry(err error, attempt int, sent bool) bool {
+	db.Lock()
+	f := db.retry
+	noRetrySent := db.noRetrySent
+	db.Unlock()
+
+	if sent && noRetrySent {
+		return false
+	} else if f == nil {
+		f = DefaultRetryPredicate
+	}
+	return f(err, attempt)
+}
+
+// withRetry runs f on a pooled connection, and again on another one
+// while the retry predicate allows.
+func (db *DB) withRetry(f func(co *Conn) error) error {
+	return db.withPriorityRetry(context.Background(), 0, f)
+}
+
+// withPriorityRetry is withRetry waiting for a conn with prio, or until
+// ctx is done.
+func (db *DB) withPriorityRetry(ctx context.Context, prio int, f func(co *Conn) error) error {
+	for attempt := 1; ; attempt++ {
+		sent := false
+		co, err := db.popConn(ctx, prio)
+		if err == nil && ctx.Err() != nil {
+			//handed over just when done, unused
+			db.PushConn(co, nil)
+			return ctx.Err()
+		} else if err == nil {
+			err = co.guard(f)
+			sent = co.CommandSent()
+			db.PushConn(co, err)
+		}
+
+		if err == nil || !db.shouldRetry(err, attempt, sent) {
+			return err
+		}
+		db.countRetry()
+		if err = db.retryWait(ctx, attempt, err); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	. "github.com/siddontang/mixer/mysql"
+)
+
+var ErrNoRow = errors.New("no current row, Next was not called or returned false")
+
+// Rows is a resultset read from the conn row by row instead of buffered,
+// see DB.QueryStream and Conn.QueryStream. The conn can not be used for
+// anything else until the rows are exhausted or closed. Rows is not safe
+// for concurrent use.
+type Rows struct {
+	c *Conn
+
+	//the conn is pushed back when done, nil for Conn.QueryStream
+	db *DB
+
+	//prepared for the args, closed when done
+	stmt   *Stmt
+	binary bool
+
+	//the columns as read, rows may rewrite the ones of row, see transcodeRows
+	fields []*Field
+
+	//the current row only
+	row *Result
+
+	hasRow bool
+	done   bool
+	err    error
+}
+
+// QueryStream executes query like Execute, but returns after the columns
+// are read, the rows are read by Rows.Next. A statement which returns no
+// rows returns Rows with no columns. The query timeout bounds the wait
+// for the columns, not the iteration, and the rows are not accounted as
+// buffered result memory.
+func (c *Conn) QueryStream(query string, args ...interface{}) (_ *Rows, err error) {
+	defer c.recoverPanic(&err)
+
+	r := &Rows{c: c, binary: len(args) > 0}
+
+	var meta *[]*Field
+	if r.binary {
+		if r.stmt, err = c.Prepare(query); err != nil {
+			return nil, err
+		}
+		meta = &r.stmt.fields
+	}
+
+	if r.row, err = r.start(query, args, meta); err != nil {
+		if r.stmt != nil {
+			r.stmt.Close()
+		}
+		return nil, err
+	}
+
+	if r.row.Resultset == nil {
+		r.finish(nil)
+		return r, nil
+	}
+	r.fields = r.row.Fields
+	if c.transcode {
+		r.row.Fields = cloneFields(r.fields)
+	}
+	return r, nil
+}
+
+// start sends the query and reads the columns
+func (r *Rows) start(query string, args []interface{}, meta *[]*Field) (*Result, error) {
+	r.c.armTimeout()
+	defer r.c.disarmTimeout()
+
+	var err error
+	if r.stmt != nil {
+		err = r.stmt.write(args...)
+	} else {
+		err = r.c.writeCommandStr(COM_QUERY, query)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return r.c.readResultHeader(meta)
+}
+
+// QueryStream is Query returning the rows as they are read from the
+// server, so a resultset larger than the memory can be read. The conn is
+// checked out until the rows are exhausted or closed, Close must be called
+// if Next may not have returned false. The query is retried as Query
+// until the columns are read, the rows are never retried.
+func (db *DB) QueryStream(query string, args ...interface{}) (*Rows, error) {
+	var r *Rows
+	err := db.observe(StatementQuery, func() error {
+		for attempt := 1; ; attempt++ {
+			sent := false
+			co, err := db.PopConn()
+			if err == nil {
+				if r, err = co.QueryStream(query, args...); err == nil {
+					r.db = db
+					if r.done {
+						//no rows, done before the pool was set
+						db.PushConn(co, nil)
+					}
+					return nil
+				}
+				sent = co.CommandSent()
+				db.PushConn(co, err)
+			}
+
+			if !db.shouldRetry(err, attempt, sent) {
+				return err
+			}
+			db.countRetry()
+			if err = db.retryWait(context.Background(), attempt, err); err != nil {
+				return err
+			}
+		}
+	})
+	return r, err
+}
+
+// Fields returns the columns of the rows, none for a statement which
+// returns no rows.
+func (r *Rows) Fields() []*Field {
+	return r.fields
+}
+
+// Columns returns the names of the columns.
+func (r *Rows) Columns() []string {
+	names := make([]string, len(r.fields))
+	for i, f := range r.fields {
+		names[i] = string(f.Name)
+	}
+	return names
+}
+
+// Next reads the next row for Scan, it returns false when the rows are
+// exhausted or failed, see Err. The conn is released then.
+func (r *Rows) Next() bool {
+	r.hasRow = false
+	if r.done {
+		return false
+	}
+
+	if err := r.next(); err != nil {
+		r.finish(err)
+		return false
+	}
+	return r.hasRow
+}
+
+func (r *Rows) next() (err error) {
+	defer r.c.recoverPanic(&err)
+
+	data, err := r.c.readPacket()
+	if err != nil {
+		return err
+	}
+
+	if data[0] == ERR_HEADER {
+		return r.c.handleErrorPacket(data)
+	} else if r.c.isEOFPacket(data) {
+		if r.c.capability&CLIENT_PROTOCOL_41 > 0 {
+			r.c.status = binary.LittleEndian.Uint16(data[3:])
+		}
+		r.finish(nil)
+		return nil
+	}
+
+	if err := r.parse(data); err != nil {
+		//the rest is drained, so the conn can be used again
+		return r.c.drainRows(err)
+	}
+	r.hasRow = true
+	return nil
+}
+
+// parse decodes data into the current row as readResultRows does
+func (r *Rows) parse(data []byte) error {
+	row := r.row
+	row.RowDatas = append(row.RowDatas[:0], data)
+
+	if r.c.transcode {
+		copy(row.Fields, cloneFields(r.fields))
+		if err := r.c.transcodeRows(row, r.binary); err != nil {
+			return err
+		}
+	}
+
+	values, err := row.RowDatas[0].Parse(row.Fields, r.binary)
+	if err != nil {
+		return err
+	}
+	row.Values = append(row.Values[:0], values)
+
+	if r.c.converter != nil {
+		return r.c.convertValues(row, r.binary)
+	}
+	return nil
+}
+
+// Scan copies the columns of the current row into dest, as
+// Resultset.ScanRow does.
+func (r *Rows) Scan(dest ...interface{}) error {
+	if !r.hasRow {
+		return ErrNoRow
+	}
+	return r.row.ScanRow(0, dest...)
+}
+
+// Values returns the columns of the current row, as Resultset.RowValues
+// does, nil if there is none.
+func (r *Rows) Values() []interface{} {
+	if !r.hasRow {
+		return nil
+	}
+	return r.row.RowValues(0)
+}
+
+// Err returns the error which ended the iteration, nil if the rows were
+// exhausted or closed.
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Close drains the rows not read yet, so the conn can be used again, and
+// releases it. It is a no-op once the rows are done.
+func (r *Rows) Close() error {
+	r.hasRow = false
+	if r.done {
+		return nil
+	}
+
+	err := r.c.guard(func(c *Conn) error {
+		return c.drainRows(nil)
+	})
+	r.release(err)
+	return err
+}
+
+// finish ends the iteration with err, nil for the end of the rows
+func (r *Rows) finish(err error) {
+	if r.done {
+		return
+	}
+	if err == nil {
+		//of a multi statement, see SetMultiStatements
+		err = r.c.discardMoreResults()
+	}
+	r.err = err
+	r.release(err)
+}
+
+// release closes the statement and gives the conn back to the pool
+func (r *Rows) release(err error) {
+	r.done = true
+
+	if r.stmt != nil && r.c.pkgErr == nil {
+		if e := r.stmt.Close(); err == nil {
+			err = e
+		}
+	}
+	if r.db != nil {
+		r.db.PushConn(r.c, err)
+	}
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"fmt"
+	. "github.com/siddontang/mixer/mysql"
+	"testing"
+)
+
+func TestDB_QueryStream(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	rows := make([][]string, 1000)
+	for i := range rows {
+		rows[i] = []string{fmt.Sprint(i), fmt.Sprintf("name%d", i)}
+	}
+	s.onQuery("select id, name from t").rows([]string{"id", "name"}, rows...)
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	defer db.Close()
+
+	r, err := db.QueryStream("select id, name from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cols := r.Columns(); len(cols) != 2 || cols[0] != "id" || cols[1] != "name" {
+		t.Fatal(cols)
+	}
+
+	var id, name string
+	if err := r.Scan(&id, &name); err != ErrNoRow {
+		t.Fatal(err)
+	}
+
+	n := 0
+	for r.Next() {
+		if err := r.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		} else if id != fmt.Sprint(n) || name != fmt.Sprintf("name%d", n) {
+			t.Fatal(n, id, name)
+		}
+		n++
+	}
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	} else if n != len(rows) {
+		t.Fatal(n)
+	}
+
+	//released when exhausted
+	if st := db.Stats(); st.InUse != 0 || st.IdleConns != 1 {
+		t.Fatalf("%+v", st)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDB_QueryStreamClose(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	rows := make([][]string, 100)
+	for i := range rows {
+		rows[i] = []string{fmt.Sprint(i)}
+	}
+	s.onQuery("select id from t").rows([]string{"id"}, rows...)
+	s.onQuery("select 1").rows([]string{"1"}, []string{"1"})
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	defer db.Close()
+
+	r, err := db.QueryStream("select id from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Next() || !r.Next() {
+		t.Fatal(r.Err())
+	}
+	if st := db.Stats(); st.InUse != 1 {
+		t.Fatalf("%+v", st)
+	}
+
+	//the rest is drained, the conn is reused
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Next() {
+		t.Fatal("next after close")
+	}
+	if _, err := db.Query("select 1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, dials, _ := s.stats(); dials != 1 {
+		t.Fatal(dials)
+	}
+}
+
+func TestDB_QueryStreamArgs(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	fields := []*Field{
+		{Name: []byte("id"), Charset: uint16(BINARY_COLLATION_ID), Type: MYSQL_TYPE_LONGLONG},
+		{Name: []byte("name"), Charset: uint16(DEFAULT_COLLATION_ID), Type: MYSQL_TYPE_VAR_STRING},
+	}
+	query := "select id, name from t where id > ?"
+	s.on(COM_STMT_EXECUTE, query).resultset(fields, true,
+		[][]byte{Uint64ToBytes(1), []byte("a")}, [][]byte{Uint64ToBytes(2), nil})
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	defer db.Close()
+
+	r, err := db.QueryStream(query, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []int64
+	var names []*string
+	for r.Next() {
+		var id int64
+		var name *string
+		if err := r.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		names = append(names, name)
+	}
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 2 || *names[0] != "a" || names[1] != nil {
+		t.Fatal(ids, names)
+	}
+
+	//the statement is closed with the rows
+	waitServerStmts(t, s, 0)
+}
+
+func TestDB_QueryStreamBroken(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	rows := make([][]string, 10)
+	for i := range rows {
+		rows[i] = []string{fmt.Sprint(i)}
+	}
+	s.onQuery("select id from t").disconnectAfter(5).rows([]string{"id"}, rows...)
+	s.onQuery("update t set a = 1").ok()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	defer db.Close()
+
+	r, err := db.QueryStream("select id from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for r.Next() {
+		n++
+	}
+	//the column count, definition and eof, then 2 rows
+	if n != 2 || !IsConnBroken(r.Err()) {
+		t.Fatal(n, r.Err())
+	}
+	if st := db.Stats(); st.OpenConns != 0 {
+		t.Fatalf("%+v", st)
+	}
+
+	//no rows, released at once
+	r, err = db.QueryStream("update t set a = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Columns()) != 0 || r.Next() || r.Err() != nil {
+		t.Fatal(r.Columns(), r.Err())
+	}
+	if st := db.Stats(); st.InUse != 0 || st.IdleConns != 1 {
+		t.Fatalf("%+v", st)
+	}
+}
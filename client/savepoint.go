@@ -0,0 +1,59 @@
+package client
+
+import (
+	"fmt"
+	. "github.com/siddontang/mixer/mysql"
+)
+
+// Savepoint sets the savepoint name in the transaction of the conn, like
+// for a nested transaction, it returns ErrTxDone if no transaction is
+// begun. name must be an unquoted identifier of letters, digits, _ and $,
+// not only digits.
+func (c *Conn) Savepoint(name string) error {
+	return c.savepointCommand("savepoint", name)
+}
+
+// RollbackTo rolls back the statements after the savepoint name and keeps
+// the transaction and the savepoint, see Savepoint.
+func (c *Conn) RollbackTo(name string) error {
+	return c.savepointCommand("rollback to savepoint", name)
+}
+
+// ReleaseSavepoint removes the savepoint name without rolling back, see
+// Savepoint.
+func (c *Conn) ReleaseSavepoint(name string) error {
+	return c.savepointCommand("release savepoint", name)
+}
+
+func (c *Conn) savepointCommand(command string, name string) (err error) {
+	defer c.recoverPanic(&err)
+
+	if !c.IsInTransaction() {
+		return ErrTxDone
+	} else if !isSavepointName(name) {
+		return fmt.Errorf("invalid savepoint name %q", name)
+	}
+
+	_, err = c.exec(fmt.Sprintf("%s %s", command, name))
+	return err
+}
+
+// isSavepointName checks name is an identifier needing no quotes, so
+// nothing can be injected by it
+func isSavepointName(name string) bool {
+	if len(name) == 0 || len(name) > 64 {
+		return false
+	}
+
+	digits := true
+	for i := 0; i < len(name); i++ {
+		switch b := name[i]; {
+		case b >= '0' && b <= '9':
+		case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b == '_', b == '$':
+			digits = false
+		default:
+			return false
+		}
+	}
+	return !digits
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	. "github.com/siddontang/mixer/mysql"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConn_Savepoint(t *testing.T) {
+	//a table of committed rows, the rows of a transaction are pending and
+	//a savepoint marks how many
+	var lock sync.Mutex
+	var rows []int
+	var pending []int
+	savepoints := make(map[string]int)
+	s := newFakeServer(func(c *fakeServerConn, query string) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		var v int
+		var name string
+		switch {
+		case fmtScan(query, "insert into t values (%d)", &v):
+			pending = append(pending, v)
+		case fmtScan(query, "savepoint %s", &name):
+			savepoints[name] = len(pending)
+		case fmtScan(query, "rollback to savepoint %s", &name):
+			pending = pending[:savepoints[name]]
+		case fmtScan(query, "release savepoint %s", &name):
+			delete(savepoints, name)
+		case query == "commit":
+			rows = append(rows, pending...)
+			pending = nil
+		case query == "select * from t":
+			values := make([][]string, len(rows))
+			for i, v := range rows {
+				values[i] = []string{strconv.Itoa(v)}
+			}
+			return c.writeResultset([]string{"id"}, values)
+		}
+		return c.exec(query)
+	})
+	defer s.Close()
+
+	db := s.openDB("mixer")
+	db.SetMaxIdleConnNum(1)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Close()
+
+	if _, err := tx.Execute("insert into t values (1)"); err != nil {
+		t.Fatal(err)
+	} else if err := tx.Savepoint("sp1"); err != nil {
+		t.Fatal(err)
+	} else if _, err := tx.Execute("insert into t values (2)"); err != nil {
+		t.Fatal(err)
+	} else if err := tx.RollbackTo("sp1"); err != nil {
+		t.Fatal(err)
+	} else if err := tx.ReleaseSavepoint("sp1"); err != nil {
+		t.Fatal(err)
+	} else if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if r, err := db.Query("select * from t"); err != nil {
+		t.Fatal(err)
+	} else if r.RowNumber() != 1 {
+		t.Fatal(r.RowNumber())
+	} else if v, _ := r.GetInt(0, 0); v != 1 {
+		t.Fatal(v)
+	}
+
+	//done
+	if err := tx.Savepoint("sp2"); err != ErrTxDone {
+		t.Fatal(err)
+	} else if err := tx.RollbackTo("sp1"); err != ErrTxDone {
+		t.Fatal(err)
+	}
+	if n := countQueries(s, "savepoint sp2"); n != 0 {
+		t.Fatal(n)
+	}
+}
+
+func TestConn_SavepointName(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Close()
+
+	for _, name := range []string{"", "123", "sp 1", "sp1; drop table t", "`sp1`", "sp-1", strings.Repeat("s", 65)} {
+		if err := tx.Savepoint(name); err == nil {
+			t.Fatal(name)
+		}
+	}
+	for _, name := range []string{"sp1", "_x", "$a", "1a", "SP_2"} {
+		if err := tx.Savepoint(name); err != nil {
+			t.Fatal(name, err)
+		}
+	}
+	_, _, queries := s.stats()
+	n := 0
+	for _, q := range queries {
+		if strings.HasPrefix(q, "savepoint ") {
+			n++
+		}
+	}
+	if n != 5 {
+		t.Fatal(queries)
+	}
+}
@@ -0,0 +1,181 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/siddontang/mixer/mysql"
+	"strings"
+)
+
+var ErrMultiShardTx = errors.New("transaction can not write to more than one shard")
+
+// txConn is a connection with a begun transaction.
+type txConn interface {
+	Execute(command string, args ...interface{}) (*Result, error)
+	Commit() error
+	Rollback() error
+	Close()
+}
+
+func beginTxConn(db *DB) (txConn, error) {
+	return db.Begin()
+}
+
+type txParticipant struct {
+	db *DB
+	co txConn
+}
+
+// ShardTx is a best-effort transaction across shards, a real transaction is
+// begun on a shard when it is written first. Commit commits the shards one
+// by one in the order they were written, so a failure leaves the shards
+// before it committed, the caller must reconcile them by the returned
+// ShardTxError. A transaction touching one shard is a normal transaction.
+type ShardTx struct {
+	r *ConsistentRouter
+
+	participants []*txParticipant
+	done         bool
+
+	begin func(db *DB) (txConn, error)
+}
+
+// ShardTxError reports which shards committed and which failed.
+type ShardTxError struct {
+	Committed []*DB
+	//shards failed to commit, and rolled back shards after the failure
+	Failed     []*DB
+	RolledBack []*DB
+
+	//error of the failed shard
+	Err error
+}
+
+func (e *ShardTxError) Error() string {
+	addrs := func(dbs []*DB) string {
+		s := make([]string, len(dbs))
+		for i, db := range dbs {
+			s[i] = db.Addr()
+		}
+		return strings.Join(s, ",")
+	}
+
+	return fmt.Sprintf("shard tx commit error %v, committed [%s], failed [%s], rolled back [%s]",
+		e.Err, addrs(e.Committed), addrs(e.Failed), addrs(e.RolledBack))
+}
+
+// SetSingleShardTx makes transactions refuse writing to a second shard
+// with ErrMultiShardTx.
+func (r *ConsistentRouter) SetSingleShardTx(single bool) {
+	r.singleShardTx = single
+}
+
+// Begin starts a shard transaction, no backend is touched until written.
+func (r *ConsistentRouter) Begin() *ShardTx {
+	return &ShardTx{r: r, begin: beginTxConn}
+}
+
+func (tx *ShardTx) participant(db *DB) (*txParticipant, error) {
+	for _, p := range tx.participants {
+		if p.db == db {
+			return p, nil
+		}
+	}
+
+	if len(tx.participants) > 0 && tx.r.singleShardTx {
+		return nil, ErrMultiShardTx
+	}
+
+	co, err := tx.begin(db)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &txParticipant{db, co}
+	tx.participants = append(tx.participants, p)
+	return p, nil
+}
+
+// Execute executes command in the transaction of the shard owning key.
+func (tx *ShardTx) Execute(key string, command string, args ...interface{}) (*Result, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+
+	p, err := tx.participant(tx.r.Route(key))
+	if err != nil {
+		return nil, err
+	}
+
+	return p.co.Execute(command, args...)
+}
+
+// Shards returns the shards written in the transaction.
+func (tx *ShardTx) Shards() []*DB {
+	dbs := make([]*DB, len(tx.participants))
+	for i, p := range tx.participants {
+		dbs[i] = p.db
+	}
+	return dbs
+}
+
+// Commit commits the shards in the written order, and rolls back the
+// rest if one failed, a *ShardTxError is returned for the failure of
+// a multi-shard transaction.
+func (tx *ShardTx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+
+	defer tx.close()
+
+	if len(tx.participants) == 1 {
+		return tx.participants[0].co.Commit()
+	}
+
+	var committed []*DB
+	var e *ShardTxError
+	for _, p := range tx.participants {
+		if e != nil {
+			if err := p.co.Rollback(); err != nil {
+				e.Failed = append(e.Failed, p.db)
+			} else {
+				e.RolledBack = append(e.RolledBack, p.db)
+			}
+		} else if err := p.co.Commit(); err != nil {
+			e = &ShardTxError{Committed: committed, Failed: []*DB{p.db}, Err: err}
+		} else {
+			committed = append(committed, p.db)
+		}
+	}
+
+	if e != nil {
+		return e
+	}
+	return nil
+}
+
+// Rollback rolls back all shards and returns the first error.
+func (tx *ShardTx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+
+	defer tx.close()
+
+	var err error
+	for _, p := range tx.participants {
+		if e := p.co.Rollback(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (tx *ShardTx) close() {
+	for _, p := range tx.participants {
+		p.co.Close()
+	}
+}
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/siddontang/mixer/mysql"
+	"testing"
+)
+
+// fakeTxConn records the calls of a shard transaction
+type fakeTxConn struct {
+	db    *DB
+	log   *[]string
+	fails map[string]error
+}
+
+func (c *fakeTxConn) call(op string) error {
+	*c.log = append(*c.log, fmt.Sprintf("%s %s", c.db.Addr(), op))
+	return c.fails[op]
+}
+
+func (c *fakeTxConn) Execute(command string, args ...interface{}) (*Result, error) {
+	return &Result{}, c.call(command)
+}
+
+func (c *fakeTxConn) Commit() error   { return c.call("commit") }
+func (c *fakeTxConn) Rollback() error { return c.call("rollback") }
+func (c *fakeTxConn) Close()          { c.call("close") }
+
+func newTestShardTx(t *testing.T, fails map[string]map[string]error) (*ConsistentRouter, func() *ShardTx, *[]string) {
+	r := newTestShardRouter(t, 2)
+
+	log := new([]string)
+	begin := func() *ShardTx {
+		tx := r.Begin()
+		tx.begin = func(db *DB) (txConn, error) {
+			*log = append(*log, db.Addr()+" begin")
+			return &fakeTxConn{db, log, fails[db.Addr()]}, nil
+		}
+		return tx
+	}
+
+	return r, begin, log
+}
+
+func newTestShardRouter(t *testing.T, n int) *ConsistentRouter {
+	shards := make([]*DB, n)
+	for i := range shards {
+		shards[i], _ = Open(fmt.Sprintf("127.0.0.1:%d", 3306+i), "root", "", "mixer")
+	}
+
+	r, err := NewConsistentRouter(shards, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// keysOfShards returns a key owned by every shard
+func keysOfShards(r *ConsistentRouter) []string {
+	keys := make([]string, len(r.shards))
+	for i, found := 0, 0; found < len(keys); i++ {
+		key := fmt.Sprintf("key%d", i)
+		if idx := r.routeIndex(key); keys[idx] == "" {
+			keys[idx] = key
+			found++
+		}
+	}
+	return keys
+}
+
+func checkLog(t *testing.T, log *[]string, expect ...string) {
+	if fmt.Sprint(*log) != fmt.Sprint(expect) {
+		t.Fatal(*log)
+	}
+	*log = (*log)[0:0]
+}
+
+func TestShardTx_SingleShard(t *testing.T) {
+	r, begin, log := newTestShardTx(t, nil)
+	keys := keysOfShards(r)
+
+	tx := begin()
+	for _, sql := range []string{"insert 1", "insert 2"} {
+		if _, err := tx.Execute(keys[0], sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkLog(t, log, "127.0.0.1:3306 begin", "127.0.0.1:3306 insert 1", "127.0.0.1:3306 insert 2",
+		"127.0.0.1:3306 commit", "127.0.0.1:3306 close")
+
+	if err := tx.Commit(); err != ErrTxDone {
+		t.Fatal(err)
+	}
+}
+
+func TestShardTx_TwoShards(t *testing.T) {
+	r, begin, log := newTestShardTx(t, nil)
+	keys := keysOfShards(r)
+
+	tx := begin()
+	tx.Execute(keys[1], "insert 1")
+	tx.Execute(keys[0], "insert 2")
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkLog(t, log, "127.0.0.1:3307 begin", "127.0.0.1:3307 insert 1",
+		"127.0.0.1:3306 begin", "127.0.0.1:3306 insert 2",
+		"127.0.0.1:3307 commit", "127.0.0.1:3306 commit",
+		"127.0.0.1:3307 close", "127.0.0.1:3306 close")
+
+	tx = begin()
+	tx.Execute(keys[0], "insert 3")
+	tx.Execute(keys[1], "insert 4")
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkLog(t, log, "127.0.0.1:3306 begin", "127.0.0.1:3306 insert 3",
+		"127.0.0.1:3307 begin", "127.0.0.1:3307 insert 4",
+		"127.0.0.1:3306 rollback", "127.0.0.1:3307 rollback",
+		"127.0.0.1:3306 close", "127.0.0.1:3307 close")
+
+	r.SetSingleShardTx(true)
+	tx = begin()
+	tx.Execute(keys[0], "insert 5")
+	if _, err := tx.Execute(keys[1], "insert 6"); err != ErrMultiShardTx {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+}
+
+func TestShardTx_CommitFailure(t *testing.T) {
+	errCommit := errors.New("commit failed")
+
+	r := newTestShardRouter(t, 3)
+	keys := keysOfShards(r)
+
+	log := new([]string)
+	tx := r.Begin()
+	tx.begin = func(db *DB) (txConn, error) {
+		var fails map[string]error
+		if db == r.shards[1] {
+			fails = map[string]error{"commit": errCommit}
+		}
+		return &fakeTxConn{db, log, fails}, nil
+	}
+
+	for _, key := range keys {
+		tx.Execute(key, "insert")
+	}
+
+	err := tx.Commit()
+	e, ok := err.(*ShardTxError)
+	if !ok {
+		t.Fatal(err)
+	}
+
+	if e.Err != errCommit {
+		t.Fatal(e.Err)
+	} else if len(e.Committed) != 1 || e.Committed[0] != r.shards[0] {
+		t.Fatal(e)
+	} else if len(e.Failed) != 1 || e.Failed[0] != r.shards[1] {
+		t.Fatal(e)
+	} else if len(e.RolledBack) != 1 || e.RolledBack[0] != r.shards[2] {
+		t.Fatal(e)
+	}
+}
@@ -0,0 +1,184 @@
+//go:build soak
+// +build soak
+
+package client
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var soakDuration = flag.Duration("soak.duration", 5*time.Second, "duration of every soak run")
+
+// safeRetryPredicate only retries errors which guarantee nothing was sent,
+// so non-idempotent writes are never applied twice
+func safeRetryPredicate(err error, attempt int) bool {
+	e, ok := err.(*FaultError)
+	return ok && (e.Point == FaultDial || e.Point == FaultHandshake) && attempt <= maxBadConnRetries
+}
+
+type soakServer struct {
+	sync.Mutex
+	*fakeServer
+
+	applied map[int64]int
+}
+
+func newSoakServer() *soakServer {
+	s := &soakServer{applied: make(map[int64]int)}
+	s.fakeServer = newFakeServer(func(c *fakeServerConn, query string) error {
+		var id int64
+		if fmtScan(query, "insert into t values (%d)", &id) {
+			s.Lock()
+			s.applied[id]++
+			s.Unlock()
+		}
+		return c.exec(query)
+	})
+	s.onQuery("select 1").rows([]string{"1"}, []string{"1"})
+	return s
+}
+
+// soak runs Exec, Query and transactions with injected faults on 8
+// workers, it returns the ids of the inserts which succeeded
+func soak(db *DB) []int64 {
+	var nextId int64
+	var mu sync.Mutex
+	var done []int64
+
+	deadline := time.Now().Add(*soakDuration)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; time.Now().Before(deadline); i++ {
+				id := atomic.AddInt64(&nextId, 1)
+				insert := fmt.Sprintf("insert into t values (%d)", id)
+
+				var err error
+				switch i % 3 {
+				case 0:
+					_, err = db.Execute(insert)
+				case 1:
+					_, err = db.Query("select 1")
+					id = 0
+				case 2:
+					var co *SqlConn
+					if co, err = db.Begin(); err == nil {
+						if _, err = co.Execute(insert); err == nil {
+							err = co.Commit()
+						}
+						co.Close()
+					}
+				}
+
+				if err == nil && id > 0 {
+					mu.Lock()
+					done = append(done, id)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return done
+}
+
+// checkNoLeaks checks all conns went back to the pool and are closed with it
+func checkNoLeaks(t *testing.T, db *DB, s *soakServer) {
+	if st := db.Stats(); st.InUse != 0 || st.OpenConns != st.IdleConns || st.Waiting != 0 {
+		t.Fatalf("%+v", st)
+	}
+
+	db.Close()
+	waitServerConns(t, s.fakeServer, 0)
+	if st := db.Stats(); st.OpenConns != 0 {
+		t.Fatalf("%+v", st)
+	}
+}
+
+func newSoakDB(s *soakServer, seed int64) (*DB, *FaultPlan) {
+	plan := NewFaultPlan(seed).
+		SetRate(FaultDial, 0.02).
+		SetRate(FaultHandshake, 0.02).
+		SetRate(FaultBeforeWrite, 0.01).
+		SetRate(FaultAfterWrite, 0.01).
+		SetRate(FaultRead, 0.01).
+		SetStall(2 * time.Millisecond)
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(4)
+	db.SetMaxOpenConns(6)
+	db.SetFaultInjector(plan)
+	return db, plan
+}
+
+// TestSoak hammers a pool with injected faults, run it by
+//
+//	go test -tags soak -run TestSoak ./client/ -soak.duration 1m
+func TestSoak(t *testing.T) {
+	s := newSoakServer()
+	defer s.Close()
+
+	db, plan := newSoakDB(s, 1)
+	db.SetRetryPredicate(safeRetryPredicate)
+
+	done := soak(db)
+	checkNoLeaks(t, db, s)
+
+	s.Lock()
+	defer s.Unlock()
+
+	for id, n := range s.applied {
+		if n > 1 {
+			t.Fatalf("insert %d applied %d times", id, n)
+		}
+	}
+	for _, id := range done {
+		if s.applied[id] != 1 {
+			t.Fatalf("succeeded insert %d not applied", id)
+		}
+	}
+
+	t.Logf("%d inserts succeeded, %d applied", len(done), len(s.applied))
+	for p := FaultDial; p <= FaultRead; p++ {
+		if plan.Injected(p) == 0 {
+			t.Fatalf("no %s fault injected", p)
+		}
+		t.Logf("%s faults: %d", p, plan.Injected(p))
+	}
+}
+
+// TestSoak_UnsafeRetry checks the soak catches the double writes of the
+// default predicate, which retries writes broken after written
+func TestSoak_UnsafeRetry(t *testing.T) {
+	s := newSoakServer()
+	defer s.Close()
+
+	db, _ := newSoakDB(s, 2)
+
+	soak(db)
+	checkNoLeaks(t, db, s)
+
+	s.Lock()
+	defer s.Unlock()
+
+	twice := 0
+	for _, n := range s.applied {
+		if n > 1 {
+			twice++
+		}
+	}
+	if twice == 0 {
+		t.Fatal("no insert applied twice")
+	}
+	t.Logf("%d inserts applied twice", twice)
+}
@@ -1,42 +1,134 @@
 package client
 
 import (
+	"container/list"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	. "github.com/siddontang/mixer/mysql"
 	"math"
+	"sync"
+	"sync/atomic"
 )
 
+var ErrStmtClosed = errors.New("statement is closed")
+
+// Stmt is a prepared statement of a conn. A Stmt may be shared by
+// goroutines, its executions and its Close are serialized, but the conn
+// must not be used by anything else meanwhile.
 type Stmt struct {
+	//guards the statement, reprepare changes it
+	sync.Mutex
+
 	conn  *Conn
 	id    uint32
 	query string
 
 	params  int
 	columns int
+
+	//position in conn's statement lru, nil if closed or evicted
+	elem   *list.Element
+	closed bool
+
+	//prepared by warm up and not handed out yet
+	warm bool
+
+	//owned by the conn for Execute with args, see SetStmtCache
+	cached bool
+
+	//1 if marked by CloseLater
+	orphan int32
+
+	//columns of the prepare or the last execute sending them, the server
+	//may skip them, see SetOptionalMetadata
+	fields []*Field
 }
 
 func (s *Stmt) ParamNum() int {
+	s.Lock()
+	defer s.Unlock()
 	return s.params
 }
 
 func (s *Stmt) ColumnNum() int {
+	s.Lock()
+	defer s.Unlock()
 	return s.columns
 }
 
 func (s *Stmt) Execute(args ...interface{}) (*Result, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.execute(args...)
+}
+
+// execute executes the statement, must hold the lock
+func (s *Stmt) execute(args ...interface{}) (_ *Result, err error) {
+	defer s.conn.recoverPanic(&err)
+
+	if s.closed {
+		return nil, ErrStmtClosed
+	}
+
+	if s.elem == nil {
+		//evicted by the conn statement limit, prepare it again
+		if err := s.reprepare(); err != nil {
+			return nil, err
+		}
+	} else {
+		s.conn.stmts.MoveToBack(s.elem)
+	}
+
+	s.conn.armTimeout()
+	defer s.conn.disarmTimeout()
+
 	if err := s.write(args...); err != nil {
 		return nil, err
 	}
 
-	return s.conn.readResult(true)
+	return s.conn.readStmtResult(true, &s.fields)
 }
 
 func (s *Stmt) Close() error {
-	if err := s.conn.writeCommandUint32(COM_STMT_CLOSE, s.id); err != nil {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+
+	if s.elem == nil {
+		return nil
+	}
+
+	return s.conn.closeStmt(s)
+}
+
+// CloseLater marks the statement to be closed by its connection when the
+// connection is pushed back to the pool. Unlike Close it does not write to
+// the connection, so it is safe to call while another goroutine uses it.
+func (s *Stmt) CloseLater() {
+	if atomic.CompareAndSwapInt32(&s.orphan, 0, 1) {
+		atomic.AddInt32(&s.conn.orphanStmts, 1)
+	}
+}
+
+func (s *Stmt) reprepare() error {
+	ns, err := s.conn.Prepare(s.query)
+	if err != nil {
 		return err
 	}
 
+	s.id = ns.id
+	s.params = ns.params
+	s.columns = ns.columns
+	s.fields = ns.fields
+	s.elem = ns.elem
+	s.elem.Value = s
+
 	return nil
 }
 
@@ -117,8 +209,12 @@ func (s *Stmt) write(args ...interface{}) error {
 			paramTypes[i<<1] = MYSQL_TYPE_DOUBLE
 			paramValues[i] = Uint64ToBytes(math.Float64bits(v))
 		case string:
+			b, err := s.conn.encodeParam(v)
+			if err != nil {
+				return err
+			}
 			paramTypes[i<<1] = MYSQL_TYPE_STRING
-			paramValues[i] = append(PutLengthEncodedInt(uint64(len(v))), v...)
+			paramValues[i] = append(PutLengthEncodedInt(uint64(len(b))), b...)
 		case []byte:
 			paramTypes[i<<1] = MYSQL_TYPE_STRING
 			paramValues[i] = append(PutLengthEncodedInt(uint64(len(v))), v...)
@@ -162,7 +258,150 @@ func (s *Stmt) write(args ...interface{}) error {
 	return s.conn.writePacket(data)
 }
 
-func (c *Conn) Prepare(query string) (*Stmt, error) {
+// SetMaxStmts limits the prepared statements held by the connection,
+// the least recently used one is closed before preparing a new one
+// if the limit is reached, 0 means no limit. An evicted statement is
+// prepared again when executed.
+func (c *Conn) SetMaxStmts(n int) {
+	c.maxStmts = n
+}
+
+// StmtNum returns the prepared statements held by the connection.
+func (c *Conn) StmtNum() int {
+	if c.stmts == nil {
+		return 0
+	}
+	return c.stmts.Len()
+}
+
+func (c *Conn) resetStmts() {
+	if c.stmts == nil {
+		c.stmts = list.New()
+		return
+	}
+
+	for e := c.stmts.Front(); e != nil; e = e.Next() {
+		e.Value.(*Stmt).elem = nil
+	}
+	c.stmts.Init()
+}
+
+func (c *Conn) closeStmt(s *Stmt) error {
+	c.stmts.Remove(s.elem)
+	s.elem = nil
+
+	return c.writeCommandUint32(COM_STMT_CLOSE, s.id)
+}
+
+func (c *Conn) evictStmts() error {
+	for c.maxStmts > 0 && c.stmts.Len() >= c.maxStmts {
+		if err := c.closeStmt(c.stmts.Front().Value.(*Stmt)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeOrphanStmts closes the statements marked by CloseLater, all of
+// them are tried even after a failure, and the errors are joined.
+func (c *Conn) closeOrphanStmts() error {
+	if atomic.SwapInt32(&c.orphanStmts, 0) == 0 || c.stmts == nil {
+		return nil
+	}
+
+	var errs []error
+	for e := c.stmts.Front(); e != nil; {
+		next := e.Next()
+		if s := e.Value.(*Stmt); atomic.LoadInt32(&s.orphan) == 1 {
+			errs = append(errs, s.Close())
+		}
+		e = next
+	}
+	return joinErrors(errs)
+}
+
+// findWarmStmt returns a statement of query prepared by warm up.
+func (c *Conn) findWarmStmt(query string) *Stmt {
+	return c.findStmt(query, func(s *Stmt) bool { return s.warm })
+}
+
+// findStmt returns the most recently used statement of query matched.
+func (c *Conn) findStmt(query string, match func(s *Stmt) bool) *Stmt {
+	if c.stmts == nil {
+		return nil
+	}
+
+	for e := c.stmts.Back(); e != nil; e = e.Prev() {
+		if s := e.Value.(*Stmt); s.query == query && match(s) {
+			return s
+		}
+	}
+	return nil
+}
+
+// SetStmtCache keeps the statements prepared by Execute with args for
+// the next Execute of the same query, instead of closing them after every
+// execution. The cached statements are in the statement lru of the
+// connection, so SetMaxStmts bounds them too.
+func (c *Conn) SetStmtCache(on bool) {
+	c.stmtCache = on
+}
+
+// executeCached executes query by its cached statement, a statement whose
+// execution failed is closed, in case the server has dropped it.
+func (c *Conn) executeCached(query string, args []interface{}) (*Result, error) {
+	s := c.findStmt(query, func(s *Stmt) bool { return s.cached })
+	if s == nil {
+		var err error
+		if s, err = c.Prepare(query); err != nil {
+			return nil, err
+		}
+		s.cached = true
+	}
+
+	r, err := s.Execute(args...)
+	if err != nil {
+		s.Close()
+	}
+	return r, err
+}
+
+// prewarm prepares query and keeps it in the statement lru, the next
+// Prepare of the same query takes it without a round trip.
+func (c *Conn) prewarm(query string) error {
+	if c.findWarmStmt(query) != nil {
+		return nil
+	}
+
+	s, err := c.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	s.warm = true
+	return nil
+}
+
+func (c *Conn) Prepare(query string) (_ *Stmt, err error) {
+	defer c.recoverPanic(&err)
+
+	if s := c.findWarmStmt(query); s != nil {
+		s.warm = false
+		c.stmts.MoveToBack(s.elem)
+		return s, nil
+	}
+
+	if c.stmts == nil {
+		c.stmts = list.New()
+	}
+
+	if err := c.evictStmts(); err != nil {
+		return nil, err
+	}
+
+	c.armTimeout()
+	defer c.disarmTimeout()
+
 	if err := c.writeCommandStr(COM_STMT_PREPARE, query); err != nil {
 		return nil, err
 	}
@@ -180,6 +419,7 @@ func (c *Conn) Prepare(query string) (*Stmt, error) {
 
 	s := new(Stmt)
 	s.conn = c
+	s.query = query
 
 	pos := 1
 
@@ -195,20 +435,32 @@ func (c *Conn) Prepare(query string) (*Stmt, error) {
 	s.params = int(binary.LittleEndian.Uint16(data[pos:]))
 	pos += 2
 
+	//reserved
+	pos++
+
 	//warnings
 	//warnings = binary.LittleEndian.Uint16(data[pos:])
+	pos += 2
 
-	if s.params > 0 {
+	//the definitions are skipped by the flag if optional
+	metadata := RESULTSET_METADATA_FULL
+	if c.capability&CLIENT_OPTIONAL_RESULTSET_METADATA > 0 && pos < len(data) {
+		metadata = data[pos]
+	}
+
+	if s.params > 0 && metadata == RESULTSET_METADATA_FULL {
 		if err := s.conn.readUntilEOF(); err != nil {
 			return nil, err
 		}
 	}
 
-	if s.columns > 0 {
-		if err := s.conn.readUntilEOF(); err != nil {
+	if s.columns > 0 && metadata == RESULTSET_METADATA_FULL {
+		if s.fields, err = c.readColumnDefs(); err != nil {
 			return nil, err
 		}
 	}
 
+	s.elem = c.stmts.PushBack(s)
+
 	return s, nil
 }
@@ -1,6 +1,8 @@
 package client
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 )
 
@@ -243,6 +245,38 @@ func TestStmt_Unsigned(t *testing.T) {
 	s.Close()
 }
 
+func TestStmt_Uint64Max(t *testing.T) {
+	c := newTestConn()
+	defer c.Close()
+
+	id := uint64(18446744073709551615)
+
+	if _, err := c.Execute(`insert into mixer_test_stmt (id, str) values (?, "max")`, id); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Execute(`delete from mixer_test_stmt where str = "max"`)
+
+	//binary protocol
+	if r, err := c.Execute(`select id from mixer_test_stmt where id = ?`, id); err != nil {
+		t.Fatal(err)
+	} else if r.RowNumber() != 1 {
+		t.Fatal(r.RowNumber())
+	} else if v, err := r.GetUint(0, 0); err != nil {
+		t.Fatal(err)
+	} else if v != id {
+		t.Fatal(v)
+	}
+
+	//text protocol
+	if r, err := c.Execute(`select id from mixer_test_stmt where str = "max"`); err != nil {
+		t.Fatal(err)
+	} else if v, err := r.GetUint(0, 0); err != nil {
+		t.Fatal(err)
+	} else if v != id {
+		t.Fatal(v)
+	}
+}
+
 func TestStmt_Signed(t *testing.T) {
 	str := `insert into mixer_test_stmt (id, i) values (?, ?)`
 
@@ -306,3 +340,146 @@ func TestStmt_Trans(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestStmt_MaxStmts(t *testing.T) {
+	c := newTestConn()
+	defer c.Close()
+
+	c.SetMaxStmts(2)
+
+	stmts := make([]*Stmt, 3)
+	for i := range stmts {
+		s, err := c.Prepare(fmt.Sprintf("select %d + ?", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stmts[i] = s
+	}
+
+	if n := c.StmtNum(); n != 2 {
+		t.Fatal(n)
+	}
+
+	//first statement is evicted, must be prepared again
+	if r, err := stmts[0].Execute(1); err != nil {
+		t.Fatal(err)
+	} else if v, _ := r.GetInt(0, 0); v != 1 {
+		t.Fatal(v)
+	}
+
+	if n := c.StmtNum(); n != 2 {
+		t.Fatal(n)
+	}
+
+	//second is the least recently used now
+	if stmts[1].elem != nil || stmts[2].elem == nil {
+		t.Fatal("invalid lru order")
+	}
+
+	for _, s := range stmts {
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := c.StmtNum(); n != 0 {
+		t.Fatal(n)
+	}
+
+	if _, err := stmts[0].Execute(1); err != ErrStmtClosed {
+		t.Fatal(err)
+	}
+}
+
+func TestStmt_Reconnect(t *testing.T) {
+	c := newTestConn()
+
+	s, err := c.Prepare("select ? + 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		if err := c.ReConnect(); err != nil {
+			t.Fatal(err)
+		}
+
+		if r, err := s.Execute(i); err != nil {
+			t.Fatal(err)
+		} else if v, _ := r.GetInt(0, 0); v != int64(i+1) {
+			t.Fatal(v)
+		}
+
+		if n := c.StmtNum(); n != 1 {
+			t.Fatal(n)
+		}
+	}
+
+	c.Close()
+	if n := c.StmtNum(); n != 0 {
+		t.Fatal(n)
+	}
+
+	//the statement is released with the closed connection
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConn_SharedStmt(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	defer db.Close()
+
+	co := popTestConn(t, db)
+	defer co.Close()
+
+	stmt, err := co.Prepare("update t set n = n + 1 where id = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//executed by 50 goroutines, and closed by one of them meanwhile
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if _, err := stmt.Execute(i); err != nil && err != ErrStmtClosed {
+					errs <- err
+					return
+				}
+				if stmt.ParamNum() != 1 {
+					errs <- fmt.Errorf("params %d", stmt.ParamNum())
+					return
+				}
+			}
+			if i == 25 {
+				if err := stmt.Close(); err != nil {
+					errs <- err
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if _, err := stmt.Execute(1); err != ErrStmtClosed {
+		t.Fatal(err)
+	} else if n := co.StmtNum(); n != 0 {
+		t.Fatal(n)
+	}
+
+	//the conn is still in sync
+	if _, err := co.Execute("select 1"); err != nil {
+		t.Fatal(err)
+	}
+}
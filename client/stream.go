@@ -0,0 +1,221 @@
+package client
+
+import (
+	"encoding/binary"
+	. "github.com/siddontang/mixer/mysql"
+)
+
+// PacketWriter receives the packets relayed by QueryTo. Like
+// PacketIO.WritePacket, data starts with 4 bytes reserved for the header,
+// so the writer sets the length and its own sequence.
+type PacketWriter interface {
+	WritePacket(data []byte) error
+}
+
+// ResultSummary is the connection state parsed from a relayed response.
+type ResultSummary struct {
+	Status uint16
+
+	//0 for statements without resultset
+	Columns int
+	Rows    uint64
+
+	AffectedRows uint64
+	InsertId     uint64
+}
+
+// QueryTo executes query and relays the response packets to w as they
+// arrive instead of buffering a resultset.
+// An error packet of the server is relayed too and returned as *SqlError,
+// the connection is still usable then. Any other error means the response
+// was not relayed completely: if w failed, the rest of the response is
+// drained from the server, and a connection which can not be drained
+// returns ErrBadConn to be closed.
+func (c *Conn) QueryTo(w PacketWriter, query string) (*ResultSummary, error) {
+	c.armTimeout()
+	defer c.disarmTimeout()
+
+	if err := c.writeCommandStr(COM_QUERY, query); err != nil {
+		return nil, err
+	}
+
+	data, err := c.readPacket()
+	if err != nil {
+		return nil, err
+	}
+
+	s := new(ResultSummary)
+
+	switch data[0] {
+	case OK_HEADER:
+		r, err := c.handleOKPacket(data)
+		if err != nil {
+			return nil, err
+		}
+
+		s.Status = r.Status
+		s.AffectedRows = r.AffectedRows
+		s.InsertId = r.InsertId
+
+		if db, ok := parseUseDB(query); ok {
+			c.db = db
+		}
+		return s, relayPacket(w, data)
+	case ERR_HEADER:
+		return nil, c.relayError(w, data)
+	case LocalInFile_HEADER:
+		return nil, ErrMalformPacket
+	}
+
+	count, metadata, err := c.resultsetMetadata(data)
+	if err != nil {
+		return nil, err
+	} else if metadata == RESULTSET_METADATA_NONE {
+		return nil, c.drainRows(ErrNoMetadata)
+	}
+	s.Columns = int(count)
+
+	//the writer is not told of the metadata flag
+	if err = relayPacket(w, PutLengthEncodedInt(count)); err != nil {
+		return nil, c.drainResultset(err, true)
+	}
+
+	//column definitions and the eof after them
+	for {
+		if data, err = c.readPacket(); err != nil {
+			return nil, err
+		}
+
+		if err = relayPacket(w, data); err != nil {
+			return nil, c.drainResultset(err, !c.isEOFPacket(data))
+		}
+
+		if c.isEOFPacket(data) {
+			break
+		}
+	}
+
+	for {
+		if data, err = c.readPacket(); err != nil {
+			return nil, err
+		}
+
+		if data[0] == ERR_HEADER {
+			return nil, c.relayError(w, data)
+		}
+
+		eof := c.isEOFPacket(data)
+		if eof {
+			if c.capability&CLIENT_PROTOCOL_41 > 0 {
+				s.Status = binary.LittleEndian.Uint16(data[3:])
+				c.status = s.Status
+			}
+		} else {
+			s.Rows++
+		}
+
+		if err = relayPacket(w, data); err != nil {
+			if eof {
+				return nil, err
+			}
+			return nil, c.drainRows(err)
+		}
+
+		if eof {
+			return s, nil
+		}
+	}
+}
+
+// rowWriter is a PacketWriter parsing a relayed text resultset, it calls
+// fields with the column definitions, then row with the cells of every row,
+// nil for NULL. An ok or error packet is left to the result of QueryTo.
+type rowWriter struct {
+	fields func(fs []*Field) error
+	row    func(cells [][]byte) error
+
+	columns int
+	fs      []*Field
+	rowsEOF bool
+}
+
+func (w *rowWriter) WritePacket(data []byte) error {
+	data = data[4:]
+
+	switch {
+	case w.columns == 0:
+		if data[0] == OK_HEADER || data[0] == ERR_HEADER {
+			return nil
+		}
+		n, _, _ := LengthEncodedInt(data)
+		w.columns = int(n)
+	case len(w.fs) < w.columns:
+		f, err := FieldData(data).Parse()
+		if err != nil {
+			return err
+		}
+		w.fs = append(w.fs, f)
+
+		if len(w.fs) == w.columns {
+			return w.fields(w.fs)
+		}
+	case !w.rowsEOF:
+		//the eof after the column definitions
+		w.rowsEOF = true
+	default:
+		if data[0] == ERR_HEADER || (data[0] == EOF_HEADER && len(data) < 9) {
+			return nil
+		}
+
+		cells, err := RowData(data).Cells(w.fs, false)
+		if err != nil {
+			return err
+		}
+		return w.row(cells)
+	}
+
+	return nil
+}
+
+func relayPacket(w PacketWriter, data []byte) error {
+	buf := make([]byte, 4+len(data))
+	copy(buf[4:], data)
+	return w.WritePacket(buf)
+}
+
+// relayError relays the error packet and returns the error it carries
+func (c *Conn) relayError(w PacketWriter, data []byte) error {
+	if err := relayPacket(w, data); err != nil {
+		return err
+	}
+	return c.handleErrorPacket(data)
+}
+
+// drainResultset reads the rest of a resultset after the writer failed with
+// err, columns is true if the column definitions are not read through.
+func (c *Conn) drainResultset(err error, columns bool) error {
+	if columns {
+		if e := c.readUntilEOF(); e != nil {
+			return ErrBadConn
+		}
+	}
+	return c.drainRows(err)
+}
+
+func (c *Conn) drainRows(err error) error {
+	for {
+		data, e := c.readPacket()
+		if e != nil {
+			return ErrBadConn
+		}
+
+		if data[0] == ERR_HEADER {
+			return err
+		} else if c.isEOFPacket(data) {
+			if c.capability&CLIENT_PROTOCOL_41 > 0 {
+				c.status = binary.LittleEndian.Uint16(data[3:])
+			}
+			return err
+		}
+	}
+}
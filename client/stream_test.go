@@ -0,0 +1,189 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	. "github.com/siddontang/mixer/mysql"
+	"net"
+	"testing"
+)
+
+// testPacketWriter records the relayed packets with their headers, it fails
+// after failAfter packets if set
+type testPacketWriter struct {
+	buf      bytes.Buffer
+	sequence uint8
+	packets  [][]byte
+
+	failAfter int
+}
+
+var errTestWriter = errors.New("test writer failed")
+
+func (w *testPacketWriter) WritePacket(data []byte) error {
+	if w.failAfter > 0 && len(w.packets) >= w.failAfter {
+		return errTestWriter
+	}
+
+	length := len(data) - 4
+	data[0] = byte(length)
+	data[1] = byte(length >> 8)
+	data[2] = byte(length >> 16)
+	data[3] = w.sequence
+	w.sequence++
+
+	w.buf.Write(data)
+	w.packets = append(w.packets, data[4:])
+	return nil
+}
+
+func testErrPacket(code uint16, msg string) []byte {
+	data := []byte{ERR_HEADER, byte(code), byte(code >> 8), '#'}
+	data = append(data, "HY000"...)
+	return append(data, msg...)
+}
+
+var testStreamField = &Field{Schema: []byte("mixer"), Table: []byte("t"), OrgTable: []byte("t"),
+	Name: []byte("id"), OrgName: []byte("id"), Charset: 63, ColumnLength: 20, Type: MYSQL_TYPE_LONGLONG}
+
+func testResultsetPackets(rows []string, last []byte) [][]byte {
+	eof := []byte{EOF_HEADER, 0, 0, 2, 0}
+
+	packets := [][]byte{{1}, testStreamField.Dump(), eof}
+	for _, row := range rows {
+		packets = append(packets, PutLengthEncodedString([]byte(row)))
+	}
+	return append(packets, last)
+}
+
+// newTestStreamConn returns a conn to a server which answers every command
+// by the next packets of responses
+func newTestStreamConn(t *testing.T, responses ...[][]byte) *Conn {
+	server, cli := net.Pipe()
+
+	c := new(Conn)
+	c.conn = cli
+	c.pkg = NewPacketIO(cli)
+	c.capability = CLIENT_PROTOCOL_41
+
+	go func() {
+		defer server.Close()
+
+		pkg := NewPacketIO(server)
+		for _, packets := range responses {
+			pkg.Sequence = 0
+			if _, err := pkg.ReadPacket(); err != nil {
+				return
+			}
+
+			for _, p := range packets {
+				if err := pkg.WritePacket(append(make([]byte, 4), p...)); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	return c
+}
+
+// encodeTestResult encodes a buffered result like the proxy writes it
+func encodeTestResult(r *Result) []byte {
+	var w testPacketWriter
+	eof := []byte{EOF_HEADER, 0, 0, byte(r.Status), byte(r.Status >> 8)}
+
+	relayPacket(&w, PutLengthEncodedInt(uint64(len(r.Fields))))
+	for _, f := range r.Fields {
+		relayPacket(&w, f.Dump())
+	}
+	relayPacket(&w, eof)
+	for _, row := range r.RowDatas {
+		relayPacket(&w, row)
+	}
+	relayPacket(&w, eof)
+
+	return w.buf.Bytes()
+}
+
+func TestConn_QueryTo(t *testing.T) {
+	packets := testResultsetPackets([]string{"1", "2", "3"}, []byte{EOF_HEADER, 0, 0, 2, 0})
+	c := newTestStreamConn(t, packets, packets)
+	defer c.Close()
+
+	var w testPacketWriter
+	s, err := c.QueryTo(&w, "select id from t")
+	if err != nil {
+		t.Fatal(err)
+	} else if s.Columns != 1 || s.Rows != 3 || s.Status != 2 {
+		t.Fatal(*s)
+	}
+
+	r, err := c.exec("select id from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(w.buf.Bytes(), encodeTestResult(r)) {
+		t.Fatal("relayed packets differ from the buffered result")
+	}
+}
+
+func TestConn_QueryToOKAndError(t *testing.T) {
+	ok := []byte{OK_HEADER, 2, 5, 2, 0, 0, 0}
+	rowsErr := testResultsetPackets([]string{"1"}, testErrPacket(ER_QUERY_INTERRUPTED, "interrupted"))
+	queryErr := testErrPacket(ER_NO_SUCH_TABLE, "no table")
+
+	c := newTestStreamConn(t, [][]byte{ok}, rowsErr, [][]byte{queryErr}, [][]byte{ok})
+	defer c.Close()
+
+	var w testPacketWriter
+	if s, err := c.QueryTo(&w, "delete from t"); err != nil {
+		t.Fatal(err)
+	} else if s.Columns != 0 || s.AffectedRows != 2 || s.InsertId != 5 || !bytes.Equal(w.packets[0], ok) {
+		t.Fatal(*s)
+	}
+
+	//an error in rows is relayed, the conn is still usable
+	w = testPacketWriter{}
+	_, err := c.QueryTo(&w, "select id from t")
+	if e, ok := err.(*SqlError); !ok || e.Code != ER_QUERY_INTERRUPTED {
+		t.Fatal(err)
+	} else if len(w.packets) != 5 || w.packets[4][0] != ERR_HEADER {
+		t.Fatal(len(w.packets))
+	}
+
+	w = testPacketWriter{}
+	_, err = c.QueryTo(&w, "select id from no_table")
+	if e, ok := err.(*SqlError); !ok || e.Code != ER_NO_SUCH_TABLE || len(w.packets) != 1 {
+		t.Fatal(err)
+	}
+
+	if _, err := c.QueryTo(&testPacketWriter{}, "delete from t"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConn_QueryToWriterFailed(t *testing.T) {
+	packets := testResultsetPackets([]string{"1", "2", "3"}, []byte{EOF_HEADER, 0, 0, 2, 0})
+
+	ok := []byte{OK_HEADER, 1, 0, 2, 0, 0, 0}
+
+	//fail in column definitions and in rows
+	for _, failAfter := range []int{2, 4} {
+		c := newTestStreamConn(t, packets, [][]byte{ok})
+
+		w := testPacketWriter{failAfter: failAfter}
+		if _, err := c.QueryTo(&w, "select id from t"); err != errTestWriter {
+			t.Fatal(failAfter, err)
+		}
+
+		//the rest is drained, the next response is read
+		if r, err := c.exec("delete from t"); err != nil {
+			t.Fatal(failAfter, err)
+		} else if r.AffectedRows != 1 {
+			t.Fatal(failAfter, r.AffectedRows)
+		}
+
+		c.Close()
+	}
+}
@@ -0,0 +1,287 @@
+package client
+
+import (
+	"fmt"
+	. "github.com/siddontang/mixer/mysql"
+	"sync/atomic"
+	"time"
+)
+
+// StatementTimeoutError is returned by a statement killed by the statement
+// timeout, Err is the ER_QUERY_INTERRUPTED of the server.
+type StatementTimeoutError struct {
+	After time.Duration
+	Err   *SqlError
+}
+
+func (e *StatementTimeoutError) Error() string {
+	return fmt.Sprintf("statement timeout after %v: %v", e.After, e.Err)
+}
+
+func (e *StatementTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout is true, as of a net.Error.
+func (e *StatementTimeoutError) Timeout() bool {
+	return true
+}
+
+// SetStatementTimeout kills every statement running longer than d by
+// calling kill with the connection id, kill should send KILL QUERY on
+// another connection. The statement returns a *StatementTimeoutError then,
+// and unlike SetQueryTimeout, the connection can be used again. 0 disables
+// the timeout.
+//
+// A statement finishing while its kill is being sent waits for the kill,
+// so the kill can not interrupt the next statement.
+func (c *Conn) SetStatementTimeout(d time.Duration, kill func(connectionId uint32) error) {
+	c.stmtTimeout = d
+	c.kill = kill
+}
+
+func (c *Conn) armWatchdog() {
+	if c.stmtTimeout <= 0 || c.kill == nil {
+		return
+	}
+
+	atomic.StoreInt32(&c.killed, 0)
+
+	done := make(chan struct{})
+	id, kill := c.connectionId, c.kill
+	c.watchdog = time.AfterFunc(c.stmtTimeout, func() {
+		defer close(done)
+
+		atomic.StoreInt32(&c.killed, 1)
+		kill(id)
+	})
+	c.killDone = done
+}
+
+func (c *Conn) disarmWatchdog() {
+	if c.watchdog == nil {
+		return
+	}
+
+	if !c.watchdog.Stop() {
+		<-c.killDone
+	}
+	c.watchdog = nil
+	c.killDone = nil
+}
+
+// timeoutError returns the error of the statement interrupted by e, a
+// *StatementTimeoutError if killed by the watchdog
+func (c *Conn) timeoutError(e *SqlError) error {
+	if e.Code == ER_QUERY_INTERRUPTED && c.watchdog != nil && atomic.LoadInt32(&c.killed) == 1 {
+		return &StatementTimeoutError{c.stmtTimeout, e}
+	}
+	return e
+}
+
+// SetReadTimeout bounds the wait for every packet read, the handshake
+// included, and SetWriteTimeout every packet written, unlike
+// SetQueryTimeout which bounds a whole response. A timed out read returns
+// ErrReadTimeout, like in the middle of a resultset, and a timed out write
+// ErrWriteTimeout, the connection can not be used again then. 0 means no
+// bound.
+func (c *Conn) SetReadTimeout(d time.Duration) {
+	c.readTimeout = d
+}
+
+// SetWriteTimeout bounds every packet written, see SetReadTimeout.
+func (c *Conn) SetWriteTimeout(d time.Duration) {
+	c.writeTimeout = d
+}
+
+// armReadTimeout sets the read deadline of the next packet, not after the
+// deadline of the query timeout, and returns the start of the read
+func (c *Conn) armReadTimeout() time.Time {
+	now := time.Now()
+	if c.readTimeout <= 0 {
+		return now
+	}
+
+	t := now.Add(c.readTimeout)
+	if !c.deadline.IsZero() && c.deadline.Before(t) {
+		t = c.deadline
+	}
+	c.conn.SetReadDeadline(t)
+	c.keepInterrupted()
+	return now
+}
+
+// armWriteTimeout sets the write deadline of the next packet, not after
+// the deadline of the query timeout, and returns the start of the write
+func (c *Conn) armWriteTimeout() time.Time {
+	now := time.Now()
+	if c.writeTimeout <= 0 {
+		return now
+	}
+
+	t := now.Add(c.writeTimeout)
+	if !c.deadline.IsZero() && c.deadline.Before(t) {
+		t = c.deadline
+	}
+	c.conn.SetWriteDeadline(t)
+	c.keepInterrupted()
+	return now
+}
+
+// keepInterrupted restores the past deadline of an interrupt by
+// ExecuteContext racing with a packet deadline
+func (c *Conn) keepInterrupted() {
+	if atomic.LoadInt32(&c.interrupted) == 1 {
+		c.conn.SetDeadline(time.Unix(1, 0))
+	}
+}
+
+// SetStatementTimeout kills the statements of the pool running longer
+// than d, see Conn.SetStatementTimeout. The kills are sent on a conn of
+// db outside the pool, so a full pool does not block them.
+func (db *DB) SetStatementTimeout(d time.Duration) {
+	db.Lock()
+	db.stmtTimeout = d
+	db.Unlock()
+}
+
+// SetReadTimeout bounds every packet read by the conns the pool opens
+// from now on, see Conn.SetReadTimeout.
+func (db *DB) SetReadTimeout(d time.Duration) {
+	db.Lock()
+	db.readTimeout = d
+	db.Unlock()
+}
+
+// SetWriteTimeout bounds every packet written by the conns the pool opens
+// from now on, see Conn.SetWriteTimeout.
+func (db *DB) SetWriteTimeout(d time.Duration) {
+	db.Lock()
+	db.writeTimeout = d
+	db.Unlock()
+}
+
+// SetQueryTimeout bounds every query on a conn of the pool from its next
+// checkout, see Conn.SetQueryTimeout, as a safety net for the callers
+// without a context. A timed out conn is closed instead of pooled, even
+// if pushed back without an error, and its query is killed by KILL QUERY
+// on another conn, as for SetStatementTimeout. 0 means no bound.
+func (db *DB) SetQueryTimeout(d time.Duration) {
+	db.Lock()
+	db.queryTimeout = d
+	db.Unlock()
+}
+
+// connQueryTimeout returns the query timeout of the conns checked out
+func (db *DB) connQueryTimeout() time.Duration {
+	db.Lock()
+	d := db.queryTimeout
+	db.Unlock()
+	return d
+}
+
+func (db *DB) statementTimeout() time.Duration {
+	db.Lock()
+	d := db.stmtTimeout
+	db.Unlock()
+	return d
+}
+
+// ExecuteWithTimeout is Execute with the statement timeout d instead of
+// the one of the pool.
+func (db *DB) ExecuteWithTimeout(d time.Duration, command string, args ...interface{}) (*Result, error) {
+	return db.executeWithTimeout(StatementExec, d, command, args)
+}
+
+// QueryWithTimeout is Query with the statement timeout d instead of the
+// one of the pool.
+func (db *DB) QueryWithTimeout(d time.Duration, query string, args ...interface{}) (*Resultset, error) {
+	return resultsetOf(db.executeWithTimeout(StatementQuery, d, query, args))
+}
+
+func (db *DB) executeWithTimeout(kind StatementKind, d time.Duration, command string, args []interface{}) (*Result, error) {
+	var r *Result
+	err := db.observe(kind, func() error {
+		return db.withRetry(func(c *Conn) error {
+			c.SetStatementTimeout(d, db.killQuery)
+
+			var err error
+			r, err = c.Execute(command, args...)
+			return err
+		})
+	})
+	return r, err
+}
+
+// killTimeout bounds the connect of the kill conn and every kill on it, or
+// the query timeout if shorter, a hung server must not hold the kill lock
+const killTimeout = 3 * time.Second
+
+// killQuery kills the running query of the server thread id on the kill
+// conn, which is connected at the first kill and again after broken
+func (db *DB) killQuery(id uint32) error {
+	d := boundTimeout(db.connQueryTimeout(), killTimeout)
+
+	db.killLock.Lock()
+	defer db.killLock.Unlock()
+
+	//the kill conn of a closed pool would never be closed
+	db.Lock()
+	closed := db.closed
+	db.Unlock()
+	if closed {
+		return ErrDBClosed
+	}
+
+	if db.killConn == nil {
+		co := db.dialConn()
+		co.dialTimeout = boundTimeout(co.dialTimeout, d)
+		co.readTimeout = boundTimeout(co.readTimeout, d)
+		co.writeTimeout = boundTimeout(co.writeTimeout, d)
+		if err := co.Connect(db.addr, db.user, db.password, ""); err != nil {
+			return err
+		}
+		db.killConn = co
+	}
+
+	db.killConn.SetQueryTimeout(d)
+	_, err := db.killConn.exec(fmt.Sprintf("kill query %d", id))
+	if se, ok := err.(*SqlError); ok && se.Code == ER_NO_SUCH_THREAD {
+		//finished and closed meanwhile
+		return nil
+	} else if err != nil && !ok {
+		db.killConn.Close()
+		db.killConn = nil
+	}
+	return err
+}
+
+// boundTimeout returns d, or max if d is 0 or longer
+func boundTimeout(d time.Duration, max time.Duration) time.Duration {
+	if d <= 0 || d > max {
+		return max
+	}
+	return d
+}
+
+// killTimedOut kills the query of a conn timed out by the query timeout,
+// the server would run it to the end though the conn is closed. The kill
+// runs in the background, PushConn does not wait for it.
+func (db *DB) killTimedOut(co *Conn) {
+	db.Lock()
+	closed := db.closed
+	db.Unlock()
+	if closed || co.connectionId == 0 {
+		return
+	}
+	go db.killQuery(co.connectionId)
+}
+
+func (db *DB) closeKillConn() {
+	db.killLock.Lock()
+	if db.killConn != nil {
+		db.killConn.Close()
+		db.killConn = nil
+	}
+	db.killLock.Unlock()
+}
@@ -0,0 +1,271 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	. "github.com/siddontang/mixer/mysql"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countKills returns the kill queries received by s
+func countKills(s *fakeServer) int {
+	_, _, queries := s.stats()
+	n := 0
+	for _, q := range queries {
+		if strings.HasPrefix(q, "kill query") {
+			n++
+		}
+	}
+	return n
+}
+
+// waitKills waits until s received n kill queries, the query timeout kills
+// in the background, and returns the kills received
+func waitKills(s *fakeServer, n int) int {
+	for i := 0; i < 1000 && countKills(s) < n; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	return countKills(s)
+}
+
+func TestDB_StatementTimeout(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	s.onQuery("select sleep(10)").delay(10*time.Second).rows([]string{"sleep"}, []string{"0"})
+	s.onQuery("select 1").rows([]string{"1"}, []string{"1"})
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(2)
+	db.SetStatementTimeout(50 * time.Millisecond)
+	defer db.Close()
+
+	checkTimeout := func(err error, d time.Duration, start time.Time) {
+		e, ok := err.(*StatementTimeoutError)
+		if !ok || e.After != d || e.Err.Code != ER_QUERY_INTERRUPTED || !e.Timeout() {
+			t.Fatal(err)
+		}
+		if elapsed := time.Now().Sub(start); elapsed < d || elapsed > time.Second {
+			t.Fatal(elapsed)
+		}
+	}
+
+	start := time.Now()
+	_, err := db.Query("select sleep(10)")
+	checkTimeout(err, 50*time.Millisecond, start)
+	if n := countKills(s); n != 1 {
+		t.Fatal(n)
+	}
+
+	//fast statements are never killed
+	for i := 0; i < 20; i++ {
+		if _, err = db.Query("select 1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countKills(s); n != 1 {
+		t.Fatal(n)
+	}
+
+	//overridden by call
+	db.SetStatementTimeout(0)
+	start = time.Now()
+	_, err = db.QueryWithTimeout(30*time.Millisecond, "select sleep(10)")
+	checkTimeout(err, 30*time.Millisecond, start)
+
+	//the next user of the conn has the timeout of the pool
+	co := popTestConn(t, db)
+	if co.stmtTimeout != 0 {
+		t.Fatal(co.stmtTimeout)
+	}
+	db.PushConn(co, nil)
+
+	//an interrupted statement not killed by the timeout
+	s.onQuery("select interrupted").err(ER_QUERY_INTERRUPTED, "Query execution was interrupted")
+	db.SetStatementTimeout(time.Second)
+	if _, err = db.Query("select interrupted"); err == nil {
+		t.Fatal("must error")
+	} else if _, ok := err.(*SqlError); !ok {
+		t.Fatal(err)
+	}
+}
+
+func TestConn_StatementTimeoutKillRace(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	s.onQuery("select slow").delay(30*time.Millisecond).rows([]string{"slow"}, []string{"1"})
+
+	c := newFakeConn(t, s)
+	defer c.Close()
+
+	//the kill is slow to reach the server, the statement finishes first
+	var kills, sent int32
+	c.SetStatementTimeout(10*time.Millisecond, func(id uint32) error {
+		atomic.AddInt32(&kills, 1)
+		time.Sleep(50 * time.Millisecond)
+		atomic.StoreInt32(&sent, 1)
+		return nil
+	})
+
+	if _, err := c.Execute("select slow"); err != nil {
+		t.Fatal(err)
+	} else if atomic.LoadInt32(&sent) != 1 {
+		t.Fatal("returned before the kill is sent")
+	}
+
+	if _, err := c.Execute("select 1"); err != nil {
+		t.Fatal(err)
+	} else if n := atomic.LoadInt32(&kills); n != 1 {
+		t.Fatal(n)
+	}
+}
+
+func TestDB_ReadTimeout(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	//stalls in the middle of the resultset
+	s.onQuery("select slow").packets([]byte{1}).delay(time.Second).rows([]string{"a"}, []string{"1"})
+	s.onQuery("select steady").delay(10*time.Millisecond).rows([]string{"a"}, []string{"1"})
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	db.SetReadTimeout(20 * time.Millisecond)
+	defer db.Close()
+
+	start := time.Now()
+	if _, err := db.Execute("select slow"); err != ErrReadTimeout {
+		t.Fatal(err)
+	} else if d := time.Now().Sub(start); d > 500*time.Millisecond {
+		t.Fatal(d)
+	}
+	if st := db.Stats(); st.OpenConns != 0 || st.Failed != 1 {
+		t.Fatalf("%+v", st)
+	}
+
+	if _, err := db.Execute("select steady"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConn_HandshakeReadTimeout(t *testing.T) {
+	db, _ := Open("fake:3306", "root", "", "")
+	db.SetDialer(func(context.Context, string, string) (net.Conn, error) {
+		//a server never greeting
+		c, _ := net.Pipe()
+		return c, nil
+	})
+	db.SetReadTimeout(20 * time.Millisecond)
+	defer db.Close()
+
+	if _, err := db.Execute("select 1"); err != ErrReadTimeout {
+		t.Fatal(err)
+	}
+}
+
+func TestConn_WriteTimeout(t *testing.T) {
+	c, server := net.Pipe()
+	defer server.Close()
+
+	//nothing reads the server side
+	co := &Conn{conn: c, pkg: NewPacketIO(c)}
+	co.SetWriteTimeout(20 * time.Millisecond)
+	defer co.Close()
+
+	if err := co.writeCommandStr(COM_QUERY, "select 1"); err != ErrWriteTimeout {
+		t.Fatal(err)
+	} else if co.pkgErr != ErrWriteTimeout {
+		t.Fatal(co.pkgErr)
+	}
+}
+
+func TestDB_QueryTimeout(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	s.onQuery("select sleep(1)").delay(time.Second).rows([]string{"sleep(1)"}, []string{"0"})
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	db.SetQueryTimeout(100 * time.Millisecond)
+	defer db.Close()
+
+	//the deadline is of every query, not of the conn
+	co := popTestConn(t, db)
+	for i := 0; i < 3; i++ {
+		if _, err := co.Execute("select 1"); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	db.PushConn(co, nil)
+
+	//closed even if pushed back without the error
+	co = popTestConn(t, db)
+	if _, err := co.Execute("select sleep(1)"); err != ErrQueryTimeout {
+		t.Fatal(err)
+	}
+	db.PushConn(co, nil)
+
+	if st := db.Stats(); st.OpenConns != 0 || st.IdleConns != 0 {
+		t.Fatalf("%+v", st)
+	}
+	//the server stops the query of the closed conn
+	if n := waitKills(s, 1); n != 1 {
+		t.Fatal(n)
+	}
+
+	if _, err := db.Execute("select sleep(1)"); err != ErrQueryTimeout {
+		t.Fatal(err)
+	}
+	if _, err := db.Execute("select 1"); err != nil {
+		t.Fatal(err)
+	}
+	//the kill conn included
+	if n := waitKills(s, 2); n != 2 {
+		t.Fatal(n)
+	} else if _, dials, _ := s.stats(); dials != 4 {
+		t.Fatal(dials)
+	}
+}
+
+func TestDB_QueryTimeoutHungKill(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	s.onQuery("select sleep(1)").delay(time.Second).rows([]string{"sleep(1)"}, []string{"0"})
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	db.SetQueryTimeout(100 * time.Millisecond)
+	defer db.Close()
+
+	//the kill hangs on the server, PushConn does not wait for it
+	co := popTestConn(t, db)
+	s.onQuery(fmt.Sprintf("kill query %d", co.ConnectionId())).delay(time.Second).ok()
+	if _, err := co.Execute("select sleep(1)"); err != ErrQueryTimeout {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	db.PushConn(co, nil)
+	if d := time.Now().Sub(start); d > 50*time.Millisecond {
+		t.Fatal(d)
+	}
+
+	//given up after the query timeout, the next kill connects again
+	co = popTestConn(t, db)
+	if _, err := co.Execute("select sleep(1)"); err != ErrQueryTimeout {
+		t.Fatal(err)
+	}
+	db.PushConn(co, nil)
+	if n := waitKills(s, 2); n != 2 {
+		t.Fatal(n)
+	} else if _, dials, _ := s.stats(); dials != 4 {
+		t.Fatal(dials)
+	}
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	. "github.com/siddontang/mixer/mysql"
+	"net"
+)
+
+// ErrNoTLS is returned by a connect with a TLS config to a server which
+// does not advertise CLIENT_SSL, nothing is sent in plaintext then.
+var ErrNoTLS = errors.New("server does not support TLS")
+
+// SetTLSConfig upgrades the next connect to TLS after the initial handshake
+// of the server, so the authentication and all commands are encrypted,
+// nil means plaintext. The server certificate is verified against
+// cfg.ServerName, the host of the addr if empty, unless
+// cfg.InsecureSkipVerify, see SkipVerifyTLSConfig. A server without TLS
+// fails the connect by ErrNoTLS.
+func (c *Conn) SetTLSConfig(cfg *tls.Config) {
+	c.tlsConfig = cfg
+}
+
+// SetTLSConfig upgrades every conn the pool opens from now on to TLS,
+// including the kill conns, see Conn.SetTLSConfig.
+func (db *DB) SetTLSConfig(cfg *tls.Config) {
+	db.Lock()
+	db.tlsConfig = cfg
+	db.Unlock()
+}
+
+// SkipVerifyTLSConfig returns a config encrypting without verifying the
+// server certificate, like for the self-signed certificate of a server
+// in a trusted network. Use a config with RootCAs to verify it.
+func SkipVerifyTLSConfig() *tls.Config {
+	return &tls.Config{InsecureSkipVerify: true}
+}
+
+// upgradeTLS sends the SSL request after the initial handshake and
+// continues the handshake encrypted
+func (c *Conn) upgradeTLS() error {
+	if c.capability&CLIENT_SSL == 0 {
+		return ErrNoTLS
+	}
+
+	//a unix socket has no host, its config needs a server name
+	cfg := c.tlsConfig
+	_, addr := splitNetwork(c.addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil && len(cfg.ServerName) == 0 {
+		cfg = cfg.Clone()
+		cfg.ServerName = host
+	}
+
+	//the handshake response up to the filler, with CLIENT_SSL
+	capability := c.clientCapability()
+	if len(c.db) > 0 {
+		capability |= CLIENT_CONNECT_WITH_DB
+	}
+	data := make([]byte, 4+4+4+1+23)
+	data[4] = byte(capability)
+	data[5] = byte(capability >> 8)
+	data[6] = byte(capability >> 16)
+	data[7] = byte(capability >> 24)
+	data[12] = byte(c.collation)
+
+	if err := c.writePacket(data); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if c.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.dialTimeout)
+		defer cancel()
+	}
+
+	tlsConn := tls.Client(c.conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return err
+	}
+
+	//the sequence goes on over the encrypted conn
+	seq := c.pkg.Sequence
+	c.conn = tlsConn
+	c.pkg = NewPacketIO(tlsConn)
+	c.pkg.Sequence = seq
+	return nil
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testTLSConfig returns the config of a server with a self-signed
+// certificate for the ip host, and the pool trusting it
+func testTLSConfig(t *testing.T, host string) (*tls.Config, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		IPAddresses:  []net.IP{net.ParseIP(host)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, roots
+}
+
+// tlsConns returns the conns of s upgraded to TLS
+func tlsConns(s *fakeServer) int {
+	s.Lock()
+	defer s.Unlock()
+
+	n := 0
+	for _, c := range s.conns {
+		if c.tls {
+			n++
+		}
+	}
+	return n
+}
+
+func TestTLS_VerifyFull(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	var roots *x509.CertPool
+	s.tlsConfig, roots = testTLSConfig(t, "127.0.0.1")
+	s.onQuery("select 1").rows([]string{"1"}, []string{"1"})
+
+	//a failed handshake may block both sides of a pipe, so over a socket
+	addr := s.listen(t)
+	db, _ := Open(addr, "root", "", "test")
+	db.SetMaxIdleConnNum(1)
+	db.SetTLSConfig(&tls.Config{RootCAs: roots})
+	defer db.Close()
+
+	//verified against the host of the addr
+	if r, err := db.Execute("select 1"); err != nil {
+		t.Fatal(err)
+	} else if v, _ := r.GetInt(0, 0); v != 1 {
+		t.Fatal(v)
+	}
+	co := popTestConn(t, db)
+	if co.GetDB() != "test" {
+		t.Fatal(co.GetDB())
+	} else if _, ok := co.conn.(*tls.Conn); !ok {
+		t.Fatal("not tls")
+	}
+	db.PushConn(co, nil)
+	if n := tlsConns(s); n != 1 {
+		t.Fatal(n)
+	}
+
+	//not trusted
+	db2, _ := Open(addr, "root", "", "")
+	db2.SetTLSConfig(&tls.Config{})
+	defer db2.Close()
+	if err := db2.Ping(); err == nil {
+		t.Fatal("untrusted certificate accepted")
+	}
+
+	//another host
+	db2.SetTLSConfig(&tls.Config{RootCAs: roots, ServerName: "other"})
+	if err := db2.Ping(); err == nil {
+		t.Fatal("certificate of another host accepted")
+	}
+}
+
+func TestTLS_SkipVerify(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+	s.tlsConfig, _ = testTLSConfig(t, "127.0.0.1")
+
+	db, err := OpenDSN("root@tcp(" + s.listen(t) + ")/?tls=skip-verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	} else if n := tlsConns(s); n != 1 {
+		t.Fatal(n)
+	}
+}
+
+func TestTLS_NotSupported(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetTLSConfig(SkipVerifyTLSConfig())
+	defer db.Close()
+
+	if err := db.Ping(); err != ErrNoTLS {
+		t.Fatal(err)
+	}
+	if _, _, queries := s.stats(); len(queries) != 0 {
+		t.Fatal(queries)
+	}
+}
@@ -0,0 +1,151 @@
+package client
+
+import (
+	"github.com/siddontang/mixer/hack"
+	. "github.com/siddontang/mixer/mysql"
+	"strings"
+	"sync"
+)
+
+// Encoding converts the text of a mysql charset from and to UTF-8, see
+// RegisterEncoding.
+type Encoding interface {
+	// Decode converts text of the charset to UTF-8.
+	Decode(b []byte) ([]byte, error)
+	// Encode converts UTF-8 text to the charset.
+	Encode(b []byte) ([]byte, error)
+}
+
+var encodings = struct {
+	sync.RWMutex
+	m map[string]Encoding
+}{m: make(map[string]Encoding)}
+
+// RegisterEncoding registers the encoding of a mysql charset, like gbk or
+// latin1, for transcoding, see Conn.SetTranscode. Built with the xtext
+// tag, the client registers the charsets of golang.org/x/text.
+func RegisterEncoding(charset string, e Encoding) {
+	encodings.Lock()
+	encodings.m[strings.ToLower(charset)] = e
+	encodings.Unlock()
+}
+
+// lookupEncoding returns the encoding of charset, nil if it needs no
+// transcoding or is not registered
+func lookupEncoding(charset string) Encoding {
+	switch charset {
+	case "", "utf8", "utf8mb4", "binary", "ascii":
+		return nil
+	}
+
+	encodings.RLock()
+	e := encodings.m[charset]
+	encodings.RUnlock()
+	return e
+}
+
+// collationCharset returns the charset of a collation id, empty if unknown
+func collationCharset(id uint16) string {
+	if id > 0xff {
+		return ""
+	}
+
+	name := Collations[CollationId(id)]
+	if i := strings.IndexByte(name, '_'); i > 0 {
+		return name[:i]
+	}
+	return name
+}
+
+func isTextField(f *Field) bool {
+	if f.Charset == uint16(BINARY_COLLATION_ID) {
+		return false
+	}
+
+	switch f.Type {
+	case MYSQL_TYPE_VARCHAR, MYSQL_TYPE_VAR_STRING, MYSQL_TYPE_STRING,
+		MYSQL_TYPE_ENUM, MYSQL_TYPE_SET, MYSQL_TYPE_TINY_BLOB,
+		MYSQL_TYPE_MEDIUM_BLOB, MYSQL_TYPE_LONG_BLOB, MYSQL_TYPE_BLOB:
+		return true
+	}
+	return false
+}
+
+// SetTranscode converts the text columns of result sets from their charset
+// to UTF-8, and the string parameters of statements from UTF-8 to the
+// connection charset, by the registered encodings. Binary columns, []byte
+// parameters and charsets without encoding are untouched.
+func (c *Conn) SetTranscode(on bool) {
+	c.transcode = on
+}
+
+// SetTranscode makes new conns transcode, see Conn.SetTranscode.
+func (db *DB) SetTranscode(on bool) {
+	db.transcode = on
+}
+
+// transcodeRows converts the text columns of result to UTF-8, the rows
+// and the column charsets are rewritten so they can be forwarded as is
+func (c *Conn) transcodeRows(result *Result, binary bool) error {
+	var encs []Encoding
+	for i, f := range result.Fields {
+		if !isTextField(f) {
+			continue
+		}
+
+		charset := collationCharset(f.Charset)
+		if len(charset) == 0 {
+			charset = c.charset
+		}
+
+		if e := lookupEncoding(charset); e != nil {
+			if encs == nil {
+				encs = make([]Encoding, len(result.Fields))
+			}
+			encs[i] = e
+		}
+	}
+
+	if encs == nil {
+		return nil
+	}
+
+	for i, row := range result.RowDatas {
+		cells, err := row.Cells(result.Fields, binary)
+		if err != nil {
+			return err
+		}
+
+		for j, e := range encs {
+			if e == nil || cells[j] == nil {
+				continue
+			}
+			if cells[j], err = e.Decode(cells[j]); err != nil {
+				return err
+			}
+		}
+
+		result.RowDatas[i] = NewRowData(result.Fields, cells, binary)
+	}
+
+	for i, e := range encs {
+		if e != nil {
+			f := result.Fields[i]
+			f.Charset = uint16(DEFAULT_COLLATION_ID)
+			//dumped again with the new charset
+			f.Data = nil
+		}
+	}
+
+	return nil
+}
+
+// encodeParam converts a string parameter to the connection charset
+func (c *Conn) encodeParam(v string) ([]byte, error) {
+	if c.transcode {
+		if e := lookupEncoding(c.charset); e != nil {
+			return e.Encode(hack.Slice(v))
+		}
+	}
+	return hack.Slice(v), nil
+}
@@ -0,0 +1,193 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	. "github.com/siddontang/mixer/mysql"
+	"testing"
+)
+
+// testGBK is a gbk encoding of a few characters only
+type testGBK struct{}
+
+var testGBKChars = map[rune][]byte{
+	'中': {0xd6, 0xd0},
+	'文': {0xce, 0xc4},
+	'测': {0xb2, 0xe2},
+	'试': {0xca, 0xd4},
+}
+
+func (testGBK) Decode(b []byte) ([]byte, error) {
+	var d []byte
+	for i := 0; i < len(b); i++ {
+		if b[i] < 0x80 {
+			d = append(d, b[i])
+			continue
+		}
+
+		found := false
+		for r, c := range testGBKChars {
+			if i+1 < len(b) && b[i] == c[0] && b[i+1] == c[1] {
+				d = append(d, string(r)...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid gbk at %d", i)
+		}
+		i++
+	}
+	return d, nil
+}
+
+func (testGBK) Encode(b []byte) ([]byte, error) {
+	var e []byte
+	for _, r := range string(b) {
+		if r < 0x80 {
+			e = append(e, byte(r))
+		} else if c, ok := testGBKChars[r]; ok {
+			e = append(e, c...)
+		} else {
+			return nil, fmt.Errorf("%q not in gbk", r)
+		}
+	}
+	return e, nil
+}
+
+func useTestGBK() func() {
+	old := lookupEncoding("gbk")
+	RegisterEncoding("gbk", testGBK{})
+	return func() { RegisterEncoding("gbk", old) }
+}
+
+var (
+	gbkText  = []byte{0xd6, 0xd0, 0xce, 0xc4}
+	gbkParam = []byte{0xb2, 0xe2, 0xca, 0xd4}
+)
+
+// transcodeFields are a gbk column, a column of the conn charset, a
+// binary column and an int column
+func transcodeFields() []*Field {
+	return []*Field{
+		{Name: []byte("name"), Charset: 28, Type: MYSQL_TYPE_VAR_STRING},
+		{Name: []byte("title"), Type: MYSQL_TYPE_BLOB},
+		{Name: []byte("raw"), Charset: uint16(BINARY_COLLATION_ID), Type: MYSQL_TYPE_BLOB},
+		{Name: []byte("id"), Charset: uint16(BINARY_COLLATION_ID), Type: MYSQL_TYPE_LONGLONG},
+	}
+}
+
+// transcodeResultset returns the packets of a resultset of one row
+func transcodeResultset(binary bool) [][]byte {
+	id := []byte("7")
+	if binary {
+		id = Uint64ToBytes(7)
+	}
+	row := [][]byte{gbkText, gbkText, gbkText, id}
+	return resultsetPackets(SERVER_STATUS_AUTOCOMMIT, transcodeFields(), binary, [][][]byte{row})
+}
+
+func checkTranscoded(t *testing.T, r *Result) {
+	if len(r.Values) != 1 {
+		t.Fatal(r.Values)
+	}
+
+	for i, expect := range []string{"中文", "中文", string(gbkText)} {
+		if v, err := r.GetString(0, i); err != nil || v != expect {
+			t.Fatalf("column %d: %q %v", i, v, err)
+		}
+	}
+	if v, err := r.GetInt(0, 3); err != nil || v != 7 {
+		t.Fatal(v, err)
+	}
+
+	for i, charset := range []uint16{uint16(DEFAULT_COLLATION_ID), uint16(DEFAULT_COLLATION_ID), uint16(BINARY_COLLATION_ID)} {
+		if f := r.Fields[i]; f.Charset != charset {
+			t.Fatalf("column %d: charset %d", i, f.Charset)
+		}
+	}
+
+	//the forwarded fields carry the new charset
+	if f, err := FieldData(r.Fields[0].Dump()).Parse(); err != nil || f.Charset != uint16(DEFAULT_COLLATION_ID) {
+		t.Fatal(f, err)
+	}
+}
+
+func TestConn_Transcode(t *testing.T) {
+	defer useTestGBK()()
+
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	s.onQuery("select * from t").packets(transcodeResultset(false)...)
+
+	//a statement of one param and 4 columns
+	query := "select * from t where name = ?"
+	prepare := [][]byte{{OK_HEADER, 1, 0, 0, 0, 4, 0, 1, 0, 0, 0, 0},
+		(&Field{Name: []byte("?")}).Dump(), {EOF_HEADER, 0, 0, 2, 0}}
+	for _, f := range transcodeFields() {
+		prepare = append(prepare, f.Dump())
+	}
+	prepare = append(prepare, []byte{EOF_HEADER, 0, 0, 2, 0})
+	s.on(COM_STMT_PREPARE, query).packets(prepare...)
+
+	//only answered if the param is encoded to gbk
+	execute := []byte{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, MYSQL_TYPE_STRING, 0}
+	execute = append(execute, PutLengthEncodedString(gbkParam)...)
+	s.on(COM_STMT_EXECUTE, string(execute)).packets(transcodeResultset(true)...)
+
+	c := newFakeConn(t, s)
+	defer c.Close()
+
+	if err := c.SetCharset("gbk"); err != nil {
+		t.Fatal(err)
+	}
+
+	//off by default
+	r, err := c.Execute("select * from t")
+	if err != nil {
+		t.Fatal(err)
+	} else if v, _ := r.GetString(0, 0); v != string(gbkText) {
+		t.Fatalf("%q", v)
+	}
+
+	c.SetTranscode(true)
+
+	if r, err = c.Execute("select * from t"); err != nil {
+		t.Fatal(err)
+	}
+	checkTranscoded(t, r)
+
+	st, err := c.Prepare(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err = st.Execute("测试"); err != nil {
+		t.Fatal(err)
+	}
+	checkTranscoded(t, r)
+
+	//a []byte param is sent as is, not matching the scripted execute
+	if r, err = st.Execute(gbkParam); err != nil {
+		t.Fatal(err)
+	} else if len(r.Values) != 1 {
+		t.Fatal(r.Values)
+	}
+	if r, err = st.Execute([]byte("测试")); err != nil {
+		t.Fatal(err)
+	} else if r.Resultset != nil {
+		t.Fatal(r.Values)
+	}
+
+	//not encodable
+	if _, err = st.Execute("mixer 代理"); err == nil {
+		t.Fatal("must error")
+	}
+
+	//utf8 needs nothing
+	if err = c.SetCharset("utf8"); err != nil {
+		t.Fatal(err)
+	} else if b, err := c.encodeParam("测试"); err != nil || !bytes.Equal(b, []byte("测试")) {
+		t.Fatal(b, err)
+	}
+}
@@ -0,0 +1,56 @@
+//go:build xtext
+// +build xtext
+
+package client
+
+import (
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// xtextEncoding is an Encoding of golang.org/x/text, it is only built with
+// the xtext build tag.
+type xtextEncoding struct {
+	e encoding.Encoding
+}
+
+func (x xtextEncoding) Decode(b []byte) ([]byte, error) {
+	return x.e.NewDecoder().Bytes(b)
+}
+
+func (x xtextEncoding) Encode(b []byte) ([]byte, error) {
+	return x.e.NewEncoder().Bytes(b)
+}
+
+func init() {
+	for charset, e := range map[string]encoding.Encoding{
+		//gbk is a superset of gb2312
+		"gbk":     simplifiedchinese.GBK,
+		"gb2312":  simplifiedchinese.GBK,
+		"gb18030": simplifiedchinese.GB18030,
+		"big5":    traditionalchinese.Big5,
+		"sjis":    japanese.ShiftJIS,
+		"cp932":   japanese.ShiftJIS,
+		"ujis":    japanese.EUCJP,
+		"eucjpms": japanese.EUCJP,
+		"euckr":   korean.EUCKR,
+		//latin1 of mysql is cp1252
+		"latin1": charmap.Windows1252,
+		"latin2": charmap.ISO8859_2,
+		"latin5": charmap.ISO8859_9,
+		"latin7": charmap.ISO8859_13,
+		"greek":  charmap.ISO8859_7,
+		"hebrew": charmap.ISO8859_8,
+		"cp1250": charmap.Windows1250,
+		"cp1251": charmap.Windows1251,
+		"cp1256": charmap.Windows1256,
+		"koi8r":  charmap.KOI8R,
+		"koi8u":  charmap.KOI8U,
+	} {
+		RegisterEncoding(charset, xtextEncoding{e})
+	}
+}
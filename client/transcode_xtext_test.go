@@ -0,0 +1,33 @@
+//go:build xtext
+// +build xtext
+
+package client
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTranscode_XText(t *testing.T) {
+	for _, c := range []struct {
+		charset string
+		text    string
+		raw     []byte
+	}{
+		{"gbk", "中文测试", []byte{0xd6, 0xd0, 0xce, 0xc4, 0xb2, 0xe2, 0xca, 0xd4}},
+		{"gb2312", "你好, mixer", []byte{0xc4, 0xe3, 0xba, 0xc3, ',', ' ', 'm', 'i', 'x', 'e', 'r'}},
+		{"latin1", "café €", []byte{'c', 'a', 'f', 0xe9, ' ', 0x80}},
+	} {
+		e := lookupEncoding(c.charset)
+		if e == nil {
+			t.Fatalf("%s not registered", c.charset)
+		}
+
+		if raw, err := e.Encode([]byte(c.text)); err != nil || !bytes.Equal(raw, c.raw) {
+			t.Fatal(c.charset, raw, err)
+		}
+		if text, err := e.Decode(c.raw); err != nil || string(text) != c.text {
+			t.Fatal(c.charset, string(text), err)
+		}
+	}
+}
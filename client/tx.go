@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrIsolationLevel = errors.New("unknown isolation level")
+
+// IsolationLevel is the isolation level of a transaction begun by BeginTx
+type IsolationLevel int
+
+const (
+	// LevelDefault keeps the isolation level of the session
+	LevelDefault IsolationLevel = iota
+	LevelReadUncommitted
+	LevelReadCommitted
+	LevelRepeatableRead
+	LevelSerializable
+)
+
+var isolationLevels = map[IsolationLevel]string{
+	LevelReadUncommitted: "READ UNCOMMITTED",
+	LevelReadCommitted:   "READ COMMITTED",
+	LevelRepeatableRead:  "REPEATABLE READ",
+	LevelSerializable:    "SERIALIZABLE",
+}
+
+// String returns the level as in SET TRANSACTION, empty for LevelDefault
+func (l IsolationLevel) String() string {
+	return isolationLevels[l]
+}
+
+// TxOptions are the characteristics of a transaction begun by BeginTx
+type TxOptions struct {
+	Isolation IsolationLevel
+	ReadOnly  bool
+}
+
+// statements returns the statements beginning the transaction
+func (o TxOptions) statements() ([]string, error) {
+	var stmts []string
+	if o.Isolation != LevelDefault {
+		level, ok := isolationLevels[o.Isolation]
+		if !ok {
+			return nil, ErrIsolationLevel
+		}
+		//of the next transaction only, the session keeps its level
+		stmts = append(stmts, "SET TRANSACTION ISOLATION LEVEL "+level)
+	}
+
+	if o.ReadOnly {
+		stmts = append(stmts, "START TRANSACTION READ ONLY")
+	} else {
+		stmts = append(stmts, "START TRANSACTION READ WRITE")
+	}
+	return stmts, nil
+}
+
+// BeginTx begins a transaction on the conn with the isolation level and
+// the access mode of opts, see DB.BeginTx. A conn failing after the level
+// is set keeps it for its next transaction, it should be closed.
+func (c *Conn) BeginTx(opts TxOptions) (err error) {
+	defer c.recoverPanic(&err)
+
+	stmts, err := opts.statements()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range stmts {
+		if _, err := c.exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BeginTx is Begin with the isolation level and the access mode of opts.
+// They apply to this transaction only, a conn failing to begin is closed
+// instead of pooled, so no borrower after it gets them.
+func (db *DB) BeginTx(opts TxOptions) (*SqlConn, error) {
+	return db.BeginTxContext(context.Background(), opts)
+}
+
+// BeginTxContext is BeginTx interrupted when ctx is done, see BeginContext.
+func (db *DB) BeginTxContext(ctx context.Context, opts TxOptions) (*SqlConn, error) {
+	stmts, err := opts.statements()
+	if err != nil {
+		return nil, err
+	}
+	return db.begin(ctx, stmts)
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	. "github.com/siddontang/mixer/mysql"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// txQueries returns the queries of s setting or starting a transaction
+func txQueries(s *fakeServer) []string {
+	_, _, queries := s.stats()
+
+	var tx []string
+	for _, q := range queries {
+		if strings.Contains(q, "TRANSACTION") {
+			tx = append(tx, q)
+		}
+	}
+	return tx
+}
+
+func TestDB_BeginTx(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	defer db.Close()
+
+	tx, err := db.BeginTx(TxOptions{Isolation: LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tx.IsInTransaction() {
+		t.Fatal("not in transaction")
+	}
+	id := tx.ConnectionId()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	tx.Close()
+
+	//the same conn, the level applied to the committed transaction only
+	tx, err = db.BeginTx(TxOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if tx.ConnectionId() != id {
+		t.Fatal(tx.ConnectionId(), id)
+	}
+	tx.Close()
+
+	want := []string{
+		"SET TRANSACTION ISOLATION LEVEL REPEATABLE READ",
+		"START TRANSACTION READ ONLY",
+		"START TRANSACTION READ WRITE",
+	}
+	if got := txQueries(s); !reflect.DeepEqual(got, want) {
+		t.Fatal(got)
+	}
+
+	if _, err := db.BeginTx(TxOptions{Isolation: IsolationLevel(10)}); err != ErrIsolationLevel {
+		t.Fatal(err)
+	}
+	if st := db.Stats(); st.Acquired != 2 {
+		t.Fatalf("%+v", st)
+	}
+}
+
+func TestDB_BeginTxFailure(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	defer db.Close()
+
+	co := popTestConn(t, db)
+	id := co.ConnectionId()
+	db.PushConn(co, nil)
+
+	//the level set for the next transaction is left on the conn
+	s.onQuery("START TRANSACTION READ WRITE").times(1).err(ER_UNKNOWN_ERROR, "Unknown error")
+	if _, err := db.BeginTx(TxOptions{Isolation: LevelSerializable}); err == nil {
+		t.Fatal("begin not failed")
+	}
+
+	if st := db.Stats(); st.OpenConns != 0 || st.IdleConns != 0 {
+		t.Fatalf("%+v", st)
+	}
+
+	tx, err := db.BeginTx(TxOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if tx.ConnectionId() == id {
+		t.Fatal("conn reused")
+	}
+	tx.Close()
+}
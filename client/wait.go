@@ -0,0 +1,209 @@
+package client
+
+import (
+	"container/heap"
+	"context"
+	. "github.com/siddontang/mixer/mysql"
+	"sync/atomic"
+	"time"
+)
+
+const defaultPriorityAging = 100 * time.Millisecond
+
+var waitNow = time.Now
+
+// WaitStats is the waiting for conns of a full pool by one priority.
+type WaitStats struct {
+	Waits uint64
+	Total time.Duration
+	Max   time.Duration
+}
+
+// connWaiter is a PopConn waiting for a conn of a full pool
+type connWaiter struct {
+	prio int
+
+	//ordering of the waiters, see nextWaiter
+	rank  int
+	vtime time.Time
+	seq   uint64
+
+	since time.Time
+
+	//a released conn, or nil with the slot of a closed conn to open a new one
+	ch chan *Conn
+}
+
+type waitQueue []*connWaiter
+
+func (q waitQueue) Len() int { return len(q) }
+
+func (q waitQueue) Less(i, j int) bool {
+	a, b := q[i], q[j]
+	if a.rank != b.rank {
+		return a.rank > b.rank
+	} else if !a.vtime.Equal(b.vtime) {
+		return a.vtime.Before(b.vtime)
+	}
+	return a.seq < b.seq
+}
+
+func (q waitQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *waitQueue) Push(x interface{}) { *q = append(*q, x.(*connWaiter)) }
+
+func (q *waitQueue) Pop() interface{} {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return w
+}
+
+// SetMaxOpenConns limits the open conns of the pool, 0 means no limit.
+// PopConn waits for a conn released by others if the pool is full, the
+// waiters are served by priority, see PopConnWithPriority.
+func (db *DB) SetMaxOpenConns(n int) {
+	db.Lock()
+	db.maxOpenConns = n
+	db.Unlock()
+}
+
+// SetWaitTimeout makes PopConn waiting for a conn of a full pool fail with
+// ErrPoolTimeout after d, so callers can tell an exhausted pool from a dead
+// server. 0 waits forever, the default.
+func (db *DB) SetWaitTimeout(d time.Duration) {
+	db.Lock()
+	db.waitTimeout = d
+	db.Unlock()
+}
+
+// SetPriorityAging sets how fast a waiter gains priority, default 100ms.
+//
+// A waiter of priority p is served as if it had arrived p aging steps
+// earlier than it did, so it is served before a waiter of priority q > p
+// which arrived more than (q-p) steps after it. Under sustained load of
+// higher priorities a low priority waiter is never starved: it waits at
+// most (q-p) steps longer than the high priority waiters around it.
+// A step of 0 disables aging, waiters are served strictly by priority
+// and in arrival order for the same priority.
+func (db *DB) SetPriorityAging(step time.Duration) {
+	db.Lock()
+	db.priorityAging = step
+	db.Unlock()
+}
+
+// PopConnWithPriority is PopConn, when the pool is full the waiter of the
+// highest priority gets the next released conn. PopConn waits with
+// priority 0.
+func (db *DB) PopConnWithPriority(prio int) (*Conn, error) {
+	return db.popConn(context.Background(), prio)
+}
+
+// QueryWithPriority is Query taking a conn with PopConnWithPriority.
+func (db *DB) QueryWithPriority(prio int, query string, args ...interface{}) (*Resultset, error) {
+	return resultsetOf(db.execute(context.Background(), prio, StatementQuery, query, args))
+}
+
+// waitConn queues a waiter and waits, it must hold the lock and returns
+// with the lock released. It fails with ErrPoolTimeout at the wait timeout,
+// or ctx.Err(), holding no slot.
+func (db *DB) waitConn(ctx context.Context, prio int) (*Conn, error) {
+	now := waitNow()
+
+	w := &connWaiter{prio: prio, since: now, vtime: now, ch: make(chan *Conn, 1)}
+	if db.priorityAging > 0 {
+		w.vtime = now.Add(-time.Duration(prio) * db.priorityAging)
+	} else {
+		w.rank = prio
+	}
+	db.waitSeq++
+	w.seq = db.waitSeq
+
+	heap.Push(&db.waiters, w)
+	timeout := db.waitTimeout
+	db.Unlock()
+
+	co, err := db.awaitConn(ctx, w, timeout)
+	if err != nil {
+		return nil, err
+	}
+	wait := waitNow().Sub(now)
+
+	db.Lock()
+	s, ok := db.waitStats[prio]
+	if !ok {
+		s = new(WaitStats)
+		db.waitStats[prio] = s
+	}
+	s.Waits++
+	s.Total += wait
+	if wait > s.Max {
+		s.Max = wait
+	}
+	db.Unlock()
+
+	return co, nil
+}
+
+// awaitConn waits for the conn or the slot handed to w, until the timeout
+// or ctx is done
+func (db *DB) awaitConn(ctx context.Context, w *connWaiter, timeout time.Duration) (*Conn, error) {
+	if timeout <= 0 && ctx.Done() == nil {
+		return <-w.ch, nil
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+
+	err := ErrPoolTimeout
+	select {
+	case co := <-w.ch:
+		return co, nil
+	case <-expired:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	db.Lock()
+	defer db.Unlock()
+
+	for i, v := range db.waiters {
+		if v == w {
+			heap.Remove(&db.waiters, i)
+			if err == ErrPoolTimeout {
+				db.waitTimeouts++
+			}
+			return nil, err
+		}
+	}
+
+	//handed over meanwhile
+	return <-w.ch, nil
+}
+
+// nextWaiter removes and returns the waiter to serve, must hold the lock
+func (db *DB) nextWaiter() *connWaiter {
+	if len(db.waiters) == 0 {
+		return nil
+	}
+	return heap.Pop(&db.waiters).(*connWaiter)
+}
+
+// releaseSlot gives the slot of a closed conn to a waiter, or frees it
+func (db *DB) releaseSlot() {
+	db.Lock()
+	w := db.nextWaiter()
+	if w == nil {
+		atomic.AddInt32(&db.connNum, -1)
+	}
+	db.Unlock()
+
+	if w != nil {
+		w.ch <- nil
+	}
+}
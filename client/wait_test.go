@@ -0,0 +1,215 @@
+package client
+
+import (
+	"context"
+	. "github.com/siddontang/mixer/mysql"
+	"testing"
+	"time"
+)
+
+type testWaiter struct {
+	prio int
+	//arrival after the first waiter
+	after time.Duration
+}
+
+// serveTestWaiters queues the waiters on a full pool, then releases conns
+// one by one and returns the waiters in the order they are served
+func serveTestWaiters(t *testing.T, aging time.Duration, waiters []testWaiter) []int {
+	start := time.Now()
+	now := start
+	waitNow = func() time.Time { return now }
+	defer func() { waitNow = time.Now }()
+
+	db, _ := Open("127.0.0.1:1", "root", "", "")
+	db.SetMaxIdleConnNum(4)
+	db.SetMaxOpenConns(1)
+	db.SetPriorityAging(aging)
+
+	served := make(chan int)
+	for i, w := range waiters {
+		now = start.Add(w.after)
+
+		go func(i int, prio int) {
+			db.Lock()
+			if co, _ := db.waitConn(context.Background(), prio); co == nil {
+				t.Error("no conn handed over")
+			}
+			served <- i
+		}(i, w.prio)
+
+		//queued before the next one arrives
+		for n := 0; n != i+1; {
+			time.Sleep(time.Millisecond)
+			db.Lock()
+			n = len(db.waiters)
+			db.Unlock()
+		}
+	}
+
+	now = start.Add(10 * time.Second)
+
+	order := make([]int, 0, len(waiters))
+	for range waiters {
+		db.PushConn(new(Conn), nil)
+		order = append(order, <-served)
+	}
+
+	if s := db.Stats(); s.Waiting != 0 || s.IdleConns != 0 {
+		t.Fatalf("%+v", s)
+	}
+	return order
+}
+
+func checkTestOrder(t *testing.T, order []int, expect ...int) {
+	for i := range expect {
+		if order[i] != expect[i] {
+			t.Fatal(order)
+		}
+	}
+}
+
+func TestWait_Priority(t *testing.T) {
+	waiters := []testWaiter{
+		{prio: 0, after: 0},
+		{prio: 5, after: time.Second},
+		{prio: 20, after: time.Second},
+		{prio: 0, after: time.Second},
+		{prio: 5, after: 2 * time.Second},
+	}
+
+	//no aging, by priority then by arrival
+	checkTestOrder(t, serveTestWaiters(t, 0, waiters), 2, 1, 4, 0, 3)
+
+	//the first waiter gained 10 steps in the second before the others
+	//arrived, only the priority 20 one is ahead of it
+	checkTestOrder(t, serveTestWaiters(t, 100*time.Millisecond, waiters), 2, 0, 1, 3, 4)
+}
+
+func TestWait_Slot(t *testing.T) {
+	db, _ := Open("127.0.0.1:1", "root", "", "")
+	db.SetMaxIdleConnNum(4)
+	db.SetMaxOpenConns(1)
+	db.connNum = 1
+
+	//the waiter takes the slot of the broken conn and fails to connect
+	//too, the slot is freed
+	done := make(chan error)
+	go func() {
+		_, err := db.PopConnWithPriority(3)
+		done <- err
+	}()
+
+	for n := 0; n != 1; {
+		time.Sleep(time.Millisecond)
+		db.Lock()
+		n = len(db.waiters)
+		db.Unlock()
+	}
+
+	db.PushConn(new(Conn), ErrBadConn)
+	if err := <-done; err == nil {
+		t.Fatal("connect must fail")
+	}
+
+	s := db.Stats()
+	if s.OpenConns != 0 || s.Waiting != 0 || s.Failed != 2 {
+		t.Fatalf("%+v", s)
+	}
+	if w := s.Waits[3]; w.Waits != 1 || w.Max <= 0 || w.Total != w.Max {
+		t.Fatalf("%+v", s.Waits)
+	}
+}
+
+func TestWait_Timeout(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(1)
+	db.SetMaxOpenConns(1)
+	db.SetWaitTimeout(20 * time.Millisecond)
+	defer db.Close()
+
+	held := popTestConn(t, db)
+
+	start := time.Now()
+	if _, err := db.Execute("select 1"); err != ErrPoolTimeout {
+		t.Fatal(err)
+	} else if d := time.Now().Sub(start); d < 20*time.Millisecond {
+		t.Fatal(d)
+	}
+	if st := db.Stats(); st.WaitTimeouts != 1 || st.Waiting != 0 || st.OpenConns != 1 {
+		t.Fatalf("%+v", st)
+	}
+
+	//a waiter gets the slot of a bad conn, and opens a new one
+	done := make(chan error)
+	go func() {
+		_, err := db.Execute("select 1")
+		done <- err
+	}()
+	for db.Stats().Waiting != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	db.PushConn(held, ErrBadConn)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if st := db.Stats(); st.OpenConns != 1 || st.IdleConns != 1 || st.WaitTimeouts != 1 {
+		t.Fatalf("%+v", st)
+	}
+	if _, dials, _ := s.stats(); dials != 2 {
+		t.Fatal(dials)
+	}
+}
+
+func TestWait_HeldConnsCount(t *testing.T) {
+	s := newFakeServer(nil)
+	defer s.Close()
+
+	db := s.openDB("")
+	db.SetMaxIdleConnNum(2)
+	db.SetMaxOpenConns(2)
+	db.SetWaitTimeout(10 * time.Millisecond)
+	defer db.Close()
+
+	//a transaction and a conn holding a prepared statement fill the pool
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	co, err := db.GetConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := co.Prepare("select ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Execute("select 1"); err != ErrPoolTimeout {
+		t.Fatal(err)
+	}
+	if _, err := db.Begin(); err != ErrPoolTimeout {
+		t.Fatal(err)
+	}
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	tx.Close()
+	if _, err := db.Execute("select 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	stmt.Close()
+	co.Close()
+	if st := db.Stats(); st.OpenConns != 2 || st.IdleConns != 2 || st.WaitTimeouts != 2 {
+		t.Fatalf("%+v", st)
+	}
+	if _, dials, _ := s.stats(); dials != 2 {
+		t.Fatal(dials)
+	}
+}
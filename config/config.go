@@ -3,6 +3,7 @@ package config
 import (
 	"github.com/siddontang/go-yaml/yaml"
 	"io/ioutil"
+	"strings"
 )
 
 type NodeConfig struct {
@@ -11,11 +12,74 @@ type NodeConfig struct {
 	IdleConns        int    `yaml:"idle_conns"`
 	RWSplit          bool   `yaml:"rw_split"`
 
+	//milliseconds idle conns may exceed idle_conns before closed
+	IdleGrace int `yaml:"idle_grace"`
+
+	//max open conns of every mysql server, 0 means no limit, a query
+	//waits at most pool_wait_timeout milliseconds for a conn then
+	MaxOpenConns    int `yaml:"max_open_conns"`
+	PoolWaitTimeout int `yaml:"pool_wait_timeout"`
+
+	//seconds conns are reused after connected and after last used, 0
+	//means no limit, like below the wait_timeout of the server
+	ConnMaxLifetime int `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime int `yaml:"conn_max_idle_time"`
+
+	//milliseconds bounding a dial, and every packet read and written,
+	//0 means no bound
+	DialTimeout  int `yaml:"dial_timeout"`
+	ReadTimeout  int `yaml:"read_timeout"`
+	WriteTimeout int `yaml:"write_timeout"`
+
+	//tls to mysql: verify-full verifies the server certificate by tls_ca,
+	//a pem file, or the system roots, skip-verify encrypts only, empty
+	//is plaintext
+	TLS   string `yaml:"tls"`
+	TLSCA string `yaml:"tls_ca"`
+
+	//idle conns of every mysql server warmed at startup and kept alive,
+	//at most idle_conns
+	MinIdleConns int `yaml:"min_idle_conns"`
+
 	User     string `yaml:"user"`
 	Password string `yaml:"password"`
 
 	Master string `yaml:"master"`
-	Slave  string `yaml:"slave"`
+
+	//slaves separated by comma
+	Slave string `yaml:"slave"`
+
+	ReadOnly bool `yaml:"read_only"`
+
+	//read-after-write consistency of sessions, see proxy.ConsistencyGTID,
+	//track_gtids makes master report the gtids of writes, in milliseconds
+	TrackGTIDs           bool `yaml:"track_gtids"`
+	ReadAfterWriteWindow int  `yaml:"read_after_write_window"`
+	GTIDWaitTimeout      int  `yaml:"gtid_wait_timeout"`
+
+	Warmup WarmupConfig `yaml:"warmup"`
+}
+
+type WarmupConfig struct {
+	//idle conns to open for every mysql server, default idle_conns
+	IdleConns int `yaml:"idle_conns"`
+	//statements prepared on every warmed conn
+	Stmts []string `yaml:"stmts"`
+	//conns warmed at the same time
+	Parallel int `yaml:"parallel"`
+	//seconds to stop warming
+	Timeout int `yaml:"timeout"`
+}
+
+// SlaveAddrs returns the addresses of all slaves.
+func (c *NodeConfig) SlaveAddrs() []string {
+	var addrs []string
+	for _, addr := range strings.Split(c.Slave, ",") {
+		if addr = strings.TrimSpace(addr); len(addr) > 0 {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
 }
 
 type SchemaConfig struct {
@@ -27,6 +91,10 @@ type SchemaConfig struct {
 type RulesConfig struct {
 	Default   string        `yaml:"default"`
 	ShardRule []ShardConfig `yaml:"shard"`
+
+	//statement on more than one table with a sharded one,
+	//reject(default) or first, routed by the first sharded table
+	Join string `yaml:"join"`
 }
 
 type ShardConfig struct {
@@ -37,12 +105,84 @@ type ShardConfig struct {
 	Range string   `yaml:"range"`
 }
 
+type HealthCheckConfig struct {
+	//seconds between two probes of a mysql server
+	Interval int `yaml:"interval"`
+	//seconds to wait for a probe
+	Timeout int `yaml:"timeout"`
+	//consecutive succeeded probes to mark a down server up
+	Rise int `yaml:"rise"`
+	//consecutive failed probes to mark an up server down
+	Fall int `yaml:"fall"`
+}
+
+type FilterRuleConfig struct {
+	Name string `yaml:"name"`
+	//allow or deny
+	Action string `yaml:"action"`
+	//statement classes, like select, drop, ddl, full_update or full_delete
+	Classes []string `yaml:"classes"`
+	//regexp matched against the query fingerprint
+	Pattern string `yaml:"pattern"`
+	//hh:mm-hh:mm in local time, the rule only matches in the window
+	Window string `yaml:"window"`
+}
+
+type FilterConfig struct {
+	//only log denied queries
+	DryRun bool `yaml:"dry_run"`
+	//ordered, the first matched rule decides
+	Rules []FilterRuleConfig `yaml:"rules"`
+}
+
+type UserConfig struct {
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+
+	//max frontend conns of the user, 0 means no limit
+	MaxConns int `yaml:"max_conns"`
+
+	//max queries per second of the user, 0 means no limit. qps_burst is
+	//the bucket size, default max_qps. a query over the limit waits up to
+	//qps_max_delay milliseconds, or is rejected if it is 0
+	MaxQPS      int `yaml:"max_qps"`
+	QPSBurst    int `yaml:"qps_burst"`
+	QPSMaxDelay int `yaml:"qps_max_delay"`
+}
+
 type Config struct {
 	Addr     string `yaml:"addr"`
 	User     string `yaml:"user"`
 	Password string `yaml:"password"`
 	LogLevel string `yaml:"log_level"`
 
+	//more users besides user
+	Users []UserConfig `yaml:"users"`
+
+	//version sent in the handshake, default mysql.ServerVersion
+	ServerVersion string `yaml:"server_version"`
+
+	HealthCheck HealthCheckConfig `yaml:"health_check"`
+
+	//reject all writes, transactions begun on backends may finish if read_only_allow_tx
+	ReadOnly        bool `yaml:"read_only"`
+	ReadOnlyAllowTx bool `yaml:"read_only_allow_tx"`
+
+	//forward /*mixer:...*/ routing hints to mysql instead of stripping them
+	KeepHints bool `yaml:"keep_hints"`
+
+	//default node of schemas which have no rules default
+	DefaultNode string `yaml:"default_node"`
+	//reject statements which can not be analyzed instead of sending them to the default node
+	StrictRouting bool `yaml:"strict_routing"`
+
+	Filter FilterConfig `yaml:"filter"`
+
+	//bytes of rows buffered by all backend result sets, 0 means no limit,
+	//a new query over it fails or waits if result_memory_wait
+	ResultMemoryLimit int64 `yaml:"result_memory_limit"`
+	ResultMemoryWait  bool  `yaml:"result_memory_wait"`
+
 	Nodes []NodeConfig `yaml:"nodes"`
 
 	Schemas []SchemaConfig `yaml:"schemas"`
@@ -0,0 +1,250 @@
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/siddontang/mixer/client"
+	"github.com/siddontang/mixer/mysql"
+	"time"
+)
+
+type conn struct {
+	db *client.DB
+	co *client.Conn
+
+	//a broken conn error was returned, closed instead of pooled
+	bad bool
+}
+
+// driverError returns err for database/sql: ErrBadConn before the command
+// was sent as driver.ErrBadConn, so database/sql retries on another conn
+// as the pool does. Once sent the statement may have run, err is kept and
+// the conn is dropped by IsValid.
+func driverError(err error, sent bool) error {
+	if err == mysql.ErrBadConn && !sent {
+		return driver.ErrBadConn
+	}
+	return err
+}
+
+// check marks the conn bad for a broken conn error
+func (c *conn) check(err error) error {
+	if err == nil {
+		return nil
+	}
+	if mysql.IsConnBroken(err) {
+		c.bad = true
+	}
+	return driverError(err, c.co.CommandSent())
+}
+
+// Close gives the conn back to the pool, a bad one is closed.
+func (c *conn) Close() error {
+	if c.bad {
+		c.db.PushConn(c.co, mysql.ErrBadConn)
+	} else {
+		c.db.PushConn(c.co, nil)
+	}
+	return nil
+}
+
+func (c *conn) IsValid() bool {
+	return !c.bad && !c.co.IsClosed()
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if !c.IsValid() {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	return c.check(c.co.Ping())
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s, err := c.co.Prepare(query)
+	if err != nil {
+		return nil, c.check(err)
+	}
+	return &stmt{c, s}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	vs, err := values(args)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := c.co.ExecuteContext(ctx, query, vs...)
+	if err != nil {
+		return nil, c.check(err)
+	}
+	return result{r}, nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	vs, err := values(args)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := c.co.ExecuteContext(ctx, query, vs...)
+	if err != nil {
+		return nil, c.check(err)
+	}
+	return newRows(r), nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	level, err := isolationLevel(sql.IsolationLevel(opts.Isolation))
+	if err != nil {
+		return nil, err
+	}
+
+	if level == client.LevelDefault && !opts.ReadOnly {
+		err = c.co.Begin()
+	} else {
+		err = c.co.BeginTx(client.TxOptions{Isolation: level, ReadOnly: opts.ReadOnly})
+	}
+	if err != nil {
+		//the isolation level may be set for the next transaction, a begin
+		//has no effect and is tried again on another conn
+		c.bad = true
+		return nil, driverError(err, false)
+	}
+	return tx{c}, nil
+}
+
+// isolationLevel returns the client level of a database/sql level
+func isolationLevel(l sql.IsolationLevel) (client.IsolationLevel, error) {
+	switch l {
+	case sql.LevelDefault:
+		return client.LevelDefault, nil
+	case sql.LevelReadUncommitted:
+		return client.LevelReadUncommitted, nil
+	case sql.LevelReadCommitted:
+		return client.LevelReadCommitted, nil
+	case sql.LevelRepeatableRead:
+		return client.LevelRepeatableRead, nil
+	case sql.LevelSerializable:
+		return client.LevelSerializable, nil
+	default:
+		return 0, fmt.Errorf("isolation level %v not supported", l)
+	}
+}
+
+// values returns the args for the client, a time as its datetime text
+func values(args []driver.NamedValue) ([]interface{}, error) {
+	vs := make([]interface{}, len(args))
+	for i, a := range args {
+		if a.Name != "" {
+			return nil, fmt.Errorf("named argument %s not supported", a.Name)
+		}
+
+		if t, ok := a.Value.(time.Time); ok {
+			vs[i] = t.Format("2006-01-02 15:04:05.999999")
+		} else {
+			vs[i] = a.Value
+		}
+	}
+	return vs, nil
+}
+
+type tx struct {
+	c *conn
+}
+
+func (t tx) Commit() error {
+	return t.c.check(t.c.co.Commit())
+}
+
+func (t tx) Rollback() error {
+	return t.c.check(t.c.co.Rollback())
+}
+
+type stmt struct {
+	c *conn
+	s *client.Stmt
+}
+
+func (s *stmt) Close() error {
+	return s.c.check(s.s.Close())
+}
+
+func (s *stmt) NumInput() int {
+	return s.s.ParamNum()
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	vs, err := values(args)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := s.s.ExecuteContext(ctx, vs...)
+	if err != nil {
+		return nil, s.c.check(err)
+	}
+	return result{r}, nil
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	vs, err := values(args)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := s.s.ExecuteContext(ctx, vs...)
+	if err != nil {
+		return nil, s.c.check(err)
+	}
+	return newRows(r), nil
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	nvs := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		nvs[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return nvs
+}
+
+type result struct {
+	r *mysql.Result
+}
+
+func (r result) LastInsertId() (int64, error) {
+	return int64(r.r.InsertId), nil
+}
+
+func (r result) RowsAffected() (int64, error) {
+	return int64(r.r.AffectedRows), nil
+}
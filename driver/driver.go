@@ -0,0 +1,93 @@
+// Package driver registers the pool of package client as the "mixer"
+// driver of database/sql:
+//
+//	db, err := sql.Open("mixer", "root:secret@tcp(127.0.0.1:3306)/mixer?maxIdleConns=16")
+//
+// The dsn is the one of client.OpenDSN. A conn of database/sql is a conn
+// checked out of the client pool of the dsn, given back when database/sql
+// closes it, so the options of the pool apply to it too. NewConnector
+// uses a pool set up in code instead, like with OnConnect.
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/siddontang/mixer/client"
+	"sync"
+)
+
+// Driver is the driver registered as "mixer".
+type Driver struct {
+	sync.Mutex
+
+	//opened by Open, one for every dsn
+	pools map[string]*client.DB
+}
+
+var mixerDriver = &Driver{}
+
+func init() {
+	sql.Register("mixer", mixerDriver)
+}
+
+// Open returns a conn of the pool of dsn, the pool is opened by the first
+// Open of dsn and shared by the later ones. sql.Open does not use it but
+// OpenConnector.
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	d.Lock()
+	db, ok := d.pools[dsn]
+	if !ok {
+		var err error
+		if db, err = client.OpenDSN(dsn); err != nil {
+			d.Unlock()
+			return nil, err
+		}
+
+		if d.pools == nil {
+			d.pools = make(map[string]*client.DB)
+		}
+		d.pools[dsn] = db
+	}
+	d.Unlock()
+
+	return (&connector{db, d}).Connect(context.Background())
+}
+
+// OpenConnector opens a pool of dsn for the connector, it is closed by
+// Close of the sql.DB.
+func (d *Driver) OpenConnector(dsn string) (driver.Connector, error) {
+	db, err := client.OpenDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &connector{db, d}, nil
+}
+
+// NewConnector returns a connector of db for sql.OpenDB, db is closed by
+// Close of the sql.DB.
+func NewConnector(db *client.DB) driver.Connector {
+	return &connector{db, mixerDriver}
+}
+
+type connector struct {
+	db *client.DB
+	d  *Driver
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	co, err := c.db.PopConnContext(ctx)
+	if err != nil {
+		return nil, driverError(err, false)
+	}
+	return &conn{db: c.db, co: co}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.d
+}
+
+// Close closes the pool, called by Close of the sql.DB.
+func (c *connector) Close() error {
+	return c.db.Close()
+}
@@ -0,0 +1,143 @@
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/siddontang/mixer/client"
+	"github.com/siddontang/mixer/mysql"
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDriver_Values(t *testing.T) {
+	now := time.Date(2014, 9, 1, 10, 20, 30, 500000000, time.UTC)
+	vs, err := values([]driver.NamedValue{
+		{Ordinal: 1, Value: int64(1)},
+		{Ordinal: 2, Value: now},
+		{Ordinal: 3, Value: nil},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vs[0] != int64(1) || vs[1] != "2014-09-01 10:20:30.5" || vs[2] != nil {
+		t.Fatal(vs)
+	}
+
+	if _, err := values([]driver.NamedValue{{Name: "id", Ordinal: 1, Value: int64(1)}}); err == nil {
+		t.Fatal("named argument accepted")
+	}
+}
+
+func TestDriver_IsolationLevel(t *testing.T) {
+	levels := map[sql.IsolationLevel]client.IsolationLevel{
+		sql.LevelDefault:         client.LevelDefault,
+		sql.LevelReadUncommitted: client.LevelReadUncommitted,
+		sql.LevelReadCommitted:   client.LevelReadCommitted,
+		sql.LevelRepeatableRead:  client.LevelRepeatableRead,
+		sql.LevelSerializable:    client.LevelSerializable,
+	}
+	for l, want := range levels {
+		if got, err := isolationLevel(l); err != nil || got != want {
+			t.Fatal(l, got, err)
+		}
+	}
+
+	if _, err := isolationLevel(sql.LevelSnapshot); err == nil {
+		t.Fatal("snapshot accepted")
+	}
+}
+
+func TestDriver_Rows(t *testing.T) {
+	r := new(mysql.Resultset)
+	r.Fields = []*mysql.Field{
+		&mysql.Field{Name: []byte("id"), Type: mysql.MYSQL_TYPE_LONGLONG, Flag: mysql.UNSIGNED_FLAG},
+		&mysql.Field{Name: []byte("name"), Type: mysql.MYSQL_TYPE_VAR_STRING, Charset: uint16(mysql.DEFAULT_COLLATION_ID)},
+	}
+	r.Values = [][]interface{}{
+		[]interface{}{[]byte("1"), []byte("a")},
+		[]interface{}{[]byte("18446744073709551615"), nil},
+	}
+
+	released := false
+	r.OnRelease(func() { released = true })
+
+	rs := newRows(&mysql.Result{Resultset: r})
+	if cols := rs.Columns(); len(cols) != 2 || cols[0] != "id" || cols[1] != "name" {
+		t.Fatal(cols)
+	}
+
+	dest := make([]driver.Value, 2)
+	if err := rs.Next(dest); err != nil {
+		t.Fatal(err)
+	} else if dest[0] != int64(1) || dest[1] != "a" {
+		t.Fatal(dest)
+	}
+	if err := rs.Next(dest); err != nil {
+		t.Fatal(err)
+	} else if string(dest[0].([]byte)) != "18446744073709551615" || dest[1] != nil {
+		t.Fatal(dest)
+	}
+	if err := rs.Next(dest); err != io.EOF {
+		t.Fatal(err)
+	}
+
+	rs.Close()
+	if !released {
+		t.Fatal("result set not released")
+	}
+
+	//a statement without a result set
+	if cols := newRows(&mysql.Result{}).Columns(); len(cols) != 0 {
+		t.Fatal(cols)
+	}
+
+	if v := driverValue(uint64(math.MaxInt64)); v != int64(math.MaxInt64) {
+		t.Fatal(v)
+	}
+}
+
+func TestDriver_ErrBadConn(t *testing.T) {
+	c := &conn{co: new(client.Conn)}
+	if err := c.check(mysql.ErrBadConn); err != driver.ErrBadConn || !c.bad {
+		t.Fatal(err, c.bad)
+	}
+
+	//the statement may have run, not retried by database/sql
+	if err := driverError(mysql.ErrBadConn, true); err != mysql.ErrBadConn {
+		t.Fatal(err)
+	}
+	if c.IsValid() || c.ResetSession(context.Background()) != driver.ErrBadConn {
+		t.Fatal("bad conn reused")
+	}
+
+	c = &conn{co: new(client.Conn)}
+	e := mysql.NewDefaultError(mysql.ER_DUP_ENTRY, "a", "key")
+	if err := c.check(e); err != e || c.bad {
+		t.Fatal(err, c.bad)
+	}
+}
+
+func TestDriver_Query(t *testing.T) {
+	db, err := sql.Open("mixer", "root@tcp(127.0.0.1:3306)/mixer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	var n int64
+	var s string
+	if err := tx.QueryRow("select ?, ?", 1, "a").Scan(&n, &s); err != nil {
+		t.Fatal(err)
+	} else if n != 1 || s != "a" {
+		t.Fatal(n, s)
+	}
+}
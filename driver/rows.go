@@ -0,0 +1,60 @@
+package driver
+
+import (
+	"database/sql/driver"
+	"github.com/siddontang/mixer/mysql"
+	"io"
+	"math"
+	"strconv"
+)
+
+// rows reads the buffered result set of a query row by row
+type rows struct {
+	r   *mysql.Resultset
+	row int
+}
+
+// newRows returns the rows of r, none for a statement without a result set
+func newRows(r *mysql.Result) *rows {
+	if r.Resultset == nil {
+		return &rows{r: new(mysql.Resultset)}
+	}
+	return &rows{r: r.Resultset}
+}
+
+func (r *rows) Columns() []string {
+	names := make([]string, len(r.r.Fields))
+	for i, f := range r.r.Fields {
+		names[i] = string(f.Name)
+	}
+	return names
+}
+
+func (r *rows) Close() error {
+	r.r.Release()
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.row >= r.r.RowNumber() {
+		return io.EOF
+	}
+
+	for i, v := range r.r.RowValues(r.row) {
+		dest[i] = driverValue(v)
+	}
+	r.row++
+	return nil
+}
+
+// driverValue returns a value of RowValues as a driver.Value, an uint64
+// out of the range of int64 as its decimal text
+func driverValue(v interface{}) driver.Value {
+	if u, ok := v.(uint64); ok {
+		if u > math.MaxInt64 {
+			return []byte(strconv.FormatUint(u, 10))
+		}
+		return int64(u)
+	}
+	return v
+}
@@ -310,6 +310,8 @@ var Collations = map[CollationId]string{
 	245: "utf8mb4_croatian_ci",
 	246: "utf8mb4_unicode_520_ci",
 	247: "utf8mb4_vietnamese_ci",
+	//default of MySQL 8.0
+	255: "utf8mb4_0900_ai_ci",
 }
 
 var CollationNames = map[string]CollationId{
@@ -532,10 +534,12 @@ var CollationNames = map[string]CollationId{
 	"utf8mb4_croatian_ci":      245,
 	"utf8mb4_unicode_520_ci":   246,
 	"utf8mb4_vietnamese_ci":    247,
+	"utf8mb4_0900_ai_ci":       255,
 }
 
 const (
 	DEFAULT_CHARSET                    = "utf8"
 	DEFAULT_COLLATION_ID   CollationId = 33
 	DEFAULT_COLLATION_NAME string      = "utf8_general_ci"
+	BINARY_COLLATION_ID    CollationId = 63
 )
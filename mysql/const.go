@@ -27,6 +27,16 @@ const (
 	SERVER_STATUS_METADATA_CHANGED     uint16 = 0x0400
 	SERVER_QUERY_WAS_SLOW              uint16 = 0x0800
 	SERVER_PS_OUT_PARAMS               uint16 = 0x1000
+	SERVER_STATUS_IN_TRANS_READONLY    uint16 = 0x2000
+	SERVER_SESSION_STATE_CHANGED       uint16 = 0x4000
+)
+
+//session state types of ok packets, see CLIENT_SESSION_TRACK
+const (
+	SESSION_TRACK_SYSTEM_VARIABLES byte = iota
+	SESSION_TRACK_SCHEMA
+	SESSION_TRACK_STATE_CHANGE
+	SESSION_TRACK_GTIDS
 )
 
 const (
@@ -87,6 +97,17 @@ const (
 	CLIENT_PLUGIN_AUTH
 	CLIENT_CONNECT_ATTRS
 	CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA
+	CLIENT_CAN_HANDLE_EXPIRED_PASSWORDS
+	CLIENT_SESSION_TRACK
+	CLIENT_DEPRECATE_EOF
+	CLIENT_OPTIONAL_RESULTSET_METADATA
+)
+
+// Metadata flags after the column count of a resultset, see
+// CLIENT_OPTIONAL_RESULTSET_METADATA.
+const (
+	RESULTSET_METADATA_NONE byte = iota
+	RESULTSET_METADATA_FULL
 )
 
 const (
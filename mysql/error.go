@@ -3,15 +3,96 @@ package mysql
 import (
 	"errors"
 	"fmt"
+	"io"
+	"net"
+	"syscall"
 )
 
 var (
 	ErrBadConn       = errors.New("connection was bad")
 	ErrMalformPacket = errors.New("Malform packet error")
+	ErrQueryTimeout  = errors.New("query timeout")
+	ErrReadTimeout   = errors.New("read timeout")
+	ErrWriteTimeout  = errors.New("write timeout")
 
 	ErrTxDone = errors.New("sql: Transaction has already been committed or rolled back")
+
+	//a NULL scanned into a destination which can not hold it, see ScanRow
+	ErrNullValue = errors.New("null value")
+)
+
+//client errors of a lost connection, relayed by a proxy like a server error
+const (
+	CR_SERVER_GONE_ERROR uint16 = 2006
+	CR_SERVER_LOST       uint16 = 2013
 )
 
+// IsConnBroken is true for an error after which the connection can not be
+// used again: ErrBadConn and the timeouts of a packet or a query, a
+// network error, an EOF, a reset or broken pipe of the socket, and the
+// server gone away or lost connection errors. A plain statement error,
+// like a duplicate key, leaves the connection usable.
+func IsConnBroken(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	for _, e := range []error{ErrBadConn, ErrMalformPacket, ErrQueryTimeout,
+		ErrReadTimeout, ErrWriteTimeout, io.EOF, io.ErrUnexpectedEOF,
+		syscall.EPIPE, syscall.ECONNRESET, syscall.ECONNABORTED} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return true
+	}
+
+	var se *SqlError
+	if errors.As(err, &se) {
+		switch se.Code {
+		case CR_SERVER_GONE_ERROR, CR_SERVER_LOST, ER_SERVER_SHUTDOWN:
+			return true
+		}
+	}
+	return false
+}
+
+// IsDeadlock is true for a statement rolled back by a deadlock, the
+// transaction can be retried from its start.
+func IsDeadlock(err error) bool {
+	return hasErrorCode(err, ER_LOCK_DEADLOCK)
+}
+
+// IsLockWaitTimeout is true for a statement which waited for a row lock
+// longer than innodb_lock_wait_timeout, only the statement is rolled
+// back by default.
+func IsLockWaitTimeout(err error) bool {
+	return hasErrorCode(err, ER_LOCK_WAIT_TIMEOUT)
+}
+
+// IsDuplicateEntry is true for an insert or update violating a primary or
+// a unique key.
+func IsDuplicateEntry(err error) bool {
+	return hasErrorCode(err, ER_DUP_ENTRY, ER_DUP_ENTRY_WITH_KEY_NAME)
+}
+
+// hasErrorCode is true if err is or wraps a *SqlError of one of codes
+func hasErrorCode(err error, codes ...uint16) bool {
+	var se *SqlError
+	if !errors.As(err, &se) {
+		return false
+	}
+	for _, code := range codes {
+		if se.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
 type SqlError struct {
 	Code    uint16
 	Message string
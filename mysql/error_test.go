@@ -0,0 +1,73 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestIsConnBroken(t *testing.T) {
+	broken := []error{
+		ErrBadConn,
+		ErrMalformPacket,
+		ErrQueryTimeout,
+		ErrReadTimeout,
+		ErrWriteTimeout,
+		io.EOF,
+		io.ErrUnexpectedEOF,
+		fmt.Errorf("read: %w", io.EOF),
+		&net.OpError{Op: "write", Net: "tcp", Err: syscall.EPIPE},
+		&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET},
+		syscall.ECONNABORTED,
+		context.DeadlineExceeded,
+		NewError(CR_SERVER_GONE_ERROR, "MySQL server has gone away"),
+		NewError(CR_SERVER_LOST, "Lost connection to MySQL server during query"),
+		NewDefaultError(ER_SERVER_SHUTDOWN),
+	}
+	for _, err := range broken {
+		if !IsConnBroken(err) {
+			t.Fatalf("%v must be broken", err)
+		}
+	}
+
+	usable := []error{
+		nil,
+		errors.New("invalid charset"),
+		NewDefaultError(ER_DUP_ENTRY, "1", "PRIMARY"),
+		NewDefaultError(ER_QUERY_INTERRUPTED),
+		ErrTxDone,
+	}
+	for _, err := range usable {
+		if IsConnBroken(err) {
+			t.Fatalf("%v must not be broken", err)
+		}
+	}
+}
+
+func TestErrorPredicates(t *testing.T) {
+	deadlock := NewDefaultError(ER_LOCK_DEADLOCK)
+	wait := NewDefaultError(ER_LOCK_WAIT_TIMEOUT)
+	dup := NewDefaultError(ER_DUP_ENTRY, "1", "PRIMARY")
+
+	for _, c := range []struct {
+		err                 error
+		deadlock, wait, dup bool
+	}{
+		{deadlock, true, false, false},
+		{fmt.Errorf("commit: %w", deadlock), true, false, false},
+		{wait, false, true, false},
+		{dup, false, false, true},
+		{NewError(ER_DUP_ENTRY_WITH_KEY_NAME, "Duplicate entry '1' for key 'a'"), false, false, true},
+		{NewDefaultError(ER_QUERY_INTERRUPTED), false, false, false},
+		{ErrBadConn, false, false, false},
+		{nil, false, false, false},
+	} {
+		if IsDeadlock(c.err) != c.deadlock || IsLockWaitTimeout(c.err) != c.wait || IsDuplicateEntry(c.err) != c.dup {
+			t.Fatal(c.err)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package mysql
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func BenchmarkPacketIORead(b *testing.B) {
+	//rows of about 100 bytes
+	payload := bytes.Repeat([]byte{'a'}, 100)
+
+	var buf bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		buf.Write([]byte{byte(len(payload)), 0, 0, 0})
+		buf.Write(payload)
+	}
+	data := buf.Bytes()
+
+	r := bytes.NewReader(data)
+	p := &PacketIO{rb: bufio.NewReaderSize(r, 1024)}
+
+	b.SetBytes(int64(len(payload) + 4))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if i%1000 == 0 {
+			r.Reset(data)
+			p.rb.Reset(r)
+		}
+
+		p.Sequence = 0
+		if _, err := p.ReadPacket(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,10 +1,101 @@
 package mysql
 
+import (
+	"strconv"
+	"strings"
+)
+
 type Result struct {
 	Status uint16
 
 	InsertId     uint64
 	AffectedRows uint64
 
+	//human readable info of the ok packet
+	info string
+
+	//gtids of the transaction committed by the statement, if tracked
+	gtid string
+
 	*Resultset
 }
+
+// SetInfo sets the info string of the ok packet.
+func (r *Result) SetInfo(info string) {
+	r.info = info
+}
+
+// Info returns the info string of the ok packet, like
+// "Records: 10  Duplicates: 0  Warnings: 0", empty if the server sent none.
+func (r *Result) Info() string {
+	return r.info
+}
+
+// SetGTID sets the gtids tracked by the session state of the ok packet.
+func (r *Result) SetGTID(gtid string) {
+	r.gtid = gtid
+}
+
+// GTID returns the gtid set committed by the statement, empty if none was
+// committed or the connection does not track gtids.
+func (r *Result) GTID() string {
+	return r.gtid
+}
+
+// RecordsDuplicatesWarnings parses the info of multi-row insert, alter table
+// and load data, for load data dups is the skipped records. It returns false
+// if the info is empty or in another format, like the one of update.
+func (r *Result) RecordsDuplicatesWarnings() (records, dups, warns int, ok bool) {
+	values := parseInfo(r.info)
+
+	var okRecords, okDups, okWarns bool
+	records, okRecords = values["Records"]
+	if dups, okDups = values["Duplicates"]; !okDups {
+		dups, okDups = values["Skipped"]
+	}
+	warns, okWarns = values["Warnings"]
+
+	if !okRecords || !okDups || !okWarns {
+		return 0, 0, 0, false
+	}
+	return records, dups, warns, true
+}
+
+// parseInfo parses "Key: value  Other key: value" into a map, it returns nil
+// if any value is not a number.
+func parseInfo(info string) map[string]int {
+	words := strings.Fields(info)
+	if len(words) == 0 {
+		return nil
+	}
+
+	values := make(map[string]int)
+
+	var key []string
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		if !strings.HasSuffix(w, ":") {
+			key = append(key, w)
+			continue
+		}
+
+		key = append(key, w[:len(w)-1])
+		if i+1 >= len(words) {
+			return nil
+		}
+
+		n, err := strconv.Atoi(words[i+1])
+		if err != nil {
+			return nil
+		}
+
+		values[strings.Join(key, " ")] = n
+		key = key[:0]
+		i++
+	}
+
+	if len(key) > 0 {
+		return nil
+	}
+	return values
+}
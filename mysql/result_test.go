@@ -0,0 +1,36 @@
+package mysql
+
+import (
+	"testing"
+)
+
+func TestResultRecordsDuplicatesWarnings(t *testing.T) {
+	tests := []struct {
+		info                 string
+		records, dups, warns int
+		ok                   bool
+	}{
+		{"Records: 10  Duplicates: 2  Warnings: 1", 10, 2, 1, true},
+		{"Records: 0  Duplicates: 0  Warnings: 0", 0, 0, 0, true},
+		{"Records: 5  Deleted: 0  Skipped: 3  Warnings: 3", 5, 3, 3, true},
+		{"Rows matched: 1  Changed: 1  Warnings: 0", 0, 0, 0, false},
+		{"", 0, 0, 0, false},
+		{"Records: x  Duplicates: 0  Warnings: 0", 0, 0, 0, false},
+		{"Records: 1  Duplicates:", 0, 0, 0, false},
+		{"garbage", 0, 0, 0, false},
+	}
+
+	for _, test := range tests {
+		var r Result
+		r.SetInfo(test.info)
+
+		if r.Info() != test.info {
+			t.Fatal(r.Info())
+		}
+
+		records, dups, warns, ok := r.RecordsDuplicatesWarnings()
+		if records != test.records || dups != test.dups || warns != test.warns || ok != test.ok {
+			t.Fatal(test.info, records, dups, warns, ok)
+		}
+	}
+}
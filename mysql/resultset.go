@@ -2,10 +2,13 @@ package mysql
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"github.com/siddontang/mixer/hack"
 	"math"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type RowData []byte
@@ -217,12 +220,182 @@ func (p RowData) ParseBinary(f []*Field) ([]interface{}, error) {
 	return data, nil
 }
 
+// Cells splits the row into the raw value of every column, nil for NULL.
+// A text row value is the text sent by the server. A binary row value is
+// its binary protocol encoding, a date or time without the length byte.
+func (p RowData) Cells(f []*Field, binary bool) ([][]byte, error) {
+	cells := make([][]byte, len(f))
+
+	if !binary {
+		pos := 0
+		for i := range f {
+			v, isNull, n, err := LengthEnodedString(p[pos:])
+			if err != nil {
+				return nil, err
+			}
+			pos += n
+
+			if !isNull {
+				//not nil for an empty value
+				cells[i] = p[pos-len(v) : pos]
+			}
+		}
+		return cells, nil
+	}
+
+	if p[0] != OK_HEADER {
+		return nil, ErrMalformPacket
+	}
+
+	pos := 1 + ((len(f) + 7 + 2) >> 3)
+	nullBitmap := p[1:pos]
+
+	for i := range f {
+		if nullBitmap[(i+2)/8]&(1<<(uint(i+2)%8)) > 0 {
+			continue
+		}
+
+		size := 0
+		switch f[i].Type {
+		case MYSQL_TYPE_NULL:
+			continue
+		case MYSQL_TYPE_TINY:
+			size = 1
+		case MYSQL_TYPE_SHORT, MYSQL_TYPE_YEAR:
+			size = 2
+		case MYSQL_TYPE_INT24, MYSQL_TYPE_LONG, MYSQL_TYPE_FLOAT:
+			size = 4
+		case MYSQL_TYPE_LONGLONG, MYSQL_TYPE_DOUBLE:
+			size = 8
+		case MYSQL_TYPE_DECIMAL, MYSQL_TYPE_NEWDECIMAL, MYSQL_TYPE_VARCHAR,
+			MYSQL_TYPE_BIT, MYSQL_TYPE_ENUM, MYSQL_TYPE_SET, MYSQL_TYPE_TINY_BLOB,
+			MYSQL_TYPE_MEDIUM_BLOB, MYSQL_TYPE_LONG_BLOB, MYSQL_TYPE_BLOB,
+			MYSQL_TYPE_VAR_STRING, MYSQL_TYPE_STRING, MYSQL_TYPE_GEOMETRY,
+			MYSQL_TYPE_DATE, MYSQL_TYPE_NEWDATE, MYSQL_TYPE_TIMESTAMP,
+			MYSQL_TYPE_DATETIME, MYSQL_TYPE_TIME:
+			num, isNull, n := LengthEncodedInt(p[pos:])
+			pos += n
+			if isNull {
+				continue
+			}
+			size = int(num)
+		default:
+			return nil, fmt.Errorf("Stmt Unknown FieldType %d %s", f[i].Type, f[i].Name)
+		}
+
+		if pos+size > len(p) {
+			return nil, ErrMalformPacket
+		}
+		cells[i] = p[pos : pos+size]
+		pos += size
+	}
+
+	return cells, nil
+}
+
+// NewRowData builds a row of the raw cell values, it is the reverse of
+// Cells.
+func NewRowData(f []*Field, cells [][]byte, binary bool) RowData {
+	if !binary {
+		var p RowData
+		for _, v := range cells {
+			if v == nil {
+				p = append(p, 0xfb)
+			} else {
+				p = append(p, PutLengthEncodedString(v)...)
+			}
+		}
+		return p
+	}
+
+	pos := 1 + ((len(f) + 7 + 2) >> 3)
+	p := make(RowData, pos)
+	p[0] = OK_HEADER
+
+	for i, v := range cells {
+		if v == nil {
+			p[1+(i+2)/8] |= 1 << (uint(i+2) % 8)
+			continue
+		}
+
+		switch f[i].Type {
+		case MYSQL_TYPE_TINY, MYSQL_TYPE_SHORT, MYSQL_TYPE_YEAR, MYSQL_TYPE_INT24,
+			MYSQL_TYPE_LONG, MYSQL_TYPE_FLOAT, MYSQL_TYPE_LONGLONG, MYSQL_TYPE_DOUBLE:
+			p = append(p, v...)
+		default:
+			p = append(p, PutLengthEncodedString(v)...)
+		}
+	}
+
+	return p
+}
+
 type Resultset struct {
 	Fields     []*Field
 	FieldNames map[string]int
 	Values     [][]interface{}
 
 	RowDatas []RowData
+
+	//returns the accounted memory of the buffered rows
+	release func()
+}
+
+// OnRelease sets f to be called by Release.
+func (r *Resultset) OnRelease(f func()) {
+	r.release = f
+}
+
+// Release tells the reader the result set is not used anymore, so memory
+// accounted for its rows can be reused by other queries.
+func (r *Resultset) Release() {
+	if r.release != nil {
+		r.release()
+	}
+}
+
+// Copy returns a deep copy of r, changing one does not change the other.
+// Values decoded by a TypeConverter are copied as they are. The copy is
+// not accounted as buffered rows, it has nothing to release.
+func (r *Resultset) Copy() *Resultset {
+	c := &Resultset{
+		Fields:     make([]*Field, len(r.Fields)),
+		FieldNames: make(map[string]int, len(r.FieldNames)),
+		Values:     make([][]interface{}, len(r.Values)),
+		RowDatas:   make([]RowData, len(r.RowDatas)),
+	}
+
+	for i, f := range r.Fields {
+		cf := *f
+		cf.Data = copyBytes(f.Data)
+		cf.Schema = copyBytes(f.Schema)
+		cf.Table = copyBytes(f.Table)
+		cf.OrgTable = copyBytes(f.OrgTable)
+		cf.Name = copyBytes(f.Name)
+		cf.OrgName = copyBytes(f.OrgName)
+		cf.DefaultValue = copyBytes(f.DefaultValue)
+		c.Fields[i] = &cf
+	}
+
+	for name, i := range r.FieldNames {
+		c.FieldNames[name] = i
+	}
+
+	for i, row := range r.Values {
+		c.Values[i] = make([]interface{}, len(row))
+		for j, v := range row {
+			if b, ok := v.([]byte); ok {
+				v = copyBytes(b)
+			}
+			c.Values[i][j] = v
+		}
+	}
+
+	for i, p := range r.RowDatas {
+		c.RowDatas[i] = copyBytes(p)
+	}
+
+	return c
 }
 
 func (r *Resultset) RowNumber() int {
@@ -391,3 +564,184 @@ func (r *Resultset) GetStringByName(row int, name string) (string, error) {
 		return r.GetString(row, column)
 	}
 }
+
+func isNumericType(tp uint8) bool {
+	switch tp {
+	case MYSQL_TYPE_TINY, MYSQL_TYPE_SHORT, MYSQL_TYPE_INT24, MYSQL_TYPE_LONG,
+		MYSQL_TYPE_LONGLONG, MYSQL_TYPE_YEAR, MYSQL_TYPE_FLOAT, MYSQL_TYPE_DOUBLE,
+		MYSQL_TYPE_DECIMAL, MYSQL_TYPE_NEWDECIMAL:
+		return true
+	}
+	return false
+}
+
+// GetJSONNumber returns a numeric column as json.Number without going
+// through float64, so a BIGINT or DECIMAL keeps its exact digits when
+// encoded to JSON. It returns an error for a non numeric column, and an
+// empty json.Number for NULL.
+func (r *Resultset) GetJSONNumber(row, column int) (json.Number, error) {
+	d, err := r.GetValue(row, column)
+	if err != nil {
+		return "", err
+	}
+
+	f := r.Fields[column]
+	if !isNumericType(f.Type) {
+		return "", fmt.Errorf("column %s type %d is not numeric", f.Name, f.Type)
+	}
+
+	switch v := d.(type) {
+	case int64:
+		return json.Number(strconv.FormatInt(v, 10)), nil
+	case uint64:
+		return json.Number(strconv.FormatUint(v, 10)), nil
+	case float64:
+		bitSize := 64
+		if f.Type == MYSQL_TYPE_FLOAT {
+			bitSize = 32
+		}
+		return json.Number(strconv.FormatFloat(v, 'g', -1, bitSize)), nil
+	case string:
+		return json.Number(v), nil
+	case []byte:
+		return json.Number(v), nil
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("data type is %T", v)
+	}
+}
+
+func (r *Resultset) GetJSONNumberByName(row int, name string) (json.Number, error) {
+	if column, err := r.NameIndex(name); err != nil {
+		return "", err
+	} else {
+		return r.GetJSONNumber(row, column)
+	}
+}
+
+// RowValues returns every column of row as its natural Go type,
+// nil if row is out of range. The mapping from field type is:
+//
+//	NULL                                       nil
+//	TINYINT, SMALLINT, MEDIUMINT, INT, BIGINT  int64, uint64 if unsigned
+//	YEAR                                       int64
+//	FLOAT, DOUBLE                              float64
+//	DECIMAL                                    string, keep the precision
+//	DATE, DATETIME, TIMESTAMP                  time.Time in UTC, zero time for 0000-00-00
+//	TIME                                       string, may be out of a day range
+//	BIT(1)                                     bool
+//	BIT(n), GEOMETRY                           []byte
+//	CHAR, VARCHAR, TEXT, ENUM, SET             string
+//	BINARY, VARBINARY, BLOB                    []byte
+//
+// A value which can not be converted is returned as it is.
+func (r *Resultset) RowValues(row int) []interface{} {
+	if row >= len(r.Values) || row < 0 {
+		return nil
+	}
+
+	vs := make([]interface{}, len(r.Fields))
+	for i, f := range r.Fields {
+		vs[i] = naturalValue(f, r.Values[row][i])
+	}
+	return vs
+}
+
+func naturalValue(f *Field, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	b, isBytes := v.([]byte)
+	if !isBytes {
+		//int64, uint64 or float64 already parsed
+		if f.Type == MYSQL_TYPE_YEAR {
+			if u, ok := v.(uint64); ok {
+				return int64(u)
+			}
+		}
+		return v
+	}
+
+	isUnsigned := f.Flag&UNSIGNED_FLAG > 0
+
+	switch f.Type {
+	case MYSQL_TYPE_TINY, MYSQL_TYPE_SHORT, MYSQL_TYPE_INT24,
+		MYSQL_TYPE_LONG, MYSQL_TYPE_LONGLONG, MYSQL_TYPE_YEAR:
+		if isUnsigned && f.Type != MYSQL_TYPE_YEAR {
+			if n, err := strconv.ParseUint(hack.String(b), 10, 64); err == nil {
+				return n
+			}
+		} else if n, err := strconv.ParseInt(hack.String(b), 10, 64); err == nil {
+			return n
+		}
+	case MYSQL_TYPE_FLOAT, MYSQL_TYPE_DOUBLE:
+		if n, err := strconv.ParseFloat(hack.String(b), 64); err == nil {
+			return n
+		}
+	case MYSQL_TYPE_DATE, MYSQL_TYPE_NEWDATE, MYSQL_TYPE_DATETIME, MYSQL_TYPE_TIMESTAMP:
+		if t, err := parseDateTime(string(b)); err == nil {
+			return t
+		}
+	case MYSQL_TYPE_BIT:
+		if f.ColumnLength == 1 && len(b) == 1 {
+			return b[0] != 0
+		}
+		return copyBytes(b)
+	case MYSQL_TYPE_DECIMAL, MYSQL_TYPE_NEWDECIMAL, MYSQL_TYPE_TIME:
+		return string(b)
+	case MYSQL_TYPE_GEOMETRY:
+		return copyBytes(b)
+	case MYSQL_TYPE_VARCHAR, MYSQL_TYPE_VAR_STRING, MYSQL_TYPE_STRING,
+		MYSQL_TYPE_ENUM, MYSQL_TYPE_SET, MYSQL_TYPE_TINY_BLOB,
+		MYSQL_TYPE_MEDIUM_BLOB, MYSQL_TYPE_LONG_BLOB, MYSQL_TYPE_BLOB:
+		if f.Charset == uint16(BINARY_COLLATION_ID) {
+			return copyBytes(b)
+		}
+		return string(b)
+	}
+
+	return copyBytes(b)
+}
+
+func parseDateTime(s string) (time.Time, error) {
+	if strings.HasPrefix(s, "0000-00-00") {
+		return time.Time{}, nil
+	}
+
+	if len(s) == len("2006-01-02") {
+		return time.ParseInLocation("2006-01-02", s, time.UTC)
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05.999999", s, time.UTC)
+}
+
+// copyBytes copies b, nil stays nil
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
+
+// MergeResultsets appends rows of the other resultsets to the first one,
+// all resultsets must have the same column number.
+func MergeResultsets(rs ...*Resultset) (*Resultset, error) {
+	if len(rs) == 0 {
+		return nil, fmt.Errorf("no resultset to merge")
+	}
+
+	r := rs[0]
+	for i := 1; i < len(rs); i++ {
+		if len(rs[i].Fields) != len(r.Fields) {
+			return nil, fmt.Errorf("resultset %d has %d columns not equal %d", i, len(rs[i].Fields), len(r.Fields))
+		}
+
+		r.Values = append(r.Values, rs[i].Values...)
+		r.RowDatas = append(r.RowDatas, rs[i].RowDatas...)
+	}
+
+	return r, nil
+}
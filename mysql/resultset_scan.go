@@ -0,0 +1,254 @@
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+var (
+	bytesType = reflect.TypeOf([]byte(nil))
+	timeType  = reflect.TypeOf(time.Time{})
+)
+
+// ScanRow copies the columns of row into dest in order, a pointer for
+// every column. A column is converted from its type in RowValues to the
+// type of its destination:
+//
+//	*interface{}              the value as is, nil for NULL
+//	*string, *[]byte          the text of any value, a DATETIME like 2006-01-02 15:04:05.999999
+//	*int, ..., *int64         an integer, or a DECIMAL, a float or a text holding one
+//	*uint, ..., *uint64       likewise, not negative
+//	*float32, *float64        any number, a DECIMAL or a BIGINT may lose precision
+//	*bool                     BIT(1), or a number not 0
+//	*time.Time                a DATE, DATETIME or TIMESTAMP in UTC
+//	sql.Scanner               like *sql.NullString, the value as is, with NULL
+//	**T                       nil for NULL, else a new T as above
+//
+// A NULL into another destination is an error wrapping ErrNullValue. An
+// integer out of the range of its destination, or a value which is not of
+// the destination kind, like a DECIMAL 1.5 into an int, is an error. TIME
+// is a text only, as it may be out of a day range.
+func (r *Resultset) ScanRow(row int, dest ...interface{}) error {
+	if row >= len(r.Values) || row < 0 {
+		return fmt.Errorf("invalid row index %d", row)
+	}
+	if len(dest) != len(r.Fields) {
+		return fmt.Errorf("%d destinations for %d columns", len(dest), len(r.Fields))
+	}
+
+	for i, f := range r.Fields {
+		if err := scanValue(naturalValue(f, r.Values[row][i]), dest[i]); err != nil {
+			return fmt.Errorf("column %s: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+func scanValue(v interface{}, dest interface{}) error {
+	if s, ok := dest.(sql.Scanner); ok {
+		return s.Scan(v)
+	}
+
+	p := reflect.ValueOf(dest)
+	if p.Kind() != reflect.Ptr || p.IsNil() {
+		return fmt.Errorf("destination %T is not a pointer", dest)
+	}
+	dv := p.Elem()
+
+	switch {
+	case dv.Kind() == reflect.Interface && dv.NumMethod() == 0:
+		if v == nil {
+			dv.Set(reflect.Zero(dv.Type()))
+		} else {
+			dv.Set(reflect.ValueOf(v))
+		}
+		return nil
+	case dv.Kind() == reflect.Ptr:
+		if v == nil {
+			dv.Set(reflect.Zero(dv.Type()))
+			return nil
+		}
+		n := reflect.New(dv.Type().Elem())
+		if err := scanValue(v, n.Interface()); err != nil {
+			return err
+		}
+		dv.Set(n)
+		return nil
+	case v == nil:
+		return ErrNullValue
+	}
+
+	switch {
+	case dv.Kind() == reflect.String:
+		dv.SetString(valueString(v))
+	case dv.Type() == bytesType:
+		dv.SetBytes([]byte(valueString(v)))
+	case dv.Type() == timeType:
+		t, ok := v.(time.Time)
+		if !ok {
+			return fmt.Errorf("%T is not a time", v)
+		}
+		dv.Set(reflect.ValueOf(t))
+	case dv.Kind() == reflect.Bool:
+		b, err := valueBool(v)
+		if err != nil {
+			return err
+		}
+		dv.SetBool(b)
+	case dv.Kind() >= reflect.Int && dv.Kind() <= reflect.Int64:
+		n, err := valueInt(v)
+		if err != nil {
+			return err
+		} else if dv.OverflowInt(n) {
+			return fmt.Errorf("%d overflows %s", n, dv.Type())
+		}
+		dv.SetInt(n)
+	case dv.Kind() >= reflect.Uint && dv.Kind() <= reflect.Uint64:
+		n, err := valueUint(v)
+		if err != nil {
+			return err
+		} else if dv.OverflowUint(n) {
+			return fmt.Errorf("%d overflows %s", n, dv.Type())
+		}
+		dv.SetUint(n)
+	case dv.Kind() == reflect.Float32 || dv.Kind() == reflect.Float64:
+		n, err := valueFloat(v)
+		if err != nil {
+			return err
+		}
+		dv.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported destination %T", dest)
+	}
+	return nil
+}
+
+// valueString returns the text of a value of RowValues
+func valueString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case time.Time:
+		if v.IsZero() {
+			return "0000-00-00 00:00:00"
+		}
+		return v.Format("2006-01-02 15:04:05.999999")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func valueInt(v interface{}) (int64, error) {
+	switch v := v.(type) {
+	case int64:
+		return v, nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, fmt.Errorf("%d overflows int64", v)
+		}
+		return int64(v), nil
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return 0, fmt.Errorf("%v is not an int64", v)
+		}
+		return int64(v), nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string, []byte:
+		s := valueString(v)
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n, nil
+		}
+		//a DECIMAL like 10.00
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return valueInt(f)
+		}
+		return 0, fmt.Errorf("%q is not an integer", s)
+	default:
+		return 0, fmt.Errorf("%T is not an integer", v)
+	}
+}
+
+func valueUint(v interface{}) (uint64, error) {
+	switch v := v.(type) {
+	case uint64:
+		return v, nil
+	case float64:
+		if v != math.Trunc(v) || v < 0 || v >= math.MaxUint64 {
+			return 0, fmt.Errorf("%v is not an uint64", v)
+		}
+		return uint64(v), nil
+	case string, []byte:
+		s := valueString(v)
+		if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return n, nil
+		}
+	}
+
+	n, err := valueInt(v)
+	if err != nil {
+		return 0, err
+	} else if n < 0 {
+		return 0, fmt.Errorf("%d is negative", n)
+	}
+	return uint64(n), nil
+}
+
+func valueFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case string, []byte:
+		s := valueString(v)
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", s)
+		}
+		return f, nil
+	default:
+		return 0, fmt.Errorf("%T is not a number", v)
+	}
+}
+
+func valueBool(v interface{}) (bool, error) {
+	switch v := v.(type) {
+	case bool:
+		return v, nil
+	case int64, uint64, float64:
+		f, _ := valueFloat(v)
+		return f != 0, nil
+	case string, []byte:
+		s := valueString(v)
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return false, fmt.Errorf("%q is not a bool", s)
+		}
+		return b, nil
+	default:
+		return false, fmt.Errorf("%T is not a bool", v)
+	}
+}
@@ -0,0 +1,124 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newScanResultset() *Resultset {
+	r := new(Resultset)
+
+	r.Fields = []*Field{
+		&Field{Name: []byte("id"), Type: MYSQL_TYPE_LONG},
+		&Field{Name: []byte("name"), Type: MYSQL_TYPE_VAR_STRING, Charset: uint16(DEFAULT_COLLATION_ID)},
+		&Field{Name: []byte("price"), Type: MYSQL_TYPE_NEWDECIMAL},
+		&Field{Name: []byte("created"), Type: MYSQL_TYPE_DATETIME},
+		&Field{Name: []byte("active"), Type: MYSQL_TYPE_BIT, ColumnLength: 1},
+		&Field{Name: []byte("note"), Type: MYSQL_TYPE_VAR_STRING, Charset: uint16(DEFAULT_COLLATION_ID)},
+	}
+
+	r.Values = [][]interface{}{
+		[]interface{}{
+			[]byte("-10"),
+			[]byte("abc"),
+			[]byte("12.50"),
+			[]byte("2014-09-01 10:20:30"),
+			[]byte{1},
+			nil,
+		},
+	}
+	return r
+}
+
+func TestResultsetScanRow(t *testing.T) {
+	r := newScanResultset()
+
+	var (
+		id      int32
+		name    string
+		price   float64
+		created time.Time
+		active  bool
+		note    *string
+	)
+	if err := r.ScanRow(0, &id, &name, &price, &created, &active, &note); err != nil {
+		t.Fatal(err)
+	}
+	if id != -10 || name != "abc" || price != 12.5 || !active || note != nil {
+		t.Fatal(id, name, price, active, note)
+	}
+	if !created.Equal(time.Date(2014, 9, 1, 10, 20, 30, 0, time.UTC)) {
+		t.Fatal(created)
+	}
+
+	//the text of any value, and the scanners of database/sql
+	var (
+		sid      string
+		sprice   []byte
+		screated string
+		sactive  uint8
+		nname    sql.NullString
+		nnote    sql.NullString
+	)
+	if err := r.ScanRow(0, &sid, &nname, &sprice, &screated, &sactive, &nnote); err != nil {
+		t.Fatal(err)
+	}
+	if sid != "-10" || string(sprice) != "12.50" || screated != "2014-09-01 10:20:30" || sactive != 1 {
+		t.Fatal(sid, string(sprice), screated, sactive)
+	}
+	if !nname.Valid || nname.String != "abc" || nnote.Valid {
+		t.Fatal(nname, nnote)
+	}
+
+	var v interface{}
+	if err := r.ScanRow(0, &v, &v, &v, &v, &v, &v); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatal(v)
+	}
+}
+
+func TestResultsetScanRowErrors(t *testing.T) {
+	r := newScanResultset()
+
+	var (
+		id      int64
+		name    string
+		price   float64
+		created time.Time
+		active  bool
+		note    string
+	)
+
+	//NULL into a string
+	if err := r.ScanRow(0, &id, &name, &price, &created, &active, &note); !errors.Is(err, ErrNullValue) {
+		t.Fatal(err)
+	}
+
+	//not an integer, negative, out of range
+	var n int64
+	var u uint64
+	var small int8
+	if err := r.ScanRow(0, &id, &name, &n, &created, &active, new(*string)); err == nil {
+		t.Fatal("12.50 scanned into an int64")
+	}
+	if err := r.ScanRow(0, &u, &name, &price, &created, &active, new(*string)); err == nil {
+		t.Fatal("-10 scanned into an uint64")
+	}
+	r.Values[0][0] = []byte("1000")
+	if err := r.ScanRow(0, &small, &name, &price, &created, &active, new(*string)); err == nil {
+		t.Fatal("1000 scanned into an int8")
+	}
+
+	if err := r.ScanRow(0, &id, &name); err == nil {
+		t.Fatal("too few destinations")
+	}
+	if err := r.ScanRow(1, &id, &name, &price, &created, &active, &note); err == nil {
+		t.Fatal("invalid row")
+	}
+	if err := r.ScanRow(0, id, &name, &price, &created, &active, new(*string)); err == nil {
+		t.Fatal("not a pointer")
+	}
+}
@@ -0,0 +1,248 @@
+package mysql
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResultsetRowValues(t *testing.T) {
+	r := new(Resultset)
+
+	r.Fields = []*Field{
+		&Field{Type: MYSQL_TYPE_LONG},
+		&Field{Type: MYSQL_TYPE_LONGLONG, Flag: UNSIGNED_FLAG},
+		&Field{Type: MYSQL_TYPE_NEWDECIMAL},
+		&Field{Type: MYSQL_TYPE_DATETIME},
+		&Field{Type: MYSQL_TYPE_DATE},
+		&Field{Type: MYSQL_TYPE_BIT, ColumnLength: 1},
+		&Field{Type: MYSQL_TYPE_VAR_STRING, Charset: uint16(DEFAULT_COLLATION_ID)},
+		&Field{Type: MYSQL_TYPE_BLOB, Charset: uint16(BINARY_COLLATION_ID)},
+		&Field{Type: MYSQL_TYPE_DOUBLE},
+		&Field{Type: MYSQL_TYPE_NULL},
+	}
+
+	r.Values = [][]interface{}{
+		[]interface{}{
+			[]byte("-10"),
+			uint64(10),
+			[]byte("1.20"),
+			[]byte("2014-09-01 10:20:30.5"),
+			[]byte("0000-00-00"),
+			[]byte{1},
+			[]byte("abc"),
+			[]byte("abc"),
+			float64(1.5),
+			nil,
+		},
+	}
+
+	expect := []interface{}{
+		int64(-10),
+		uint64(10),
+		"1.20",
+		time.Date(2014, 9, 1, 10, 20, 30, 500000000, time.UTC),
+		time.Time{},
+		true,
+		"abc",
+		[]byte("abc"),
+		float64(1.5),
+		nil,
+	}
+
+	if vs := r.RowValues(0); !reflect.DeepEqual(vs, expect) {
+		t.Fatalf("%#v != %#v", vs, expect)
+	}
+
+	if r.RowValues(-1) != nil || r.RowValues(1) != nil {
+		t.Fatal("must nil for invalid row")
+	}
+}
+
+func TestResultsetJSONNumber(t *testing.T) {
+	r := new(Resultset)
+
+	r.Fields = []*Field{
+		&Field{Name: []byte("d"), Type: MYSQL_TYPE_NEWDECIMAL},
+		&Field{Name: []byte("u"), Type: MYSQL_TYPE_LONGLONG, Flag: UNSIGNED_FLAG},
+		&Field{Name: []byte("f"), Type: MYSQL_TYPE_FLOAT},
+		&Field{Name: []byte("s"), Type: MYSQL_TYPE_VAR_STRING},
+		&Field{Name: []byte("n"), Type: MYSQL_TYPE_DOUBLE},
+	}
+	r.FieldNames = map[string]int{"d": 0, "u": 1, "f": 2, "s": 3, "n": 4}
+
+	r.Values = [][]interface{}{
+		[]interface{}{
+			[]byte("12345678901234567890.0123456789"),
+			uint64(18446744073709551615),
+			float64(float32(1.1)),
+			[]byte("123"),
+			nil,
+		},
+	}
+
+	expect := []json.Number{"12345678901234567890.0123456789", "18446744073709551615", "1.1"}
+	for i, e := range expect {
+		if n, err := r.GetJSONNumber(0, i); err != nil {
+			t.Fatal(err)
+		} else if n != e {
+			t.Fatal(i, n)
+		}
+	}
+
+	if _, err := r.GetJSONNumberByName(0, "s"); err == nil {
+		t.Fatal("must error for string column")
+	}
+
+	if n, err := r.GetJSONNumberByName(0, "n"); err != nil || n != "" {
+		t.Fatal(n, err)
+	}
+
+	d, _ := r.GetJSONNumber(0, 0)
+	if b, err := json.Marshal(map[string]json.Number{"d": d}); err != nil {
+		t.Fatal(err)
+	} else if string(b) != `{"d":12345678901234567890.0123456789}` {
+		t.Fatal(string(b))
+	}
+}
+
+func TestRowDataCells(t *testing.T) {
+	f := []*Field{
+		&Field{Type: MYSQL_TYPE_LONGLONG},
+		&Field{Type: MYSQL_TYPE_VAR_STRING},
+		&Field{Type: MYSQL_TYPE_SHORT},
+		&Field{Type: MYSQL_TYPE_DATE},
+		&Field{Type: MYSQL_TYPE_VAR_STRING},
+	}
+
+	//the third column is NULL
+	row := RowData{OK_HEADER, 1 << 4}
+	row = append(row, 7, 0, 0, 0, 0, 0, 0, 0)
+	row = append(row, PutLengthEncodedString([]byte("ulid"))...)
+	row = append(row, 4, 0xde, 0x07, 9, 1)
+	row = append(row, 0)
+
+	cells, err := row.Cells(f, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := [][]byte{{7, 0, 0, 0, 0, 0, 0, 0}, []byte("ulid"), nil, {0xde, 0x07, 9, 1}, {}}
+	if !reflect.DeepEqual(cells, expect) {
+		t.Fatalf("%v != %v", cells, expect)
+	}
+	if p := NewRowData(f, cells, true); !bytes.Equal(p, row) {
+		t.Fatalf("%v != %v", p, row)
+	}
+
+	text := RowData(PutLengthEncodedString([]byte("7")))
+	text = append(text, PutLengthEncodedString([]byte("ulid"))...)
+	text = append(text, 0xfb)
+	text = append(text, PutLengthEncodedString([]byte("2014-09-01"))...)
+	text = append(text, 0)
+
+	if cells, err = text.Cells(f, false); err != nil {
+		t.Fatal(err)
+	}
+
+	expect = [][]byte{[]byte("7"), []byte("ulid"), nil, []byte("2014-09-01"), {}}
+	if !reflect.DeepEqual(cells, expect) {
+		t.Fatalf("%v != %v", cells, expect)
+	}
+	if p := NewRowData(f, cells, false); !bytes.Equal(p, text) {
+		t.Fatalf("%v != %v", p, text)
+	}
+}
+
+// benchRowFields are the columns of a typical row, id, counters, names
+// and a timestamp
+var benchRowFields = []*Field{
+	&Field{Type: MYSQL_TYPE_LONGLONG},
+	&Field{Type: MYSQL_TYPE_LONG},
+	&Field{Type: MYSQL_TYPE_VAR_STRING},
+	&Field{Type: MYSQL_TYPE_VAR_STRING},
+	&Field{Type: MYSQL_TYPE_DOUBLE},
+	&Field{Type: MYSQL_TYPE_DATETIME},
+	&Field{Type: MYSQL_TYPE_TINY},
+	&Field{Type: MYSQL_TYPE_BLOB},
+}
+
+func benchTextRow() RowData {
+	var row RowData
+	for _, v := range []string{"1234567890", "42", "alice", "alice@example.com",
+		"3.1415", "2014-09-01 10:20:30", "1", "some longer text in a blob column"} {
+		row = append(row, PutLengthEncodedString([]byte(v))...)
+	}
+	return row
+}
+
+func benchBinaryRow() RowData {
+	row := RowData{OK_HEADER, 0, 0}
+	row = append(row, 0xd2, 0x02, 0x96, 0x49, 0, 0, 0, 0)
+	row = append(row, 42, 0, 0, 0)
+	row = append(row, PutLengthEncodedString([]byte("alice"))...)
+	row = append(row, PutLengthEncodedString([]byte("alice@example.com"))...)
+	row = append(row, 0x6f, 0x12, 0x83, 0xc0, 0xca, 0x21, 0x09, 0x40)
+	row = append(row, 7, 0xde, 0x07, 9, 1, 10, 20, 30)
+	row = append(row, 1)
+	row = append(row, PutLengthEncodedString([]byte("some longer text in a blob column"))...)
+	return row
+}
+
+func BenchmarkRowDataParseText(b *testing.B) {
+	row := benchTextRow()
+
+	b.SetBytes(int64(len(row)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := row.ParseText(benchRowFields); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRowDataParseBinary(b *testing.B) {
+	row := benchBinaryRow()
+
+	b.SetBytes(int64(len(row)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := row.ParseBinary(benchRowFields); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestResultsetCopy(t *testing.T) {
+	r := new(Resultset)
+	r.Fields = []*Field{
+		&Field{Name: []byte("id"), Type: MYSQL_TYPE_LONGLONG},
+		&Field{Name: []byte("name"), Type: MYSQL_TYPE_VAR_STRING, Charset: uint16(DEFAULT_COLLATION_ID)},
+	}
+	r.FieldNames = map[string]int{"id": 0, "name": 1}
+	r.Values = [][]interface{}{{int64(1), []byte("a")}, {int64(2), nil}}
+	r.RowDatas = []RowData{NewRowData(r.Fields, [][]byte{[]byte("1"), []byte("a")}, false),
+		NewRowData(r.Fields, [][]byte{[]byte("2"), nil}, false)}
+
+	c := r.Copy()
+	if !reflect.DeepEqual(c, r) {
+		t.Fatalf("%+v != %+v", c, r)
+	}
+
+	c.Fields[1].Name[0] = 'N'
+	c.FieldNames["other"] = 2
+	c.Values[0][0] = int64(10)
+	c.Values[0][1].([]byte)[0] = 'b'
+	c.RowDatas[0][1] = '9'
+
+	if string(r.Fields[1].Name) != "name" || len(r.FieldNames) != 2 || r.Values[0][0] != int64(1) ||
+		string(r.Values[0][1].([]byte)) != "a" || r.RowDatas[0][1] != '1' {
+		t.Fatalf("%+v", r)
+	}
+}
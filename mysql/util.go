@@ -58,40 +58,51 @@ func RandomBuf(size int) []byte {
 	return buf
 }
 
-func LengthEncodedInt(b []byte) (num uint64, isNull bool, n int) {
+// LengthEncodedInt reads a length encoded integer, n is the bytes it takes
+// and may be over len(b) if b is truncated.
+func LengthEncodedInt(b []byte) (uint64, bool, int) {
+	//0-250: value of first byte
+	if len(b) > 0 && b[0] < 0xfb {
+		return uint64(b[0]), false, 1
+	}
+	return lengthEncodedIntLong(b)
+}
+
+func lengthEncodedIntLong(b []byte) (num uint64, isNull bool, n int) {
+	if len(b) == 0 {
+		return 0, false, 1
+	}
+
 	switch b[0] {
 
 	// 251: NULL
 	case 0xfb:
-		n = 1
-		isNull = true
-		return
+		return 0, true, 1
 
 	// 252: value of following 2
 	case 0xfc:
-		num = uint64(b[1]) | uint64(b[2])<<8
-		n = 3
-		return
+		if len(b) < 3 {
+			return 0, false, 3
+		}
+		return uint64(binary.LittleEndian.Uint16(b[1:3])), false, 3
 
 	// 253: value of following 3
 	case 0xfd:
-		num = uint64(b[1]) | uint64(b[2])<<8 | uint64(b[3])<<16
-		n = 4
-		return
+		if len(b) < 4 {
+			return 0, false, 4
+		}
+		return uint64(b[1]) | uint64(b[2])<<8 | uint64(b[3])<<16, false, 4
 
 	// 254: value of following 8
 	case 0xfe:
-		num = uint64(b[1]) | uint64(b[2])<<8 | uint64(b[3])<<16 |
-			uint64(b[4])<<24 | uint64(b[5])<<32 | uint64(b[6])<<40 |
-			uint64(b[7])<<48 | uint64(b[8])<<56
-		n = 9
-		return
+		if len(b) < 9 {
+			return 0, false, 9
+		}
+		return binary.LittleEndian.Uint64(b[1:9]), false, 9
 	}
 
-	// 0-250: value of first byte
-	num = uint64(b[0])
-	n = 1
-	return
+	//255 is not a length
+	return uint64(b[0]), false, 1
 }
 
 func PutLengthEncodedInt(n uint64) []byte {
@@ -112,36 +123,50 @@ func PutLengthEncodedInt(n uint64) []byte {
 	return nil
 }
 
+// LengthEnodedString reads a length encoded string, it returns io.EOF if b
+// is truncated. An empty string is nil.
 func LengthEnodedString(b []byte) ([]byte, bool, int, error) {
-	// Get length
-	num, isNull, n := LengthEncodedInt(b)
-	if num < 1 {
-		return nil, isNull, n, nil
+	//short strings of a 1 byte length first
+	if len(b) > 0 && b[0] < 0xfb {
+		end := 1 + int(b[0])
+		if end > len(b) {
+			return nil, false, end, io.EOF
+		} else if end == 1 {
+			return nil, false, 1, nil
+		}
+		return b[1:end], false, end, nil
 	}
 
-	n += int(num)
-
-	// Check data length
-	if len(b) >= n {
-		return b[n-int(num) : n], false, n, nil
+	num, isNull, n := lengthEncodedIntLong(b)
+	if n > len(b) {
+		return nil, false, n, io.EOF
+	} else if num < 1 {
+		return nil, isNull, n, nil
+	} else if num > uint64(len(b)-n) {
+		return nil, false, len(b) + 1, io.EOF
 	}
-	return nil, false, n, io.EOF
+
+	end := n + int(num)
+	return b[n:end], false, end, nil
 }
 
+// SkipLengthEnodedString returns the bytes a length encoded string takes.
 func SkipLengthEnodedString(b []byte) (int, error) {
-	// Get length
-	num, _, n := LengthEncodedInt(b)
-	if num < 1 {
-		return n, nil
+	if len(b) > 0 && b[0] < 0xfb {
+		end := 1 + int(b[0])
+		if end > len(b) {
+			return end, io.EOF
+		}
+		return end, nil
 	}
 
-	n += int(num)
-
-	// Check data length
-	if len(b) >= n {
-		return n, nil
+	num, _, n := lengthEncodedIntLong(b)
+	if n > len(b) {
+		return n, io.EOF
+	} else if num > uint64(len(b)-n) {
+		return len(b) + 1, io.EOF
 	}
-	return n, io.EOF
+	return n + int(num), nil
 }
 
 func PutLengthEncodedString(b []byte) []byte {
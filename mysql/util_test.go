@@ -0,0 +1,179 @@
+package mysql
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+)
+
+// refLengthEncodedInt is the plain reading of the protocol the optimized
+// reader is checked against
+func refLengthEncodedInt(b []byte) (num uint64, isNull bool, n int) {
+	switch b[0] {
+	case 0xfb:
+		return 0, true, 1
+	case 0xfc:
+		n = 3
+	case 0xfd:
+		n = 4
+	case 0xfe:
+		n = 9
+	default:
+		return uint64(b[0]), false, 1
+	}
+
+	for i := n - 1; i > 0; i-- {
+		num = num<<8 | uint64(b[i])
+	}
+	return num, false, n
+}
+
+var testLengthEncodedInts = []uint64{0, 1, 250, 251, 252, 0xffff, 0x10000,
+	0xffffff, 0x1000000, math.MaxUint32, math.MaxUint64}
+
+func TestLengthEncodedInt(t *testing.T) {
+	for _, v := range testLengthEncodedInts {
+		b := PutLengthEncodedInt(v)
+		if num, isNull, n := LengthEncodedInt(b); num != v || isNull || n != len(b) {
+			t.Fatal(v, num, isNull, n)
+		}
+	}
+
+	if _, isNull, n := LengthEncodedInt([]byte{0xfb}); !isNull || n != 1 {
+		t.Fatal("must null")
+	}
+
+	//truncated, n is over the data
+	for _, b := range [][]byte{{}, {0xfc, 1}, {0xfd, 1, 2}, {0xfe, 1, 2, 3}} {
+		if _, _, n := LengthEncodedInt(b); n <= len(b) {
+			t.Fatal(b, n)
+		}
+	}
+}
+
+func TestLengthEncodedString(t *testing.T) {
+	for _, size := range []int{0, 1, 250, 251, 0x10000} {
+		s := bytes.Repeat([]byte{'a'}, size)
+		b := append(PutLengthEncodedString(s), 'x')
+
+		v, isNull, n, err := LengthEnodedString(b)
+		if err != nil || isNull || n != len(b)-1 || !bytes.Equal(v, s) {
+			t.Fatal(size, err, isNull, n)
+		}
+
+		if m, err := SkipLengthEnodedString(b); err != nil || m != n {
+			t.Fatal(size, err, m)
+		}
+
+		if size > 0 {
+			if _, _, _, err = LengthEnodedString(b[:n-1]); err != io.EOF {
+				t.Fatal(size, err)
+			}
+			if _, err = SkipLengthEnodedString(b[:n-1]); err != io.EOF {
+				t.Fatal(size, err)
+			}
+		}
+	}
+
+	//truncated length and a length over the int range
+	for _, b := range [][]byte{{0xfc, 1}, {0xfe, 0, 0, 0, 0, 0, 0, 0, 0x80}} {
+		if _, _, _, err := LengthEnodedString(b); err != io.EOF {
+			t.Fatal(b, err)
+		}
+		if _, err := SkipLengthEnodedString(b); err != io.EOF {
+			t.Fatal(b, err)
+		}
+	}
+}
+
+func FuzzLengthEncodedInt(f *testing.F) {
+	for _, v := range testLengthEncodedInts {
+		f.Add(PutLengthEncodedInt(v))
+	}
+	f.Add([]byte{0xfb})
+	f.Add([]byte{0xfe, 1})
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		num, isNull, n := LengthEncodedInt(b)
+		if n > len(b) {
+			return
+		}
+
+		refNum, refNull, refN := refLengthEncodedInt(b)
+		if num != refNum || isNull != refNull || n != refN {
+			t.Fatal(b, num, isNull, n)
+		}
+
+		if !isNull && b[0] != 0xff {
+			if e := PutLengthEncodedInt(num); len(e) > n {
+				t.Fatal(b, e)
+			}
+		}
+	})
+}
+
+func FuzzLengthEncodedString(f *testing.F) {
+	f.Add([]byte{0})
+	f.Add([]byte{3, 'a', 'b', 'c'})
+	f.Add([]byte{0xfc, 2, 0, 'a'})
+	f.Add([]byte{0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		v, isNull, n, err := LengthEnodedString(b)
+		m, skipErr := SkipLengthEnodedString(b)
+		if err != skipErr {
+			t.Fatal(b, err, skipErr)
+		} else if err != nil {
+			return
+		}
+
+		if n > len(b) || m != n {
+			t.Fatal(b, n, m)
+		}
+
+		num, null, l := refLengthEncodedInt(b)
+		if isNull != null || uint64(len(v)) != num || !bytes.Equal(v, b[l:n]) {
+			t.Fatal(b, v, isNull)
+		}
+	})
+}
+
+func BenchmarkLengthEncodedInt(b *testing.B) {
+	var data []byte
+	for i := 0; i < 64; i++ {
+		//mostly small lengths like in rows
+		v := uint64(i * 3)
+		if i%8 == 0 {
+			v = uint64(i) << 16
+		}
+		data = append(data, PutLengthEncodedInt(v)...)
+	}
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for pos := 0; pos < len(data); {
+			_, _, n := LengthEncodedInt(data[pos:])
+			pos += n
+		}
+	}
+}
+
+func BenchmarkLengthEncodedString(b *testing.B) {
+	var data []byte
+	for i := 0; i < 64; i++ {
+		data = append(data, PutLengthEncodedString(bytes.Repeat([]byte{'a'}, i%32))...)
+	}
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for pos := 0; pos < len(data); {
+			_, _, n, _ := LengthEnodedString(data[pos:])
+			pos += n
+		}
+	}
+}
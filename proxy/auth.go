@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"github.com/siddontang/mixer/config"
+	. "github.com/siddontang/mixer/mysql"
+	"net"
+)
+
+var errAuthFailed = errors.New("auth failed")
+
+type handshakeResponse struct {
+	capability uint32
+	collation  CollationId
+
+	user string
+	auth []byte
+	db   string
+
+	//auth plugin the client used
+	plugin string
+}
+
+// readNullString returns the string before the next [00] from pos
+func readNullString(data []byte, pos int) (string, int, error) {
+	if pos > len(data) {
+		return "", pos, ErrMalformPacket
+	}
+
+	i := bytes.IndexByte(data[pos:], 0)
+	if i < 0 {
+		return "", pos, ErrMalformPacket
+	}
+	return string(data[pos : pos+i]), pos + i + 1, nil
+}
+
+// parseHandshakeResponse parses a protocol 41 handshake response
+func parseHandshakeResponse(data []byte) (*handshakeResponse, error) {
+	//capability 4, max packet size 4, charset 1, reserved 23
+	if len(data) < 32 {
+		return nil, ErrMalformPacket
+	}
+
+	r := new(handshakeResponse)
+	r.capability = binary.LittleEndian.Uint32(data[:4])
+	if r.capability&CLIENT_PROTOCOL_41 == 0 {
+		return nil, NewDefaultError(ER_NOT_SUPPORTED_AUTH_MODE)
+	}
+
+	r.collation = CollationId(data[8])
+	pos := 32
+
+	var err error
+	if r.user, pos, err = readNullString(data, pos); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case pos >= len(data):
+		//no auth data, empty password
+		return r, nil
+	case r.capability&CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA > 0:
+		auth, _, n, err := LengthEnodedString(data[pos:])
+		if err != nil {
+			return nil, ErrMalformPacket
+		}
+		r.auth = auth
+		pos += n
+	case r.capability&CLIENT_SECURE_CONNECTION > 0:
+		n := int(data[pos])
+		pos++
+		if pos+n > len(data) {
+			return nil, ErrMalformPacket
+		}
+		r.auth = data[pos : pos+n]
+		pos += n
+	default:
+		var auth string
+		if auth, pos, err = readNullString(data, pos); err != nil {
+			return nil, err
+		}
+		r.auth = []byte(auth)
+	}
+
+	if r.capability&CLIENT_CONNECT_WITH_DB > 0 && pos < len(data) {
+		if r.db, pos, err = readNullString(data, pos); err != nil {
+			//some clients do not terminate the last string
+			r.db, pos = string(data[pos:]), len(data)
+		}
+	}
+
+	if r.capability&CLIENT_PLUGIN_AUTH > 0 && pos < len(data) {
+		if r.plugin, pos, err = readNullString(data, pos); err != nil {
+			r.plugin, pos = string(data[pos:]), len(data)
+		}
+	}
+
+	return r, nil
+}
+
+// switchAuth sends an auth switch request to mysql_native_password with
+// the same salt and returns the client auth data
+func (c *Conn) switchAuth() ([]byte, error) {
+	data := make([]byte, 4, 4+1+len(AUTH_NAME)+1+len(c.salt)+1)
+	data = append(data, 0xfe)
+	data = append(data, AUTH_NAME...)
+	data = append(data, 0)
+	data = append(data, c.salt...)
+	data = append(data, 0)
+
+	if err := c.writePacket(data); err != nil {
+		return nil, err
+	}
+
+	return c.readPacket()
+}
+
+// parseUsers builds the user table and limits by the global user and users config
+func (s *Server) parseUsers() {
+	s.users = make(map[string]string, len(s.cfg.Users)+1)
+	s.limits = make(map[string]*userLimit, len(s.cfg.Users)+1)
+	if len(s.cfg.User) > 0 {
+		s.users[s.cfg.User] = s.cfg.Password
+		s.limits[s.cfg.User] = newUserLimit(config.UserConfig{User: s.cfg.User})
+	}
+
+	for _, u := range s.cfg.Users {
+		s.users[u.User] = u.Password
+		s.limits[u.User] = newUserLimit(u)
+	}
+}
+
+// checkAuth verifies a mysql_native_password auth response
+func (s *Server) checkAuth(user string, salt []byte, auth []byte) error {
+	password, ok := s.users[user]
+	if !ok {
+		return errAuthFailed
+	}
+
+	expect := CalcPassword(salt, []byte(password))
+	if len(expect) != len(auth) || subtle.ConstantTimeCompare(expect, auth) != 1 {
+		return errAuthFailed
+	}
+	return nil
+}
+
+func remoteHost(co net.Conn) string {
+	addr := co.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "YES"
+	}
+	return "NO"
+}
@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"bytes"
+	"github.com/siddontang/mixer/client"
+	"github.com/siddontang/mixer/config"
+	. "github.com/siddontang/mixer/mysql"
+	"net"
+	"testing"
+)
+
+func newTestAuthServer(t *testing.T) (*Server, net.Listener) {
+	s := new(Server)
+	s.cfg = &config.Config{
+		User:     "root",
+		Password: "",
+		Users: []config.UserConfig{
+			{User: "mixer", Password: "mixer_pass"},
+		},
+	}
+	s.version = "5.6.0-mixer-test"
+	s.parseUsers()
+	s.schemas = map[string]*Schema{"mixer": &Schema{db: "mixer"}}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			co, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			c := s.newConn(co)
+			go func() {
+				c.Handshake()
+				c.c.Close()
+			}()
+		}
+	}()
+
+	return s, l
+}
+
+func checkAuthError(t *testing.T, err error, code uint16) {
+	if e, ok := err.(*SqlError); !ok || e.Code != code {
+		t.Fatal(err)
+	}
+}
+
+func TestAuth_Handshake(t *testing.T) {
+	_, l := newTestAuthServer(t)
+	defer l.Close()
+
+	addr := l.Addr().String()
+
+	var c client.Conn
+	if err := c.Connect(addr, "mixer", "mixer_pass", "mixer"); err != nil {
+		t.Fatal(err)
+	}
+	//the conn is closed after the handshake, max_allowed_packet unknown
+	if n := c.MaxAllowedPacket(); n != 0 {
+		t.Fatal(n)
+	}
+	c.Close()
+
+	if err := c.Connect(addr, "root", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	checkAuthError(t, c.Connect(addr, "mixer", "bad", ""), ER_ACCESS_DENIED_ERROR)
+	checkAuthError(t, c.Connect(addr, "root", "bad", ""), ER_ACCESS_DENIED_ERROR)
+	checkAuthError(t, c.Connect(addr, "nobody", "", ""), ER_ACCESS_DENIED_ERROR)
+	checkAuthError(t, c.Connect(addr, "mixer", "mixer_pass", "no_db"), ER_BAD_DB_ERROR)
+}
+
+// writeTestHandshakeResponse writes a response like the mysql 8 client,
+// lenenc auth data with the caching_sha2_password plugin
+func writeTestHandshakeResponse(pkg *PacketIO, user string, db string) error {
+	capability := CLIENT_PROTOCOL_41 | CLIENT_SECURE_CONNECTION | CLIENT_PLUGIN_AUTH |
+		CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA | CLIENT_CONNECT_WITH_DB
+
+	data := make([]byte, 4, 128)
+	data = append(data, Uint32ToBytes(capability)...)
+	data = append(data, 0, 0, 0, 1)
+	data = append(data, 45)
+	data = append(data, make([]byte, 23)...)
+	data = append(data, user...)
+	data = append(data, 0)
+	data = append(data, PutLengthEncodedString(bytes.Repeat([]byte{1}, 32))...)
+	data = append(data, db...)
+	data = append(data, 0)
+	data = append(data, "caching_sha2_password"...)
+	data = append(data, 0)
+
+	return pkg.WritePacket(data)
+}
+
+func TestAuth_SwitchPlugin(t *testing.T) {
+	_, l := newTestAuthServer(t)
+	defer l.Close()
+
+	co, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer co.Close()
+
+	pkg := NewPacketIO(co)
+
+	data, err := pkg.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//version
+	pos := 1 + bytes.IndexByte(data[1:], 0)
+	if string(data[1:pos]) != "5.6.0-mixer-test" {
+		t.Fatal(string(data[1:pos]))
+	}
+
+	if !bytes.HasSuffix(data, []byte(AUTH_NAME+"\x00")) {
+		t.Fatal("must announce auth plugin")
+	}
+
+	salt := append([]byte{}, data[pos+5:pos+13]...)
+	salt = append(salt, data[pos+13+1+2+1+2+2+11:pos+13+1+2+1+2+2+11+12]...)
+
+	if err := writeTestHandshakeResponse(pkg, "mixer", "mixer"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = pkg.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	} else if data[0] != 0xfe || !bytes.HasPrefix(data[1:], []byte(AUTH_NAME+"\x00")) {
+		t.Fatal("must switch auth", data)
+	} else if !bytes.Equal(data[len(AUTH_NAME)+2:len(AUTH_NAME)+2+20], salt) {
+		t.Fatal("must switch with the same salt")
+	}
+
+	if err := pkg.WritePacket(append(make([]byte, 4), CalcPassword(salt, []byte("mixer_pass"))...)); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err = pkg.ReadPacket(); err != nil {
+		t.Fatal(err)
+	} else if data[0] != OK_HEADER {
+		t.Fatal(data)
+	}
+}
+
+func TestAuth_ParseHandshakeResponse(t *testing.T) {
+	data := append(Uint32ToBytes(CLIENT_PROTOCOL_41|CLIENT_SECURE_CONNECTION|CLIENT_CONNECT_WITH_DB), 0, 0, 0, 0, 33)
+	data = append(data, make([]byte, 23)...)
+	data = append(data, "mixer\x00"...)
+	data = append(data, 3, 1, 2, 3)
+	//unterminated db
+	data = append(data, "mixer"...)
+
+	r, err := parseHandshakeResponse(data)
+	if err != nil {
+		t.Fatal(err)
+	} else if r.user != "mixer" || !bytes.Equal(r.auth, []byte{1, 2, 3}) || r.db != "mixer" || r.collation != 33 {
+		t.Fatalf("%+v", r)
+	}
+
+	bad := [][]byte{
+		data[:20],
+		data[:34],
+		//auth length over the packet
+		append(append([]byte{}, data[:38]...), 100, 1),
+	}
+	for _, b := range bad {
+		if _, err := parseHandshakeResponse(b); err != ErrMalformPacket {
+			t.Fatal(b, err)
+		}
+	}
+}
@@ -1,8 +1,6 @@
 package proxy
 
 import (
-	"bytes"
-	"encoding/binary"
 	"fmt"
 	"github.com/siddontang/go-log/log"
 	"github.com/siddontang/mixer/client"
@@ -10,13 +8,14 @@ import (
 	. "github.com/siddontang/mixer/mysql"
 	"net"
 	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 )
 
 var DEFAULT_CAPABILITY uint32 = CLIENT_LONG_PASSWORD | CLIENT_LONG_FLAG |
 	CLIENT_CONNECT_WITH_DB | CLIENT_PROTOCOL_41 |
-	CLIENT_TRANSACTIONS | CLIENT_SECURE_CONNECTION
+	CLIENT_TRANSACTIONS | CLIENT_SECURE_CONNECTION | CLIENT_PLUGIN_AUTH
 
 //client <-> proxy
 type Conn struct {
@@ -53,6 +52,19 @@ type Conn struct {
 	stmtId uint32
 
 	stmts map[uint32]*Stmt
+
+	//routing hint of the running statement
+	hint routeHint
+
+	//the executing prepared statement
+	stmt *Stmt
+
+	//limits of the user, set once counted
+	limit *userLimit
+
+	//read-after-write consistency mode and the last write to every node
+	consistency string
+	lastWrites  map[*Node]writeMark
 }
 
 var baseConnId uint32 = 10000
@@ -77,6 +89,9 @@ func (s *Server) newConn(co net.Conn) *Conn {
 
 	c.txConns = make(map[*Node]*client.SqlConn)
 
+	c.consistency = ConsistencyNone
+	c.lastWrites = make(map[*Node]writeMark)
+
 	c.closed = false
 
 	c.collation = DEFAULT_COLLATION_ID
@@ -121,6 +136,13 @@ func (c *Conn) Close() error {
 
 	c.rollback()
 
+	c.releaseLimit()
+
+	for id, s := range c.stmts {
+		s.closeBackends()
+		delete(c.stmts, id)
+	}
+
 	c.closed = true
 
 	return nil
@@ -133,7 +155,7 @@ func (c *Conn) writeInitialHandshake() error {
 	data = append(data, 10)
 
 	//server version[00]
-	data = append(data, ServerVersion...)
+	data = append(data, c.server.version...)
 	data = append(data, 0)
 
 	//connection id
@@ -170,6 +192,10 @@ func (c *Conn) writeInitialHandshake() error {
 	//filter [00]
 	data = append(data, 0)
 
+	//auth-plugin name[00]
+	data = append(data, AUTH_NAME...)
+	data = append(data, 0)
+
 	return c.writePacket(data)
 }
 
@@ -188,53 +214,39 @@ func (c *Conn) readHandshakeResponse() error {
 		return err
 	}
 
-	pos := 0
-
-	//capability
-	c.capability = binary.LittleEndian.Uint32(data[:4])
-	pos += 4
-
-	//skip max packet size
-	pos += 4
-
-	//charset, skip, if you want to use another charset, use set names
-	//c.collation = CollationId(data[pos])
-	pos++
-
-	//skip reserved 23[00]
-	pos += 23
-
-	//user name
-	c.user = string(data[pos : pos+bytes.IndexByte(data[pos:], 0)])
-	pos += len(c.user) + 1
-
-	//auth length and auth
-	authLen := int(data[pos])
-	pos++
-	auth := data[pos : pos+authLen]
+	resp, err := parseHandshakeResponse(data)
+	if err != nil {
+		return err
+	}
 
-	checkAuth := CalcPassword(c.salt, []byte(c.server.cfg.Password))
+	c.capability = resp.capability
+	c.user = resp.user
 
-	if !bytes.Equal(auth, checkAuth) {
-		return NewDefaultError(ER_ACCESS_DENIED_ERROR, c.c.RemoteAddr().String(), c.user, "Yes")
+	//use the client charset if we know it, it can be changed by set names later
+	if name, ok := Collations[resp.collation]; ok {
+		c.collation = resp.collation
+		c.charset = name[:strings.IndexByte(name+"_", '_')]
 	}
 
-	pos += authLen
-
-	if c.capability&CLIENT_CONNECT_WITH_DB > 0 {
-		if len(data[pos:]) == 0 {
-			return nil
+	auth := resp.auth
+	if c.capability&CLIENT_PLUGIN_AUTH > 0 && len(resp.plugin) > 0 && resp.plugin != AUTH_NAME {
+		//client wants another auth method, ask it to use ours
+		if auth, err = c.switchAuth(); err != nil {
+			return err
 		}
+	}
 
-		db := string(data[pos : pos+bytes.IndexByte(data[pos:], 0)])
-		pos += len(c.db) + 1
+	if err := c.server.checkAuth(c.user, c.salt, auth); err != nil {
+		return NewDefaultError(ER_ACCESS_DENIED_ERROR, c.user, remoteHost(c.c), yesNo(len(auth) > 0))
+	}
 
-		if err := c.useDB(db); err != nil {
+	if len(resp.db) > 0 {
+		if err := c.useDB(resp.db); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return c.acquireLimit()
 }
 
 func (c *Conn) Run() {
@@ -277,6 +289,13 @@ func (c *Conn) dispatch(data []byte) error {
 	cmd := data[0]
 	data = data[1:]
 
+	switch cmd {
+	case COM_QUERY, COM_STMT_EXECUTE:
+		if err := c.waitQuery(); err != nil {
+			return err
+		}
+	}
+
 	switch cmd {
 	case COM_QUIT:
 		c.Close()
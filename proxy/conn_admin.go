@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"fmt"
+	. "github.com/siddontang/mixer/mysql"
 	"github.com/siddontang/mixer/sqlparser"
 	"strings"
 )
@@ -15,6 +16,14 @@ func (c *Conn) handleAdmin(admin *sqlparser.Admin) error {
 		err = c.adminUpNodeServer(admin.Values)
 	case "downnode":
 		err = c.adminDownNodeServer(admin.Values)
+	case "readonly":
+		err = c.adminReadOnly(admin.Values)
+	case "flushcache":
+		err = c.adminFlushCache(admin.Values)
+	case "warmup":
+		return c.adminWarmUp(admin.Values)
+	case "explain":
+		return c.adminExplain(admin.Values)
 	default:
 		return fmt.Errorf("admin %s not supported now", name)
 	}
@@ -45,20 +54,128 @@ func (c *Conn) adminUpNodeServer(values sqlparser.ValExprs) error {
 	}
 }
 
+//admin downnode(node, slave, addr) downs one slave, without addr downs all slaves
 func (c *Conn) adminDownNodeServer(values sqlparser.ValExprs) error {
-	if len(values) != 2 {
-		return fmt.Errorf("upnode needs 2 args, not %d", len(values))
+	if len(values) != 2 && len(values) != 3 {
+		return fmt.Errorf("downnode needs 2 or 3 args, not %d", len(values))
 	}
 
 	nodeName := nstring(values[0])
 	sType := strings.ToLower(nstring(values[1]))
 
+	var addr string
+	if len(values) == 3 {
+		addr = strings.ToLower(nstring(values[2]))
+	}
+
 	switch sType {
 	case Master:
 		return c.server.DownMaster(nodeName)
 	case Slave:
-		return c.server.DownSlave(nodeName)
+		return c.server.DownSlave(nodeName, addr)
 	default:
 		return fmt.Errorf("invalid server type %s", sType)
 	}
 }
+
+func parseOnOff(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "1", "on", "true":
+		return true, nil
+	case "0", "off", "false":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid switch value %s", s)
+	}
+}
+
+//admin readonly(on) for all nodes, admin readonly(node, on) for one node
+func (c *Conn) adminReadOnly(values sqlparser.ValExprs) error {
+	switch len(values) {
+	case 1:
+		on, err := parseOnOff(nstring(values[0]))
+		if err != nil {
+			return err
+		}
+		c.server.SetReadOnly(on)
+		return nil
+	case 2:
+		n := c.server.getNode(nstring(values[0]))
+		if n == nil {
+			return fmt.Errorf("invalid node %s", nstring(values[0]))
+		}
+
+		on, err := parseOnOff(nstring(values[1]))
+		if err != nil {
+			return err
+		}
+		n.SetReadOnly(on)
+		return nil
+	default:
+		return fmt.Errorf("readonly needs 1 or 2 args, not %d", len(values))
+	}
+}
+
+//admin flushcache(node) drops the cached results of every db of the node
+func (c *Conn) adminFlushCache(values sqlparser.ValExprs) error {
+	if len(values) != 1 {
+		return fmt.Errorf("flushcache needs 1 args, not %d", len(values))
+	}
+
+	n := c.server.getNode(nstring(values[0]))
+	if n == nil {
+		return fmt.Errorf("invalid node %s", nstring(values[0]))
+	}
+
+	n.FlushResultCache()
+	return nil
+}
+
+//admin warmup(node) returns the result of every mysql server
+func (c *Conn) adminWarmUp(values sqlparser.ValExprs) error {
+	if len(values) != 1 {
+		return fmt.Errorf("warmup needs 1 args, not %d", len(values))
+	}
+
+	results, err := c.server.WarmUp(nstring(values[0]))
+	if results == nil {
+		return err
+	}
+
+	r, err := buildWarmupStatus(results)
+	if err != nil {
+		return err
+	}
+
+	return c.writeResultset(c.status, r)
+}
+
+var explainNames = []string{"Table", "Rule", "Nodes", "Reason"}
+
+//admin explain(sql) returns the routing decision of sql in current schema
+func (c *Conn) adminExplain(values sqlparser.ValExprs) error {
+	if len(values) != 1 {
+		return fmt.Errorf("explain needs 1 args, not %d", len(values))
+	} else if c.schema == nil {
+		return NewDefaultError(ER_NO_DB_ERROR)
+	}
+
+	sql, ok := values[0].(sqlparser.StrVal)
+	if !ok {
+		return fmt.Errorf("explain needs a quoted sql, not %s", nstring(values[0]))
+	}
+
+	e, err := c.schema.Explain(string(sql))
+	if err != nil {
+		return err
+	}
+
+	r, err := buildResultset(explainNames, [][]interface{}{
+		{e.Table, e.Rule, strings.Join(e.Nodes, ","), e.Reason},
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.writeResultset(c.status, r)
+}
@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"context"
 	"fmt"
 	"github.com/siddontang/mixer/client"
 	"github.com/siddontang/mixer/hack"
@@ -21,10 +22,21 @@ func (c *Conn) handleQuery(sql string) (err error) {
 
 	sql = strings.TrimRight(sql, ";")
 
+	if c.hint, sql, err = parseHint(sql, !c.server.cfg.KeepHints); err != nil {
+		return err
+	}
+	defer func() {
+		c.hint = routeHint{}
+	}()
+
+	if err = c.checkFilter(sql); err != nil {
+		return err
+	}
+
 	var stmt sqlparser.Statement
 	stmt, err = sqlparser.Parse(sql)
 	if err != nil {
-		return fmt.Errorf(`parse sql "%s" error`, sql)
+		return c.handleUnroutable(sql, fmt.Errorf(`parse sql "%s" error`, sql))
 	}
 
 	switch v := stmt.(type) {
@@ -53,17 +65,89 @@ func (c *Conn) handleQuery(sql string) (err error) {
 	case *sqlparser.Admin:
 		return c.handleAdmin(v)
 	default:
-		return fmt.Errorf("statement %T not support now", stmt)
+		return c.handleUnroutable(sql, fmt.Errorf("statement %T not support now", stmt))
 	}
 
 	return nil
 }
 
+func (c *Conn) checkFilter(sql string) error {
+	if c.server.filter == nil {
+		return nil
+	}
+	return c.server.filter.Check(sql)
+}
+
+// handleUnroutable sends sql which can not be analyzed to the master of
+// the schema default node, or rejects it with reason in strict routing
+func (c *Conn) handleUnroutable(sql string, reason error) error {
+	if c.schema == nil {
+		return reason
+	}
+
+	//a node hint routes explicitly
+	nodes, err := c.hintNodes()
+	if err == nil && nodes == nil {
+		nodes, err = c.joinNodes(sql)
+	}
+
+	if err != nil {
+		return err
+	} else if nodes == nil {
+		if err = c.schema.checkUnroutable(sql, reason); err != nil {
+			return err
+		}
+		nodes = []*Node{c.server.getNode(c.schema.rule.DefaultRule.Nodes[0])}
+	}
+
+	if err = c.checkReadOnly(nodes); err != nil {
+		return err
+	}
+
+	co, err := c.getConn(nodes[0], false)
+	if err != nil {
+		return err
+	}
+
+	r, err := co.Execute(sql)
+	c.closeShardConns([]*client.SqlConn{co}, false)
+	if err != nil {
+		return err
+	}
+
+	if r.Resultset != nil {
+		return c.writeResultset(c.status|r.Status, r.Resultset)
+	}
+	return c.mergeExecResult([]*Result{r})
+}
+
+// joinNodes routes a multi-table statement the parser does not support by
+// its first sharded table, nil if sql is not one, see router.JoinFirstSharded
+func (c *Conn) joinNodes(sql string) ([]*Node, error) {
+	a, err := sqlparser.AnalyzeLite(sql)
+	if err != nil || !a.Join {
+		return nil, nil
+	}
+
+	ns, err := a.ShardList(c.schema.rule, nil)
+	if err != nil {
+		return nil, err
+	} else if len(ns) != 1 {
+		return nil, fmt.Errorf("multi-table statement routed to %d nodes, must be one", len(ns))
+	}
+
+	return []*Node{c.server.getNode(ns[0])}, nil
+}
+
 func (c *Conn) getShardList(stmt sqlparser.Statement, bindVars map[string]interface{}) ([]*Node, error) {
 	if c.schema == nil {
 		return nil, NewDefaultError(ER_NO_DB_ERROR)
 	}
 
+	if nodes, err := c.hintNodes(); err != nil || nodes != nil {
+		return nodes, err
+	}
+
 	ns, err := sqlparser.GetStmtShardList(stmt, c.schema.rule, bindVars)
 	if err != nil {
 		return nil, err
@@ -83,7 +167,7 @@ func (c *Conn) getShardList(stmt sqlparser.Statement, bindVars map[string]interf
 func (c *Conn) getConn(n *Node, isSelect bool) (co *client.SqlConn, err error) {
 	if !c.needBeginTx() {
 		if isSelect {
-			co, err = n.getSelectConn()
+			co, err = c.getReadConn(n)
 		} else {
 			co, err = n.getMasterConn()
 		}
@@ -131,8 +215,13 @@ func (c *Conn) getShardConns(isSelect bool,stmt sqlparser.Statement, bindVars ma
 		return nil, nil
 	}
 
+	return c.getNodeConns(isSelect, nodes)
+}
+
+func (c *Conn) getNodeConns(isSelect bool, nodes []*Node) ([]*client.SqlConn, error) {
 	conns := make([]*client.SqlConn, 0, len(nodes))
 
+	var err error
 	var co *client.SqlConn
 	for _, n := range nodes {
 		co, err = c.getConn(n, isSelect)
@@ -153,7 +242,7 @@ func (c *Conn) executeInShard(conns []*client.SqlConn, sql string, args []interf
 	rs := make([]interface{}, len(conns))
 
 	f := func(rs []interface{}, i int, co *client.SqlConn) {
-		r, err := co.Execute(sql, args...)
+		r, err := c.execute(co, sql, args)
 		if err != nil {
 			rs[i] = err
 		} else {
@@ -233,10 +322,19 @@ func makeBindVars(args []interface{}) map[string]interface{} {
 	return bindVars
 }
 
+// isReadSelect checks whether stmt can go to a slave, a locking select
+// goes to master unless hinted to slave.
+func (c *Conn) isReadSelect(stmt *sqlparser.Select) bool {
+	if c.hint.master {
+		return false
+	}
+	return len(stmt.Lock) == 0 || c.hint.slave
+}
+
 func (c *Conn) handleSelect(stmt *sqlparser.Select, sql string, args []interface{}) error {
 	bindVars := makeBindVars(args)
 
-	conns, err := c.getShardConns(true,stmt, bindVars)
+	conns, err := c.getShardConns(c.isReadSelect(stmt), stmt, bindVars)
 	if err != nil {
 		return err
 	} else if conns == nil {
@@ -254,9 +352,20 @@ func (c *Conn) handleSelect(stmt *sqlparser.Select, sql string, args []interface
 		err = c.mergeSelectResult(rs, stmt)
 	}
 
+	releaseResults(rs)
+
 	return err
 }
 
+// releaseResults releases the buffered rows of rs once they are written
+func releaseResults(rs []*Result) {
+	for _, r := range rs {
+		if r != nil && r.Resultset != nil {
+			r.Resultset.Release()
+		}
+	}
+}
+
 func (c *Conn) beginShardConns(conns []*client.SqlConn) error {
 	if c.isInTransaction() {
 		return nil
@@ -288,34 +397,89 @@ func (c *Conn) commitShardConns(conns []*client.SqlConn) error {
 func (c *Conn) handleExec(stmt sqlparser.Statement, sql string, args []interface{}) error {
 	bindVars := makeBindVars(args)
 
-	conns, err := c.getShardConns(false,stmt, bindVars)
+	if c.hint.slave {
+		return fmt.Errorf("slave hint is only for select")
+	}
+
+	switch stmt.(type) {
+	case *sqlparser.Insert, *sqlparser.Replace:
+		if len(c.hint.node) > 0 {
+			//pinned, no split
+			break
+		}
+
+		if c.schema == nil {
+			return NewDefaultError(ER_NO_DB_ERROR)
+		}
+
+		shards, err := sqlparser.SplitInsert(stmt, c.schema.rule, bindVars)
+		if err != nil {
+			return err
+		} else if len(shards) > 1 {
+			return c.handleSplitExec(shards)
+		}
+	}
+
+	nodes, err := c.getShardList(stmt, bindVars)
 	if err != nil {
 		return err
-	} else if conns == nil {
+	} else if nodes == nil {
 		return c.writeOK(nil)
 	}
 
+	if err = c.checkReadOnly(nodes); err != nil {
+		return err
+	}
+
+	conns, err := c.getNodeConns(false, nodes)
+	if err != nil {
+		c.closeShardConns(conns, false)
+		return err
+	}
+
 	var rs []*Result
 
 	if len(conns) == 1 {
 		rs, err = c.executeInShard(conns, sql, args)
 	} else {
-		//for multi nodes, 2PC simple, begin, exec, commit
-		//if commit error, data maybe corrupt
-		for {
-			if err = c.beginShardConns(conns); err != nil {
-				break
-			}
+		rs, err = c.execShardConnsInTx(conns, func() ([]*Result, error) {
+			return c.executeInShard(conns, sql, args)
+		})
+	}
 
-			if rs, err = c.executeInShard(conns, sql, args); err != nil {
-				break
-			}
+	c.markWrites(nodes, conns)
+	c.closeShardConns(conns, err != nil)
 
-			err = c.commitShardConns(conns)
-			break
-		}
+	if err == nil {
+		err = c.mergeExecResult(rs)
+	}
+
+	return err
+}
+
+// handleSplitExec executes the per node statements of a split multi-row insert
+func (c *Conn) handleSplitExec(shards []sqlparser.InsertShard) error {
+	nodes := make([]*Node, len(shards))
+	sqls := make([]string, len(shards))
+	for i, s := range shards {
+		nodes[i] = c.server.getNode(s.Node)
+		sqls[i] = s.SQL
+	}
+
+	if err := c.checkReadOnly(nodes); err != nil {
+		return err
+	}
+
+	conns, err := c.getNodeConns(false, nodes)
+
+	var rs []*Result
+	if err == nil {
+		rs, err = c.execShardConnsInTx(conns, func() ([]*Result, error) {
+			return c.executeEachInShard(conns, sqls)
+		})
 	}
 
+	c.markWrites(nodes, conns)
 	c.closeShardConns(conns, err != nil)
 
 	if err == nil {
@@ -325,7 +489,46 @@ func (c *Conn) handleExec(stmt sqlparser.Statement, sql string, args []interface
 	return err
 }
 
-func (c *Conn) mergeExecResult(rs []*Result) error {
+// execShardConnsInTx runs exec for multi nodes with 2PC simple, begin, exec, commit
+// if commit error, data maybe corrupt
+func (c *Conn) execShardConnsInTx(conns []*client.SqlConn, exec func() ([]*Result, error)) ([]*Result, error) {
+	if err := c.beginShardConns(conns); err != nil {
+		return nil, err
+	}
+
+	rs, err := exec()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.commitShardConns(conns); err != nil {
+		return nil, err
+	}
+
+	return rs, nil
+}
+
+// executeEachInShard executes sqls[i] on conns[i] concurrently
+func (c *Conn) executeEachInShard(conns []*client.SqlConn, sqls []string) ([]*Result, error) {
+	rs := make([]*Result, len(conns))
+
+	errs := scatter(context.Background(), len(conns), 0, false, func(ctx context.Context, i int) error {
+		var err error
+		rs[i], err = conns[i].Execute(sqls[i])
+		return err
+	})
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rs, nil
+}
+
+// mergeExecResults sums affected rows and keeps the least insert id.
+func mergeExecResults(rs []*Result) *Result {
 	r := new(Result)
 
 	for _, v := range rs {
@@ -333,13 +536,19 @@ func (c *Conn) mergeExecResult(rs []*Result) error {
 		r.AffectedRows += v.AffectedRows
 		if r.InsertId == 0 {
 			r.InsertId = v.InsertId
-		} else if r.InsertId > v.InsertId {
+		} else if v.InsertId > 0 && r.InsertId > v.InsertId {
 			//last insert id is first gen id for multi row inserted
 			//see http://dev.mysql.com/doc/refman/5.6/en/information-functions.html#function_last-insert-id
 			r.InsertId = v.InsertId
 		}
 	}
 
+	return r
+}
+
+func (c *Conn) mergeExecResult(rs []*Result) error {
+	r := mergeExecResults(rs)
+
 	if r.InsertId > 0 {
 		c.lastInsertId = int64(r.InsertId)
 	}
@@ -350,19 +559,17 @@ func (c *Conn) mergeExecResult(rs []*Result) error {
 }
 
 func (c *Conn) mergeSelectResult(rs []*Result, stmt *sqlparser.Select) error {
-	r := rs[0].Resultset
+	status := c.status
 
-	status := c.status | rs[0].Status
-
-	for i := 1; i < len(rs); i++ {
+	sets := make([]*Resultset, len(rs))
+	for i := range rs {
 		status |= rs[i].Status
+		sets[i] = rs[i].Resultset
+	}
 
-		//check fields equal
-
-		for j := range rs[i].Values {
-			r.Values = append(r.Values, rs[i].Values[j])
-			r.RowDatas = append(r.RowDatas, rs[i].RowDatas[j])
-		}
+	r, err := MergeResultsets(sets...)
+	if err != nil {
+		return err
 	}
 
 	//to do order by, group by, limit offset
@@ -52,6 +52,10 @@ func formatField(field *Field, value interface{}) error {
 		field.Charset = 63
 		field.Type = MYSQL_TYPE_LONGLONG
 		field.Flag = BINARY_FLAG | NOT_NULL_FLAG | UNSIGNED_FLAG
+	case float32, float64:
+		field.Charset = 63
+		field.Type = MYSQL_TYPE_DOUBLE
+		field.Flag = BINARY_FLAG | NOT_NULL_FLAG
 	case string, []byte:
 		field.Charset = 33
 		field.Type = MYSQL_TYPE_VAR_STRING
@@ -62,9 +66,20 @@ func formatField(field *Field, value interface{}) error {
 }
 
 func (c *Conn) buildResultset(names []string, values [][]interface{}) (*Resultset, error) {
+	return buildResultset(names, values)
+}
+
+// buildResultset builds a text protocol resultset, field types come from
+// the first row, all columns are strings if there is no row.
+func buildResultset(names []string, values [][]interface{}) (*Resultset, error) {
 	r := new(Resultset)
 
 	r.Fields = make([]*Field, len(names))
+	if len(values) == 0 {
+		for i := range names {
+			r.Fields[i] = &Field{Name: hack.Slice(names[i]), Charset: 33, Type: MYSQL_TYPE_VAR_STRING}
+		}
+	}
 
 	var b []byte
 	var err error
@@ -33,7 +33,7 @@ func (c *Conn) handleSimpleSelect(sql string, stmt *sqlparser.SimpleSelect) erro
 	case "row_count":
 		r, err = c.buildSimpleSelectResult(c.affectedRows, f.Name, expr.As)
 	case "version":
-		r, err = c.buildSimpleSelectResult(ServerVersion, f.Name, expr.As)
+		r, err = c.buildSimpleSelectResult(c.server.version, f.Name, expr.As)
 	case "connection_id":
 		r, err = c.buildSimpleSelectResult(c.connectionId, f.Name, expr.As)
 	case "database":
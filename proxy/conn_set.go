@@ -21,6 +21,8 @@ func (c *Conn) handleSet(stmt *sqlparser.Set) error {
 		return c.handleSetAutoCommit(stmt.Exprs[0].Expr)
 	case `NAMES`:
 		return c.handleSetNames(stmt.Exprs[0].Expr)
+	case `MIXER_CONSISTENCY`:
+		return c.handleSetConsistency(stmt.Exprs[0].Expr)
 	default:
 		return fmt.Errorf("set %s is not supported now", k)
 	}
@@ -97,8 +97,20 @@ func (c *Conn) handleShowProxy(sql string, stmt *sqlparser.Show) (*Resultset, er
 		r, err = c.handleShowProxyConfig()
 	case "status":
 		r, err = c.handleShowProxyStatus(sql, stmt)
+	case "pool":
+		r, err = buildPoolStatus(c.server.nodes)
+	case "conns":
+		r, err = buildConnsStatus(c.server.nodes)
+	case "nodes":
+		r, err = buildNodesStatus(c.server.nodes, c.server.IsReadOnly())
+	case "rules":
+		r, err = buildRulesStatus(c.server.schemas)
+	case "version":
+		r, err = buildVersionStatus()
+	case "users":
+		r, err = buildUsersStatus(c.server.limits)
 	default:
-		err = fmt.Errorf("Unsupport show proxy [%v] yet, just support [config|status] now.", stmt.Key)
+		err = fmt.Errorf("Unsupport show proxy [%v] yet, just support [config|status|pool|conns|nodes|rules|version|users] now.", stmt.Key)
 		log.Warn(err.Error())
 		return nil, err
 	}
@@ -116,6 +128,8 @@ func (c *Conn) handleShowProxyConfig() (*Resultset, error) {
 	rows = append(rows, []string{"Global_Config", "User", c.server.cfg.User})
 	rows = append(rows, []string{"Global_Config", "Password", c.server.cfg.Password})
 	rows = append(rows, []string{"Global_Config", "LogLevel", c.server.cfg.LogLevel})
+	rows = append(rows, []string{"Global_Config", "Read_Only", onOff(c.server.IsReadOnly())})
+	rows = append(rows, []string{"Global_Config", "Read_Only_Allow_Tx", onOff(c.server.isReadOnlyAllowTx())})
 	rows = append(rows, []string{"Global_Config", "Schemas_Count", fmt.Sprintf("%d", len(c.server.schemas))})
 	rows = append(rows, []string{"Global_Config", "Nodes_Count", fmt.Sprintf("%d", len(c.server.nodes))})
 
@@ -132,12 +146,14 @@ func (c *Conn) handleShowProxyConfig() (*Resultset, error) {
 				nodeRows = append(nodeRows, []string{nodeSection, "Master", node.master.String()})
 			}
 
-			if node.slave != nil {
-				nodeRows = append(nodeRows, []string{nodeSection, "Slave", node.slave.String()})
+			node.Lock()
+			for _, s := range node.slaves {
+				nodeRows = append(nodeRows, []string{nodeSection, "Slave", s.db.String()})
+				nodeRows = append(nodeRows, []string{nodeSection, "Last_Slave_Ping", fmt.Sprintf("%v", time.Unix(s.lastPing, 0))})
 			}
-			nodeRows = append(nodeRows, []string{nodeSection, "Last_Master_Ping", fmt.Sprintf("%v", time.Unix(node.lastMasterPing, 0))})
+			node.Unlock()
 
-			nodeRows = append(nodeRows, []string{nodeSection, "Last_Slave_Ping", fmt.Sprintf("%v", time.Unix(node.lastSlavePing, 0))})
+			nodeRows = append(nodeRows, []string{nodeSection, "Last_Master_Ping", fmt.Sprintf("%v", time.Unix(node.lastMasterPing, 0))})
 
 			nodeRows = append(nodeRows, []string{nodeSection, "down_after_noalive", fmt.Sprintf("%v", node.downAfterNoAlive)})
 
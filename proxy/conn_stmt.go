@@ -3,11 +3,13 @@ package proxy
 import (
 	"encoding/binary"
 	"fmt"
+	"github.com/siddontang/mixer/client"
 	. "github.com/siddontang/mixer/mysql"
 	"github.com/siddontang/mixer/sqlparser"
 	"math"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 var paramFieldData []byte
@@ -32,12 +34,84 @@ type Stmt struct {
 	s sqlparser.Statement
 
 	sql string
+
+	hint routeHint
+
+	//the client visible id maps to a statement prepared on every backend
+	//conn the stmt is executed on, keyed by the conn and the sql sent
+	backendLock sync.Mutex
+	backends    map[backendStmtKey]*client.Stmt
+}
+
+type backendStmtKey struct {
+	co  *client.Conn
+	sql string
 }
 
 func (s *Stmt) ResetParams() {
 	s.args = make([]interface{}, s.params)
 }
 
+// backend returns the statement of sql prepared on co, it is prepared
+// the first time the stmt is routed to co, e.g. after a failover.
+func (s *Stmt) backend(co *client.SqlConn, sql string) (*client.Stmt, error) {
+	key := backendStmtKey{co.Conn, sql}
+
+	s.backendLock.Lock()
+	t, ok := s.backends[key]
+	s.backendLock.Unlock()
+
+	if ok {
+		return t, nil
+	}
+
+	t, err := co.Prepare(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	s.backendLock.Lock()
+	if s.backends == nil {
+		s.backends = make(map[backendStmtKey]*client.Stmt)
+	}
+	//statements are released by server with the closed conns
+	for k := range s.backends {
+		if k.co.IsClosed() {
+			delete(s.backends, k)
+		}
+	}
+	s.backends[key] = t
+	s.backendLock.Unlock()
+
+	return t, nil
+}
+
+// closeBackends releases all backend statements, they are closed when
+// their conns are pushed back to the pool because the conns may be used
+// by others now.
+func (s *Stmt) closeBackends() {
+	s.backendLock.Lock()
+	for _, t := range s.backends {
+		t.CloseLater()
+	}
+	s.backends = nil
+	s.backendLock.Unlock()
+}
+
+// execute executes sql on co, the executing stmt uses its backend statement
+// prepared on co.
+func (c *Conn) execute(co *client.SqlConn, sql string, args []interface{}) (*Result, error) {
+	if c.stmt == nil || len(args) == 0 {
+		return co.Execute(sql, args...)
+	}
+
+	t, err := c.stmt.backend(co, sql)
+	if err != nil {
+		return nil, err
+	}
+	return t.Execute(args...)
+}
+
 func (c *Conn) handleStmtPrepare(sql string) error {
 	if c.schema == nil {
 		return NewDefaultError(ER_NO_DB_ERROR)
@@ -48,6 +122,14 @@ func (c *Conn) handleStmtPrepare(sql string) error {
 	sql = strings.TrimRight(sql, ";")
 
 	var err error
+	if s.hint, sql, err = parseHint(sql, !c.server.cfg.KeepHints); err != nil {
+		return err
+	}
+
+	if err = c.checkFilter(sql); err != nil {
+		return err
+	}
+
 	s.s, err = sqlparser.Parse(sql)
 	if err != nil {
 		return fmt.Errorf(`parse sql "%s" error`, sql)
@@ -84,7 +166,7 @@ func (c *Conn) handleStmtPrepare(sql string) error {
 			return fmt.Errorf("parepre error %s", err)
 		}
 
-		if t, err := co.Prepare(sql); err != nil {
+		if t, err := s.backend(co, sql); err != nil {
 			return fmt.Errorf("parepre error %s", err)
 		} else {
 
@@ -97,6 +179,7 @@ func (c *Conn) handleStmtPrepare(sql string) error {
 	c.stmtId++
 
 	if err = c.writePrepare(s); err != nil {
+		s.closeBackends()
 		return err
 	}
 
@@ -219,6 +302,13 @@ func (c *Conn) handleStmtExecute(data []byte) error {
 
 	var err error
 
+	c.hint = s.hint
+	c.stmt = s
+	defer func() {
+		c.hint = routeHint{}
+		c.stmt = nil
+	}()
+
 	switch stmt := s.s.(type) {
 	case *sqlparser.Select:
 		err = c.handleSelect(stmt, s.sql, s.args)
@@ -421,7 +511,10 @@ func (c *Conn) handleStmtClose(data []byte) error {
 
 	id := binary.LittleEndian.Uint32(data[0:4])
 
-	delete(c.stmts, id)
+	if s, ok := c.stmts[id]; ok {
+		s.closeBackends()
+		delete(c.stmts, id)
+	}
 
 	return nil
 }
@@ -1,7 +1,15 @@
 package proxy
 
 import (
+	"encoding/binary"
+	"github.com/siddontang/mixer/client"
+	"github.com/siddontang/mixer/config"
+	. "github.com/siddontang/mixer/mysql"
+	"net"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestStmt_DropTable(t *testing.T) {
@@ -284,3 +292,208 @@ func TestStmt_Trans(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// fakeStmtBackend is a mysql server only answering prepare and execute,
+// it records the statement commands it receives
+type fakeStmtBackend struct {
+	sync.Mutex
+
+	s *Server
+	l net.Listener
+
+	nextId   uint32
+	prepares []string
+	executes []uint32
+	closes   []uint32
+}
+
+func newFakeStmtBackend(t *testing.T) *fakeStmtBackend {
+	b := new(fakeStmtBackend)
+	b.s = &Server{cfg: &config.Config{User: "root"}}
+	b.s.parseUsers()
+	b.s.schemas = map[string]*Schema{"mixer": &Schema{db: "mixer"}}
+
+	var err error
+	if b.l, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			co, err := b.l.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(b.s.newConn(co))
+		}
+	}()
+
+	return b
+}
+
+func (b *fakeStmtBackend) serve(c *Conn) {
+	defer c.c.Close()
+
+	if err := c.Handshake(); err != nil {
+		return
+	}
+
+	for {
+		c.pkg.Sequence = 0
+
+		data, err := c.readPacket()
+		if err != nil {
+			return
+		}
+
+		b.Lock()
+		switch data[0] {
+		case COM_STMT_PREPARE:
+			b.nextId++
+			query := string(data[1:])
+			b.prepares = append(b.prepares, query)
+			err = c.writePrepare(&Stmt{id: b.nextId, params: strings.Count(query, "?")})
+		case COM_STMT_EXECUTE:
+			b.executes = append(b.executes, binary.LittleEndian.Uint32(data[1:]))
+			err = c.writeOK(&Result{AffectedRows: 1})
+		case COM_STMT_CLOSE:
+			b.closes = append(b.closes, binary.LittleEndian.Uint32(data[1:]))
+		case COM_QUIT:
+			err = net.ErrClosed
+		case COM_QUERY:
+			if !strings.HasPrefix(string(data[1:]), "select") {
+				err = c.writeOK(&Result{AffectedRows: 1})
+				break
+			}
+
+			//read only check
+			var r *Resultset
+			if r, err = buildResultset([]string{"read_only", "super_read_only"}, [][]interface{}{{0, 0}}); err == nil {
+				err = c.writeResultset(c.status, r)
+			}
+		default:
+			err = c.writeOK(nil)
+		}
+		b.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (b *fakeStmtBackend) stats() (int, []uint32, []uint32) {
+	b.Lock()
+	defer b.Unlock()
+	return len(b.prepares), append([]uint32(nil), b.executes...), append([]uint32(nil), b.closes...)
+}
+
+func TestStmt_BackendFailover(t *testing.T) {
+	b1 := newFakeStmtBackend(t)
+	defer b1.l.Close()
+	b2 := newFakeStmtBackend(t)
+	defer b2.l.Close()
+
+	s := new(Server)
+	s.cfg = &config.Config{
+		User: "root",
+		Schemas: []config.SchemaConfig{
+			{DB: "mixer", Nodes: []string{"node1"}, RulesConifg: config.RulesConfig{Default: "node1"}},
+		},
+	}
+	s.parseUsers()
+
+	n := &Node{server: s, cfg: config.NodeConfig{Name: "node1", User: "root", IdleConns: 1}}
+	s.nodes = map[string]*Node{"node1": n}
+	if err := s.parseSchemas(); err != nil {
+		t.Fatal(err)
+	}
+
+	setMaster := func(addr string) {
+		//the schema db as default db, conns are reused after use db
+		db, err := client.Open(addr, "root", "", "mixer")
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.SetMaxIdleConnNum(1)
+		n.Lock()
+		n.master, n.db = db, db
+		n.Unlock()
+	}
+	setMaster(b1.l.Addr().String())
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			co, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c := s.newConn(co)
+			go func() {
+				if c.Handshake() == nil {
+					c.Run()
+				}
+			}()
+		}
+	}()
+
+	var c client.Conn
+	if err := c.Connect(l.Addr().String(), "root", "", "mixer"); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	st, err := c.Prepare("insert into mixer_test_proxy_stmt (id) values (?)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the statement prepared for the client is reused by execute
+	if _, err := st.Execute(1); err != nil {
+		t.Fatal(err)
+	}
+	if prepares, executes, _ := b1.stats(); prepares != 1 || len(executes) != 1 || executes[0] != 1 {
+		t.Fatal(prepares, executes)
+	}
+
+	if err := s.DownMaster("node1"); err != nil {
+		t.Fatal(err)
+	}
+	setMaster(b2.l.Addr().String())
+
+	//the same client statement is prepared again on the new master
+	for i := 0; i < 2; i++ {
+		if r, err := st.Execute(2); err != nil {
+			t.Fatal(err)
+		} else if r.AffectedRows != 1 {
+			t.Fatal(r.AffectedRows)
+		}
+	}
+	if prepares, executes, _ := b2.stats(); prepares != 1 || len(executes) != 2 || executes[1] != 1 {
+		t.Fatal(prepares, executes)
+	}
+
+	//closed backend statements are released when the conn is pushed back
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Execute("insert into mixer_test_proxy_stmt (id) values (3)"); err != nil {
+		t.Fatal(err)
+	}
+
+	//close has no response, wait the backend to read it
+	var closes []uint32
+	for i := 0; i < 100 && len(closes) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		_, _, closes = b2.stats()
+	}
+	if len(closes) != 1 || closes[0] != 1 {
+		t.Fatal(closes)
+	}
+}
@@ -37,10 +37,12 @@ func (c *Conn) handleRollback() (err error) {
 func (c *Conn) commit() (err error) {
 	c.status &= ^SERVER_STATUS_IN_TRANS
 
-	for _, co := range c.txConns {
+	now := consistencyNow()
+	for n, co := range c.txConns {
 		if e := co.Commit(); e != nil {
 			err = e
 		}
+		c.markWrite(n, co, now)
 		co.Close()
 	}
 
@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/siddontang/go-log/log"
+	"github.com/siddontang/mixer/client"
+	"github.com/siddontang/mixer/sqlparser"
+	"strings"
+	"time"
+)
+
+// Read-after-write consistency modes of a session, set by
+// set mixer_consistency = 'none' | 'master' | 'gtid'.
+//
+// For the read_after_write_window of its node after a write, a select of
+// the session goes to master in master mode. In gtid mode it goes to a
+// slave once the slave executed the gtids of the write, waiting at most
+// gtid_wait_timeout, or to master otherwise; a write without tracked gtid
+// is treated like in master mode.
+const (
+	ConsistencyNone   = "none"
+	ConsistencyMaster = "master"
+	ConsistencyGTID   = "gtid"
+)
+
+const (
+	defaultReadAfterWriteWindow = time.Second
+	defaultGTIDWaitTimeout      = 50 * time.Millisecond
+)
+
+var consistencyNow = time.Now
+
+// writeMark is the last write of a session to a node
+type writeMark struct {
+	at   time.Time
+	gtid string
+}
+
+func (c *Conn) handleSetConsistency(val sqlparser.ValExpr) error {
+	value, ok := val.(sqlparser.StrVal)
+	if !ok {
+		return fmt.Errorf("set mixer_consistency error")
+	}
+
+	mode := strings.ToLower(string(value))
+	switch mode {
+	case ConsistencyNone, ConsistencyMaster, ConsistencyGTID:
+	default:
+		return fmt.Errorf("invalid consistency %s", value)
+	}
+
+	c.consistency = mode
+
+	return c.writeOK(nil)
+}
+
+// markWrites remembers the writes to nodes by conns[i], after executed
+func (c *Conn) markWrites(nodes []*Node, conns []*client.SqlConn) {
+	now := consistencyNow()
+	for i, co := range conns {
+		c.markWrite(nodes[i], co, now)
+	}
+}
+
+func (c *Conn) markWrite(n *Node, co *client.SqlConn, now time.Time) {
+	c.Lock()
+	c.lastWrites[n] = writeMark{at: now, gtid: co.LastGTID()}
+	c.Unlock()
+}
+
+// getReadConn returns a conn for a select out of transactions, which
+// sees the last write of the session to n by the consistency mode.
+func (c *Conn) getReadConn(n *Node) (*client.SqlConn, error) {
+	if c.consistency == ConsistencyNone || len(c.consistency) == 0 || c.hint.slave {
+		return n.getSelectConn()
+	}
+
+	c.Lock()
+	w, ok := c.lastWrites[n]
+	c.Unlock()
+
+	if !ok {
+		return n.getSelectConn()
+	}
+	return n.getReadAfterWriteConn(c.consistency, w)
+}
+
+// getReadAfterWriteConn returns a conn for a select which must see the
+// write w, see ConsistencyMaster and ConsistencyGTID.
+func (n *Node) getReadAfterWriteConn(mode string, w writeMark) (*client.SqlConn, error) {
+	if !n.cfg.RWSplit || consistencyNow().Sub(w.at) >= n.readAfterWriteWindow() {
+		return n.getSelectConn()
+	}
+
+	if mode == ConsistencyGTID && len(w.gtid) > 0 {
+		if co := n.getCaughtUpSlaveConn(w.gtid); co != nil {
+			return co, nil
+		}
+	}
+
+	if co, err := n.getMasterReadConn(); err == nil {
+		return co, nil
+	}
+
+	//no master, the read can not be consistent
+	return n.getSelectConn()
+}
+
+// getCaughtUpSlaveConn returns a conn of a slave which executed gtid,
+// nil if no slave has in the wait timeout.
+func (n *Node) getCaughtUpSlaveConn(gtid string) *client.SqlConn {
+	n.Lock()
+	s := n.selectSlave()
+	n.Unlock()
+
+	if s == nil {
+		return nil
+	}
+
+	co, err := s.db.GetConn()
+	n.releaseSlave(s)
+	if err != nil {
+		return nil
+	}
+
+	ok, err := co.WaitGTID(gtid, n.gtidWaitTimeout())
+	if err != nil {
+		log.Warn("wait gtid %s on %s slave %s error %v", gtid, n, s.db.Addr(), err)
+		co.Close()
+		return nil
+	} else if !ok {
+		co.Close()
+		return nil
+	}
+
+	return co
+}
+
+// getMasterReadConn returns a conn of the running master for a select,
+// which is allowed on a read only master too.
+func (n *Node) getMasterReadConn() (*client.SqlConn, error) {
+	n.Lock()
+	db := n.db
+	if n.masterDown {
+		db = nil
+	}
+	n.Unlock()
+
+	if db == nil {
+		return nil, fmt.Errorf("master is down")
+	}
+
+	return db.GetConn()
+}
+
+func (n *Node) readAfterWriteWindow() time.Duration {
+	if n.cfg.ReadAfterWriteWindow > 0 {
+		return time.Duration(n.cfg.ReadAfterWriteWindow) * time.Millisecond
+	}
+	return defaultReadAfterWriteWindow
+}
+
+func (n *Node) gtidWaitTimeout() time.Duration {
+	if n.cfg.GTIDWaitTimeout > 0 {
+		return time.Duration(n.cfg.GTIDWaitTimeout) * time.Millisecond
+	}
+	return defaultGTIDWaitTimeout
+}
@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"github.com/siddontang/mixer/client"
+	"github.com/siddontang/mixer/config"
+	. "github.com/siddontang/mixer/mysql"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeReplica is a mysql server answering the selects of read routing,
+// select role returns its name, and it executes every waited gtid at
+// caughtUp, like a slave catching up.
+type fakeReplica struct {
+	sync.Mutex
+
+	name string
+	s    *Server
+	l    net.Listener
+
+	caughtUp time.Time
+	waits    int
+}
+
+func newFakeReplica(t *testing.T, name string) *fakeReplica {
+	r := &fakeReplica{name: name}
+	r.s = &Server{cfg: &config.Config{User: "root"}}
+	r.s.parseUsers()
+	r.s.schemas = map[string]*Schema{}
+
+	var err error
+	if r.l, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			co, err := r.l.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(r.s.newConn(co))
+		}
+	}()
+
+	return r
+}
+
+func (r *fakeReplica) catchUpAfter(d time.Duration) {
+	r.Lock()
+	r.caughtUp = time.Now().Add(d)
+	r.waits = 0
+	r.Unlock()
+}
+
+// waitGTID answers wait_for_executed_gtid_set, 0 if caught up in timeout
+func (r *fakeReplica) waitGTID(query string) int64 {
+	args := query[strings.LastIndex(query, ",")+1 : strings.LastIndex(query, ")")]
+	seconds, _ := strconv.ParseFloat(strings.TrimSpace(args), 64)
+	timeout := time.Duration(seconds * float64(time.Second))
+
+	r.Lock()
+	r.waits++
+	remain := r.caughtUp.Sub(time.Now())
+	r.Unlock()
+
+	if remain > timeout {
+		time.Sleep(timeout)
+		return 1
+	} else if remain > 0 {
+		time.Sleep(remain)
+	}
+	return 0
+}
+
+func (r *fakeReplica) serve(c *Conn) {
+	defer c.c.Close()
+
+	if err := c.Handshake(); err != nil {
+		return
+	}
+
+	for {
+		c.pkg.Sequence = 0
+
+		data, err := c.readPacket()
+		if err != nil || data[0] != COM_QUERY {
+			return
+		}
+
+		var names []string
+		var values [][]interface{}
+
+		query := string(data[1:])
+		switch {
+		case strings.Contains(query, "@@read_only"):
+			names, values = []string{"read_only", "super_read_only"}, [][]interface{}{{0, 0}}
+		case strings.Contains(query, "wait_for_executed_gtid_set"):
+			names, values = []string{"wait"}, [][]interface{}{{r.waitGTID(query)}}
+		case query == "select role":
+			names, values = []string{"role"}, [][]interface{}{{r.name}}
+		default:
+			if c.writeOK(nil) != nil {
+				return
+			}
+			continue
+		}
+
+		rs, err := buildResultset(names, values)
+		if err != nil || c.writeResultset(c.status, rs) != nil {
+			return
+		}
+	}
+}
+
+func newTestReplicaDB(t *testing.T, r *fakeReplica) *client.DB {
+	db, err := client.Open(r.l.Addr().String(), "root", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxIdleConnNum(2)
+	return db
+}
+
+func TestConsistency_ReadAfterWrite(t *testing.T) {
+	master := newFakeReplica(t, "master")
+	defer master.l.Close()
+	slave := newFakeReplica(t, "slave")
+	defer slave.l.Close()
+
+	n := &Node{cfg: config.NodeConfig{Name: "node1", RWSplit: true,
+		ReadAfterWriteWindow: 1000, GTIDWaitTimeout: 50}}
+	n.master = newTestReplicaDB(t, master)
+	n.db = n.master
+	slaveDB := newTestReplicaDB(t, slave)
+	if err := n.AddSlave(slaveDB); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Conn{lastWrites: map[*Node]writeMark{}}
+
+	checkRead := func(mode string, w *writeMark, expect string) {
+		c.consistency = mode
+		delete(c.lastWrites, n)
+		if w != nil {
+			c.lastWrites[n] = *w
+		}
+
+		co, err := c.getReadConn(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer co.Close()
+
+		r, err := co.Execute("select role")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if role, _ := r.GetString(0, 0); role != expect {
+			t.Fatalf("%s mode read %s, not %s", mode, role, expect)
+		}
+	}
+
+	gtid := "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5"
+	write := &writeMark{at: time.Now(), gtid: gtid}
+	noGTID := &writeMark{at: time.Now()}
+	oldWrite := &writeMark{at: time.Now().Add(-2 * time.Second), gtid: gtid}
+
+	//no write, or the session does not care
+	checkRead(ConsistencyGTID, nil, "slave")
+	checkRead(ConsistencyNone, write, "slave")
+
+	checkRead(ConsistencyMaster, write, "master")
+	checkRead(ConsistencyMaster, oldWrite, "slave")
+
+	//the slave catches up in the wait timeout
+	slave.catchUpAfter(20 * time.Millisecond)
+	checkRead(ConsistencyGTID, write, "slave")
+
+	//too late, falls back to master
+	slave.catchUpAfter(time.Second)
+	checkRead(ConsistencyGTID, write, "master")
+	if slave.waits != 1 {
+		t.Fatal(slave.waits)
+	}
+
+	//no gtid to wait for, and the window passed
+	checkRead(ConsistencyGTID, noGTID, "master")
+	checkRead(ConsistencyGTID, oldWrite, "slave")
+
+	//the waited conn went back to the pool
+	if s := slaveDB.Stats(); s.InUse != 0 {
+		t.Fatalf("%+v", s)
+	}
+}
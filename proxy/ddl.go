@@ -0,0 +1,263 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	. "github.com/siddontang/mixer/mysql"
+	"github.com/siddontang/mixer/sqlparser"
+	"strings"
+)
+
+var errShardSkipped = errors.New("shard skipped, aborted by previous error")
+
+type ShardResult struct {
+	Node  string
+	Table string
+	SQL   string
+
+	Result *Result
+	Err    error
+}
+
+type DDLOptions struct {
+	//physical table name format, args are table name and shard index,
+	//eg "%s_%04d", empty means every shard uses the logic table name
+	TableFormat string
+
+	//max shards executed concurrently, <= 0 means all shards at once
+	Parallel int
+
+	//go on executing remaining shards after an error and report all failures
+	ContinueOnError bool
+}
+
+// BroadcastDDL executes ddl on every shard of table's rule, stop on first error
+func (s *Schema) BroadcastDDL(table string, ddl string) ([]ShardResult, error) {
+	return s.BroadcastDDLWithOptions(table, ddl, DDLOptions{})
+}
+
+func (s *Schema) BroadcastDDLWithOptions(table string, ddl string, opts DDLOptions) ([]ShardResult, error) {
+	rs, err := s.ddlPlan(table, ddl, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = broadcast(rs, opts, func(r *ShardResult) (*Result, error) {
+		return s.execOnMaster(r.Node, r.SQL)
+	})
+
+	return rs, err
+}
+
+func (s *Schema) ddlPlan(table string, ddl string, opts DDLOptions) ([]ShardResult, error) {
+	ddl = strings.TrimRight(ddl, "; ")
+
+	stmt, err := sqlparser.Parse(ddl)
+	if err != nil {
+		return nil, fmt.Errorf(`parse sql "%s" error`, ddl)
+	}
+
+	if _, ok := stmt.(*sqlparser.DDL); !ok {
+		return nil, fmt.Errorf(`"%s" is not a ddl statement, refuse to broadcast`, ddl)
+	}
+
+	rule := s.rule.GetRule(table)
+
+	rs := make([]ShardResult, len(rule.Nodes))
+	for i, node := range rule.Nodes {
+		if _, ok := s.nodes[node]; !ok {
+			return nil, fmt.Errorf("schema [%s] node [%s] not exists", s.db, node)
+		}
+
+		t := table
+		if len(opts.TableFormat) > 0 {
+			t = fmt.Sprintf(opts.TableFormat, table, i)
+		}
+
+		rs[i].Node = node
+		rs[i].Table = t
+		rs[i].SQL = rewriteTableName(ddl, table, t)
+	}
+
+	return rs, nil
+}
+
+func (s *Schema) execOnMaster(node string, sql string) (*Result, error) {
+	co, err := s.nodes[node].getMasterConn()
+	if err != nil {
+		return nil, err
+	}
+	defer co.Close()
+
+	if err = co.UseDB(s.db); err != nil {
+		return nil, err
+	}
+
+	return co.Execute(sql)
+}
+
+// broadcast runs f for every shard with bounded parallelism,
+// results are saved in rs, the first error is returned
+func broadcast(rs []ShardResult, opts DDLOptions, f func(r *ShardResult) (*Result, error)) error {
+	errs := scatter(context.Background(), len(rs), opts.Parallel, !opts.ContinueOnError, func(ctx context.Context, i int) error {
+		var err error
+		rs[i].Result, err = f(&rs[i])
+		return err
+	})
+
+	var firstErr error
+	for i, err := range errs {
+		rs[i].Err = err
+		if err != nil && err != errShardSkipped && firstErr == nil {
+			firstErr = fmt.Errorf("node %s table %s: %v", rs[i].Node, rs[i].Table, err)
+		}
+	}
+
+	return firstErr
+}
+
+// tableKeywords are followed by a table name
+var tableKeywords = map[string]bool{
+	"TABLE": true, "INTO": true, "FROM": true, "JOIN": true,
+	"UPDATE": true, "TRUNCATE": true, "REFERENCES": true,
+}
+
+// rewriteTableName replaces the table from with to in sql where a table
+// is named: after TABLE, INTO, FROM, JOIN and alike, the ON of a CREATE or
+// DROP INDEX, next in a list of tables, and as the qualifier of a column
+// like from.id. A column, an index or an alias named from is kept, so are
+// quoted strings and comments.
+func rewriteTableName(sql string, from string, to string) string {
+	if from == to {
+		return sql
+	}
+
+	buf := make([]byte, 0, len(sql)+16)
+
+	isIdent := func(ch byte) bool {
+		return ch == '_' || ch == '$' || ch >= '0' && ch <= '9' ||
+			ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= 0x80
+	}
+
+	//the first word of the statement
+	first := ""
+	//the next name is a table, or the db of one
+	tableNext := false
+	//the last name was a table, a comma goes on with the list
+	afterTable := false
+	//the last byte was a dot, the name is qualified
+	afterDot := false
+
+	for i := 0; i < len(sql); {
+		ch := sql[i]
+
+		//the end of a comment or a quoted string at i
+		end := -1
+		switch {
+		case ch == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			if end = strings.Index(sql[i+2:], "*/"); end < 0 {
+				end = len(sql)
+			} else {
+				end += i + 4
+			}
+		case ch == '#' || ch == '-' && strings.HasPrefix(sql[i:], "--") && (i+2 == len(sql) || sql[i+2] <= ' '):
+			if end = strings.IndexByte(sql[i:], '\n'); end < 0 {
+				end = len(sql)
+			} else {
+				end += i
+			}
+		case ch == '\'' || ch == '"':
+			end = i + 1
+			for end < len(sql) && sql[end] != ch {
+				if sql[end] == '\\' && end+1 < len(sql) {
+					end++
+				}
+				end++
+			}
+			if end < len(sql) {
+				end++
+			}
+			tableNext, afterTable = false, false
+		}
+		if end >= 0 {
+			buf = append(buf, sql[i:end]...)
+			i = end
+			continue
+		}
+
+		if ch != '`' && !isIdent(ch) {
+			switch {
+			case ch == ',' && afterTable:
+				tableNext = true
+			case ch != '.' && ch > ' ':
+				tableNext = false
+			}
+			if ch > ' ' {
+				afterTable = false
+			}
+			afterDot = ch == '.'
+			buf = append(buf, ch)
+			i++
+			continue
+		}
+
+		//a name, quoted or not
+		var name string
+		j := i
+		if ch == '`' {
+			if j = strings.IndexByte(sql[i+1:], '`'); j < 0 {
+				buf = append(buf, sql[i:]...)
+				break
+			}
+			j += i + 2
+			name = sql[i+1 : j-1]
+		} else {
+			for j < len(sql) && isIdent(sql[j]) {
+				j++
+			}
+			name = sql[i:j]
+		}
+		dotted := j < len(sql) && sql[j] == '.'
+
+		word := ""
+		if ch != '`' {
+			word = strings.ToUpper(name)
+			if first == "" {
+				first = word
+			}
+		}
+
+		rewrite := false
+		switch {
+		case tableNext && (word == "IF" || word == "NOT" || word == "EXISTS"):
+			//IF NOT EXISTS before the table
+		case tableNext:
+			//a db name before the table keeps tableNext
+			if !dotted {
+				rewrite = name == from
+				tableNext, afterTable = false, true
+			}
+		case tableKeywords[word] || word == "ON" && (first == "CREATE" || first == "DROP"):
+			tableNext, afterTable = true, false
+		default:
+			//a column qualified by the table
+			rewrite = dotted && !afterDot && name == from
+			afterTable = false
+		}
+		afterDot = false
+
+		if !rewrite {
+			buf = append(buf, sql[i:j]...)
+		} else if ch == '`' {
+			buf = append(buf, '`')
+			buf = append(buf, to...)
+			buf = append(buf, '`')
+		} else {
+			buf = append(buf, to...)
+		}
+		i = j
+	}
+
+	return string(buf)
+}
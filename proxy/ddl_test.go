@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/siddontang/mixer/config"
+	. "github.com/siddontang/mixer/mysql"
+	"github.com/siddontang/mixer/router"
+	"sync"
+	"testing"
+)
+
+func newTestDDLSchema(t *testing.T) *Schema {
+	cfg := config.SchemaConfig{
+		DB:    "mixer",
+		Nodes: []string{"node1", "node2", "node3"},
+		RulesConifg: config.RulesConfig{
+			Default: "node1",
+			ShardRule: []config.ShardConfig{
+				{
+					Table: "mixer_test_ddl",
+					Key:   "id",
+					Nodes: []string{"node1", "node2", "node3"},
+					Type:  router.HashRuleType,
+				},
+			},
+		},
+	}
+
+	rule, err := router.NewRouter(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Schema{db: cfg.DB, rule: rule, nodes: make(map[string]*Node)}
+	for _, name := range cfg.Nodes {
+		s.nodes[name] = &Node{cfg: config.NodeConfig{Name: name}}
+	}
+	return s
+}
+
+func TestDDL_Plan(t *testing.T) {
+	s := newTestDDLSchema(t)
+
+	ddl := "alter table mixer_test_ddl add column /* mixer_test_ddl */ c int default 'mixer_test_ddl'"
+
+	opts := DDLOptions{TableFormat: "%s_%04d"}
+	rs, err := s.ddlPlan("mixer_test_ddl", ddl, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rs) != 3 {
+		t.Fatal(len(rs))
+	}
+
+	for i, r := range rs {
+		table := fmt.Sprintf("mixer_test_ddl_%04d", i)
+		sql := fmt.Sprintf("alter table %s add column /* mixer_test_ddl */ c int default 'mixer_test_ddl'", table)
+		if r.Table != table {
+			t.Fatal(r.Table)
+		}
+		if r.SQL != sql {
+			t.Fatal(r.SQL)
+		}
+		if r.Node != fmt.Sprintf("node%d", i+1) {
+			t.Fatal(r.Node)
+		}
+	}
+
+	rs, err = s.ddlPlan("mixer_test_ddl", "create table `mixer_test_ddl` (id int)", DDLOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if rs[2].SQL != "create table `mixer_test_ddl` (id int)" {
+		t.Fatal(rs[2].SQL)
+	}
+
+	if _, err = s.ddlPlan("mixer_test_ddl", "delete from mixer_test_ddl", opts); err == nil {
+		t.Fatal("must refuse non ddl statement")
+	}
+
+	if rs, err = s.ddlPlan("mixer_test_unshard", "drop table mixer_test_unshard", opts); err != nil {
+		t.Fatal(err)
+	} else if len(rs) != 1 || rs[0].Node != "node1" {
+		t.Fatal(rs)
+	}
+}
+
+func TestDDL_Broadcast(t *testing.T) {
+	s := newTestDDLSchema(t)
+
+	newPlan := func() []ShardResult {
+		rs, err := s.ddlPlan("mixer_test_ddl", "drop table mixer_test_ddl", DDLOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs
+	}
+
+	var m sync.Mutex
+	executed := map[string]bool{}
+	f := func(r *ShardResult) (*Result, error) {
+		m.Lock()
+		executed[r.Node] = true
+		m.Unlock()
+
+		if r.Node == "node1" {
+			return nil, fmt.Errorf("mock error")
+		}
+		return &Result{}, nil
+	}
+
+	rs := newPlan()
+	if err := broadcast(rs, DDLOptions{Parallel: 1}, f); err == nil {
+		t.Fatal("must error")
+	}
+
+	if rs[0].Err == nil || rs[1].Err != errShardSkipped || rs[2].Err != errShardSkipped {
+		t.Fatal(rs)
+	}
+
+	if len(executed) != 1 {
+		t.Fatal(executed)
+	}
+
+	executed = map[string]bool{}
+	rs = newPlan()
+	if err := broadcast(rs, DDLOptions{Parallel: 2, ContinueOnError: true}, f); err == nil {
+		t.Fatal("must error")
+	}
+
+	if len(executed) != 3 {
+		t.Fatal(executed)
+	}
+
+	if rs[0].Err == nil || rs[1].Err != nil || rs[2].Err != nil {
+		t.Fatal(rs)
+	}
+}
+
+func TestDDL_RewriteTableName(t *testing.T) {
+	for _, c := range [][2]string{
+		//a column and an index named as the table are kept
+		{"ALTER TABLE t ADD INDEX t (t)", "ALTER TABLE t_1 ADD INDEX t (t)"},
+		{"create table if not exists `t` (t int)", "create table if not exists `t_1` (t int)"},
+		{"drop table if exists db.t, t", "drop table if exists db.t_1, t_1"},
+		{"create index t on t (t)", "create index t on t_1 (t)"},
+
+		//comments are kept
+		{"alter table t -- t\nadd column c int", "alter table t_1 -- t\nadd column c int"},
+		{"alter table t # t\nadd column c int", "alter table t_1 # t\nadd column c int"},
+		{"alter table t /* t */ add column c int", "alter table t_1 /* t */ add column c int"},
+
+		//queries, the qualifier of a column included
+		{"select t.t from t where t = 't'", "select t_1.t from t_1 where t = 't'"},
+		{"insert into t(t) values (1)", "insert into t_1(t) values (1)"},
+		{"update t set t = t-1", "update t_1 set t = t-1"},
+	} {
+		if sql := rewriteTableName(c[0], "t", "t_1"); sql != c[1] {
+			t.Fatal(sql)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/siddontang/go-log/log"
+	"github.com/siddontang/mixer/config"
+	. "github.com/siddontang/mixer/mysql"
+	"github.com/siddontang/mixer/sqlparser"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	FilterAllow = "allow"
+	FilterDeny  = "deny"
+)
+
+var filterNow = time.Now
+
+type filterRule struct {
+	name   string
+	action string
+
+	classes map[string]bool
+	re      *regexp.Regexp
+
+	//minutes of day, the rule only matches in [start, stop), may wrap midnight
+	window bool
+	start  int
+	stop   int
+}
+
+type queryFilter struct {
+	rules  []*filterRule
+	dryRun bool
+}
+
+// QueryFilter checks queries against ordered allow and deny rules, the
+// first matched rule decides and a query matching no rule is allowed.
+// Rules are replaced atomically by Set.
+type QueryFilter struct {
+	v atomic.Value
+}
+
+func NewQueryFilter(cfg config.FilterConfig) (*QueryFilter, error) {
+	f := new(QueryFilter)
+	if err := f.Set(cfg); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Set replaces all rules, the old rules are kept if cfg is invalid
+func (f *QueryFilter) Set(cfg config.FilterConfig) error {
+	qf := &queryFilter{dryRun: cfg.DryRun}
+
+	for i, rc := range cfg.Rules {
+		r, err := newFilterRule(rc)
+		if err != nil {
+			return fmt.Errorf("filter rule %d: %v", i, err)
+		}
+		qf.rules = append(qf.rules, r)
+	}
+
+	f.v.Store(qf)
+	return nil
+}
+
+func newFilterRule(cfg config.FilterRuleConfig) (*filterRule, error) {
+	r := &filterRule{name: cfg.Name, action: strings.ToLower(cfg.Action)}
+
+	if len(r.name) == 0 {
+		return nil, fmt.Errorf("name must be set")
+	}
+
+	if r.action != FilterAllow && r.action != FilterDeny {
+		return nil, fmt.Errorf("%s invalid action %s, must %s or %s", r.name, cfg.Action, FilterAllow, FilterDeny)
+	}
+
+	if len(cfg.Classes) > 0 {
+		r.classes = make(map[string]bool, len(cfg.Classes))
+		for _, c := range cfg.Classes {
+			r.classes[strings.ToLower(c)] = true
+		}
+	}
+
+	if len(cfg.Pattern) > 0 {
+		var err error
+		if r.re, err = regexp.Compile(cfg.Pattern); err != nil {
+			return nil, fmt.Errorf("%s invalid pattern: %v", r.name, err)
+		}
+	}
+
+	if len(cfg.Window) > 0 {
+		var h1, m1, h2, m2 int
+		if n, _ := fmt.Sscanf(cfg.Window, "%d:%d-%d:%d", &h1, &m1, &h2, &m2); n != 4 ||
+			h1 > 23 || h2 > 23 || m1 > 59 || m2 > 59 || h1 < 0 || h2 < 0 || m1 < 0 || m2 < 0 {
+			return nil, fmt.Errorf("%s invalid window %s, must be hh:mm-hh:mm", r.name, cfg.Window)
+		}
+		r.window = true
+		r.start = h1*60 + m1
+		r.stop = h2*60 + m2
+	}
+
+	return r, nil
+}
+
+func (r *filterRule) inWindow(t time.Time) bool {
+	if !r.window {
+		return true
+	}
+
+	m := t.Hour()*60 + t.Minute()
+	if r.start <= r.stop {
+		return m >= r.start && m < r.stop
+	}
+	return m >= r.start || m < r.stop
+}
+
+func (r *filterRule) match(classes []string, fingerprint func() string, now time.Time) bool {
+	if r.classes != nil {
+		matched := false
+		for _, c := range classes {
+			if r.classes[c] {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if r.re != nil && !r.re.MatchString(fingerprint()) {
+		return false
+	}
+
+	return r.inWindow(now)
+}
+
+// Check returns an ER_SPECIFIC_ACCESS_DENIED_ERROR naming the rule if sql is
+// denied, in dry run it only logs the deny.
+func (f *QueryFilter) Check(sql string) error {
+	qf, _ := f.v.Load().(*queryFilter)
+	if qf == nil || len(qf.rules) == 0 {
+		return nil
+	}
+
+	classes := sqlparser.Classify(sql)
+
+	var fp string
+	fingerprint := func() string {
+		if len(fp) == 0 {
+			fp = sqlparser.Fingerprint(sql)
+		}
+		return fp
+	}
+
+	now := filterNow()
+	for _, r := range qf.rules {
+		if !r.match(classes, fingerprint, now) {
+			continue
+		}
+
+		if r.action == FilterAllow {
+			return nil
+		}
+
+		if qf.dryRun {
+			log.Warn("filter rule %s would deny %s", r.name, sql)
+			return nil
+		}
+
+		return NewError(ER_SPECIFIC_ACCESS_DENIED_ERROR,
+			fmt.Sprintf("Access denied; query is denied by filter rule %s", r.name))
+	}
+
+	return nil
+}
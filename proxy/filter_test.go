@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"github.com/siddontang/mixer/config"
+	. "github.com/siddontang/mixer/mysql"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func checkFilterDeny(t *testing.T, f *QueryFilter, sql string, rule string) {
+	err := f.Check(sql)
+	if err == nil {
+		t.Fatalf("%s must be denied", sql)
+	}
+
+	e, ok := err.(*SqlError)
+	if !ok || e.Code != ER_SPECIFIC_ACCESS_DENIED_ERROR || !strings.Contains(e.Message, rule) {
+		t.Fatal(sql, err)
+	}
+}
+
+func checkFilterAllow(t *testing.T, f *QueryFilter, sql string) {
+	if err := f.Check(sql); err != nil {
+		t.Fatal(sql, err)
+	}
+}
+
+func TestFilter_Class(t *testing.T) {
+	f, err := NewQueryFilter(config.FilterConfig{
+		Rules: []config.FilterRuleConfig{
+			{Name: "no_full_write", Action: FilterDeny, Classes: []string{"full_update", "full_delete"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkFilterDeny(t, f, "delete from t", "no_full_write")
+	checkFilterDeny(t, f, "DELETE FROM t LIMIT 10", "no_full_write")
+	checkFilterDeny(t, f, "update t set name = 'where'", "no_full_write")
+	checkFilterDeny(t, f, "update t set k = (select k from t2 where id = 1)", "no_full_write")
+
+	checkFilterAllow(t, f, "delete from t where id = 1")
+	checkFilterAllow(t, f, "update t set k = 1 where id = 1")
+	checkFilterAllow(t, f, "select * from t")
+}
+
+func TestFilter_Pattern(t *testing.T) {
+	f, err := NewQueryFilter(config.FilterConfig{
+		Rules: []config.FilterRuleConfig{
+			{Name: "no_sleep", Action: FilterDeny, Pattern: `(?i)\bsleep\(`},
+			{Name: "no_user_password", Action: FilterDeny, Classes: []string{"select"}, Pattern: `from users where password = \?`},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkFilterDeny(t, f, "select sleep(10)", "no_sleep")
+	checkFilterDeny(t, f, "select * from users where password = 'abc'", "no_user_password")
+	checkFilterAllow(t, f, "select * from users where name = 'password = 1'")
+
+	//pattern matches the fingerprint, not the raw sql
+	checkFilterAllow(t, f, "select 'sleep(10)'")
+}
+
+func TestFilter_Order(t *testing.T) {
+	now := time.Date(2014, 1, 1, 3, 0, 0, 0, time.Local)
+	filterNow = func() time.Time {
+		return now
+	}
+	defer func() {
+		filterNow = time.Now
+	}()
+
+	f, err := NewQueryFilter(config.FilterConfig{
+		Rules: []config.FilterRuleConfig{
+			{Name: "maintenance", Action: FilterAllow, Classes: []string{"drop", "truncate"}, Window: "02:00-04:00"},
+			{Name: "allow_tmp", Action: FilterAllow, Classes: []string{"drop"}, Pattern: `^drop table tmp_`},
+			{Name: "no_drop", Action: FilterDeny, Classes: []string{"drop", "truncate"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkFilterAllow(t, f, "drop table t")
+	checkFilterAllow(t, f, "truncate table t")
+
+	now = time.Date(2014, 1, 1, 12, 0, 0, 0, time.Local)
+	checkFilterDeny(t, f, "drop table t", "no_drop")
+	checkFilterDeny(t, f, "TRUNCATE t", "no_drop")
+	checkFilterAllow(t, f, "drop table tmp_t")
+
+	//window wraps midnight
+	if err := f.Set(config.FilterConfig{
+		Rules: []config.FilterRuleConfig{
+			{Name: "night", Action: FilterDeny, Classes: []string{"ddl"}, Window: "22:00-02:00"},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	checkFilterAllow(t, f, "alter table t add k int")
+	now = time.Date(2014, 1, 1, 23, 0, 0, 0, time.Local)
+	checkFilterDeny(t, f, "alter table t add k int", "night")
+	now = time.Date(2014, 1, 1, 1, 59, 0, 0, time.Local)
+	checkFilterDeny(t, f, "create table t (id int)", "night")
+}
+
+func TestFilter_DryRun(t *testing.T) {
+	cfg := config.FilterConfig{
+		DryRun: true,
+		Rules: []config.FilterRuleConfig{
+			{Name: "no_full_delete", Action: FilterDeny, Classes: []string{"full_delete"}},
+		},
+	}
+
+	f, err := NewQueryFilter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFilterAllow(t, f, "delete from t")
+
+	cfg.DryRun = false
+	if err := f.Set(cfg); err != nil {
+		t.Fatal(err)
+	}
+	checkFilterDeny(t, f, "delete from t", "no_full_delete")
+}
+
+func TestFilter_Invalid(t *testing.T) {
+	f, _ := NewQueryFilter(config.FilterConfig{
+		Rules: []config.FilterRuleConfig{
+			{Name: "no_drop", Action: FilterDeny, Classes: []string{"drop"}},
+		},
+	})
+
+	bad := []config.FilterRuleConfig{
+		{Action: FilterDeny},
+		{Name: "a", Action: "reject"},
+		{Name: "a", Action: FilterDeny, Pattern: "("},
+		{Name: "a", Action: FilterDeny, Window: "25:00-01:00"},
+		{Name: "a", Action: FilterDeny, Window: "night"},
+	}
+	for _, r := range bad {
+		if err := f.Set(config.FilterConfig{Rules: []config.FilterRuleConfig{r}}); err == nil {
+			t.Fatal("must error", r)
+		}
+	}
+
+	//old rules kept
+	checkFilterDeny(t, f, "drop table t", "no_drop")
+}
+
+func TestFilter_Swap(t *testing.T) {
+	deny := config.FilterConfig{Rules: []config.FilterRuleConfig{{Name: "deny_all", Action: FilterDeny}}}
+	allow := config.FilterConfig{Rules: []config.FilterRuleConfig{{Name: "allow_all", Action: FilterAllow}}}
+
+	f, _ := NewQueryFilter(allow)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if err := f.Check("select 1"); err != nil && !strings.Contains(err.Error(), "deny_all") {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	for j := 0; j < 1000; j++ {
+		if j%2 == 0 {
+			f.Set(deny)
+		} else {
+			f.Set(allow)
+		}
+	}
+	wg.Wait()
+}
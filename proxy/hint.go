@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+)
+
+const hintPrefix = "mixer:"
+
+// routeHint overrides the automatic routing of a statement, it is given
+// by leading comments like /*mixer:master*/, /*mixer:slave*/ and
+// /*mixer:node=node1*/, or combined like /*mixer:node=node1,slave*/.
+// /*mixer:nocache*/ is accepted too, it bypasses the result cache of
+// client.DB.Query.
+type routeHint struct {
+	//send a select to master
+	master bool
+	//send a locking select to slave
+	slave bool
+	//pin the statement to the node
+	node string
+	//bypass the result cache
+	nocache bool
+}
+
+func (h *routeHint) parse(body string) error {
+	for _, item := range strings.Split(body, ",") {
+		item = strings.TrimSpace(item)
+
+		switch {
+		case strings.EqualFold(item, Master):
+			h.master = true
+		case strings.EqualFold(item, Slave):
+			h.slave = true
+		case strings.EqualFold(item, "nocache"):
+			h.nocache = true
+		case strings.HasPrefix(strings.ToLower(item), "node="):
+			h.node = strings.TrimSpace(item[len("node="):])
+			if len(h.node) == 0 {
+				return fmt.Errorf("empty node in hint %s", body)
+			}
+		default:
+			return fmt.Errorf("invalid hint %s", item)
+		}
+	}
+
+	if h.master && h.slave {
+		return fmt.Errorf("hint can not be both master and slave")
+	}
+	return nil
+}
+
+// parseHint parses the hints in the leading comments of sql, other comments
+// are skipped. The hint comments are stripped from the returned sql if strip.
+func parseHint(sql string, strip bool) (routeHint, string, error) {
+	var h routeHint
+
+	var kept []string
+	rest := sql
+	for {
+		rest = strings.TrimLeft(rest, " \t\r\n")
+		if !strings.HasPrefix(rest, "/*") {
+			break
+		}
+
+		end := strings.Index(rest, "*/")
+		if end == -1 {
+			break
+		}
+
+		comment := rest[0 : end+2]
+		rest = rest[end+2:]
+
+		body := strings.TrimSpace(comment[2 : len(comment)-2])
+		if !strings.HasPrefix(body, hintPrefix) {
+			kept = append(kept, comment)
+			continue
+		}
+
+		if err := h.parse(body[len(hintPrefix):]); err != nil {
+			return h, sql, err
+		}
+	}
+
+	if !strip {
+		return h, sql, nil
+	}
+
+	kept = append(kept, rest)
+	return h, strings.Join(kept, " "), nil
+}
+
+// hintNodes returns the node pinned by the hint, nil if not pinned.
+func (c *Conn) hintNodes() ([]*Node, error) {
+	if len(c.hint.node) == 0 {
+		return nil, nil
+	}
+
+	n, ok := c.schema.nodes[c.hint.node]
+	if !ok {
+		return nil, fmt.Errorf("hint node %s is not in schema %s", c.hint.node, c.schema.db)
+	}
+
+	return []*Node{n}, nil
+}
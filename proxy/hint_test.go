@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"github.com/siddontang/mixer/sqlparser"
+	"testing"
+)
+
+func TestHint_Parse(t *testing.T) {
+	tbl := []struct {
+		sql      string
+		hint     routeHint
+		stripped string
+	}{
+		{"select 1", routeHint{}, "select 1"},
+		{"/*mixer:master*/ select 1", routeHint{master: true}, "select 1"},
+		{"  \n/*mixer:slave*/select 1 for update", routeHint{slave: true}, "select 1 for update"},
+		{"/* app */ /*mixer:node=node2*/ select 1", routeHint{node: "node2"}, "/* app */ select 1"},
+		{"/*mixer: node=node2, MASTER */ select 1", routeHint{master: true, node: "node2"}, "select 1"},
+		{"/* mixer:master */ select 1", routeHint{master: true}, "select 1"},
+		{"/*mixer:nocache,slave*/ select 1", routeHint{slave: true, nocache: true}, "select 1"},
+		{"/*mixers:master*/ select 1", routeHint{}, "/*mixers:master*/ select 1"},
+		{"select /*mixer:master*/ 1", routeHint{}, "select /*mixer:master*/ 1"},
+	}
+
+	for _, v := range tbl {
+		h, sql, err := parseHint(v.sql, true)
+		if err != nil {
+			t.Fatal(v.sql, err)
+		} else if h != v.hint || sql != v.stripped {
+			t.Fatal(v.sql, h, sql)
+		}
+
+		if _, sql, _ = parseHint(v.sql, false); sql != v.sql {
+			t.Fatal(v.sql, sql)
+		}
+	}
+
+	for _, sql := range []string{
+		"/*mixer:master,slave*/ select 1",
+		"/*mixer:node=*/ select 1",
+		"/*mixer:replica*/ select 1",
+	} {
+		if _, _, err := parseHint(sql, true); err == nil {
+			t.Fatal("must error", sql)
+		}
+	}
+}
+
+func newTestHintConn(t *testing.T) *Conn {
+	s := newTestDDLSchema(t)
+	return &Conn{server: &Server{nodes: s.nodes}, schema: s}
+}
+
+func hintShardList(t *testing.T, c *Conn, sql string) ([]*Node, *sqlparser.Select, error) {
+	var err error
+	var stripped string
+	if c.hint, stripped, err = parseHint(sql, true); err != nil {
+		t.Fatal(err)
+	}
+
+	stmt, err := sqlparser.Parse(stripped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, err := c.getShardList(stmt, nil)
+	return nodes, stmt.(*sqlparser.Select), err
+}
+
+func TestHint_Route(t *testing.T) {
+	c := newTestHintConn(t)
+
+	nodes, stmt, err := hintShardList(t, c, "select * from mixer_test_ddl where id = 1")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(nodes) != 1 || nodes[0].String() != "node2" {
+		t.Fatal(nodes)
+	} else if !c.isReadSelect(stmt) {
+		t.Fatal("must read slave")
+	}
+
+	//node hint wins the shard key
+	nodes, stmt, err = hintShardList(t, c, "/*mixer:node=node3,master*/ select * from mixer_test_ddl where id = 1")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(nodes) != 1 || nodes[0].String() != "node3" {
+		t.Fatal(nodes)
+	} else if c.isReadSelect(stmt) {
+		t.Fatal("must read master")
+	}
+
+	//locking select goes to master unless hinted
+	_, stmt, _ = hintShardList(t, c, "select * from mixer_test_ddl where id = 1 for update")
+	if c.isReadSelect(stmt) {
+		t.Fatal("must read master")
+	}
+
+	_, stmt, _ = hintShardList(t, c, "/* x */ /*mixer:slave*/ select * from mixer_test_ddl where id = 1 for update")
+	if !c.isReadSelect(stmt) {
+		t.Fatal("must read slave")
+	}
+
+	if _, _, err = hintShardList(t, c, "/*mixer:node=node9*/ select 1 from mixer_test_ddl"); err == nil {
+		t.Fatal("must error for unknown node")
+	}
+
+	c.hint = routeHint{slave: true}
+	if err = c.handleExec(nil, "delete from mixer_test_ddl", nil); err == nil {
+		t.Fatal("must error for slave hint of write")
+	}
+}
+
+func TestHint_Join(t *testing.T) {
+	c := newTestHintConn(t)
+
+	sql := "select * from mixer_test_ddl a join t b on a.id = b.id where a.id = 1"
+	if _, _, err := hintShardList(t, c, sql); err == nil {
+		t.Fatal("must reject join")
+	} else if _, err = c.joinNodes(sql + " lock in share mode"); err == nil {
+		t.Fatal("must reject unparsed join")
+	}
+
+	//node hint wins the join routing
+	if nodes, _, err := hintShardList(t, c, "/*mixer:node=node3*/ "+sql); err != nil {
+		t.Fatal(err)
+	} else if len(nodes) != 1 || nodes[0].String() != "node3" {
+		t.Fatal(nodes)
+	}
+
+	c.schema.rule.JoinFirstSharded = true
+	if nodes, _, err := hintShardList(t, c, sql); err != nil {
+		t.Fatal(err)
+	} else if len(nodes) != 1 || nodes[0].String() != "node2" {
+		t.Fatal(nodes)
+	}
+
+	if nodes, err := c.joinNodes(sql + " lock in share mode"); err != nil {
+		t.Fatal(err)
+	} else if len(nodes) != 1 || nodes[0].String() != "node2" {
+		t.Fatal(nodes)
+	}
+
+	if _, err := c.joinNodes("select * from mixer_test_ddl a, t b lock in share mode"); err == nil {
+		t.Fatal("must error for join on all nodes")
+	}
+}
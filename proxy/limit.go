@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/siddontang/mixer/config"
+	. "github.com/siddontang/mixer/mysql"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	limitNow   = time.Now
+	limitSleep = time.Sleep
+)
+
+var userStatusNames = []string{"User", "Conns", "Max_Conns", "Max_QPS",
+	"Rejected_Conns", "Delayed_Queries", "Rejected_Queries"}
+
+// userLimit enforces the connection and qps limits of a frontend user,
+// 0 means no limit.
+type userLimit struct {
+	user string
+
+	maxConns int32
+	conns    int32
+
+	//token bucket refilled by qps tokens per second up to burst, a query
+	//without token waits for it up to maxDelay, or is rejected if 0
+	qps      float64
+	burst    float64
+	maxDelay time.Duration
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+
+	rejectedConns   uint64
+	delayedQueries  uint64
+	rejectedQueries uint64
+}
+
+func newUserLimit(cfg config.UserConfig) *userLimit {
+	l := &userLimit{
+		user:     cfg.User,
+		maxConns: int32(cfg.MaxConns),
+		qps:      float64(cfg.MaxQPS),
+		burst:    float64(cfg.QPSBurst),
+		maxDelay: time.Duration(cfg.QPSMaxDelay) * time.Millisecond,
+	}
+
+	if l.burst <= 0 {
+		l.burst = l.qps
+	}
+	l.tokens = l.burst
+	l.last = limitNow()
+
+	return l
+}
+
+// acquireConn counts a new connection, it returns false if the user has
+// max conns already.
+func (l *userLimit) acquireConn() bool {
+	for {
+		n := atomic.LoadInt32(&l.conns)
+		if l.maxConns > 0 && n >= l.maxConns {
+			atomic.AddUint64(&l.rejectedConns, 1)
+			return false
+		}
+
+		if atomic.CompareAndSwapInt32(&l.conns, n, n+1) {
+			return true
+		}
+	}
+}
+
+func (l *userLimit) releaseConn() {
+	atomic.AddInt32(&l.conns, -1)
+}
+
+// reserve takes a token and returns how long to wait for it, it returns
+// false if the wait is over max delay.
+func (l *userLimit) reserve() (time.Duration, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := limitNow()
+	l.tokens += now.Sub(l.last).Seconds() * l.qps
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0, true
+	}
+
+	//the token is owed and paid by waiting
+	wait := time.Duration((1 - l.tokens) / l.qps * float64(time.Second))
+	if wait > l.maxDelay {
+		return 0, false
+	}
+
+	l.tokens--
+	return wait, true
+}
+
+// waitQuery applies the qps limit to a query of the user.
+func (l *userLimit) waitQuery() error {
+	if l.qps <= 0 {
+		return nil
+	}
+
+	wait, ok := l.reserve()
+	if !ok {
+		atomic.AddUint64(&l.rejectedQueries, 1)
+		return NewError(ER_USER_LIMIT_REACHED,
+			fmt.Sprintf("User '%s' has exceeded the 'max_qps' resource (current value: %d)", l.user, int64(l.qps)))
+	}
+
+	if wait > 0 {
+		atomic.AddUint64(&l.delayedQueries, 1)
+		limitSleep(wait)
+	}
+	return nil
+}
+
+// acquireLimit counts the connection for its user, it must be called
+// after the user is authenticated.
+func (c *Conn) acquireLimit() error {
+	l := c.server.limits[c.user]
+	if l == nil {
+		return nil
+	}
+
+	if !l.acquireConn() {
+		return NewDefaultError(ER_TOO_MANY_USER_CONNECTIONS, c.user)
+	}
+
+	c.limit = l
+	return nil
+}
+
+func (c *Conn) releaseLimit() {
+	if c.limit != nil {
+		c.limit.releaseConn()
+		c.limit = nil
+	}
+}
+
+func (c *Conn) waitQuery() error {
+	if c.limit == nil {
+		return nil
+	}
+	return c.limit.waitQuery()
+}
+
+// buildUsersStatus builds the resultset of show proxy users
+func buildUsersStatus(limits map[string]*userLimit) (*Resultset, error) {
+	users := make([]string, 0, len(limits))
+	for user := range limits {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	var values [][]interface{}
+	for _, user := range users {
+		l := limits[user]
+		values = append(values, []interface{}{
+			user,
+			int64(atomic.LoadInt32(&l.conns)),
+			int64(l.maxConns),
+			int64(l.qps),
+			atomic.LoadUint64(&l.rejectedConns),
+			atomic.LoadUint64(&l.delayedQueries),
+			atomic.LoadUint64(&l.rejectedQueries),
+		})
+	}
+
+	return buildResultset(userStatusNames, values)
+}
@@ -0,0 +1,224 @@
+package proxy
+
+import (
+	"github.com/siddontang/mixer/client"
+	"github.com/siddontang/mixer/config"
+	. "github.com/siddontang/mixer/mysql"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestLimitServer(t *testing.T, users []config.UserConfig) (*Server, net.Listener) {
+	s := new(Server)
+	s.cfg = &config.Config{User: "root", Users: users}
+	s.version = "5.6.0-mixer-test"
+	s.parseUsers()
+	s.schemas = map[string]*Schema{}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			co, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			c := s.newConn(co)
+			go func() {
+				if err := c.Handshake(); err != nil {
+					c.Close()
+					return
+				}
+				c.Run()
+			}()
+		}
+	}()
+
+	return s, l
+}
+
+func sqlErrorCode(err error) uint16 {
+	if e, ok := err.(*SqlError); ok {
+		return e.Code
+	}
+	return 0
+}
+
+// connectTestUsers connects n sessions of user at the same time
+func connectTestUsers(addr string, user string, n int) ([]*client.Conn, []error) {
+	conns := make([]*client.Conn, n)
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := new(client.Conn)
+			if errs[i] = c.Connect(addr, user, "", ""); errs[i] == nil {
+				conns[i] = c
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	return conns, errs
+}
+
+// queryTestSessions runs n queries on every session at the same time
+func queryTestSessions(t *testing.T, conns []*client.Conn, n int) (ok int64, rejected int64) {
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		if c == nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func(c *client.Conn) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				_, err := c.Execute("select version()")
+				switch {
+				case err == nil:
+					atomic.AddInt64(&ok, 1)
+				case sqlErrorCode(err) == ER_USER_LIMIT_REACHED:
+					atomic.AddInt64(&rejected, 1)
+				default:
+					t.Error(err)
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+	return
+}
+
+func TestLimit_Sessions(t *testing.T) {
+	//no refill, only the burst is allowed
+	now := time.Now()
+	limitNow = func() time.Time { return now }
+	defer func() { limitNow = time.Now }()
+
+	s, l := newTestLimitServer(t, []config.UserConfig{
+		{User: "limited", MaxConns: 4, MaxQPS: 1, QPSBurst: 5},
+		{User: "free"},
+	})
+	defer l.Close()
+
+	addr := l.Addr().String()
+
+	limited, errs := connectTestUsers(addr, "limited", 8)
+	free, freeErrs := connectTestUsers(addr, "free", 8)
+
+	n := 0
+	for i, err := range errs {
+		if err == nil {
+			n++
+			defer limited[i].Close()
+		} else if sqlErrorCode(err) != ER_TOO_MANY_USER_CONNECTIONS {
+			t.Fatal(err)
+		}
+	}
+	if n != 4 {
+		t.Fatal(n)
+	}
+
+	for i, err := range freeErrs {
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer free[i].Close()
+	}
+
+	if ok, rejected := queryTestSessions(t, limited, 5); ok != 5 || rejected != 15 {
+		t.Fatal(ok, rejected)
+	}
+	if ok, rejected := queryTestSessions(t, free, 5); ok != 40 || rejected != 0 {
+		t.Fatal(ok, rejected)
+	}
+
+	r, err := buildUsersStatus(s.limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//free, limited, root
+	if len(r.RowDatas) != 3 {
+		t.Fatal(len(r.RowDatas))
+	}
+	checkLimitStatus := func(row int, user string, conns int64, rejectedConns uint64, rejectedQueries uint64) {
+		vs, err := r.RowDatas[row].ParseText(r.Fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(vs[0].([]byte)) != user || vs[1].(int64) != conns ||
+			vs[4].(uint64) != rejectedConns || vs[6].(uint64) != rejectedQueries {
+			t.Fatal(row, vs)
+		}
+	}
+	checkLimitStatus(0, "free", 8, 0, 0)
+	checkLimitStatus(1, "limited", 4, 4, 15)
+
+	//a closed session frees its slot
+	for i, c := range limited {
+		if c != nil {
+			c.Close()
+			limited[i] = nil
+			break
+		}
+	}
+
+	var c client.Conn
+	for i := 0; ; i++ {
+		if err := c.Connect(addr, "limited", "", ""); err == nil {
+			break
+		} else if i == 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Close()
+}
+
+func TestLimit_QPSDelay(t *testing.T) {
+	now := time.Now()
+	limitNow = func() time.Time { return now }
+	defer func() { limitNow = time.Now }()
+
+	var slept []time.Duration
+	limitSleep = func(d time.Duration) {
+		slept = append(slept, d)
+		now = now.Add(d)
+	}
+	defer func() { limitSleep = time.Sleep }()
+
+	l := newUserLimit(config.UserConfig{User: "app", MaxQPS: 10, QPSBurst: 1, QPSMaxDelay: 250})
+
+	for i := 0; i < 3; i++ {
+		if err := l.waitQuery(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(slept) != 2 || slept[0] != 100*time.Millisecond || slept[1] != 100*time.Millisecond {
+		t.Fatal(slept)
+	}
+
+	//three owed tokens need 300ms, over the max delay
+	l.tokens = -2
+	l.last = now
+	if err := l.waitQuery(); sqlErrorCode(err) != ER_USER_LIMIT_REACHED {
+		t.Fatal(err)
+	}
+	if l.delayedQueries != 2 || l.rejectedQueries != 1 {
+		t.Fatal(l.delayedQueries, l.rejectedQueries)
+	}
+}
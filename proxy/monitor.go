@@ -0,0 +1,300 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"github.com/siddontang/go-log/log"
+	"github.com/siddontang/mixer/client"
+	"github.com/siddontang/mixer/config"
+	"sync"
+	"time"
+)
+
+var errProbeTimeout = errors.New("probe timeout")
+var errMasterReadOnly = errors.New("master is read only")
+
+const (
+	defaultProbeInterval = 10 * time.Second
+	defaultProbeTimeout  = 3 * time.Second
+	defaultProbeRise     = 2
+	defaultProbeFall     = 3
+)
+
+type MonitorConfig struct {
+	Interval time.Duration
+	Timeout  time.Duration
+	Rise     int
+	Fall     int
+}
+
+func newMonitorConfig(cfg config.HealthCheckConfig) MonitorConfig {
+	return MonitorConfig{
+		Interval: time.Duration(cfg.Interval) * time.Second,
+		Timeout:  time.Duration(cfg.Timeout) * time.Second,
+		Rise:     cfg.Rise,
+		Fall:     cfg.Fall,
+	}
+}
+
+// HealthEvent is emitted when a mysql server of a node changes its state.
+type HealthEvent struct {
+	Node string
+	Role string
+	Addr string
+	Up   bool
+
+	//error of the last failed probe when going down
+	Err error
+}
+
+func (e HealthEvent) String() string {
+	if e.Up {
+		return fmt.Sprintf("%s %s %s down -> up", e.Node, e.Role, e.Addr)
+	}
+	return fmt.Sprintf("%s %s %s up -> down, %v", e.Node, e.Role, e.Addr, e.Err)
+}
+
+// ProbeFunc checks db and returns the replication lag in seconds,
+// the lag is only fetched for a slave.
+type ProbeFunc func(db *client.DB, role string, timeout time.Duration) (int64, error)
+
+type dbHealth struct {
+	db *client.DB
+
+	up        bool
+	successes int
+	failures  int
+}
+
+// Monitor probes the master and slave of every node periodically,
+// a server is marked down after Fall consecutive failures, or if no
+// probe succeeded in the node's down_after_noalive, and marked up again
+// after Rise consecutive successes.
+type Monitor struct {
+	sync.Mutex
+
+	cfg   MonitorConfig
+	nodes []*Node
+
+	probe   ProbeFunc
+	onEvent func(HealthEvent)
+
+	health map[string]*dbHealth
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewMonitor(nodes map[string]*Node, cfg MonitorConfig, onEvent func(HealthEvent)) *Monitor {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultProbeInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultProbeTimeout
+	}
+	if cfg.Rise <= 0 {
+		cfg.Rise = defaultProbeRise
+	}
+	if cfg.Fall <= 0 {
+		cfg.Fall = defaultProbeFall
+	}
+
+	m := new(Monitor)
+	m.cfg = cfg
+	m.nodes = sortedNodes(nodes)
+	m.probe = probeDB
+	m.onEvent = onEvent
+	m.health = make(map[string]*dbHealth)
+
+	return m
+}
+
+// SetProbe replaces the default probe, must be called before Start.
+func (m *Monitor) SetProbe(probe ProbeFunc) {
+	m.probe = probe
+}
+
+func (m *Monitor) Start() {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.quit != nil {
+		return
+	}
+
+	m.quit = make(chan struct{})
+	m.wg.Add(1)
+	go m.run(m.quit)
+}
+
+// Stop stops probing and waits for the running round finished.
+func (m *Monitor) Stop() {
+	m.Lock()
+	quit := m.quit
+	m.quit = nil
+	m.Unlock()
+
+	if quit == nil {
+		return
+	}
+
+	close(quit)
+	m.wg.Wait()
+}
+
+func (m *Monitor) run(quit chan struct{}) {
+	defer m.wg.Done()
+
+	t := time.NewTicker(m.cfg.Interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			m.check()
+		case <-quit:
+			return
+		}
+	}
+}
+
+// check probes all servers once.
+func (m *Monitor) check() {
+	for _, n := range m.nodes {
+		n.Lock()
+		master := n.master
+		n.Unlock()
+
+		if master != nil {
+			m.checkDB(n, Master, master)
+		}
+
+		//slaves may be added or removed at any time
+		for _, slave := range n.slaveDBs() {
+			m.checkDB(n, Slave, slave)
+		}
+	}
+}
+
+func (m *Monitor) checkDB(n *Node, role string, db *client.DB) {
+	key := n.String() + "/" + role + "/" + db.Addr()
+
+	h, ok := m.health[key]
+	if !ok || h.db != db {
+		//a new server is up until probes prove not
+		h = &dbHealth{db: db, up: true}
+		m.health[key] = h
+	}
+
+	start := time.Now()
+	lag, err := m.probeWithTimeout(db, role)
+	latency := time.Since(start)
+
+	n.setProbeResult(role, db, latency, lag, err)
+
+	if err == nil {
+		h.successes++
+		h.failures = 0
+		if !h.up && h.successes >= m.cfg.Rise {
+			h.up = true
+			m.emit(n, role, db, nil)
+		}
+		return
+	}
+
+	h.failures++
+	h.successes = 0
+
+	if !h.up {
+		return
+	}
+
+	if h.failures >= m.cfg.Fall || n.noAliveTooLong(role, db) {
+		h.up = false
+		m.emit(n, role, db, err)
+	}
+}
+
+func (m *Monitor) probeWithTimeout(db *client.DB, role string) (int64, error) {
+	type result struct {
+		lag int64
+		err error
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		lag, err := m.probe(db, role, m.cfg.Timeout)
+		ch <- result{lag, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.lag, r.err
+	case <-time.After(m.cfg.Timeout):
+		return 0, errProbeTimeout
+	}
+}
+
+func (m *Monitor) emit(n *Node, role string, db *client.DB, err error) {
+	e := HealthEvent{Node: n.String(), Role: role, Addr: db.Addr(), Up: err == nil, Err: err}
+
+	n.setHealth(role, db, e.Up)
+
+	if e.Up {
+		log.Info("health check: %s", e)
+	} else {
+		log.Error("health check: %s", e)
+	}
+
+	if m.onEvent != nil {
+		m.onEvent(e)
+	}
+}
+
+// probeDB pings db and fetches Seconds_Behind_Master for a slave,
+// a slave whose replication is stopped is treated as failed, so is a
+// master which is read only, like a demoted one after failover.
+func probeDB(db *client.DB, role string, timeout time.Duration) (int64, error) {
+	co, err := db.PopConn()
+	if err != nil {
+		return 0, err
+	}
+
+	co.SetQueryTimeout(timeout)
+
+	var lag int64
+	if err = co.Ping(); err == nil {
+		if role == Slave {
+			lag, err = probeSlaveLag(co)
+		} else if readOnly, e := db.CheckReadOnly(co); e != nil {
+			err = e
+		} else if readOnly {
+			err = errMasterReadOnly
+		}
+	}
+
+	co.SetQueryTimeout(0)
+	db.PushConn(co, err)
+
+	return lag, err
+}
+
+func probeSlaveLag(co *client.Conn) (int64, error) {
+	r, err := co.Execute("show slave status")
+	if err != nil {
+		return 0, err
+	}
+
+	if r.RowNumber() == 0 {
+		//not a replica
+		return 0, nil
+	}
+
+	if isNull, err := r.IsNullByName(0, "Seconds_Behind_Master"); err != nil {
+		return 0, err
+	} else if isNull {
+		return 0, fmt.Errorf("replication is not running")
+	}
+
+	return r.GetIntByName(0, "Seconds_Behind_Master")
+}
@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/siddontang/mixer/client"
+	"github.com/siddontang/mixer/config"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scriptedBackends makes probes of an addr fail or hang by script
+type scriptedBackends struct {
+	sync.Mutex
+	down map[string]bool
+	hang map[string]bool
+}
+
+func (b *scriptedBackends) set(addr string, down bool) {
+	b.Lock()
+	b.down[addr] = down
+	b.Unlock()
+}
+
+func (b *scriptedBackends) probe(db *client.DB, role string, timeout time.Duration) (int64, error) {
+	b.Lock()
+	down, hang := b.down[db.Addr()], b.hang[db.Addr()]
+	b.Unlock()
+
+	if hang {
+		time.Sleep(timeout * 2)
+	}
+
+	if down {
+		return 0, fmt.Errorf("%s is down", db.Addr())
+	}
+
+	if role == Slave {
+		return 5, nil
+	}
+	return 0, nil
+}
+
+func newTestMonitorNode(name string, master string, slave string) *Node {
+	n := &Node{cfg: config.NodeConfig{Name: name, Master: master, Slave: slave, RWSplit: true}}
+	n.master, _ = client.Open(master, "root", "", "")
+	n.db = n.master
+	if len(slave) > 0 {
+		db, _ := client.Open(slave, "root", "", "")
+		n.AddSlave(db)
+	}
+	return n
+}
+
+func TestMonitor_Transitions(t *testing.T) {
+	b := &scriptedBackends{down: map[string]bool{}, hang: map[string]bool{}}
+
+	n := newTestMonitorNode("node1", "127.0.0.1:3306", "127.0.0.1:4306")
+
+	var events []HealthEvent
+	m := NewMonitor(map[string]*Node{"node1": n}, MonitorConfig{Rise: 2, Fall: 3}, func(e HealthEvent) {
+		events = append(events, e)
+	})
+	m.SetProbe(b.probe)
+
+	m.check()
+	if len(events) != 0 {
+		t.Fatal(events)
+	} else if n.slaves[0].lag != 5 {
+		t.Fatal(n.slaves[0].lag)
+	}
+
+	//flapping less than fall times must not mark down
+	b.set("127.0.0.1:4306", true)
+	m.check()
+	m.check()
+	b.set("127.0.0.1:4306", false)
+	m.check()
+	b.set("127.0.0.1:4306", true)
+	m.check()
+	m.check()
+	if len(events) != 0 {
+		t.Fatal(events)
+	}
+
+	m.check()
+	if len(events) != 1 || events[0].Up || events[0].Role != Slave || events[0].Err == nil {
+		t.Fatal(events)
+	}
+
+	if st := n.Status(); st[1].State != StateDown {
+		t.Fatal(st[1])
+	}
+
+	//slave is down, select must go to master
+	if db := n.getSelectDB(); db != n.master {
+		t.Fatal("must select master")
+	}
+
+	m.check()
+	if len(events) != 1 {
+		t.Fatal(events)
+	}
+
+	b.set("127.0.0.1:4306", false)
+	m.check()
+	if len(events) != 1 {
+		t.Fatal(events)
+	}
+
+	m.check()
+	if len(events) != 2 || !events[1].Up || events[1].Role != Slave {
+		t.Fatal(events)
+	}
+
+	if db := n.getSelectDB(); db != n.slaves[0].db {
+		t.Fatal("must select slave")
+	}
+}
+
+func TestMonitor_Timeout(t *testing.T) {
+	b := &scriptedBackends{down: map[string]bool{}, hang: map[string]bool{"127.0.0.1:3306": true}}
+
+	n := newTestMonitorNode("node1", "127.0.0.1:3306", "")
+
+	var events []HealthEvent
+	m := NewMonitor(map[string]*Node{"node1": n}, MonitorConfig{Timeout: 10 * time.Millisecond, Fall: 1},
+		func(e HealthEvent) {
+			events = append(events, e)
+		})
+	m.SetProbe(b.probe)
+
+	m.check()
+	if len(events) != 1 || events[0].Err != errProbeTimeout {
+		t.Fatal(events)
+	}
+
+	if _, err := n.getMasterConn(); err == nil {
+		t.Fatal("must error for down master")
+	}
+}
+
+func TestMonitor_StartStop(t *testing.T) {
+	b := &scriptedBackends{down: map[string]bool{"127.0.0.1:3306": true}, hang: map[string]bool{}}
+
+	n := newTestMonitorNode("node1", "127.0.0.1:3306", "")
+
+	ch := make(chan HealthEvent, 1)
+	m := NewMonitor(map[string]*Node{"node1": n}, MonitorConfig{Interval: 10 * time.Millisecond, Fall: 2},
+		func(e HealthEvent) {
+			ch <- e
+		})
+	m.SetProbe(b.probe)
+
+	m.Start()
+	m.Start()
+
+	select {
+	case e := <-ch:
+		if e.Up {
+			t.Fatal(e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+	}
+
+	m.Stop()
+	m.Stop()
+}
@@ -1,10 +1,14 @@
 package proxy
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"github.com/siddontang/go-log/log"
 	"github.com/siddontang/mixer/client"
 	"github.com/siddontang/mixer/config"
+	. "github.com/siddontang/mixer/mysql"
+	"io/ioutil"
 	"sync"
 	"time"
 )
@@ -25,31 +29,24 @@ type Node struct {
 	db *client.DB
 
 	master *client.DB
-	slave  *client.DB
+
+	//slaves share the selects in turn
+	slaves    []*slaveDB
+	nextSlave int
 
 	downAfterNoAlive time.Duration
 
 	lastMasterPing int64
-	lastSlavePing  int64
-}
 
-func (n *Node) run() {
-	//to do
-	//1 check connection alive
-	//2 check remove mysql server alive
+	//health state set by the monitor, a down server is not used
+	masterDown bool
 
-	t := time.NewTicker(3000 * time.Second)
-	defer t.Stop()
+	masterLatency time.Duration
 
-	n.lastMasterPing = time.Now().Unix()
-	n.lastSlavePing = n.lastMasterPing
-	for {
-		select {
-		case <-t.C:
-			n.checkMaster()
-			n.checkSlave()
-		}
-	}
+	readOnly bool
+
+	//warm up a new master by it
+	warmup WarmupSpec
 }
 
 func (n *Node) String() string {
@@ -59,75 +56,139 @@ func (n *Node) String() string {
 func (n *Node) getMasterConn() (*client.SqlConn, error) {
 	n.Lock()
 	db := n.db
+	if n.masterDown {
+		db = nil
+	}
 	n.Unlock()
 
 	if db == nil {
 		return nil, fmt.Errorf("master is down")
+	} else if db.IsReadOnly() {
+		return nil, fmt.Errorf("master %s is read only", db.Addr())
 	}
 
 	return db.GetConn()
 }
 
-func (n *Node) getSelectConn() (*client.SqlConn, error) {
-	var db *client.DB
-
+// Command executes an admin statement on the running master.
+func (n *Node) Command(sql string) (*Result, error) {
 	n.Lock()
-	if n.cfg.RWSplit && n.slave != nil {
-		db = n.slave
-	} else {
-		db = n.db
-	}
+	db := n.db
 	n.Unlock()
 
 	if db == nil {
-		return nil, fmt.Errorf("no alive mysql server")
+		return nil, fmt.Errorf("master is down")
 	}
 
-	return db.GetConn()
+	return db.Command(sql)
 }
 
-func (n *Node) checkMaster() {
+// selectSlave returns the next alive slave in turn and holds a checkout
+// on it, which must be released by the caller, must be called with lock.
+func (n *Node) selectSlave() *slaveDB {
+	for i := 0; i < len(n.slaves); i++ {
+		s := n.slaves[(n.nextSlave+i)%len(n.slaves)]
+		if !s.down && !s.draining {
+			n.nextSlave = (n.nextSlave + i + 1) % len(n.slaves)
+			s.checkouts++
+			return s
+		}
+	}
+
+	return nil
+}
+
+func (n *Node) releaseSlave(s *slaveDB) {
 	n.Lock()
-	db := n.db
+	s.checkouts--
 	n.Unlock()
+}
 
-	if db == nil {
-		log.Info("no master avaliable")
-		return
+// pickSelectDB returns the db for selects, a slave is returned with a
+// checkout held if rw_split.
+func (n *Node) pickSelectDB() (*slaveDB, *client.DB) {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.cfg.RWSplit {
+		if s := n.selectSlave(); s != nil {
+			return s, s.db
+		}
 	}
 
-	if err := db.Ping(); err != nil {
-		log.Error("%s ping master %s error %s", n, db.Addr(), err.Error())
-	} else {
-		n.lastMasterPing = time.Now().Unix()
-		return
+	if !n.masterDown {
+		return nil, n.db
 	}
+	return nil, nil
+}
 
-	if int64(n.downAfterNoAlive) > 0 && time.Now().Unix()-n.lastMasterPing > int64(n.downAfterNoAlive) {
-		log.Error("%s down master db %s", n, n.master.Addr())
+func (n *Node) getSelectDB() *client.DB {
+	s, db := n.pickSelectDB()
+	if s != nil {
+		n.releaseSlave(s)
+	}
+	return db
+}
+
+func (n *Node) getSelectConn() (*client.SqlConn, error) {
+	s, db := n.pickSelectDB()
+	if db == nil {
+		return nil, fmt.Errorf("no alive mysql server")
+	}
 
-		n.downMaster()
+	co, err := db.GetConn()
+	if s != nil {
+		//the conn is in use of the pool now, removal waits for it
+		n.releaseSlave(s)
 	}
+	return co, err
 }
 
-func (n *Node) checkSlave() {
-	if n.slave == nil {
-		return
+func (n *Node) setProbeResult(role string, db *client.DB, latency time.Duration, lag int64, err error) {
+	now := time.Now().Unix()
+
+	n.Lock()
+	if role == Master {
+		n.masterLatency = latency
+		if err == nil {
+			n.lastMasterPing = now
+		}
+	} else if s := n.findSlave(db.Addr()); s != nil && s.db == db {
+		s.latency = latency
+		if err == nil {
+			s.lastPing = now
+			s.lag = lag
+		}
 	}
+	n.Unlock()
+}
 
-	db := n.slave
-	if err := db.Ping(); err != nil {
-		log.Error("%s ping slave %s error %s", n, db.Addr(), err.Error())
-	} else {
-		n.lastSlavePing = time.Now().Unix()
+func (n *Node) setHealth(role string, db *client.DB, up bool) {
+	n.Lock()
+	if role == Master {
+		n.masterDown = !up
+	} else if s := n.findSlave(db.Addr()); s != nil && s.db == db {
+		s.down = !up
 	}
+	n.Unlock()
+}
 
-	if int64(n.downAfterNoAlive) > 0 && time.Now().Unix()-n.lastSlavePing > int64(n.downAfterNoAlive) {
-		log.Error("%s slave db %s not alive over %ds, down it",
-			n, db.Addr(), int64(n.downAfterNoAlive/time.Second))
+// noAliveTooLong checks no probe succeeded in down_after_noalive
+func (n *Node) noAliveTooLong(role string, db *client.DB) bool {
+	if n.downAfterNoAlive <= 0 {
+		return false
+	}
 
-		n.downSlave()
+	n.Lock()
+	last := n.lastMasterPing
+	if role == Slave {
+		if s := n.findSlave(db.Addr()); s != nil {
+			last = s.lastPing
+		}
 	}
+	n.Unlock()
+
+	return time.Now().Unix()-last > int64(n.downAfterNoAlive/time.Second)
 }
 
 func (n *Node) openDB(addr string) (*client.DB, error) {
@@ -137,9 +198,53 @@ func (n *Node) openDB(addr string) (*client.DB, error) {
 	}
 
 	db.SetMaxIdleConnNum(n.cfg.IdleConns)
+	db.SetMinIdleConns(n.cfg.MinIdleConns)
+	db.SetIdleGrace(time.Duration(n.cfg.IdleGrace) * time.Millisecond)
+	db.SetMaxOpenConns(n.cfg.MaxOpenConns)
+	db.SetWaitTimeout(time.Duration(n.cfg.PoolWaitTimeout) * time.Millisecond)
+	db.SetConnMaxLifetime(time.Duration(n.cfg.ConnMaxLifetime) * time.Second)
+	db.SetConnMaxIdleTime(time.Duration(n.cfg.ConnMaxIdleTime) * time.Second)
+	db.SetDialTimeout(time.Duration(n.cfg.DialTimeout) * time.Millisecond)
+	db.SetReadTimeout(time.Duration(n.cfg.ReadTimeout) * time.Millisecond)
+	db.SetWriteTimeout(time.Duration(n.cfg.WriteTimeout) * time.Millisecond)
+	db.SetTrackGTIDs(n.cfg.TrackGTIDs)
+
+	tlsConfig, err := n.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	db.SetTLSConfig(tlsConfig)
 	return db, nil
 }
 
+// tlsConfig returns the config of the tls mode of the node, nil for
+// plaintext
+func (n *Node) tlsConfig() (*tls.Config, error) {
+	switch n.cfg.TLS {
+	case "":
+		return nil, nil
+	case "skip-verify":
+		return client.SkipVerifyTLSConfig(), nil
+	case "verify-full":
+	default:
+		return nil, fmt.Errorf("%s invalid tls mode %s", n, n.cfg.TLS)
+	}
+
+	cfg := new(tls.Config)
+	if len(n.cfg.TLSCA) > 0 {
+		pem, err := ioutil.ReadFile(n.cfg.TLSCA)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s no certificate in tls_ca %s", n, n.cfg.TLSCA)
+		}
+	}
+	return cfg, nil
+}
+
 func (n *Node) checkUpDB(addr string) (*client.DB, error) {
 	db, err := n.openDB(addr)
 	if err != nil {
@@ -170,29 +275,34 @@ func (n *Node) upMaster(addr string) error {
 	n.Lock()
 	n.master = db
 	n.db = db
+	n.masterDown = false
+	n.lastMasterPing = time.Now().Unix()
 	n.Unlock()
 
+	//the new master pool is cold
+	go n.WarmUp(n.warmup)
+
 	return nil
 }
 
 func (n *Node) upSlave(addr string) error {
 	n.Lock()
-	if n.slave != nil {
-		n.Unlock()
-		return fmt.Errorf("%s, slave must be down first", n)
-	}
+	s := n.findSlave(addr)
 	n.Unlock()
 
+	if s != nil {
+		return fmt.Errorf("%s, slave %s must be down first", n, addr)
+	}
+
 	db, err := n.checkUpDB(addr)
 	if err != nil {
 		return err
 	}
 
-	n.Lock()
-	n.slave = db
-	n.Unlock()
-
-	return nil
+	if err = n.AddSlave(db); err != nil {
+		db.Close()
+	}
+	return err
 }
 
 func (n *Node) downMaster() error {
@@ -200,17 +310,26 @@ func (n *Node) downMaster() error {
 	if n.master != nil {
 		n.master = nil
 	}
+	n.Unlock()
 	return nil
 }
 
-func (n *Node) downSlave() error {
+func (n *Node) downSlave(addr string) error {
 	n.Lock()
-	db := n.slave
-	n.slave = nil
+	var addrs []string
+	if len(addr) > 0 {
+		addrs = []string{addr}
+	} else {
+		for _, s := range n.slaves {
+			addrs = append(addrs, s.db.Addr())
+		}
+	}
 	n.Unlock()
 
-	if db != nil {
-		db.Close()
+	for _, addr := range addrs {
+		if err := n.RemoveSlave(addr, false); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -242,12 +361,13 @@ func (s *Server) DownMaster(node string) error {
 	return n.downMaster()
 }
 
-func (s *Server) DownSlave(node string) error {
+// DownSlave removes the slave addr of node, or all slaves if addr is empty.
+func (s *Server) DownSlave(node string, addr string) error {
 	n := s.getNode(node)
 	if n == nil {
 		return fmt.Errorf("invalid node [%s].", node)
 	}
-	return n.downSlave()
+	return n.downSlave(addr)
 }
 
 func (s *Server) getNode(name string) *Node {
@@ -280,6 +400,8 @@ func (s *Server) parseNode(cfg config.NodeConfig) (*Node, error) {
 	n.cfg = cfg
 
 	n.downAfterNoAlive = time.Duration(cfg.DownAfterNoAlive) * time.Second
+	n.readOnly = cfg.ReadOnly
+	n.warmup = newWarmupSpec(cfg.Warmup)
 
 	if len(cfg.Master) == 0 {
 		return nil, fmt.Errorf("must setting master MySQL node.")
@@ -292,14 +414,27 @@ func (s *Server) parseNode(cfg config.NodeConfig) (*Node, error) {
 
 	n.db = n.master
 
-	if len(cfg.Slave) > 0 {
-		if n.slave, err = n.openDB(cfg.Slave); err != nil {
-			log.Error(err.Error())
-			n.slave = nil
+	n.lastMasterPing = time.Now().Unix()
+
+	for _, addr := range cfg.SlaveAddrs() {
+		var db *client.DB
+		if db, err = n.openDB(addr); err != nil {
+			log.Error("open %s slave %s error %v", n, addr, err)
+			continue
+		}
+
+		if err = n.AddSlave(db); err != nil {
+			log.Error("add %s slave %s error %v", n, addr, err)
+			db.Close()
 		}
 	}
 
-	go n.run()
+	if cfg.MinIdleConns > 0 {
+		//the pools are cold at startup
+		spec := n.warmup
+		spec.IdleConns = cfg.MinIdleConns
+		go n.WarmUp(spec)
+	}
 
 	return n, nil
 }
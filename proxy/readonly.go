@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	. "github.com/siddontang/mixer/mysql"
+	"sync/atomic"
+)
+
+func newReadOnlyError() error {
+	return NewDefaultError(ER_OPTION_PREVENTS_STATEMENT, "--read-only")
+}
+
+func atomicBool(v bool) int32 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// SetReadOnly rejects writes to all nodes in the proxy without touching
+// the backends, reads proceed normally.
+func (s *Server) SetReadOnly(readOnly bool) {
+	atomic.StoreInt32(&s.readOnly, atomicBool(readOnly))
+}
+
+func (s *Server) IsReadOnly() bool {
+	return atomic.LoadInt32(&s.readOnly) == 1
+}
+
+// SetReadOnlyAllowTx lets a transaction which has already begun on a backend
+// go on writing after read-only is enabled, so it can finish.
+func (s *Server) SetReadOnlyAllowTx(allow bool) {
+	atomic.StoreInt32(&s.readOnlyAllowTx, atomicBool(allow))
+}
+
+func (s *Server) isReadOnlyAllowTx() bool {
+	return atomic.LoadInt32(&s.readOnlyAllowTx) == 1
+}
+
+// SetReadOnly rejects writes to the node.
+func (n *Node) SetReadOnly(readOnly bool) {
+	n.Lock()
+	n.readOnly = readOnly
+	n.Unlock()
+}
+
+func (n *Node) IsReadOnly() bool {
+	n.Lock()
+	readOnly := n.readOnly
+	n.Unlock()
+	return readOnly
+}
+
+// checkReadOnly returns error 1290 if any node the write goes to is read-only.
+func (c *Conn) checkReadOnly(nodes []*Node) error {
+	global := c.server.IsReadOnly()
+
+	for _, n := range nodes {
+		if !global && !n.IsReadOnly() {
+			continue
+		}
+
+		if c.server.isReadOnlyAllowTx() && c.needBeginTx() {
+			c.Lock()
+			_, ok := c.txConns[n]
+			c.Unlock()
+
+			if ok {
+				continue
+			}
+		}
+
+		return newReadOnlyError()
+	}
+
+	return nil
+}
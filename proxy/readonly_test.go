@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"github.com/siddontang/mixer/client"
+	"github.com/siddontang/mixer/config"
+	. "github.com/siddontang/mixer/mysql"
+	"sync"
+	"testing"
+)
+
+func newTestReadOnlyConn() (*Conn, *Node, *Node) {
+	c := new(Conn)
+	c.server = new(Server)
+	c.status = SERVER_STATUS_AUTOCOMMIT
+	c.txConns = make(map[*Node]*client.SqlConn)
+
+	n1 := &Node{cfg: config.NodeConfig{Name: "node1"}}
+	n2 := &Node{cfg: config.NodeConfig{Name: "node2"}}
+
+	return c, n1, n2
+}
+
+func checkReadOnlyError(t *testing.T, err error) {
+	if err == nil {
+		t.Fatal("must reject write")
+	}
+
+	e, ok := err.(*SqlError)
+	if !ok || e.Code != ER_OPTION_PREVENTS_STATEMENT {
+		t.Fatal(err)
+	}
+}
+
+func TestReadOnly_Reject(t *testing.T) {
+	c, n1, n2 := newTestReadOnlyConn()
+
+	if err := c.checkReadOnly([]*Node{n1, n2}); err != nil {
+		t.Fatal(err)
+	}
+
+	n2.SetReadOnly(true)
+	if err := c.checkReadOnly([]*Node{n1}); err != nil {
+		t.Fatal(err)
+	}
+	checkReadOnlyError(t, c.checkReadOnly([]*Node{n1, n2}))
+
+	n2.SetReadOnly(false)
+	c.server.SetReadOnly(true)
+	checkReadOnlyError(t, c.checkReadOnly([]*Node{n1}))
+
+	c.server.SetReadOnly(false)
+	if err := c.checkReadOnly([]*Node{n1, n2}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadOnly_AllowTx(t *testing.T) {
+	c, n1, n2 := newTestReadOnlyConn()
+
+	//n1 has begun a transaction before read-only enabled
+	c.status |= SERVER_STATUS_IN_TRANS
+	c.txConns[n1] = new(client.SqlConn)
+
+	c.server.SetReadOnly(true)
+	checkReadOnlyError(t, c.checkReadOnly([]*Node{n1}))
+
+	c.server.SetReadOnlyAllowTx(true)
+	if err := c.checkReadOnly([]*Node{n1}); err != nil {
+		t.Fatal(err)
+	}
+
+	//a transaction can not start writing on a new node
+	checkReadOnlyError(t, c.checkReadOnly([]*Node{n1, n2}))
+
+	c.status &= ^SERVER_STATUS_IN_TRANS
+	delete(c.txConns, n1)
+	checkReadOnlyError(t, c.checkReadOnly([]*Node{n1}))
+}
+
+func TestReadOnly_Toggle(t *testing.T) {
+	c, n1, n2 := newTestReadOnlyConn()
+	nodes := []*Node{n1, n2}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if err := c.checkReadOnly(nodes); err != nil {
+					if e, ok := err.(*SqlError); !ok || e.Code != ER_OPTION_PREVENTS_STATEMENT {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for j := 0; j < 1000; j++ {
+		c.server.SetReadOnly(j%2 == 0)
+		n2.SetReadOnly(j%3 == 0)
+	}
+	wg.Wait()
+
+	c.server.SetReadOnly(false)
+	n2.SetReadOnly(false)
+	if err := c.checkReadOnly(nodes); err != nil {
+		t.Fatal(err)
+	}
+}
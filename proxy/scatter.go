@@ -0,0 +1,272 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	. "github.com/siddontang/mixer/mysql"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ShardError struct {
+	Node string
+	Err  error
+}
+
+// errShardAborted is the error of a shard canceled by the failure of
+// another one, see ScatterOptions
+var errShardAborted = errors.New("shard canceled, aborted by previous error")
+
+func (e ShardError) Error() string {
+	return fmt.Sprintf("node %s: %v", e.Node, e.Err)
+}
+
+// ScatterError holds every failed shard of a scatter query.
+type ScatterError []ShardError
+
+func (e ScatterError) Error() string {
+	s := make([]string, len(e))
+	for i := range e {
+		s[i] = e[i].Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+type ScatterOptions struct {
+	//physical table name format, see DDLOptions
+	TableFormat string
+
+	//max shards queried concurrently, <= 0 means all shards at once
+	Parallel int
+
+	//deadline for the whole gather, 0 means no timeout
+	Timeout time.Duration
+
+	//return rows of the succeeded shards together with a ScatterError
+	//instead of failing the whole query
+	AllowPartial bool
+
+	//ExecScatter skips the shards not started yet after a shard failed
+	//and cancels the running ones, by default every shard is executed and
+	//failures are reported
+	FailFast bool
+}
+
+// QueryScatter executes query on every target node of table concurrently and
+// merges the resultsets, nodes nil means all nodes of the table's rule. A
+// shard query still running when ctx is done, or when another shard fails
+// without AllowPartial, is killed on the backend and its conn closed.
+func (s *Schema) QueryScatter(ctx context.Context, table string, nodes []string,
+	query string, args []interface{}, opts ScatterOptions) (*Resultset, error) {
+	if nodes == nil {
+		nodes = s.rule.GetRule(table).Nodes
+	}
+
+	sqls := make([]string, len(nodes))
+	for i, node := range nodes {
+		if _, ok := s.nodes[node]; !ok {
+			return nil, fmt.Errorf("schema [%s] node [%s] not exists", s.db, node)
+		}
+
+		sqls[i] = query
+		if len(opts.TableFormat) > 0 {
+			sqls[i] = rewriteTableName(query, table, fmt.Sprintf(opts.TableFormat, table, i))
+		}
+	}
+
+	return gatherResultsets(ctx, nodes, opts, func(ctx context.Context, i int) (*Result, error) {
+		co, err := s.nodes[nodes[i]].getSelectConn()
+		if err != nil {
+			return nil, err
+		}
+		defer co.Close()
+
+		if err = co.UseDB(s.db); err != nil {
+			return nil, err
+		}
+
+		return co.ExecuteContext(ctx, sqls[i], args...)
+	})
+}
+
+// ExecScatter executes a write on the masters of every target node of table
+// concurrently, nodes nil means all nodes of the table's rule. Results of
+// the succeeded shards are merged, and every failed shard is reported. A
+// write is never retried, a shard aborted by ctx may or may not be applied.
+func (s *Schema) ExecScatter(ctx context.Context, table string, nodes []string,
+	query string, args []interface{}, opts ScatterOptions) (*Result, []ShardError) {
+	if nodes == nil {
+		nodes = s.rule.GetRule(table).Nodes
+	}
+
+	sqls := make([]string, len(nodes))
+	for i, node := range nodes {
+		if _, ok := s.nodes[node]; !ok {
+			return nil, []ShardError{{node, fmt.Errorf("schema [%s] node [%s] not exists", s.db, node)}}
+		}
+
+		sqls[i] = query
+		if len(opts.TableFormat) > 0 {
+			sqls[i] = rewriteTableName(query, table, fmt.Sprintf(opts.TableFormat, table, i))
+		}
+	}
+
+	return gatherResults(ctx, nodes, opts, func(ctx context.Context, i int) (*Result, error) {
+		co, err := s.nodes[nodes[i]].getMasterConn()
+		if err != nil {
+			return nil, err
+		}
+		defer co.Close()
+
+		if err = co.UseDB(s.db); err != nil {
+			return nil, err
+		}
+
+		return co.ExecuteContext(ctx, sqls[i], args...)
+	})
+}
+
+func gatherResults(ctx context.Context, nodes []string, opts ScatterOptions,
+	f func(ctx context.Context, i int) (*Result, error)) (*Result, []ShardError) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	rs := make([]*Result, len(nodes))
+
+	errs := scatter(ctx, len(nodes), opts.Parallel, opts.FailFast, func(ctx context.Context, i int) error {
+		var err error
+		rs[i], err = f(ctx, i)
+		return err
+	})
+
+	var se []ShardError
+	done := make([]*Result, 0, len(nodes))
+	for i, err := range errs {
+		if err != nil {
+			se = append(se, ShardError{nodes[i], err})
+		} else {
+			done = append(done, rs[i])
+		}
+	}
+
+	return mergeExecResults(done), se
+}
+
+func gatherResultsets(ctx context.Context, nodes []string, opts ScatterOptions,
+	f func(ctx context.Context, i int) (*Result, error)) (*Resultset, error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	rs := make([]*Result, len(nodes))
+
+	errs := scatter(ctx, len(nodes), opts.Parallel, !opts.AllowPartial, func(ctx context.Context, i int) error {
+		var err error
+		rs[i], err = f(ctx, i)
+		if err == nil && rs[i].Resultset == nil {
+			err = fmt.Errorf("query returns no resultset")
+		}
+		return err
+	})
+
+	var se ScatterError
+	sets := make([]*Resultset, 0, len(nodes))
+	for i, err := range errs {
+		if err != nil {
+			se = append(se, ShardError{nodes[i], err})
+		} else {
+			sets = append(sets, rs[i].Resultset)
+		}
+	}
+
+	if len(se) > 0 && (!opts.AllowPartial || len(sets) == 0) {
+		return nil, se
+	}
+
+	r, err := MergeResultsets(sets...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(se) > 0 {
+		return r, se
+	}
+	return r, nil
+}
+
+// scatter runs f for tasks [0, n) with at most parallel goroutines, the
+// returned slice holds each task's error. Tasks not started when ctx is done,
+// or after an error if failFast is set, are skipped, and with failFast the
+// running ones are canceled, their queries killed on the backend.
+func scatter(ctx context.Context, n int, parallel int, failFast bool, f func(ctx context.Context, i int) error) []error {
+	if parallel <= 0 || parallel > n {
+		parallel = n
+	}
+
+	errs := make([]error, n)
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var m sync.Mutex
+	var failed bool
+
+	sem := make(chan struct{}, parallel)
+
+	for i := 0; i < n; i++ {
+		acquired := false
+		select {
+		case sem <- struct{}{}:
+			acquired = true
+		case <-ctx.Done():
+		}
+
+		m.Lock()
+		aborted := failed && failFast
+		m.Unlock()
+
+		if err := parent.Err(); err != nil || aborted {
+			if acquired {
+				<-sem
+			}
+			errs[i] = err
+			if err == nil {
+				errs[i] = errShardSkipped
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if err := f(ctx, i); err != nil {
+				m.Lock()
+				if failFast && failed && parent.Err() == nil && ctx.Err() != nil {
+					err = errShardAborted
+				} else if failFast {
+					cancel()
+				}
+				errs[i] = err
+				failed = true
+				m.Unlock()
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	return errs
+}
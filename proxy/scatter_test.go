@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	. "github.com/siddontang/mixer/mysql"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestShardResult(values ...int64) *Result {
+	r := &Resultset{Fields: []*Field{&Field{Name: []byte("id")}}}
+	for _, v := range values {
+		r.Values = append(r.Values, []interface{}{v})
+		r.RowDatas = append(r.RowDatas, RowData(fmt.Sprintf("%d", v)))
+	}
+	return &Result{Resultset: r}
+}
+
+func TestScatter_Gather(t *testing.T) {
+	nodes := []string{"node1", "node2", "node3"}
+
+	shard := func(fail int, delay time.Duration) func(ctx context.Context, i int) (*Result, error) {
+		return func(ctx context.Context, i int) (*Result, error) {
+			if i == fail {
+				return nil, fmt.Errorf("mock error")
+			}
+
+			if delay > 0 && i == 2 {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+			return newTestShardResult(int64(i*10), int64(i*10+1)), nil
+		}
+	}
+
+	r, err := gatherResultsets(context.Background(), nodes, ScatterOptions{Parallel: 2}, shard(-1, 0))
+	if err != nil {
+		t.Fatal(err)
+	} else if r.RowNumber() != 6 {
+		t.Fatal(r.RowNumber())
+	}
+
+	if _, err = gatherResultsets(context.Background(), nodes, ScatterOptions{}, shard(1, 0)); err == nil {
+		t.Fatal("must error")
+	}
+
+	r, err = gatherResultsets(context.Background(), nodes, ScatterOptions{AllowPartial: true}, shard(1, 0))
+	if se, ok := err.(ScatterError); !ok || len(se) != 1 || se[0].Node != "node2" {
+		t.Fatal(err)
+	} else if r.RowNumber() != 4 {
+		t.Fatal(r.RowNumber())
+	}
+
+	start := time.Now()
+	opts := ScatterOptions{Timeout: 50 * time.Millisecond, AllowPartial: true}
+	r, err = gatherResultsets(context.Background(), nodes, opts, shard(-1, 10*time.Second))
+	if d := time.Now().Sub(start); d > time.Second {
+		t.Fatal("gather not cancelled in time", d)
+	}
+
+	if se, ok := err.(ScatterError); !ok || len(se) != 1 || se[0].Err != context.DeadlineExceeded {
+		t.Fatal(err)
+	} else if r.RowNumber() != 4 {
+		t.Fatal(r.RowNumber())
+	}
+}
+
+func TestScatter_FailFastCancel(t *testing.T) {
+	start := time.Now()
+	errs := scatter(context.Background(), 3, 0, true, func(ctx context.Context, i int) error {
+		if i == 0 {
+			//after the others started
+			time.Sleep(20 * time.Millisecond)
+			return fmt.Errorf("mock error")
+		}
+
+		select {
+		case <-time.After(10 * time.Second):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	if d := time.Now().Sub(start); d > time.Second {
+		t.Fatal("running shards not canceled in time", d)
+	}
+
+	if errs[0] == nil || errs[1] != errShardAborted || errs[2] != errShardAborted {
+		t.Fatal(errs)
+	}
+}
+
+// concurrencyBackend counts the shards executing at the same time
+type concurrencyBackend struct {
+	sync.Mutex
+	running int
+	max     int
+
+	fail map[int]bool
+}
+
+func (b *concurrencyBackend) exec(ctx context.Context, i int) (*Result, error) {
+	b.Lock()
+	b.running++
+	if b.running > b.max {
+		b.max = b.running
+	}
+	b.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	b.Lock()
+	b.running--
+	b.Unlock()
+
+	if b.fail[i] {
+		return nil, fmt.Errorf("mock error")
+	}
+	return &Result{AffectedRows: uint64(i + 1), InsertId: uint64(10 - i)}, nil
+}
+
+func TestScatter_Exec(t *testing.T) {
+	nodes := []string{"node1", "node2", "node3", "node4", "node5"}
+
+	b := &concurrencyBackend{}
+	r, se := gatherResults(context.Background(), nodes, ScatterOptions{Parallel: 2}, b.exec)
+	if se != nil {
+		t.Fatal(se)
+	} else if r.AffectedRows != 15 || r.InsertId != 6 {
+		t.Fatal(r)
+	} else if b.max != 2 {
+		t.Fatal("parallel limit", b.max)
+	}
+
+	b = &concurrencyBackend{fail: map[int]bool{1: true, 3: true}}
+	r, se = gatherResults(context.Background(), nodes, ScatterOptions{}, b.exec)
+	if len(se) != 2 || se[0].Node != "node2" || se[1].Node != "node4" {
+		t.Fatal(se)
+	} else if r.AffectedRows != 1+3+5 {
+		t.Fatal(r)
+	}
+
+	b = &concurrencyBackend{fail: map[int]bool{0: true}}
+	r, se = gatherResults(context.Background(), nodes, ScatterOptions{Parallel: 1, FailFast: true}, b.exec)
+	if len(se) != 5 || se[1].Err != errShardSkipped {
+		t.Fatal(se)
+	} else if r.AffectedRows != 0 {
+		t.Fatal(r)
+	}
+
+	//shards not started are aborted by the context
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Millisecond)
+	defer cancel()
+
+	b = &concurrencyBackend{}
+	r, se = gatherResults(ctx, nodes, ScatterOptions{Parallel: 1}, b.exec)
+	if len(se) == 0 || len(se) == 5 {
+		t.Fatal(se)
+	}
+	for _, e := range se {
+		if e.Err != context.DeadlineExceeded {
+			t.Fatal(e)
+		}
+	}
+	if int(r.AffectedRows) == 0 {
+		t.Fatal(r)
+	}
+}
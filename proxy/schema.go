@@ -3,6 +3,8 @@ package proxy
 import (
 	"fmt"
 	"github.com/siddontang/mixer/router"
+	"github.com/siddontang/mixer/sqlparser"
+	"strings"
 )
 
 type Schema struct {
@@ -11,6 +13,9 @@ type Schema struct {
 	nodes map[string]*Node
 
 	rule *router.Router
+
+	//reject unroutable statements
+	strict bool
 }
 
 func (s *Server) parseSchemas() error {
@@ -37,6 +42,10 @@ func (s *Server) parseSchemas() error {
 			nodes[n] = s.getNode(n)
 		}
 
+		if len(schemaCfg.RulesConifg.Default) == 0 {
+			schemaCfg.RulesConifg.Default = s.cfg.DefaultNode
+		}
+
 		rule, err := router.NewRouter(&schemaCfg)
 		if err != nil {
 			return err
@@ -46,6 +55,8 @@ func (s *Server) parseSchemas() error {
 			db:    schemaCfg.DB,
 			nodes: nodes,
 			rule:  rule,
+
+			strict: s.cfg.StrictRouting,
 		}
 	}
 
@@ -55,3 +66,50 @@ func (s *Server) parseSchemas() error {
 func (s *Server) getSchema(db string) *Schema {
 	return s.schemas[db]
 }
+
+// Explain returns how sql is routed in the schema, an unroutable
+// statement goes to the default node unless strict routing.
+func (s *Schema) Explain(sql string) (*sqlparser.RouteExplain, error) {
+	sql = strings.TrimRight(sql, "; ")
+
+	_, sql, err := parseHint(sql, true)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := sqlparser.Explain(sql, s.rule, nil)
+	if e == nil {
+		return nil, err
+	} else if e.Reason != sqlparser.ReasonUnroutable {
+		return e, nil
+	}
+
+	if err != nil {
+		err = fmt.Errorf(`parse sql "%s" error`, sql)
+	} else {
+		err = fmt.Errorf(`statement "%s" can not be routed`, sql)
+	}
+
+	if err = s.checkUnroutable(sql, err); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// checkUnroutable returns nil if sql can be sent to the default node
+func (s *Schema) checkUnroutable(sql string, reason error) error {
+	if s.strict {
+		return fmt.Errorf("%v, rejected by strict routing", reason)
+	}
+
+	if stmt, err := sqlparser.Parse(sql); err == nil {
+		if ddl, ok := stmt.(*sqlparser.DDL); ok {
+			table := string(ddl.Table)
+			if s.rule.GetRule(table) != s.rule.DefaultRule {
+				return fmt.Errorf("ddl on sharded table %s must be broadcast", table)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"github.com/siddontang/mixer/config"
+	"github.com/siddontang/mixer/router"
+	"github.com/siddontang/mixer/sqlparser"
+	"strings"
+	"testing"
+)
+
+func newTestRoutingServer(t *testing.T, strict bool) *Server {
+	s := new(Server)
+	s.cfg = &config.Config{
+		DefaultNode:   "node2",
+		StrictRouting: strict,
+		Schemas: []config.SchemaConfig{
+			{
+				DB:    "mixer",
+				Nodes: []string{"node1", "node2"},
+				RulesConifg: config.RulesConfig{
+					ShardRule: []config.ShardConfig{
+						{Table: "mixer_test_shard", Key: "id", Nodes: []string{"node1", "node2"}, Type: router.HashRuleType},
+					},
+				},
+			},
+			{
+				DB:    "mixer_override",
+				Nodes: []string{"node1", "node2"},
+				RulesConifg: config.RulesConfig{
+					Default: "node1",
+				},
+			},
+		},
+	}
+
+	s.nodes = make(map[string]*Node)
+	for _, name := range []string{"node1", "node2"} {
+		s.nodes[name] = &Node{cfg: config.NodeConfig{Name: name}}
+	}
+
+	if err := s.parseSchemas(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func checkSchemaExplain(t *testing.T, s *Schema, sql string, nodes string, reason string) {
+	e, err := s.Explain(sql)
+	if err != nil {
+		t.Fatal(sql, err)
+	} else if strings.Join(e.Nodes, ",") != nodes || e.Reason != reason {
+		t.Fatal(sql, *e)
+	}
+}
+
+func TestSchema_DefaultNode(t *testing.T) {
+	s := newTestRoutingServer(t, false)
+
+	schema := s.getSchema("mixer")
+	checkSchemaExplain(t, schema, "select * from mixer_test_unsharded where id = 1", "node2", sqlparser.ReasonUnsharded)
+	checkSchemaExplain(t, schema, "select * from mixer_test_shard where id = 1", "node2", sqlparser.ReasonShardKey)
+
+	//schema rules default overrides the global default node
+	checkSchemaExplain(t, s.getSchema("mixer_override"), "select * from mixer_test_unsharded where id = 1", "node1", sqlparser.ReasonUnsharded)
+}
+
+func TestSchema_Unroutable(t *testing.T) {
+	schema := newTestRoutingServer(t, false).getSchema("mixer")
+
+	checkSchemaExplain(t, schema, "call mixer_proc(1)", "node2", sqlparser.ReasonUnroutable)
+	checkSchemaExplain(t, schema, "/*mixer:master*/ call mixer_proc(1)", "node2", sqlparser.ReasonUnroutable)
+	checkSchemaExplain(t, schema, "create table mixer_test_unsharded (id int)", "node2", sqlparser.ReasonUnroutable)
+
+	//ddl on a sharded table must not go to one node
+	if _, err := schema.Explain("alter table mixer_test_shard add name int"); err == nil {
+		t.Fatal("must reject sharded ddl")
+	}
+
+	//analyze error is not unroutable
+	if _, err := schema.Explain("update mixer_test_shard set id = 2 where id = 1"); err == nil {
+		t.Fatal("must reject update routing key")
+	}
+}
+
+func TestSchema_StrictRouting(t *testing.T) {
+	schema := newTestRoutingServer(t, true).getSchema("mixer")
+
+	for _, sql := range []string{"call mixer_proc(1)", "create table mixer_test_unsharded (id int)"} {
+		if _, err := schema.Explain(sql); err == nil || !strings.Contains(err.Error(), "strict routing") {
+			t.Fatal(sql, err)
+		}
+	}
+
+	checkSchemaExplain(t, schema, "select * from mixer_test_unsharded where id = 1", "node2", sqlparser.ReasonUnsharded)
+}
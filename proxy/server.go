@@ -2,7 +2,9 @@ package proxy
 
 import (
 	"github.com/siddontang/go-log/log"
+	"github.com/siddontang/mixer/client"
 	"github.com/siddontang/mixer/config"
+	"github.com/siddontang/mixer/mysql"
 
 	"net"
 	"runtime"
@@ -18,11 +20,26 @@ type Server struct {
 
 	running bool
 
+	//atomic flags, see SetReadOnly
+	readOnly        int32
+	readOnlyAllowTx int32
+
 	listener net.Listener
 
 	nodes map[string]*Node
 
+	monitor *Monitor
+
 	schemas map[string]*Schema
+
+	filter *QueryFilter
+
+	//user to password
+	users map[string]string
+	//conn and qps limits of users
+	limits map[string]*userLimit
+
+	version string
 }
 
 func NewServer(cfg *config.Config) (*Server, error) {
@@ -34,6 +51,13 @@ func NewServer(cfg *config.Config) (*Server, error) {
 	s.user = cfg.User
 	s.password = cfg.Password
 
+	s.version = cfg.ServerVersion
+	if len(s.version) == 0 {
+		s.version = mysql.ServerVersion
+	}
+
+	s.parseUsers()
+
 	if err := s.parseNodes(); err != nil {
 		return nil, err
 	}
@@ -42,7 +66,19 @@ func NewServer(cfg *config.Config) (*Server, error) {
 		return nil, err
 	}
 
+	s.SetReadOnly(cfg.ReadOnly)
+	s.SetReadOnlyAllowTx(cfg.ReadOnlyAllowTx)
+
+	client.SetGlobalResultMemoryLimit(cfg.ResultMemoryLimit)
+	client.SetGlobalResultMemoryWait(cfg.ResultMemoryWait)
+
 	var err error
+	if s.filter, err = NewQueryFilter(cfg.Filter); err != nil {
+		return nil, err
+	}
+
+	s.monitor = NewMonitor(s.nodes, newMonitorConfig(cfg.HealthCheck), nil)
+
 	netProto := "tcp"
 	if strings.Contains(netProto, "/") {
 		netProto = "unix"
@@ -53,10 +89,17 @@ func NewServer(cfg *config.Config) (*Server, error) {
 		return nil, err
 	}
 
+	s.monitor.Start()
+
 	log.Info("Server run MySql Protocol Listen(%s) at [%s]", netProto, s.addr)
 	return s, nil
 }
 
+// SetFilter replaces the query filter rules atomically
+func (s *Server) SetFilter(cfg config.FilterConfig) error {
+	return s.filter.Set(cfg)
+}
+
 func (s *Server) Run() error {
 	s.running = true
 
@@ -78,6 +121,8 @@ func (s *Server) Close() {
 	if s.listener != nil {
 		s.listener.Close()
 	}
+
+	s.monitor.Stop()
 }
 
 func (s *Server) onConn(c net.Conn) {
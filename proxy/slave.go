@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/siddontang/go-log/log"
+	"github.com/siddontang/mixer/client"
+	"time"
+)
+
+// slaveDrainTimeout bounds how long a graceful removal waits for in-flight reads.
+var slaveDrainTimeout = 10 * time.Second
+
+const slaveDrainInterval = 10 * time.Millisecond
+
+type slaveDB struct {
+	db *client.DB
+
+	//health state set by the monitor
+	down     bool
+	latency  time.Duration
+	lag      int64
+	lastPing int64
+
+	//removing gracefully, no new checkout
+	draining bool
+
+	//selected but the conn not got from pool yet
+	checkouts int
+}
+
+// findSlave must be called with lock.
+func (n *Node) findSlave(addr string) *slaveDB {
+	for _, s := range n.slaves {
+		if s.db.Addr() == addr {
+			return s
+		}
+	}
+	return nil
+}
+
+// slaveDBs returns a snapshot of the slaves.
+func (n *Node) slaveDBs() []*client.DB {
+	n.Lock()
+	dbs := make([]*client.DB, len(n.slaves))
+	for i, s := range n.slaves {
+		dbs[i] = s.db
+	}
+	n.Unlock()
+
+	return dbs
+}
+
+// AddSlave adds db to the slaves of the node at runtime, it shares
+// the selects with other slaves immediately.
+func (n *Node) AddSlave(db *client.DB) error {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.findSlave(db.Addr()) != nil {
+		return fmt.Errorf("%s slave %s already exists", n, db.Addr())
+	}
+
+	n.slaves = append(n.slaves, &slaveDB{db: db, lastPing: time.Now().Unix()})
+	return nil
+}
+
+// RemoveSlave removes the slave addr from the node. A graceful removal
+// stops new selects to the slave and waits the in-flight ones finished,
+// at most slaveDrainTimeout, before closing its pool.
+func (n *Node) RemoveSlave(addr string, graceful bool) error {
+	n.Lock()
+	s := n.findSlave(addr)
+	if s == nil {
+		n.Unlock()
+		return fmt.Errorf("%s has no slave %s", n, addr)
+	} else if s.draining {
+		n.Unlock()
+		return fmt.Errorf("%s slave %s is removing", n, addr)
+	}
+	s.draining = true
+	n.Unlock()
+
+	if graceful {
+		n.drainSlave(s)
+	}
+
+	n.Lock()
+	slaves := make([]*slaveDB, 0, len(n.slaves))
+	for _, v := range n.slaves {
+		if v != s {
+			slaves = append(slaves, v)
+		}
+	}
+	n.slaves = slaves
+	n.Unlock()
+
+	s.db.Close()
+	return nil
+}
+
+func (n *Node) drainSlave(s *slaveDB) {
+	deadline := time.Now().Add(slaveDrainTimeout)
+	for {
+		n.Lock()
+		checkouts := s.checkouts
+		n.Unlock()
+
+		if checkouts == 0 && s.db.Stats().InUse == 0 {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			log.Warn("%s slave %s still has reads in flight after %v, close it", n, s.db.Addr(), slaveDrainTimeout)
+			return
+		}
+
+		time.Sleep(slaveDrainInterval)
+	}
+}
@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"github.com/siddontang/mixer/client"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// readLoad selects in a loop and holds every selected slave for a while
+// like a running read, it records errors if a read hits a removed slave.
+type readLoad struct {
+	sync.Mutex
+	n *Node
+
+	removed map[*client.DB]bool
+	reads   map[*client.DB]int
+	errors  int32
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newReadLoad(n *Node, workers int) *readLoad {
+	l := &readLoad{n: n, removed: map[*client.DB]bool{}, reads: map[*client.DB]int{}, quit: make(chan struct{})}
+
+	for i := 0; i < workers; i++ {
+		l.wg.Add(1)
+		go l.run()
+	}
+	return l
+}
+
+func (l *readLoad) run() {
+	defer l.wg.Done()
+
+	for {
+		select {
+		case <-l.quit:
+			return
+		default:
+		}
+
+		s, db := l.n.pickSelectDB()
+		if db == nil {
+			atomic.AddInt32(&l.errors, 1)
+			continue
+		}
+
+		time.Sleep(time.Millisecond)
+
+		l.Lock()
+		if l.removed[db] {
+			atomic.AddInt32(&l.errors, 1)
+		}
+		l.reads[db]++
+		l.Unlock()
+
+		if s != nil {
+			l.n.releaseSlave(s)
+		}
+	}
+}
+
+func (l *readLoad) count(db *client.DB) int {
+	l.Lock()
+	defer l.Unlock()
+	return l.reads[db]
+}
+
+func (l *readLoad) remove(addr string, graceful bool) error {
+	l.n.Lock()
+	db := l.n.findSlave(addr).db
+	l.n.Unlock()
+
+	err := l.n.RemoveSlave(addr, graceful)
+
+	l.Lock()
+	l.removed[db] = true
+	l.Unlock()
+
+	return err
+}
+
+func (l *readLoad) stop() {
+	close(l.quit)
+	l.wg.Wait()
+}
+
+func waitReads(t *testing.T, l *readLoad, db *client.DB) {
+	for i := 0; i < 100; i++ {
+		if l.count(db) > 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no reads to", db.Addr())
+}
+
+func TestSlave_AddRemove(t *testing.T) {
+	n := newTestMonitorNode("node1", "127.0.0.1:3306", "127.0.0.1:4306")
+	first := n.slaves[0].db
+
+	l := newReadLoad(n, 8)
+
+	waitReads(t, l, first)
+
+	second, _ := client.Open("127.0.0.1:4307", "root", "", "")
+	if err := n.AddSlave(second); err != nil {
+		t.Fatal(err)
+	} else if err := n.AddSlave(second); err == nil {
+		t.Fatal("must error for duplicate slave")
+	}
+
+	waitReads(t, l, second)
+
+	if err := l.remove("127.0.0.1:4306", true); err != nil {
+		t.Fatal(err)
+	}
+
+	before := l.count(second)
+	time.Sleep(20 * time.Millisecond)
+	if l.count(second) == before {
+		t.Fatal("reads must go to the left slave")
+	}
+
+	if err := l.remove("127.0.0.1:4307", true); err != nil {
+		t.Fatal(err)
+	}
+
+	//all reads go to master now
+	waitReads(t, l, n.master)
+
+	l.stop()
+
+	if l.errors != 0 {
+		t.Fatal(l.errors)
+	}
+
+	if err := n.RemoveSlave("127.0.0.1:4307", true); err == nil {
+		t.Fatal("must error for removed slave")
+	}
+}
+
+func TestSlave_DrainTimeout(t *testing.T) {
+	old := slaveDrainTimeout
+	slaveDrainTimeout = 50 * time.Millisecond
+	defer func() {
+		slaveDrainTimeout = old
+	}()
+
+	n := newTestMonitorNode("node1", "127.0.0.1:3306", "127.0.0.1:4306")
+
+	//a read never finished
+	s, _ := n.pickSelectDB()
+	if s == nil {
+		t.Fatal("must select slave")
+	}
+
+	start := time.Now()
+	if err := n.RemoveSlave("127.0.0.1:4306", true); err != nil {
+		t.Fatal(err)
+	} else if d := time.Since(start); d < slaveDrainTimeout {
+		t.Fatal(d)
+	}
+
+	if len(n.slaves) != 0 {
+		t.Fatal(len(n.slaves))
+	}
+}
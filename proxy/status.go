@@ -0,0 +1,286 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/siddontang/mixer/client"
+	. "github.com/siddontang/mixer/mysql"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	StateUp   = "up"
+	StateDown = "down"
+)
+
+var (
+	poolStatusNames = []string{"Node", "Role", "Addr", "Max_Idle",
+		"Open", "Idle", "In_Use", "Acquired", "Errors", "Max_Stmts", "Idle_Stmts", "Idle_Full_Closes",
+		"Cache_Hits", "Cache_Misses", "Created", "Reused", "Closed", "Waits", "Wait_Time"}
+
+	nodeStatusNames = []string{"Node", "Role", "Addr", "State",
+		"Idle", "In_Use", "Lag", "Error_Rate", "Read_Only"}
+
+	connStatusNames = []string{"Node", "Role", "Addr", "Id", "Created", "Last_Used",
+		"In_Use", "DB", "In_Trans", "Stmts"}
+
+	ruleStatusNames = []string{"DB", "Table", "Type", "Key", "Nodes"}
+
+	versionStatusNames = []string{"Variable_name", "Value"}
+)
+
+// DBStatus is the state of a master or slave mysql server of a node.
+type DBStatus struct {
+	Node  string
+	Role  string
+	Addr  string
+	State string
+
+	//replication lag in seconds fetched by the monitor, 0 for master
+	Lag int64
+
+	//duration of the last probe
+	Latency time.Duration
+
+	//writes to the node are rejected by proxy
+	ReadOnly bool
+
+	Stats client.DBStats
+}
+
+func (s *DBStatus) ErrorRate() float64 {
+	if s.Stats.Acquired == 0 {
+		return 0
+	}
+	return float64(s.Stats.Failed) / float64(s.Stats.Acquired)
+}
+
+// Status returns the master status and the status of every slave.
+func (n *Node) Status() []DBStatus {
+	n.Lock()
+	running := n.db
+	master := n.master
+	masterUp := !n.masterDown
+	m := DBStatus{Node: n.cfg.Name, Role: Master, Addr: n.cfg.Master, State: StateDown,
+		Latency: n.masterLatency, ReadOnly: n.readOnly}
+
+	slaves := make([]DBStatus, len(n.slaves))
+	slaveDBs := make([]*client.DB, len(n.slaves))
+	var missing []DBStatus
+	for i, s := range n.slaves {
+		slaves[i] = DBStatus{Node: n.cfg.Name, Role: Slave, Addr: s.db.Addr(), State: StateDown,
+			Latency: s.latency, Lag: s.lag, ReadOnly: n.readOnly}
+		if !s.down && !s.draining {
+			slaves[i].State = StateUp
+		}
+		slaveDBs[i] = s.db
+	}
+
+	//a configured slave failed to open or removed is down
+	for _, addr := range n.cfg.SlaveAddrs() {
+		if n.findSlave(addr) == nil {
+			missing = append(missing, DBStatus{Node: n.cfg.Name, Role: Slave, Addr: addr, State: StateDown,
+				ReadOnly: n.readOnly})
+		}
+	}
+	n.Unlock()
+
+	st := make([]DBStatus, 0, 1+len(slaves)+len(missing))
+
+	if master != nil {
+		m.Addr = master.Addr()
+		m.Stats = master.Stats()
+		if running == master && masterUp {
+			m.State = StateUp
+		}
+	}
+	st = append(st, m)
+
+	for i, s := range slaves {
+		s.Stats = slaveDBs[i].Stats()
+		st = append(st, s)
+	}
+
+	return append(st, missing...)
+}
+
+func sortedNodes(nodes map[string]*Node) []*Node {
+	names := make([]string, 0, len(nodes))
+	for name := range nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ns := make([]*Node, len(names))
+	for i, name := range names {
+		ns[i] = nodes[name]
+	}
+	return ns
+}
+
+// buildPoolStatus builds the resultset of show proxy pool, one row per connection pool
+func buildPoolStatus(nodes map[string]*Node) (*Resultset, error) {
+	var values [][]interface{}
+	for _, n := range sortedNodes(nodes) {
+		for _, s := range n.Status() {
+			values = append(values, []interface{}{
+				s.Node,
+				s.Role,
+				s.Addr,
+				int64(s.Stats.MaxIdleConns),
+				int64(s.Stats.OpenConns),
+				int64(s.Stats.IdleConns),
+				int64(s.Stats.InUse),
+				s.Stats.Acquired,
+				s.Stats.Failed,
+				int64(s.Stats.MaxStmtsPerConn),
+				int64(s.Stats.IdleStmts),
+				s.Stats.IdleFullCloses,
+				s.Stats.CacheHits,
+				s.Stats.CacheMisses,
+				s.Stats.Created,
+				s.Stats.Reused,
+				s.Stats.Closed,
+				s.Stats.WaitCount,
+				//milliseconds
+				int64(s.Stats.WaitDuration / time.Millisecond),
+			})
+		}
+	}
+
+	return buildResultset(poolStatusNames, values)
+}
+
+// buildConnsStatus builds the resultset of show proxy conns, one row per
+// backend connection of every pool
+func buildConnsStatus(nodes map[string]*Node) (*Resultset, error) {
+	var values [][]interface{}
+	for _, n := range sortedNodes(nodes) {
+		roles, dbs := n.roleDBs()
+		for i, db := range dbs {
+			for _, ci := range db.DumpConns() {
+				values = append(values, []interface{}{
+					n.cfg.Name,
+					roles[i],
+					db.Addr(),
+					ci.ConnectionId,
+					formatStatusTime(ci.Created),
+					formatStatusTime(ci.LastUsed),
+					onOff(ci.InUse),
+					ci.DB,
+					onOff(ci.InTransaction),
+					int64(ci.Stmts),
+				})
+			}
+		}
+	}
+
+	return buildResultset(connStatusNames, values)
+}
+
+// roleDBs returns the master and slave pools of n with their roles
+func (n *Node) roleDBs() ([]string, []*client.DB) {
+	n.Lock()
+	defer n.Unlock()
+
+	var roles []string
+	var dbs []*client.DB
+	if n.master != nil {
+		roles = append(roles, Master)
+		dbs = append(dbs, n.master)
+	}
+	for _, s := range n.slaves {
+		roles = append(roles, Slave)
+		dbs = append(dbs, s.db)
+	}
+	return roles, dbs
+}
+
+// FlushResultCache drops the cached results of the master and slaves.
+func (n *Node) FlushResultCache() {
+	_, dbs := n.roleDBs()
+	for _, db := range dbs {
+		db.FlushResultCache()
+	}
+}
+
+func formatStatusTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+func onOff(b bool) string {
+	if b {
+		return "ON"
+	}
+	return "OFF"
+}
+
+// buildNodesStatus builds the resultset of show proxy nodes,
+// all nodes are read-only if readOnly is set
+func buildNodesStatus(nodes map[string]*Node, readOnly bool) (*Resultset, error) {
+	var values [][]interface{}
+	for _, n := range sortedNodes(nodes) {
+		for _, s := range n.Status() {
+			values = append(values, []interface{}{
+				s.Node,
+				s.Role,
+				s.Addr,
+				s.State,
+				int64(s.Stats.IdleConns),
+				int64(s.Stats.InUse),
+				s.Lag,
+				s.ErrorRate(),
+				onOff(readOnly || s.ReadOnly),
+			})
+		}
+	}
+
+	return buildResultset(nodeStatusNames, values)
+}
+
+// buildRulesStatus builds the resultset of show proxy rules, default rules are listed
+// with table "*"
+func buildRulesStatus(schemas map[string]*Schema) (*Resultset, error) {
+	dbs := make([]string, 0, len(schemas))
+	for db := range schemas {
+		dbs = append(dbs, db)
+	}
+	sort.Strings(dbs)
+
+	var values [][]interface{}
+	for _, db := range dbs {
+		rt := schemas[db].rule
+
+		d := rt.DefaultRule
+		values = append(values, []interface{}{db, "*", d.Type, d.Key, strings.Join(d.Nodes, ",")})
+
+		tables := make([]string, 0, len(rt.Rules))
+		for table := range rt.Rules {
+			tables = append(tables, table)
+		}
+		sort.Strings(tables)
+
+		for _, table := range tables {
+			r := rt.Rules[table]
+			values = append(values, []interface{}{db, table, r.Type, r.Key, strings.Join(r.Nodes, ",")})
+		}
+	}
+
+	return buildResultset(ruleStatusNames, values)
+}
+
+// buildVersionStatus builds the resultset of show proxy version
+func buildVersionStatus() (*Resultset, error) {
+	values := [][]interface{}{
+		{"version", ServerVersion},
+		{"protocol_version", fmt.Sprintf("%d", MinProtocolVersion)},
+		{"go_version", runtime.Version()},
+	}
+
+	return buildResultset(versionStatusNames, values)
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"github.com/siddontang/mixer/client"
+	"github.com/siddontang/mixer/config"
+	. "github.com/siddontang/mixer/mysql"
+	"testing"
+)
+
+func checkStatusFields(t *testing.T, r *Resultset, names []string) {
+	if len(r.Fields) != len(names) {
+		t.Fatal(len(r.Fields), len(names))
+	}
+
+	for i, name := range names {
+		if string(r.Fields[i].Name) != name {
+			t.Fatal(i, string(r.Fields[i].Name), name)
+		}
+	}
+}
+
+func TestStatus_Layout(t *testing.T) {
+	master, _ := client.Open("127.0.0.1:3306", "root", "", "")
+	master.SetMaxIdleConnNum(8)
+
+	nodes := map[string]*Node{
+		"node2": &Node{cfg: config.NodeConfig{Name: "node2", Master: "127.0.0.1:3307"}},
+		"node1": &Node{
+			cfg:    config.NodeConfig{Name: "node1", Master: "127.0.0.1:3306", Slave: "127.0.0.1:4306"},
+			db:     master,
+			master: master,
+		},
+	}
+
+	r, err := buildNodesStatus(nodes, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStatusFields(t, r, nodeStatusNames)
+
+	if len(r.RowDatas) != 3 {
+		t.Fatal(len(r.RowDatas))
+	}
+
+	rows := []struct{ node, role, state string }{
+		{"node1", Master, StateUp},
+		{"node1", Slave, StateDown},
+		{"node2", Master, StateDown},
+	}
+
+	for i, row := range rows {
+		vs, err := r.RowDatas[i].ParseText(r.Fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(vs[0].([]byte)) != row.node || string(vs[1].([]byte)) != row.role ||
+			string(vs[3].([]byte)) != row.state {
+			t.Fatal(i, vs)
+		}
+	}
+
+	if r, err = buildPoolStatus(nodes); err != nil {
+		t.Fatal(err)
+	}
+	checkStatusFields(t, r, poolStatusNames)
+
+	if vs, err := r.RowDatas[0].ParseText(r.Fields); err != nil {
+		t.Fatal(err)
+	} else if vs[3].(int64) != 8 {
+		t.Fatal(vs)
+	}
+
+	if r, err = buildPoolStatus(map[string]*Node{}); err != nil {
+		t.Fatal(err)
+	}
+	checkStatusFields(t, r, poolStatusNames)
+
+	if r, err = buildConnsStatus(nodes); err != nil {
+		t.Fatal(err)
+	}
+	checkStatusFields(t, r, connStatusNames)
+
+	s := newTestDDLSchema(t)
+	if r, err = buildRulesStatus(map[string]*Schema{s.db: s}); err != nil {
+		t.Fatal(err)
+	}
+	checkStatusFields(t, r, ruleStatusNames)
+
+	if len(r.RowDatas) != 2 {
+		t.Fatal(len(r.RowDatas))
+	}
+
+	if r, err = buildVersionStatus(); err != nil {
+		t.Fatal(err)
+	}
+	checkStatusFields(t, r, versionStatusNames)
+
+	if vs, err := r.RowDatas[0].ParseText(r.Fields); err != nil {
+		t.Fatal(err)
+	} else if string(vs[1].([]byte)) != ServerVersion {
+		t.Fatal(vs)
+	}
+}
+
+func TestStatus_PoolTracking(t *testing.T) {
+	n := newTestServer(t).getNode("node1")
+
+	before := n.Status()[0].Stats
+
+	conns := make([]*client.SqlConn, 4)
+	for i := range conns {
+		co, err := n.getMasterConn()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := co.Execute("select 1"); err != nil {
+			t.Fatal(err)
+		}
+		conns[i] = co
+	}
+
+	during := n.Status()[0].Stats
+	if during.InUse < 4 {
+		t.Fatal(during.InUse)
+	} else if during.Acquired != before.Acquired+4 {
+		t.Fatal(during.Acquired, before.Acquired)
+	}
+
+	for _, co := range conns {
+		co.Close()
+	}
+
+	after := n.Status()[0].Stats
+	if after.InUse != during.InUse-4 {
+		t.Fatal(after.InUse, during.InUse)
+	} else if after.IdleConns < 4 {
+		t.Fatal(after.IdleConns)
+	}
+}
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/siddontang/go-log/log"
+	"github.com/siddontang/mixer/client"
+	"github.com/siddontang/mixer/config"
+	. "github.com/siddontang/mixer/mysql"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWarmupParallel = 4
+	defaultWarmupTimeout  = 10 * time.Second
+)
+
+var warmupStatusNames = []string{"Node", "Role", "Addr", "Idle", "Warmed", "Error"}
+
+// WarmupSpec describes how to warm up the mysql servers of a node.
+type WarmupSpec struct {
+	//idle conns to open for every server, 0 uses the node's idle_conns
+	IdleConns int
+	//statements prepared on every warmed conn
+	Stmts []string
+	//conns warmed at the same time for every server
+	Parallel int
+	Timeout  time.Duration
+}
+
+func newWarmupSpec(cfg config.WarmupConfig) WarmupSpec {
+	return WarmupSpec{
+		IdleConns: cfg.IdleConns,
+		Stmts:     cfg.Stmts,
+		Parallel:  cfg.Parallel,
+		Timeout:   time.Duration(cfg.Timeout) * time.Second,
+	}
+}
+
+// WarmupResult is the warm up result of a master or slave server.
+type WarmupResult struct {
+	Node string
+	Role string
+	Addr string
+
+	//idle conns after warm up
+	Idle   int
+	Warmed int
+	Err    error
+}
+
+// WarmUp opens idle conns and prepares statements on the master and all
+// slaves of the node in parallel, returns the result of every server and
+// the first error.
+func (n *Node) WarmUp(spec WarmupSpec) ([]WarmupResult, error) {
+	if spec.IdleConns <= 0 {
+		spec.IdleConns = n.cfg.IdleConns
+	}
+	if spec.Parallel <= 0 {
+		spec.Parallel = defaultWarmupParallel
+	}
+	if spec.Timeout <= 0 {
+		spec.Timeout = defaultWarmupTimeout
+	}
+
+	n.Lock()
+	master := n.master
+	n.Unlock()
+
+	var dbs []*client.DB
+	var roles []string
+	if master != nil {
+		dbs = append(dbs, master)
+		roles = append(roles, Master)
+	}
+	for _, db := range n.slaveDBs() {
+		dbs = append(dbs, db)
+		roles = append(roles, Slave)
+	}
+
+	deadline := time.Now().Add(spec.Timeout)
+
+	results := make([]WarmupResult, len(dbs))
+
+	var wg sync.WaitGroup
+	for i, db := range dbs {
+		wg.Add(1)
+		go func(r *WarmupResult, db *client.DB) {
+			defer wg.Done()
+
+			r.Warmed, r.Err = db.WarmUp(spec.IdleConns, spec.Stmts, spec.Parallel, deadline)
+			r.Idle = db.Stats().IdleConns
+		}(&results[i], db)
+
+		results[i].Node = n.String()
+		results[i].Role = roles[i]
+		results[i].Addr = db.Addr()
+	}
+	wg.Wait()
+
+	var err error
+	for _, r := range results {
+		if r.Err != nil {
+			log.Warn("warm up %s %s %s error %v", r.Node, r.Role, r.Addr, r.Err)
+			if err == nil {
+				err = fmt.Errorf("warm up %s %s %s error %v", r.Node, r.Role, r.Addr, r.Err)
+			}
+		}
+	}
+
+	return results, err
+}
+
+// WarmUp warms up node by its configured warmup spec.
+func (s *Server) WarmUp(node string) ([]WarmupResult, error) {
+	n := s.getNode(node)
+	if n == nil {
+		return nil, fmt.Errorf("invalid node %s", node)
+	}
+
+	return n.WarmUp(n.warmup)
+}
+
+func buildWarmupStatus(results []WarmupResult) (*Resultset, error) {
+	var values [][]interface{}
+	for _, r := range results {
+		var e string
+		if r.Err != nil {
+			e = r.Err.Error()
+		}
+		values = append(values, []interface{}{r.Node, r.Role, r.Addr, int64(r.Idle), int64(r.Warmed), e})
+	}
+
+	return buildResultset(warmupStatusNames, values)
+}
@@ -0,0 +1,34 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWarmUp_Unreachable(t *testing.T) {
+	//nothing listens on port 1
+	n := newTestMonitorNode("node1", "127.0.0.1:1", "127.0.0.1:2")
+	n.master.SetMaxIdleConnNum(4)
+	n.slaves[0].db.SetMaxIdleConnNum(4)
+
+	results, err := n.WarmUp(WarmupSpec{IdleConns: 2, Stmts: []string{"select 1"}, Timeout: time.Second})
+	if err == nil {
+		t.Fatal("must error")
+	}
+
+	if len(results) != 2 {
+		t.Fatal(len(results))
+	}
+
+	for i, role := range []string{Master, Slave} {
+		if r := results[i]; r.Role != role || r.Err == nil || r.Warmed != 0 || r.Idle != 0 {
+			t.Fatal(r)
+		}
+	}
+
+	r, err := buildWarmupStatus(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStatusFields(t, r, warmupStatusNames)
+}
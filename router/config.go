@@ -12,6 +12,9 @@ var (
 	DefaultRuleType = "default"
 	HashRuleType    = "hash"
 	RangeRuleType   = "range"
+
+	JoinReject = "reject"
+	JoinFirst  = "first"
 )
 
 type RuleConfig struct {
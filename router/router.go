@@ -55,6 +55,9 @@ type Router struct {
 	Rules       map[string]*Rule //key is <table name>
 	DefaultRule *Rule
 	nodes       []string //just for human saw
+
+	//route a multi-table statement by its first sharded table instead of rejecting
+	JoinFirstSharded bool
 }
 
 func NewRouter(schemaConfig *config.SchemaConfig) (*Router, error) {
@@ -70,6 +73,15 @@ func NewRouter(schemaConfig *config.SchemaConfig) (*Router, error) {
 	rt.Rules = make(map[string]*Rule, len(schemaConfig.RulesConifg.ShardRule))
 	rt.DefaultRule = NewDefaultRule(rt.DB, schemaConfig.RulesConifg.Default)
 
+	switch schemaConfig.RulesConifg.Join {
+	case "", JoinReject:
+	case JoinFirst:
+		rt.JoinFirstSharded = true
+	default:
+		return nil, fmt.Errorf("invalid join route %s, must %s or %s",
+			schemaConfig.RulesConifg.Join, JoinReject, JoinFirst)
+	}
+
 	for _, shard := range schemaConfig.RulesConifg.ShardRule {
 		rc := &RuleConfig{shard}
 		for _, node := range shard.Nodes {
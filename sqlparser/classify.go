@@ -0,0 +1,43 @@
+package sqlparser
+
+import (
+	"strings"
+)
+
+const (
+	ClassDDL = "ddl"
+
+	//update or delete without where
+	ClassFullUpdate = "full_update"
+	ClassFullDelete = "full_delete"
+)
+
+// Classify returns the classes of sql: the lowered first keyword like
+// select, delete or drop, ddl for create, alter, drop, rename and truncate,
+// full_update and full_delete for update and delete without where.
+// It returns nil if sql can not be tokenized.
+func Classify(sql string) []string {
+	toks, err := liteTokens(sql)
+	if err != nil || len(toks) == 0 {
+		return nil
+	}
+
+	first := strings.ToLower(toks[0].val)
+	classes := []string{first}
+
+	switch first {
+	case "create", "alter", "drop", "rename", "truncate":
+		classes = append(classes, ClassDDL)
+	case "update", "delete":
+		p := &liteParser{toks: toks[1:]}
+		if err := p.skipUntil("where"); err != nil || !p.peek().is("where") {
+			if first == "update" {
+				classes = append(classes, ClassFullUpdate)
+			} else {
+				classes = append(classes, ClassFullDelete)
+			}
+		}
+	}
+
+	return classes
+}
@@ -0,0 +1,97 @@
+package sqlparser
+
+import (
+	"github.com/siddontang/mixer/router"
+)
+
+const (
+	ReasonShardKey   = "shard key"
+	ReasonAllShards  = "all shards"
+	ReasonSingleNode = "single node rule"
+	ReasonUnsharded  = "unsharded table"
+	ReasonNoWhere    = "no where clause"
+	ReasonUnroutable = "unroutable statement"
+)
+
+// RouteExplain is the routing decision of a statement
+type RouteExplain struct {
+	//table used to select the rule, empty if unroutable
+	Table string
+	//matched rule type
+	Rule  string
+	Nodes []string
+
+	Reason string
+}
+
+// Explain returns how sql is routed with r. A statement which can not be parsed
+// is reported as unroutable with the parse error, other statements than
+// select, insert, replace, update and delete are unroutable without error.
+func Explain(sql string, r *router.Router, bindVars map[string]interface{}) (*RouteExplain, error) {
+	stmt, err := Parse(sql)
+	if err != nil {
+		return unroutableExplain(r), err
+	}
+
+	return ExplainStmt(stmt, r, bindVars)
+}
+
+func ExplainStmt(stmt Statement, r *router.Router, bindVars map[string]interface{}) (e *RouteExplain, err error) {
+	e = new(RouteExplain)
+
+	switch v := stmt.(type) {
+	case *Select:
+		e.Table = String(v.From[0])
+	case *Insert:
+		e.Table = String(v.Table)
+	case *Replace:
+		e.Table = String(v.Table)
+	case *Update:
+		e.Table = String(v.Table)
+	case *Delete:
+		e.Table = String(v.Table)
+	default:
+		return unroutableExplain(r), nil
+	}
+
+	defer func() {
+		if err != nil {
+			e = nil
+		}
+	}()
+	defer handleError(&err)
+
+	plan := getRoutingPlan(stmt, r)
+	plan.bindVars = bindVars
+
+	ns := plan.shardListFromPlan()
+
+	e.Rule = plan.rule.Type
+	e.Nodes = make([]string, 0, len(ns))
+	for _, i := range ns {
+		e.Nodes = append(e.Nodes, plan.rule.Nodes[i])
+	}
+
+	switch {
+	case r.GetRule(e.Table) == r.DefaultRule:
+		e.Reason = ReasonUnsharded
+	case plan.rule == r.DefaultRule:
+		e.Reason = ReasonNoWhere
+	case len(plan.rule.Nodes) == 1:
+		e.Reason = ReasonSingleNode
+	case len(ns) == len(plan.fullList):
+		e.Reason = ReasonAllShards
+	default:
+		e.Reason = ReasonShardKey
+	}
+
+	return e, nil
+}
+
+func unroutableExplain(r *router.Router) *RouteExplain {
+	return &RouteExplain{
+		Rule:   r.DefaultRule.Type,
+		Nodes:  append([]string(nil), r.DefaultRule.Nodes...),
+		Reason: ReasonUnroutable,
+	}
+}
@@ -0,0 +1,66 @@
+package sqlparser
+
+import (
+	"github.com/siddontang/mixer/config"
+	"github.com/siddontang/mixer/router"
+	"strings"
+	"testing"
+)
+
+func newTestExplainRouter(t *testing.T) *router.Router {
+	cfg := config.SchemaConfig{
+		DB:    "mixer",
+		Nodes: []string{"node1", "node2", "node3"},
+		RulesConifg: config.RulesConfig{
+			Default: "node1",
+			ShardRule: []config.ShardConfig{
+				{Table: "test1", Key: "id", Nodes: []string{"node1", "node2", "node3"}, Type: router.HashRuleType},
+				{Table: "test2", Key: "id", Nodes: []string{"node1", "node2", "node3"}, Type: router.RangeRuleType, Range: "-10000-20000-"},
+			},
+		},
+	}
+
+	r, err := router.NewRouter(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func checkExplain(t *testing.T, sql string, table string, rule string, nodes string, reason string) {
+	r := newTestExplainRouter(t)
+
+	e, err := Explain(sql, r, nil)
+	if err != nil {
+		t.Fatal(sql, err)
+	}
+
+	if e.Table != table || e.Rule != rule || strings.Join(e.Nodes, ",") != nodes || e.Reason != reason {
+		t.Fatal(sql, *e)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	checkExplain(t, "select * from test1 where id = 4", "test1", router.HashRuleType, "node2", ReasonShardKey)
+	checkExplain(t, "select * from test1 where name = 'a'", "test1", router.HashRuleType, "node1,node2,node3", ReasonAllShards)
+	checkExplain(t, "insert into test2 (id, name) values (10001, 'a')", "test2", router.RangeRuleType, "node2", ReasonShardKey)
+	checkExplain(t, "select * from test1", "test1", router.DefaultRuleType, "node1", ReasonNoWhere)
+	checkExplain(t, "update unsharded set name = 'a' where id = 1", "unsharded", router.DefaultRuleType, "node1", ReasonUnsharded)
+	checkExplain(t, "create table test1 (id int)", "", router.DefaultRuleType, "node1", ReasonUnroutable)
+}
+
+func TestExplain_Error(t *testing.T) {
+	r := newTestExplainRouter(t)
+
+	e, err := Explain("call proc(1)", r, nil)
+	if err == nil {
+		t.Fatal("must parse error")
+	} else if e == nil || e.Reason != ReasonUnroutable || e.Nodes[0] != "node1" {
+		t.Fatal(e)
+	}
+
+	e, err = Explain("update test1 set id = 2 where id = 1", r, nil)
+	if err == nil || e != nil {
+		t.Fatal("must analyze error", e)
+	}
+}
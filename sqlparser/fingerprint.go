@@ -0,0 +1,161 @@
+package sqlparser
+
+import (
+	"bytes"
+)
+
+var fingerprintOps = map[int]string{
+	LE:              "<=",
+	GE:              ">=",
+	NE:              "!=",
+	NULL_SAFE_EQUAL: "<=>",
+}
+
+// Fingerprint returns the shape of query for aggregation: literals and bind
+// vars are replaced by ?, lists of them like in (1, 2) or values (1), (2)
+// collapse to a single (?), comments are stripped and whitespace normalized.
+// Keywords are lowered, identifiers are kept as is.
+func Fingerprint(query string) string {
+	tkn := NewStringTokenizer(query)
+
+	var toks []string
+	for {
+		typ, val := tkn.Scan()
+		if typ == 0 {
+			break
+		}
+
+		var tok string
+		switch typ {
+		case COMMENT:
+			continue
+		case STRING, NUMBER, VALUE_ARG:
+			tok = "?"
+			//a negative number is one literal
+			if typ == NUMBER && isUnaryMinus(toks) {
+				toks = toks[:len(toks)-1]
+			}
+		case ID:
+			tok = string(val)
+		case LEX_ERROR:
+			//unterminated string or unknown char, never leak a literal
+			if len(val) == 1 && tkn.lastChar != EOFCHAR {
+				tok = string(val)
+			} else {
+				tok = "?"
+			}
+		default:
+			if op, ok := fingerprintOps[typ]; ok {
+				tok = op
+			} else if val != nil {
+				tok = string(val)
+			} else {
+				tok = string(rune(typ))
+			}
+		}
+
+		toks = append(toks, tok)
+		toks = collapseFingerprintList(toks)
+	}
+
+	if len(toks) > 0 && toks[len(toks)-1] == ";" {
+		toks = toks[:len(toks)-1]
+	}
+
+	return joinFingerprint(toks)
+}
+
+func isUnaryMinus(toks []string) bool {
+	n := len(toks)
+	if n == 0 || toks[n-1] != "-" {
+		return false
+	} else if n == 1 {
+		return true
+	}
+
+	switch prev := toks[n-2]; prev {
+	case "?", ")":
+		return false
+	default:
+		//binary minus after an identifier
+		return !isFingerprintIdent(prev)
+	}
+}
+
+func isFingerprintIdent(tok string) bool {
+	if len(tok) == 0 {
+		return false
+	}
+
+	if _, ok := keywords[tok]; ok {
+		return false
+	}
+
+	ch := uint16(tok[0])
+	return isLetter(ch) || isDigit(ch) || ch >= 0x80
+}
+
+// collapseFingerprintList folds the tail ( ? , ? ) into (?) and (?) , (?) into (?)
+func collapseFingerprintList(toks []string) []string {
+	n := len(toks)
+	if n == 0 || toks[n-1] != ")" {
+		return toks
+	}
+
+	i := n - 2
+	for ; i >= 0; i-- {
+		if toks[i] == "(" {
+			break
+		}
+
+		//odd positions from ( are placeholders, even are commas
+		if (n-2-i)%2 == 0 && toks[i] != "?" && toks[i] != "(?)" {
+			return toks
+		} else if (n-2-i)%2 == 1 && toks[i] != "," {
+			return toks
+		}
+	}
+
+	if i < 0 || i == n-2 || toks[n-2] == "," {
+		return toks
+	}
+
+	toks = append(toks[:i], "(?)")
+
+	//values (?), (?)
+	for len(toks) >= 3 && toks[len(toks)-2] == "," && toks[len(toks)-3] == "(?)" {
+		toks = toks[:len(toks)-2]
+	}
+
+	return toks
+}
+
+func joinFingerprint(toks []string) string {
+	var buf bytes.Buffer
+	for i, tok := range toks {
+		if i > 0 && needFingerprintSpace(toks[:i], tok) {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(tok)
+	}
+	return buf.String()
+}
+
+func needFingerprintSpace(prevs []string, tok string) bool {
+	prev := prevs[len(prevs)-1]
+
+	switch tok {
+	case ",", ")", ".":
+		return false
+	case "(", "(?)":
+		//function call like count(*), but not column list of insert into t (id)
+		return !isFingerprintIdent(prev) || (len(prevs) > 1 && prevs[len(prevs)-2] == "into")
+	}
+
+	switch prev {
+	case "(", ".", "@":
+		return false
+	}
+
+	return true
+}
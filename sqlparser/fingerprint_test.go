@@ -0,0 +1,47 @@
+package sqlparser
+
+import (
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		query       string
+		fingerprint string
+	}{
+		{"select * from t where id = 1", "select * from t where id = ?"},
+		{"SELECT  a.id,\n\tb.name FROM t1 a\nJOIN t2 b ON a.id = b.id", "select a.id, b.name from t1 a join t2 b on a.id = b.id"},
+		{"select * from t where name = 'it''s' and age > 10.5", "select * from t where name = ? and age > ?"},
+		{`select * from t where name = "select from where"`, "select * from t where name = ?"},
+		{"select * from t where id in (1, 2, 3)", "select * from t where id in (?)"},
+		{"select * from t where id in ( 1 )", "select * from t where id in (?)"},
+		{"select * from t where id not in ('a','b')", "select * from t where id not in (?)"},
+		{"insert into t (id, name) values (1, 'a'), (2, 'b'), (3, 'c')", "insert into t (id, name) values (?)"},
+		{"insert into t values (1, 'a')", "insert into t values (?)"},
+		{"insert into t (id) values (?)", "insert into t (id) values (?)"},
+		{"update t set a = -1, b = b - 2 where id = :id", "update t set a = ?, b = b - ? where id = ?"},
+		{"/* app:web */ select count(*) from t -- trailing\n where id <= 3", "select count(*) from t where id <= ?"},
+		{"select * from t where id <> 1 and k >= 2 and v <=> null", "select * from t where id != ? and k >= ? and v <=> null"},
+		{"select * from t where id between 1 and 10;", "select * from t where id between ? and ?"},
+		{"select * from `order` where `id` = 0x1f", "select * from order where id = ?"},
+		{"select * from t where h = x'ff' or h in (X'0A', b'101', x'')", "select * from t where h = ? or h in (?)"},
+		{"select x, b from t where x = 'a'", "select x, b from t where x = ?"},
+		{"select @@version", "select @@version"},
+		{"select abs(-3), concat('a', 'b') from t", "select abs(?), concat(?) from t"},
+		{"select * from t where name = 'unterminated", "select * from t where name = ?"},
+	}
+
+	for _, test := range tests {
+		if f := Fingerprint(test.query); f != test.fingerprint {
+			t.Errorf("%q: got %q, want %q", test.query, f, test.fingerprint)
+		}
+	}
+}
+
+func TestFingerprint_SameShape(t *testing.T) {
+	a := Fingerprint("select * from t where id in (1, 2) and name = 'a'")
+	b := Fingerprint("SELECT * FROM t WHERE id IN (3,4,5,6) AND name='bb'")
+	if a != b {
+		t.Fatal(a, b)
+	}
+}
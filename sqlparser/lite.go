@@ -0,0 +1,921 @@
+package sqlparser
+
+import (
+	"fmt"
+	"github.com/siddontang/mixer/router"
+	"strconv"
+	"strings"
+)
+
+// lite.go analyzes statements with the tokenizer only, it does not need
+// the statement to be supported by the parser.
+
+type LiteTable struct {
+	Qualifier string
+	Name      string
+	Alias     string
+}
+
+// LitePredicate is a condition on a column in where, or the value of a column in insert
+type LitePredicate struct {
+	//table alias or name before the column, may be empty
+	Qualifier string
+	Column    string
+
+	//=, in, between, >=, <=, > or <
+	Op string
+
+	//int64, uint64, float64, string or ValArg for a bind var,
+	//between has two values as start and stop
+	Values []interface{}
+}
+
+type LiteAnalysis struct {
+	//select, insert, replace, update or delete
+	Type string
+
+	Tables []LiteTable
+
+	//more than one table referenced, by join, comma, union or insert select
+	Join bool
+
+	//conditions joined by and at the top level of where, empty if or used
+	Predicates []LitePredicate
+}
+
+type liteToken struct {
+	typ int
+	val string
+
+	//`name`
+	quoted bool
+}
+
+func (t liteToken) is(words ...string) bool {
+	if t.typ == STRING || t.quoted || len(t.val) == 0 || !isLetter(uint16(t.val[0])) {
+		return false
+	}
+
+	for _, w := range words {
+		if strings.EqualFold(t.val, w) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t liteToken) isChar(ch byte) bool {
+	return t.typ == int(ch)
+}
+
+func (t liteToken) isName() bool {
+	if t.quoted {
+		return true
+	} else if t.typ == STRING || t.typ == NUMBER || t.typ == VALUE_ARG || len(t.val) == 0 || !isLetter(uint16(t.val[0])) {
+		return false
+	}
+	return !liteReserved(t)
+}
+
+// words end a table reference or can not be an alias
+var liteReservedWords = []string{
+	"where", "group", "having", "order", "limit", "for", "lock", "union",
+	"join", "inner", "left", "right", "cross", "natural", "straight_join", "outer",
+	"on", "using", "set", "values", "value", "select", "procedure", "into",
+	"partition", "force", "use", "ignore", "from", "as", "and", "or", "not", "duplicate",
+}
+
+func liteReserved(t liteToken) bool {
+	return t.is(liteReservedWords...)
+}
+
+func liteTokens(sql string) ([]liteToken, error) {
+	tkn := NewStringTokenizer(sql)
+
+	var toks []liteToken
+	for {
+		if tkn.lastChar == 0 {
+			tkn.next()
+		}
+		tkn.skipBlank()
+		quoted := tkn.lastChar == '`'
+
+		typ, val := tkn.Scan()
+		switch typ {
+		case 0:
+			return toks, nil
+		case COMMENT:
+			continue
+		case LEX_ERROR:
+			if len(val) != 1 || tkn.lastChar == EOFCHAR {
+				return nil, fmt.Errorf("syntax error at position %d near %s", tkn.Position, val)
+			}
+			//unknown char like @ or #
+			toks = append(toks, liteToken{typ: int(val[0]), val: string(val)})
+			continue
+		}
+
+		t := liteToken{typ: typ, quoted: quoted}
+		if op, ok := fingerprintOps[typ]; ok {
+			t.val = op
+		} else if val != nil {
+			t.val = string(val)
+		} else {
+			t.val = string(rune(typ))
+		}
+		toks = append(toks, t)
+	}
+}
+
+type liteParser struct {
+	toks []liteToken
+	pos  int
+
+	a *LiteAnalysis
+}
+
+// AnalyzeLite returns the tables and the simple column predicates of a
+// select, insert, replace, update or delete without the full parser.
+// Subqueries in the select list or where are skipped, derived tables in
+// from are analyzed for their tables only.
+func AnalyzeLite(sql string) (*LiteAnalysis, error) {
+	toks, err := liteTokens(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	for len(toks) > 0 && toks[len(toks)-1].isChar(';') {
+		toks = toks[:len(toks)-1]
+	}
+
+	p := &liteParser{toks: toks, a: new(LiteAnalysis)}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+
+	p.a.Join = len(p.a.Tables) > 1
+	return p.a, nil
+}
+
+// KeyPredicates returns predicates on column of table t, unqualified
+// predicates are included
+func (a *LiteAnalysis) KeyPredicates(t LiteTable, column string) []LitePredicate {
+	var ps []LitePredicate
+	for _, p := range a.Predicates {
+		if !strings.EqualFold(p.Column, column) {
+			continue
+		}
+
+		if len(p.Qualifier) == 0 || p.Qualifier == t.Alias || (len(t.Alias) == 0 && p.Qualifier == t.Name) {
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
+
+// ShardRule returns the rule of the first sharded table and the predicates
+// on its key, the default rule if no table is sharded. A multi-table
+// statement with a sharded table is rejected unless r.JoinFirstSharded.
+func (a *LiteAnalysis) ShardRule(r *router.Router) (*router.Rule, []LitePredicate, error) {
+	for _, t := range a.Tables {
+		rule := r.GetRule(t.Name)
+		if rule == r.DefaultRule {
+			continue
+		}
+
+		if a.Join && !r.JoinFirstSharded {
+			return nil, nil, fmt.Errorf("multi-table statement on sharded table %s is not supported", t.Name)
+		}
+
+		return rule, a.KeyPredicates(t, rule.Key), nil
+	}
+
+	return r.DefaultRule, nil, nil
+}
+
+// ShardList returns the nodes of the rule from ShardRule which the = and in
+// predicates on its key route to, all the nodes of the rule if none can.
+func (a *LiteAnalysis) ShardList(r *router.Router, bindVars map[string]interface{}) (nodes []string, err error) {
+	defer handleError(&err)
+
+	rule, ps, err := a.ShardRule(r)
+	if err != nil {
+		return nil, err
+	}
+
+	list := makeList(0, len(rule.Nodes))
+	for _, p := range ps {
+		if p.Op != "=" && p.Op != "in" {
+			continue
+		}
+
+		var l []int
+		for _, v := range p.Values {
+			if arg, ok := v.(ValArg); ok {
+				v = bindVars[string(arg[1:])]
+			}
+
+			switch v.(type) {
+			case int64, uint64, string:
+				l = unionList(l, []int{rule.FindNodeIndex(v)})
+			default:
+				//a float or missing bind var can not be routed
+				l = list
+			}
+		}
+		list = interList(list, l)
+	}
+
+	nodes = make([]string, 0, len(list))
+	for _, i := range list {
+		nodes = append(nodes, rule.Nodes[i])
+	}
+	return nodes, nil
+}
+
+func (p *liteParser) end() bool {
+	return p.pos >= len(p.toks)
+}
+
+func (p *liteParser) peek() liteToken {
+	if p.end() {
+		return liteToken{}
+	}
+	return p.toks[p.pos]
+}
+
+func (p *liteParser) peekAt(i int) liteToken {
+	if p.pos+i >= len(p.toks) {
+		return liteToken{}
+	}
+	return p.toks[p.pos+i]
+}
+
+func (p *liteParser) next() liteToken {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// skipParens skips from ( to the matched ) and returns the tokens inside
+func (p *liteParser) skipParens() ([]liteToken, error) {
+	start := p.pos
+	depth := 0
+	for ; !p.end(); p.pos++ {
+		switch t := p.toks[p.pos]; {
+		case t.isChar('('):
+			depth++
+		case t.isChar(')'):
+			depth--
+			if depth == 0 {
+				p.pos++
+				return p.toks[start+1 : p.pos-1], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unmatched parenthesis")
+}
+
+// skipUntil skips tokens not in parenthesis until one of words
+func (p *liteParser) skipUntil(words ...string) error {
+	return p.skipUntilFunc(func(t liteToken) bool {
+		return t.is(words...)
+	})
+}
+
+func (p *liteParser) skipUntilFunc(stop func(t liteToken) bool) error {
+	for !p.end() {
+		t := p.peek()
+		if stop(t) {
+			return nil
+		} else if t.isChar(')') {
+			return fmt.Errorf("unmatched parenthesis")
+		} else if t.isChar('(') {
+			if _, err := p.skipParens(); err != nil {
+				return err
+			}
+		} else {
+			p.pos++
+		}
+	}
+	return nil
+}
+
+func (p *liteParser) skipWords(words ...string) {
+	for p.peek().is(words...) {
+		p.pos++
+	}
+}
+
+func (p *liteParser) parse() error {
+	t := p.next()
+	switch {
+	case t.is("select"):
+		p.a.Type = "select"
+		return p.parseSelect()
+	case t.is("insert"), t.is("replace"):
+		p.a.Type = strings.ToLower(t.val)
+		return p.parseInsert()
+	case t.is("update"):
+		p.a.Type = "update"
+		return p.parseUpdate()
+	case t.is("delete"):
+		p.a.Type = "delete"
+		return p.parseDelete()
+	default:
+		return fmt.Errorf("statement %s is not supported", t.val)
+	}
+}
+
+func (p *liteParser) parseSelect() error {
+	if err := p.skipUntil("from", "union"); err != nil {
+		return err
+	}
+
+	if p.peek().is("from") {
+		p.pos++
+		if err := p.parseTableRefs(); err != nil {
+			return err
+		}
+	}
+
+	if p.peek().is("where") {
+		p.pos++
+		if err := p.parseWhere(); err != nil {
+			return err
+		}
+	}
+
+	if err := p.skipUntil("union"); err != nil {
+		return err
+	}
+
+	if p.peek().is("union") {
+		p.pos++
+		p.skipWords("all", "distinct")
+		if p.next().is("select") {
+			err := p.parseSelect()
+			//rows of every part are returned, no predicate limits all
+			p.a.Predicates = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *liteParser) parseInsert() error {
+	p.skipWords("low_priority", "delayed", "high_priority", "ignore")
+	p.skipWords("into")
+
+	table, err := p.parseTableName()
+	if err != nil {
+		return err
+	}
+	p.a.Tables = append(p.a.Tables, table)
+
+	if p.peek().is("partition") {
+		p.pos++
+		if _, err := p.skipParens(); err != nil {
+			return err
+		}
+	}
+
+	var columns []string
+	if p.peek().isChar('(') && !p.peekAt(1).is("select") {
+		cols, err := p.skipParens()
+		if err != nil {
+			return err
+		}
+		for _, c := range splitLiteList(cols) {
+			if len(c) == 0 {
+				return fmt.Errorf("empty insert column")
+			}
+			columns = append(columns, c[len(c)-1].val)
+		}
+	}
+
+	switch t := p.next(); {
+	case t.is("values", "value"):
+		return p.parseInsertValues(columns)
+	case t.is("set"):
+		return p.parseAssignments()
+	case t.is("select"):
+		//predicates of the select are not for the inserted rows
+		err := p.parseSelect()
+		p.a.Predicates = nil
+		return err
+	case t.isChar('('):
+		p.pos--
+		sub, err := p.skipParens()
+		if err != nil {
+			return err
+		}
+		return p.parseSub(sub)
+	default:
+		return fmt.Errorf("unexpected %s in insert", t.val)
+	}
+}
+
+func (p *liteParser) parseInsertValues(columns []string) error {
+	values := make([][]interface{}, len(columns))
+	literal := make([]bool, len(columns))
+	for i := range literal {
+		literal[i] = true
+	}
+
+	for {
+		if !p.peek().isChar('(') {
+			return fmt.Errorf("unexpected %s in insert values", p.peek().val)
+		}
+
+		row, err := p.skipParens()
+		if err != nil {
+			return err
+		}
+
+		items := splitLiteList(row)
+		if len(columns) > 0 && len(items) != len(columns) {
+			return fmt.Errorf("insert column count doesn't match value count")
+		}
+
+		for i := range columns {
+			if v, ok := liteValue(items[i]); ok {
+				values[i] = append(values[i], v)
+			} else {
+				//an expression, no predicate for the column
+				literal[i] = false
+			}
+		}
+
+		if !p.peek().isChar(',') {
+			break
+		}
+		p.pos++
+	}
+
+	for i, c := range columns {
+		if !literal[i] {
+			continue
+		}
+
+		op := "="
+		if len(values[i]) > 1 {
+			op = "in"
+		}
+		p.a.Predicates = append(p.a.Predicates, LitePredicate{Column: c, Op: op, Values: values[i]})
+	}
+
+	//on duplicate key update
+	return nil
+}
+
+func (p *liteParser) parseAssignments() error {
+	for {
+		var item []liteToken
+		for !p.end() && !p.peek().isChar(',') && !p.peek().is("where", "order", "limit", "on") {
+			if p.peek().isChar('(') {
+				start := p.pos
+				if _, err := p.skipParens(); err != nil {
+					return err
+				}
+				item = append(item, p.toks[start:p.pos]...)
+				continue
+			}
+			item = append(item, p.next())
+		}
+
+		if p.a.Type != "update" {
+			if col, qual, rest, ok := liteColumn(item); ok && len(rest) > 1 && rest[0].isChar('=') {
+				if v, ok := liteValue(rest[1:]); ok {
+					p.a.Predicates = append(p.a.Predicates, LitePredicate{Qualifier: qual, Column: col, Op: "=", Values: []interface{}{v}})
+				}
+			}
+		}
+
+		if !p.peek().isChar(',') {
+			return nil
+		}
+		p.pos++
+	}
+}
+
+func (p *liteParser) parseUpdate() error {
+	p.skipWords("low_priority", "ignore")
+
+	if err := p.parseTableRefs(); err != nil {
+		return err
+	}
+
+	if !p.next().is("set") {
+		return fmt.Errorf("update needs set")
+	}
+
+	if err := p.parseAssignments(); err != nil {
+		return err
+	}
+
+	if p.peek().is("where") {
+		p.pos++
+		return p.parseWhere()
+	}
+	return nil
+}
+
+func (p *liteParser) parseDelete() error {
+	p.skipWords("low_priority", "quick", "ignore")
+
+	if !p.peek().is("from") {
+		//delete t1, t2 from t1 join t2 ...
+		if err := p.skipUntil("from"); err != nil {
+			return err
+		}
+	}
+
+	if !p.next().is("from") {
+		return fmt.Errorf("delete needs from")
+	}
+
+	if err := p.parseTableRefs(); err != nil {
+		return err
+	}
+
+	if p.peek().is("using") {
+		//delete from t1 using t1 join t2, tables after using are referenced
+		p.pos++
+		p.a.Tables = p.a.Tables[:0]
+		if err := p.parseTableRefs(); err != nil {
+			return err
+		}
+	}
+
+	if p.peek().is("where") {
+		p.pos++
+		return p.parseWhere()
+	}
+	return nil
+}
+
+func (p *liteParser) parseTableName() (LiteTable, error) {
+	var t LiteTable
+
+	name := p.next()
+	if !name.isName() {
+		return t, fmt.Errorf("unexpected %s, need table name", name.val)
+	}
+	t.Name = name.val
+
+	if p.peek().isChar('.') {
+		p.pos++
+		name = p.next()
+		if !name.isName() {
+			return t, fmt.Errorf("unexpected %s, need table name", name.val)
+		}
+		t.Qualifier, t.Name = t.Name, name.val
+	}
+	return t, nil
+}
+
+func (p *liteParser) parseAlias() string {
+	if p.peek().is("as") {
+		p.pos++
+		return p.next().val
+	} else if p.peek().isName() {
+		return p.next().val
+	}
+	return ""
+}
+
+// parseTableRefs parses table references until a clause ends them
+func (p *liteParser) parseTableRefs() error {
+	for {
+		switch t := p.peek(); {
+		case t.isChar('('):
+			sub, err := p.skipParens()
+			if err != nil {
+				return err
+			}
+			if err := p.parseSub(sub); err != nil {
+				return err
+			}
+			p.parseAlias()
+		default:
+			table, err := p.parseTableName()
+			if err != nil {
+				return err
+			}
+			table.Alias = p.parseAlias()
+			p.a.Tables = append(p.a.Tables, table)
+		}
+
+		if err := p.skipIndexHints(); err != nil {
+			return err
+		}
+
+		//join condition
+		if p.peek().is("on") {
+			p.pos++
+			err := p.skipUntilFunc(func(t liteToken) bool {
+				return t.isChar(',') || t.is("join", "inner", "left", "right", "cross", "natural",
+					"straight_join", "where", "group", "having", "order", "limit", "for", "lock", "union",
+					"set", "using")
+			})
+			if err != nil {
+				return err
+			}
+		} else if p.peek().is("using") && p.peekAt(1).isChar('(') {
+			p.pos++
+			if _, err := p.skipParens(); err != nil {
+				return err
+			}
+		}
+
+		switch t := p.peek(); {
+		case t.isChar(','):
+			p.pos++
+		case t.is("join", "inner", "left", "right", "cross", "natural", "straight_join"):
+			p.skipWords("inner", "left", "right", "cross", "natural", "outer")
+			if !p.next().is("join", "straight_join") {
+				return fmt.Errorf("unexpected %s, need join", p.toks[p.pos-1].val)
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *liteParser) skipIndexHints() error {
+	for p.peek().is("use", "force", "ignore") && p.peekAt(1).is("index", "key") {
+		p.pos += 2
+		if p.peek().is("for") {
+			//for join, for order by, for group by
+			p.pos++
+			p.skipWords("join", "order", "group", "by")
+		}
+		if _, err := p.skipParens(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseSub analyzes a parenthesized derived table or table list for tables
+func (p *liteParser) parseSub(toks []liteToken) error {
+	sub := &liteParser{toks: toks, a: new(LiteAnalysis)}
+
+	var err error
+	if sub.peek().is("select") {
+		sub.pos++
+		err = sub.parseSelect()
+	} else {
+		err = sub.parseTableRefs()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	p.a.Tables = append(p.a.Tables, sub.a.Tables...)
+	return nil
+}
+
+func (p *liteParser) parseWhere() error {
+	start := p.pos
+	if err := p.skipUntil("group", "having", "order", "limit", "for", "lock", "union"); err != nil {
+		return err
+	}
+
+	if ps, ok := litePredicates(p.toks[start:p.pos]); ok {
+		p.a.Predicates = append(p.a.Predicates, ps...)
+	}
+	return nil
+}
+
+// litePredicates returns the predicates of and joined conditions, false if or used
+func litePredicates(toks []liteToken) ([]LitePredicate, bool) {
+	var ps []LitePredicate
+
+	var cond []liteToken
+	between := false
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		switch {
+		case t.is("or", "xor") || (t.isChar('|') && i+1 < len(toks) && toks[i+1].isChar('|')):
+			return nil, false
+		case t.is("between"):
+			between = true
+		case t.is("and") && between:
+			between = false
+		case t.is("and") || (t.isChar('&') && i+1 < len(toks) && toks[i+1].isChar('&')):
+			if t.isChar('&') {
+				i++
+			}
+			p, ok := litePredicate(cond)
+			if !ok {
+				return nil, false
+			}
+			ps = append(ps, p...)
+			cond = nil
+			continue
+		case t.isChar('('):
+			end := matchLiteParen(toks, i)
+			if end < 0 {
+				return nil, false
+			}
+			cond = append(cond, toks[i:end+1]...)
+			i = end
+			continue
+		}
+		cond = append(cond, t)
+	}
+
+	p, ok := litePredicate(cond)
+	if !ok {
+		return nil, false
+	}
+	return append(ps, p...), true
+}
+
+func matchLiteParen(toks []liteToken, start int) int {
+	depth := 0
+	for i := start; i < len(toks); i++ {
+		if toks[i].isChar('(') {
+			depth++
+		} else if toks[i].isChar(')') {
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+var liteReverseOps = map[string]string{
+	"=": "=", "<=>": "=", ">=": "<=", "<=": ">=", ">": "<", "<": ">",
+}
+
+// litePredicate returns predicates of a condition, false if a
+// parenthesized condition uses or
+func litePredicate(cond []liteToken) ([]LitePredicate, bool) {
+	if len(cond) == 0 {
+		return []LitePredicate{}, true
+	}
+
+	//(a = 1 and b = 2)
+	if cond[0].isChar('(') && matchLiteParen(cond, 0) == len(cond)-1 {
+		if len(cond) > 1 && cond[1].is("select") {
+			return []LitePredicate{}, true
+		}
+		return litePredicates(cond[1 : len(cond)-1])
+	}
+
+	col, qual, rest, ok := liteColumn(cond)
+	if !ok {
+		//1 = id
+		for i := 1; i < len(cond); i++ {
+			op, isOp := liteReverseOps[cond[i].val]
+			if !isOp || cond[i].typ == STRING {
+				continue
+			}
+			v, isValue := liteValue(cond[:i])
+			col, qual, rest, ok = liteColumn(cond[i+1:])
+			if isValue && ok && len(rest) == 0 {
+				return []LitePredicate{{Qualifier: qual, Column: col, Op: op, Values: []interface{}{v}}}, true
+			}
+			break
+		}
+		return []LitePredicate{}, true
+	}
+
+	p := LitePredicate{Qualifier: qual, Column: col}
+	if len(rest) < 2 {
+		return []LitePredicate{}, true
+	}
+
+	switch op := rest[0]; {
+	case op.typ != STRING && (op.val == "=" || op.val == "<=>" || op.val == ">=" || op.val == "<=" || op.val == ">" || op.val == "<"):
+		v, ok := liteValue(rest[1:])
+		if !ok {
+			return []LitePredicate{}, true
+		}
+		p.Op = op.val
+		if p.Op == "<=>" {
+			p.Op = "="
+		}
+		p.Values = []interface{}{v}
+	case op.is("in"):
+		if !rest[1].isChar('(') || matchLiteParen(rest, 1) != len(rest)-1 {
+			return []LitePredicate{}, true
+		}
+		for _, item := range splitLiteList(rest[2 : len(rest)-1]) {
+			v, ok := liteValue(item)
+			if !ok {
+				//subquery or expression
+				return []LitePredicate{}, true
+			}
+			p.Values = append(p.Values, v)
+		}
+		p.Op = "in"
+	case op.is("between"):
+		for i := 2; i < len(rest); i++ {
+			if rest[i].is("and") {
+				from, ok1 := liteValue(rest[1:i])
+				to, ok2 := liteValue(rest[i+1:])
+				if !ok1 || !ok2 {
+					return []LitePredicate{}, true
+				}
+				p.Op = "between"
+				p.Values = []interface{}{from, to}
+				break
+			}
+		}
+		if len(p.Op) == 0 {
+			return []LitePredicate{}, true
+		}
+	default:
+		return []LitePredicate{}, true
+	}
+
+	return []LitePredicate{p}, true
+}
+
+// liteColumn parses [qualifier.]column at the start of toks
+func liteColumn(toks []liteToken) (col string, qual string, rest []liteToken, ok bool) {
+	if len(toks) == 0 || !toks[0].isName() {
+		return
+	}
+
+	if len(toks) >= 3 && toks[1].isChar('.') && toks[2].isName() {
+		if len(toks) >= 5 && toks[3].isChar('.') && toks[4].isName() {
+			//db.table.column
+			return toks[4].val, toks[2].val, toks[5:], true
+		}
+		return toks[2].val, toks[0].val, toks[3:], true
+	}
+
+	if len(toks) >= 2 && toks[1].isChar('(') {
+		//function
+		return
+	}
+
+	return toks[0].val, "", toks[1:], true
+}
+
+// liteValue returns the value of a literal or bind var
+func liteValue(toks []liteToken) (interface{}, bool) {
+	neg := false
+	if len(toks) == 2 && toks[0].isChar('-') && toks[1].typ == NUMBER {
+		neg = true
+		toks = toks[1:]
+	}
+
+	if len(toks) != 1 {
+		return nil, false
+	}
+
+	t := toks[0]
+	switch t.typ {
+	case STRING:
+		return t.val, true
+	case VALUE_ARG:
+		return ValArg(t.val), true
+	case NUMBER:
+		s := t.val
+		if neg {
+			s = "-" + s
+		}
+		if v, err := strconv.ParseInt(s, 0, 64); err == nil {
+			return v, true
+		} else if v, err := strconv.ParseUint(s, 0, 64); err == nil {
+			return v, true
+		} else if v, err := strconv.ParseFloat(s, 64); err == nil {
+			return v, true
+		}
+		return s, true
+	default:
+		return nil, false
+	}
+}
+
+// splitLiteList splits toks by comma not in parenthesis
+func splitLiteList(toks []liteToken) [][]liteToken {
+	var items [][]liteToken
+
+	start := 0
+	depth := 0
+	for i, t := range toks {
+		if t.isChar('(') {
+			depth++
+		} else if t.isChar(')') {
+			depth--
+		} else if t.isChar(',') && depth == 0 {
+			items = append(items, toks[start:i])
+			start = i + 1
+		}
+	}
+
+	if len(toks) > 0 {
+		items = append(items, toks[start:])
+	}
+	return items
+}
@@ -0,0 +1,281 @@
+package sqlparser
+
+import (
+	"fmt"
+	"github.com/siddontang/mixer/config"
+	"github.com/siddontang/mixer/router"
+	"strings"
+	"testing"
+)
+
+func formatLiteTables(ts []LiteTable) string {
+	s := make([]string, len(ts))
+	for i, t := range ts {
+		s[i] = t.Name
+		if len(t.Qualifier) > 0 {
+			s[i] = t.Qualifier + "." + s[i]
+		}
+		if len(t.Alias) > 0 {
+			s[i] += " " + t.Alias
+		}
+	}
+	return strings.Join(s, ",")
+}
+
+func formatLitePredicates(ps []LitePredicate) string {
+	s := make([]string, len(ps))
+	for i, p := range ps {
+		col := p.Column
+		if len(p.Qualifier) > 0 {
+			col = p.Qualifier + "." + col
+		}
+
+		vs := make([]string, len(p.Values))
+		for j, v := range p.Values {
+			if a, ok := v.(ValArg); ok {
+				vs[j] = string(a)
+			} else {
+				vs[j] = fmt.Sprintf("%v", v)
+			}
+		}
+		s[i] = fmt.Sprintf("%s %s %s", col, p.Op, strings.Join(vs, ","))
+	}
+	return strings.Join(s, "; ")
+}
+
+func TestAnalyzeLite(t *testing.T) {
+	tests := []struct {
+		sql        string
+		tables     string
+		join       bool
+		predicates string
+	}{
+		//select
+		{"select * from t where id = 1", "t", false, "id = 1"},
+		{"SELECT * FROM t WHERE ID = 1", "t", false, "ID = 1"},
+		{"select * from db.t as a where a.id = -5", "db.t a", false, "a.id = -5"},
+		{"select * from `db`.`order` o where o.`id` = '7'", "db.order o", false, "o.id = 7"},
+		{"select * from t where 10 = id and name = 'x'", "t", false, "id = 10; name = x"},
+		{"select * from t where id in (1, 2, 3)", "t", false, "id in 1,2,3"},
+		{"select * from t where id not in (1, 2)", "t", false, ""},
+		{"select * from t where id between 1 and 10 and k = 2", "t", false, "id between 1,10; k = 2"},
+		{"select * from t where id not between 1 and 10", "t", false, ""},
+		{"select * from t where id >= 10 and id <= 20", "t", false, "id >= 10; id <= 20"},
+		{"select * from t where 10 < id", "t", false, "id > 10"},
+		{"select * from t where id <=> 3", "t", false, "id = 3"},
+		{"select * from t where id = ? and k in (?, ?)", "t", false, "id = :v1; k in :v2,:v3"},
+		{"select * from t where id = :id", "t", false, "id = :id"},
+		{"select * from t where id = 1 or id = 2", "t", false, ""},
+		{"select * from t where id = 1 || id = 2", "t", false, ""},
+		{"select * from t where (id = 1 and k = 2) and v = 3", "t", false, "id = 1; k = 2; v = 3"},
+		{"select * from t where (id = 1 or k = 2) and v = 3", "t", false, ""},
+		{"select * from t where id = 1 && k = 2", "t", false, "id = 1; k = 2"},
+		{"select * from t where id + 1 = 2", "t", false, ""},
+		{"select * from t where id = k", "t", false, ""},
+		{"select * from t where abs(id) = 1 and id = 2", "t", false, "id = 2"},
+		{"select * from t where id = 18446744073709551615", "t", false, "id = 18446744073709551615"},
+		{"select * from t where id = 1.5", "t", false, "id = 1.5"},
+		{"select * from t where id = 1 for update", "t", false, "id = 1"},
+		{"select * from t where id = 1 lock in share mode", "t", false, "id = 1"},
+		{"select * from t where id = 1 order by k limit 10;", "t", false, "id = 1"},
+		{"select k, count(*) from t where id = 1 group by k having count(*) > 1", "t", false, "id = 1"},
+		{"select 1", "", false, ""},
+		{"select @@version", "", false, ""},
+
+		//keywords in strings and subqueries in select list
+		{"select 'from x where id = 1' from t where id = 2", "t", false, "id = 2"},
+		{"select (select max(id) from t2 where id = 9) m from t where id = 2", "t", false, "id = 2"},
+		{"select * from t where name = 'a or b' and id = 1", "t", false, "name = a or b; id = 1"},
+		{"select extract(year from d) from t where id = 1", "t", false, "id = 1"},
+		{"select * from t where id in (select id from t2 where k = 1)", "t", false, ""},
+		{"select * from t where exists (select 1 from t2 where t2.id = t.id) and id = 1", "t", false, "id = 1"},
+		{"select /* from t9 */ * from t -- where id = 3\n where id = 4", "t", false, "id = 4"},
+
+		//joins
+		{"select * from t1, t2 where t1.id = t2.id and t1.id = 1", "t1,t2", true, "t1.id = 1"},
+		{"select * from t1 a join t2 b on a.id = b.id where a.id = 1", "t1 a,t2 b", true, "a.id = 1"},
+		{"select * from t1 a left outer join t2 b on a.id = b.id, t3 c where c.id = 1", "t1 a,t2 b,t3 c", true, "c.id = 1"},
+		{"select * from t1 inner join t2 using (id) where id = 1", "t1,t2", true, "id = 1"},
+		{"select * from t1 straight_join t2 on t1.id = t2.id", "t1,t2", true, ""},
+		{"select * from t1 natural join t2", "t1,t2", true, ""},
+		{"select * from t1 force index (idx) where id = 1", "t1", false, "id = 1"},
+		{"select * from t1 use index for join (a, b) join t2 ignore key (c) on t1.id = t2.id", "t1,t2", true, ""},
+		{"select * from (select * from t1 where id = 2) as s where s.k = 1", "t1", false, "s.k = 1"},
+		{"select * from (t1 join t2 on t1.id = t2.id) where t1.id = 1", "t1,t2", true, "t1.id = 1"},
+		{"select id from t1 where id = 1 union all select id from t2 where id = 2", "t1,t2", true, ""},
+
+		//insert and replace
+		{"insert into t (id, name) values (1, 'a')", "t", false, "id = 1; name = a"},
+		{"insert into t (id, name) values (1, 'a'), (2, 'b')", "t", false, "id in 1,2; name in a,b"},
+		{"insert into t (id, name) values (1, now())", "t", false, "id = 1"},
+		{"insert into t (id) values (1), (k + 1)", "t", false, ""},
+		{"INSERT IGNORE INTO db.t (`id`) VALUES (?)", "db.t", false, "id = :v1"},
+		{"insert low_priority t (t.id) value (3)", "t", false, "id = 3"},
+		{"insert into t values (1, 'a')", "t", false, ""},
+		{"insert into t set id = 1, name = 'a'", "t", false, "id = 1; name = a"},
+		{"insert into t (id, k) values (1, 2) on duplicate key update k = 3", "t", false, "id = 1; k = 2"},
+		{"insert into t (id) select id from t2 where id = 1", "t,t2", true, ""},
+		{"replace into t (id) values (5)", "t", false, "id = 5"},
+
+		//update
+		{"update t set name = 'a' where id = 1", "t", false, "id = 1"},
+		{"update low_priority ignore t set name = 'where id = 2' where id = 1", "t", false, "id = 1"},
+		{"update t set k = k + 1", "t", false, ""},
+		{"update t1 a join t2 b on a.id = b.id set a.k = b.k where a.id = 1", "t1 a,t2 b", true, "a.id = 1"},
+		{"update t set k = (select max(k) from t2) where id in (1, 2) order by id limit 1", "t", false, "id in 1,2"},
+
+		//delete
+		{"delete from t where id = 1", "t", false, "id = 1"},
+		{"delete quick ignore from db.t where id between 1 and 2 limit 10", "db.t", false, "id between 1,2"},
+		{"delete a from t1 a join t2 b on a.id = b.id where a.id = 1", "t1 a,t2 b", true, "a.id = 1"},
+		{"delete from t1 using t1 join t2 on t1.id = t2.id where t1.id = 1", "t1,t2", true, "t1.id = 1"},
+		{"delete from t", "t", false, ""},
+
+		//orm style
+		{"SELECT `users`.* FROM `users` WHERE `users`.`id` = 1 LIMIT 1", "users", false, "users.id = 1"},
+		{"SELECT `users`.`id`, `users`.`name` FROM `users` WHERE (`users`.`id` IN (1, 2, 3))", "users", false, "users.id in 1,2,3"},
+		{"SELECT COUNT(*) AS `__count` FROM `app_user` WHERE `app_user`.`id` >= 10", "app_user", false, "app_user.id >= 10"},
+		{"SELECT `app_order`.`id` FROM `app_order` INNER JOIN `app_user` ON (`app_order`.`user_id` = `app_user`.`id`) WHERE `app_user`.`id` = 5",
+			"app_order,app_user", true, "app_user.id = 5"},
+		{"SELECT this_.id AS id1_0_ FROM orders this_ WHERE this_.user_id=? AND this_.status=?", "orders this_", false, "this_.user_id = :v1; this_.status = :v2"},
+		{"INSERT INTO `users` (`name`,`id`,`created_at`) VALUES ('a',1,'2014-01-01 00:00:00')", "users", false, "name = a; id = 1; created_at = 2014-01-01 00:00:00"},
+		{"UPDATE `users` SET `name` = 'b', `updated_at` = '2014-01-01' WHERE `users`.`id` = 1", "users", false, "users.id = 1"},
+		{"DELETE FROM `users` WHERE `users`.`id` = 1", "users", false, "users.id = 1"},
+		{"SELECT COUNT(*) FROM (SELECT `t`.`id` FROM `t` WHERE `t`.`id` = 1) subquery", "t", false, ""},
+		{"select * from t where id = 1;;", "t", false, "id = 1"},
+	}
+
+	for _, test := range tests {
+		a, err := AnalyzeLite(test.sql)
+		if err != nil {
+			t.Errorf("%s: %v", test.sql, err)
+			continue
+		}
+
+		if tables := formatLiteTables(a.Tables); tables != test.tables {
+			t.Errorf("%s: tables %q, want %q", test.sql, tables, test.tables)
+		}
+		if a.Join != test.join {
+			t.Errorf("%s: join %v", test.sql, a.Join)
+		}
+		if ps := formatLitePredicates(a.Predicates); ps != test.predicates {
+			t.Errorf("%s: predicates %q, want %q", test.sql, ps, test.predicates)
+		}
+	}
+}
+
+func TestAnalyzeLite_Error(t *testing.T) {
+	tests := []string{
+		"",
+		"show tables",
+		"call proc(1)",
+		"select * from t where name = 'unterminated",
+		"select * from t where (id = 1",
+		"select * from t where id = 1)",
+		"insert into t (id) values (1, 2)",
+		"update t where id = 1",
+		"delete t",
+		"select * from t1 left t2",
+	}
+
+	for _, sql := range tests {
+		if _, err := AnalyzeLite(sql); err == nil {
+			t.Errorf("%q must error", sql)
+		}
+	}
+}
+
+func newTestLiteRouter(t *testing.T, join string) *router.Router {
+	cfg := config.SchemaConfig{
+		DB:    "mixer",
+		Nodes: []string{"node1", "node2"},
+		RulesConifg: config.RulesConfig{
+			Default: "node1",
+			Join:    join,
+			ShardRule: []config.ShardConfig{
+				{Table: "t1", Key: "id", Nodes: []string{"node1", "node2"}, Type: router.HashRuleType},
+				{Table: "t2", Key: "uid", Nodes: []string{"node1", "node2"}, Type: router.HashRuleType},
+			},
+		},
+	}
+
+	r, err := router.NewRouter(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestAnalyzeLite_ShardRule(t *testing.T) {
+	r := newTestLiteRouter(t, "")
+
+	a, _ := AnalyzeLite("select * from unsharded u join t0 on u.id = t0.id")
+	if rule, _, err := a.ShardRule(r); err != nil || rule != r.DefaultRule {
+		t.Fatal(rule, err)
+	}
+
+	a, _ = AnalyzeLite("select * from t2 where uid = 1 and id = 2")
+	if rule, ps, err := a.ShardRule(r); err != nil || rule.Table != "t2" || formatLitePredicates(ps) != "uid = 1" {
+		t.Fatal(rule, ps, err)
+	}
+
+	a, _ = AnalyzeLite("select * from unsharded u join t1 a on u.id = a.id join t2 b on a.id = b.uid where a.id = 1 and b.uid = 2")
+	if _, _, err := a.ShardRule(r); err == nil {
+		t.Fatal("must reject join")
+	}
+
+	r = newTestLiteRouter(t, router.JoinFirst)
+	if rule, ps, err := a.ShardRule(r); err != nil || rule.Table != "t1" || formatLitePredicates(ps) != "a.id = 1" {
+		t.Fatal(rule, ps, err)
+	}
+
+	cfg := config.SchemaConfig{DB: "mixer", Nodes: []string{"node1"}, RulesConifg: config.RulesConfig{Default: "node1", Join: "all"}}
+	if _, err := router.NewRouter(&cfg); err == nil {
+		t.Fatal("must error for invalid join")
+	}
+}
+
+func TestAnalyzeLite_ShardList(t *testing.T) {
+	sql := "select * from t1 a join t2 b on a.id = b.uid where a.id = 1"
+	stmt, err := Parse(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestLiteRouter(t, "")
+	if _, err := GetStmtShardList(stmt, r, nil); err == nil {
+		t.Fatal("must reject join")
+	}
+
+	r = newTestLiteRouter(t, router.JoinFirst)
+	if ns, err := GetStmtShardList(stmt, r, nil); err != nil || fmt.Sprint(ns) != "[node2]" {
+		t.Fatal(ns, err)
+	}
+
+	tests := []struct {
+		sql   string
+		nodes string
+	}{
+		{"select * from unsharded u, t0 where u.id = t0.id", "[node1]"},
+		{"select * from t1, t2 where t1.id in (1, 2)", "[node1 node2]"},
+		{"select * from t1, t2 where t1.id in (2, 4)", "[node1]"},
+		{"select * from t1, t2 where t1.id > 1", "[node1 node2]"},
+		{"select * from t1, t2 where t1.id = :v1", "[node2]"},
+		{"select * from t1, t2 where t1.id = :v2", "[node1 node2]"},
+		{"select * from t1, t2 where t1.id = 1 and t1.id = 2", "[]"},
+	}
+
+	for _, test := range tests {
+		stmt, err := Parse(test.sql)
+		if err != nil {
+			t.Fatal(test.sql, err)
+		}
+
+		ns, err := GetStmtShardList(stmt, r, map[string]interface{}{"v1": int64(1)})
+		if err != nil {
+			t.Fatal(test.sql, err)
+		} else if s := fmt.Sprint(ns); s != test.nodes {
+			t.Fatalf("%s: got %s, want %s", test.sql, s, test.nodes)
+		}
+	}
+}
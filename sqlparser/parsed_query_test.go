@@ -35,6 +35,16 @@ func TestParsedQuery(t *testing.T) {
 			},
 			nil,
 			"select * from a where id1 = 1 and id2 = null",
+		}, {
+			"unsigned bindvar sub",
+			"select * from a where id1 = :id1 and id2 = :id2 and id3 = :id3",
+			map[string]interface{}{
+				"id1": uint64(18446744073709551615),
+				"id2": uint8(255),
+				"id3": int16(-1),
+			},
+			nil,
+			"select * from a where id1 = 18446744073709551615 and id2 = 255 and id3 = -1",
 		}, {
 			"missing bind var",
 			"select * from a where id1 = :id1 and id2 = :id2",
@@ -24,6 +24,9 @@ type RoutingPlan struct {
 
 	fullList []int
 
+	//position of the shard key in every insert value tuple
+	keyIndex int
+
 	bindVars map[string]interface{}
 }
 
@@ -64,6 +67,15 @@ func GetShardListIndex(sql string, r *router.Router, bindVars map[string]interfa
 func GetStmtShardList(stmt Statement, r *router.Router, bindVars map[string]interface{}) (nodes []string, err error) {
 	defer handleError(&err)
 
+	if isJoin(stmt) {
+		//routed by the first sharded table or rejected, see router.JoinFirstSharded
+		a, err := AnalyzeLite(String(stmt))
+		if err != nil {
+			return nil, err
+		}
+		return a.ShardList(r, bindVars)
+	}
+
 	plan := getRoutingPlan(stmt, r)
 
 	plan.bindVars = bindVars
@@ -90,6 +102,18 @@ func GetStmtShardListIndex(stmt Statement, r *router.Router, bindVars map[string
 	return ns, nil
 }
 
+func isJoin(stmt Statement) bool {
+	sel, ok := stmt.(*Select)
+	if !ok {
+		return false
+	} else if len(sel.From) > 1 {
+		return true
+	}
+
+	_, ok = sel.From[0].(*JoinTableExpr)
+	return ok
+}
+
 func (plan *RoutingPlan) notList(l []int) []int {
 	return differentList(plan.fullList, l)
 }
@@ -235,6 +259,7 @@ func getRoutingPlan(statement Statement, router *router.Router) (plan *RoutingPl
 			checkUpdateExprs(UpdateExprs(stmt.OnDup), plan.rule)
 		}
 
+		plan.keyIndex = findInsertKeyIndex(stmt.Columns, plan.rule)
 		plan.criteria = plan.routingAnalyzeValues(stmt.Rows.(Values))
 		plan.fullList = makeList(0, len(plan.rule.Nodes))
 		return plan
@@ -244,6 +269,7 @@ func getRoutingPlan(statement Statement, router *router.Router) (plan *RoutingPl
 		}
 
 		plan.rule = router.GetRule(String(stmt.Table))
+		plan.keyIndex = findInsertKeyIndex(stmt.Columns, plan.rule)
 		plan.criteria = plan.routingAnalyzeValues(stmt.Rows.(Values))
 		plan.fullList = makeList(0, len(plan.rule.Nodes))
 		return plan
@@ -255,10 +281,13 @@ func getRoutingPlan(statement Statement, router *router.Router) (plan *RoutingPl
 		plan.rule = router.GetRule(String(stmt.Table))
 
 		checkUpdateExprs(stmt.Exprs, plan.rule)
+		checkWhereShardKey(stmt.Where, plan.rule)
 
 		where = stmt.Where
 	case *Delete:
 		plan.rule = router.GetRule(String(stmt.Table))
+		checkWhereShardKey(stmt.Where, plan.rule)
+
 		where = stmt.Where
 	}
 
@@ -273,11 +302,14 @@ func getRoutingPlan(statement Statement, router *router.Router) (plan *RoutingPl
 }
 
 func (plan *RoutingPlan) routingAnalyzeValues(vals Values) Values {
-	// Analyze first value of every item in the list
+	// Analyze shard key value of every item in the list
 	for i := 0; i < len(vals); i++ {
 		switch tuple := vals[i].(type) {
 		case ValTuple:
-			result := plan.routingAnalyzeValue(tuple[0])
+			if plan.keyIndex >= len(tuple) {
+				panic(NewParserError("insert column count doesn't match value count"))
+			}
+			result := plan.routingAnalyzeValue(tuple[plan.keyIndex])
 			if result != VALUE_NODE {
 				panic(NewParserError("insert is too complex"))
 			}
@@ -369,8 +401,7 @@ func (plan *RoutingPlan) findShardList(valExpr ValExpr) []int {
 func (plan *RoutingPlan) findInsertShard(vals Values) int {
 	index := -1
 	for i := 0; i < len(vals); i++ {
-		first_value_expression := vals[i].(ValTuple)[0]
-		newIndex := plan.findShard(first_value_expression)
+		newIndex := plan.findShard(vals[i].(ValTuple)[plan.keyIndex])
 		if index == -1 {
 			index = newIndex
 		} else if index != newIndex {
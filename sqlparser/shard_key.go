@@ -0,0 +1,144 @@
+package sqlparser
+
+import (
+	"github.com/siddontang/mixer/router"
+)
+
+// InsertShard is the part of an insert routed to one node.
+type InsertShard struct {
+	Node string
+	SQL  string
+}
+
+func isShardedRule(rule *router.Rule) bool {
+	return rule.Type != router.DefaultRuleType && len(rule.Nodes) > 1
+}
+
+func findInsertKeyIndex(cols Columns, rule *router.Rule) int {
+	if !isShardedRule(rule) {
+		return 0
+	}
+
+	if len(cols) == 0 {
+		panic(NewParserError("insert into sharded table %s must specify columns", rule.Table))
+	}
+
+	for i, col := range cols {
+		if e, ok := col.(*NonStarExpr); ok {
+			if c, ok := e.Expr.(*ColName); ok && string(c.Name) == rule.Key {
+				return i
+			}
+		}
+	}
+
+	panic(NewParserError("insert into sharded table %s must contain shard key %s", rule.Table, rule.Key))
+}
+
+func checkWhereShardKey(where *Where, rule *router.Rule) {
+	if !isShardedRule(rule) {
+		return
+	}
+
+	if where == nil || !hasShardKeyCondition(where.Expr, rule.Key) {
+		panic(NewParserError("write on sharded table %s must have shard key %s in where", rule.Table, rule.Key))
+	}
+}
+
+// hasShardKeyCondition checks every branch of expr limits the shard key,
+// otherwise the write would fan out to all nodes.
+func hasShardKeyCondition(expr BoolExpr, key string) bool {
+	isKey := func(v ValExpr) bool {
+		c, ok := v.(*ColName)
+		return ok && string(c.Name) == key
+	}
+
+	isValue := func(v ValExpr) bool {
+		switch v.(type) {
+		case StrVal, NumVal, ValArg, ValTuple:
+			return true
+		}
+		return false
+	}
+
+	switch node := expr.(type) {
+	case *AndExpr:
+		return hasShardKeyCondition(node.Left, key) || hasShardKeyCondition(node.Right, key)
+	case *OrExpr:
+		return hasShardKeyCondition(node.Left, key) && hasShardKeyCondition(node.Right, key)
+	case *ParenBoolExpr:
+		return hasShardKeyCondition(node.Expr, key)
+	case *ComparisonExpr:
+		if StringIn(node.Operator, "=", "<", ">", "<=", ">=", "<=>", "in") {
+			return (isKey(node.Left) && isValue(node.Right)) || (isValue(node.Left) && isKey(node.Right))
+		}
+	case *RangeCond:
+		return node.Operator == "between" && isKey(node.Left) && isValue(node.From) && isValue(node.To)
+	}
+	return false
+}
+
+// SplitInsert groups the rows of an insert or replace by the node their shard key
+// routes to and builds one statement per node, bind vars are inlined into the sql.
+func SplitInsert(stmt Statement, r *router.Router, bindVars map[string]interface{}) (shards []InsertShard, err error) {
+	defer handleError(&err)
+
+	plan := getRoutingPlan(stmt, r)
+	plan.bindVars = bindVars
+
+	vals, ok := plan.criteria.(Values)
+	if !ok {
+		panic(NewParserError("%T is not an insert statement", stmt))
+	}
+
+	index := []int{0}
+	rows := []Values{vals}
+
+	if isShardedRule(plan.rule) {
+		index = index[0:0]
+		rows = rows[0:0]
+
+		pos := make(map[int]int)
+		for _, tuple := range vals {
+			n := plan.findShard(tuple.(ValTuple)[plan.keyIndex])
+			i, ok := pos[n]
+			if !ok {
+				i = len(index)
+				pos[n] = i
+				index = append(index, n)
+				rows = append(rows, nil)
+			}
+			rows[i] = append(rows[i], tuple)
+		}
+	}
+
+	shards = make([]InsertShard, len(index))
+	for i, n := range index {
+		var node SQLNode
+		switch v := stmt.(type) {
+		case *Insert:
+			ins := *v
+			ins.Rows = rows[i]
+			node = &ins
+		case *Replace:
+			rep := *v
+			rep.Rows = rows[i]
+			node = &rep
+		}
+
+		shards[i].Node = plan.rule.Nodes[n]
+		shards[i].SQL = generateQuery(node, bindVars)
+	}
+
+	return shards, nil
+}
+
+func generateQuery(node SQLNode, bindVars map[string]interface{}) string {
+	buf := NewTrackedBuffer(nil)
+	buf.Fprintf("%v", node)
+
+	sql, err := buf.ParsedQuery().GenerateQuery(bindVars, nil)
+	if err != nil {
+		panic(NewParserError("%s", err.Error()))
+	}
+	return string(sql)
+}
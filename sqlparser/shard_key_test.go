@@ -0,0 +1,139 @@
+package sqlparser
+
+import (
+	"github.com/siddontang/mixer/config"
+	"github.com/siddontang/mixer/router"
+	"testing"
+)
+
+func newTestShardKeyRouter(t *testing.T) *router.Router {
+	cfg := config.SchemaConfig{
+		DB:    "mixer",
+		Nodes: []string{"node1", "node2"},
+		RulesConifg: config.RulesConfig{
+			Default: "node1",
+			ShardRule: []config.ShardConfig{
+				{
+					Table: "test_key",
+					Key:   "id",
+					Nodes: []string{"node1", "node2"},
+					Type:  router.HashRuleType,
+				},
+			},
+		},
+	}
+
+	r, err := router.NewRouter(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestShardKey_SplitInsert(t *testing.T) {
+	r := newTestShardKeyRouter(t)
+
+	stmt, err := Parse("insert into test_key (str, id) values ('a', 1), ('b', 2), ('c', 3), ('d', 4)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shards, err := SplitInsert(stmt, r, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(shards) != 2 {
+		t.Fatal(shards)
+	}
+
+	if shards[0].Node != "node2" || shards[0].SQL != "insert into test_key(str, id) values ('a', 1), ('c', 3)" {
+		t.Fatal(shards[0])
+	}
+
+	if shards[1].Node != "node1" || shards[1].SQL != "insert into test_key(str, id) values ('b', 2), ('d', 4)" {
+		t.Fatal(shards[1])
+	}
+
+	stmt, err = Parse("replace into test_key (id, str) values (?, ?), (?, ?)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bindVars := map[string]interface{}{"v1": 2, "v2": "a", "v3": 4, "v4": "b"}
+	if shards, err = SplitInsert(stmt, r, bindVars); err != nil {
+		t.Fatal(err)
+	} else if len(shards) != 1 {
+		t.Fatal(shards)
+	} else if shards[0].Node != "node1" || shards[0].SQL != "replace into test_key(id, str) values (2, 'a'), (4, 'b')" {
+		t.Fatal(shards[0])
+	}
+
+	stmt, err = Parse("insert into test_unshard values (1), (2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if shards, err = SplitInsert(stmt, r, nil); err != nil {
+		t.Fatal(err)
+	} else if len(shards) != 1 || shards[0].Node != "node1" {
+		t.Fatal(shards)
+	}
+}
+
+func TestShardKey_Missing(t *testing.T) {
+	r := newTestShardKeyRouter(t)
+
+	sqls := []string{
+		"insert into test_key (str) values ('a')",
+		"insert into test_key values (1, 'a')",
+		"update test_key set str = 'a'",
+		"update test_key set str = 'a' where str = 'b'",
+		"update test_key set str = 'a' where id = 1 or str = 'b'",
+		"delete from test_key",
+		"delete from test_key where id > str",
+	}
+
+	for _, sql := range sqls {
+		if _, err := GetShardList(sql, r, nil); err == nil {
+			t.Fatal(sql, "must error")
+		}
+	}
+
+	sqls = []string{
+		"update test_key set str = 'a' where id = 1 and str = 'b'",
+		"update test_key set str = 'a' where id = 1 or id in (2, 3)",
+		"delete from test_key where (id = 1)",
+		"delete from test_unshard",
+	}
+
+	for _, sql := range sqls {
+		if _, err := GetShardList(sql, r, nil); err != nil {
+			t.Fatal(sql, err)
+		}
+	}
+}
+
+func TestShardKey_Mutation(t *testing.T) {
+	r := newTestShardKeyRouter(t)
+
+	sqls := []string{
+		"update test_key set id = 10 where id = 1",
+		"insert into test_key (id, str) values (1, 'a') on duplicate key update id = 2",
+	}
+
+	for _, sql := range sqls {
+		if _, err := GetShardList(sql, r, nil); err == nil {
+			t.Fatal(sql, "must error")
+		}
+
+		stmt, err := Parse(sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := stmt.(*Insert); ok {
+			if _, err := SplitInsert(stmt, r, nil); err == nil {
+				t.Fatal(sql, "must error")
+			}
+		}
+	}
+}
@@ -41,3 +41,14 @@ func TestMixer(t *testing.T) {
 	sql = "show proxy abc"
 	testParse(t, sql)
 }
+
+func TestBitString(t *testing.T) {
+	stmt, err := Parse("select * from t where h = x'ff' and b = B'01' and s = x''")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := String(stmt); s != "select * from t where h = 0xff and b = 0b01 and s = ''" {
+		t.Fatal(s)
+	}
+}
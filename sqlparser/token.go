@@ -259,6 +259,14 @@ func (tkn *Tokenizer) scanIdentifier() (int, []byte) {
 	for tkn.next(); isLetter(tkn.lastChar) || isDigit(tkn.lastChar); tkn.next() {
 		buffer.WriteByte(byte(tkn.lastChar))
 	}
+	if buffer.Len() == 1 && tkn.lastChar == '\'' {
+		switch buffer.Bytes()[0] {
+		case 'x', 'X':
+			return tkn.scanBitString(16)
+		case 'b', 'B':
+			return tkn.scanBitString(2)
+		}
+	}
 	lowered := bytes.ToLower(buffer.Bytes())
 	if keywordId, found := keywords[string(lowered)]; found {
 		return keywordId, lowered
@@ -266,6 +274,29 @@ func (tkn *Tokenizer) scanIdentifier() (int, []byte) {
 	return ID, buffer.Bytes()
 }
 
+// scanBitString scans x'ff' or b'01' as the number 0xff or 0b01, x'' is an
+// empty string
+func (tkn *Tokenizer) scanBitString(base int) (int, []byte) {
+	buffer := bytes.NewBuffer(make([]byte, 0, 8))
+	if base == 16 {
+		buffer.WriteString("0x")
+	} else {
+		buffer.WriteString("0b")
+	}
+
+	tkn.next()
+	tkn.scanMantissa(base, buffer)
+	if tkn.lastChar != '\'' {
+		return LEX_ERROR, buffer.Bytes()
+	}
+	tkn.next()
+
+	if buffer.Len() == 2 {
+		return STRING, []byte{}
+	}
+	return NUMBER, buffer.Bytes()
+}
+
 func (tkn *Tokenizer) scanBindVar() (int, []byte) {
 	buffer := bytes.NewBuffer(make([]byte, 0, 8))
 	buffer.WriteByte(byte(tkn.lastChar))
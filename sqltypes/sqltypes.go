@@ -198,16 +198,26 @@ func BuildValue(goval interface{}) (v Value, err error) {
 		// no op
 	case int:
 		v = Value{Numeric(strconv.AppendInt(nil, int64(bindVal), 10))}
+	case int8:
+		v = Value{Numeric(strconv.AppendInt(nil, int64(bindVal), 10))}
+	case int16:
+		v = Value{Numeric(strconv.AppendInt(nil, int64(bindVal), 10))}
 	case int32:
 		v = Value{Numeric(strconv.AppendInt(nil, int64(bindVal), 10))}
 	case int64:
 		v = Value{Numeric(strconv.AppendInt(nil, int64(bindVal), 10))}
 	case uint:
 		v = Value{Numeric(strconv.AppendUint(nil, uint64(bindVal), 10))}
+	case uint8:
+		v = Value{Numeric(strconv.AppendUint(nil, uint64(bindVal), 10))}
+	case uint16:
+		v = Value{Numeric(strconv.AppendUint(nil, uint64(bindVal), 10))}
 	case uint32:
 		v = Value{Numeric(strconv.AppendUint(nil, uint64(bindVal), 10))}
 	case uint64:
 		v = Value{Numeric(strconv.AppendUint(nil, uint64(bindVal), 10))}
+	case float32:
+		v = Value{Fractional(strconv.AppendFloat(nil, float64(bindVal), 'f', -1, 32))}
 	case float64:
 		v = Value{Fractional(strconv.AppendFloat(nil, bindVal, 'f', -1, 64))}
 	case string: